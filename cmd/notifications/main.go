@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,11 +15,45 @@ import (
 	"product-notifications/internal/config"
 	"product-notifications/internal/notifications"
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/messaging"
 
 	"github.com/joho/godotenv"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const (
+	metricBreakerOpen  = "notifications_consumer_breaker_open"
+	metricEventAge     = "notifications_event_age_seconds"
+	metricClockSkew    = "notifications_clock_skew_total"
+	metricStaleEvents  = "notifications_stale_events_total"
+	metricOutOfOrder   = "notifications_out_of_order_events_total"
+	metricPaused       = "notifications_consumer_paused"
+	metricUnackedAge   = "notifications_oldest_unacked_seconds"
+	metricBadSignature = "notifications_invalid_signature_total"
+	metricUnsupported  = "notifications_skipped_unsupported_schema_total"
+
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degraded"
+
+	metricsReadHeaderTimeout = 5 * time.Second
+)
+
+// eventConsumer is what run needs from the RabbitMQ and Kafka consumers.
+type eventConsumer interface {
+	Listen(ctx context.Context) error
+	Paused() bool
+	OldestUnacked() time.Duration
+	Close() error
+}
+
+// pausableConsumer is implemented by consumers that an operator can pause
+// and resume with SIGUSR1 and SIGUSR2.
+type pausableConsumer interface {
+	Pause()
+	Resume()
+}
+
 func main() {
 	_ = godotenv.Load()
 
@@ -31,23 +69,115 @@ func run(logger *slog.Logger) int {
 		return 1
 	}
 
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
-	if err != nil {
-		logger.Error("connect rabbitmq", "error", err)
-		return 1
+	breakerOpen := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: metricBreakerOpen,
+		Help: "1 while the consumer is paused after repeated handler failures",
+	})
+	eventAge := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    metricEventAge,
+		Help:    "Seconds between an event's timestamp and its handling by the consumer",
+		Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	})
+	clockSkew := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricClockSkew,
+		Help: "Total number of events timestamped in the consumer's future",
+	})
+	staleEvents := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricStaleEvents,
+		Help: "Total number of events skipped for being older than EVENT_MAX_STALENESS",
+	})
+	outOfOrder := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricOutOfOrder,
+		Help: "Total number of events skipped for an aggregate version below one already handled",
+	})
+	paused := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: metricPaused,
+		Help: "1 while consumption is paused by SIGUSR1, until SIGUSR2",
+	})
+	badSignature := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricBadSignature,
+		Help: "Total number of messages rejected for a missing or invalid x-signature",
+	})
+	unsupportedSchema := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricUnsupported,
+		Help: "Total number of events skipped or dead-lettered for a schema version outside EVENT_SCHEMA_MIN..EVENT_SCHEMA_MAX",
+	})
+	prometheus.MustRegister(breakerOpen, eventAge, clockSkew, staleEvents, outOfOrder, paused, badSignature, unsupportedSchema)
+
+	consumerOpts := []notifications.Option{
+		notifications.WithBreaker(notifications.BreakerConfig{
+			Threshold: int(cfg.BreakerThreshold),
+			Window:    cfg.BreakerWindow,
+			Cooldown:  cfg.BreakerCooldown,
+		}, breakerOpen),
+		notifications.WithEventAge(eventAge, clockSkew),
+		notifications.WithSetupTimeout(cfg.BrokerSetupTimeout),
+		notifications.WithMaxStaleness(cfg.EventMaxStaleness, staleEvents),
+		notifications.WithPauseGauge(paused),
+		notifications.WithSchemaRange(int(cfg.EventSchemaMin), int(cfg.EventSchemaMax),
+			cfg.EventSchemaUnsupported == config.UnsupportedSchemaDeadLetter, unsupportedSchema),
+	}
+	if cfg.DrainTimeout > 0 {
+		consumerOpts = append(consumerOpts, notifications.WithShutdownDrain(cfg.DrainTimeout))
+	}
+	if cfg.ConsumerExclusive {
+		consumerOpts = append(consumerOpts, notifications.WithExclusive())
+	}
+	if cfg.EventVersionCheck {
+		consumerOpts = append(consumerOpts, notifications.WithVersionCheck(int(cfg.EventVersionMaxProducts), outOfOrder))
+	}
+	if cfg.EventSigningSecret != "" {
+		consumerOpts = append(consumerOpts, notifications.WithSignatureCheck([]byte(cfg.EventSigningSecret), cfg.EventSignatureRequired, badSignature))
 	}
-	defer conn.Close()
 
-	consumer, err := notifications.NewConsumer(conn, products.EventsQueue, logger)
-	if err != nil {
-		logger.Error("init consumer", "error", err)
-		return 1
+	var consumer eventConsumer
+	if cfg.EventTransport == config.EventTransportKafka {
+		consumer = notifications.NewKafkaConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupID, logger, consumerOpts...)
+	} else {
+		conn, err := messaging.Dial(cfg.RabbitMQURL, cfg.AMQPHeartbeat)
+		if err != nil {
+			logger.Error("connect rabbitmq", "error", err)
+			return 1
+		}
+		defer conn.Close()
+
+		rabbitConsumer, err := notifications.NewConsumer(conn, products.EventsQueue, logger, consumerOpts...)
+		if err != nil {
+			logger.Error("init consumer", "error", err)
+			return 1
+		}
+		consumer = rabbitConsumer
 	}
 	defer consumer.Close()
 
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: metricUnackedAge,
+		Help: "Seconds the message being handled has gone unacknowledged; 0 while idle",
+	}, func() float64 { return consumer.OldestUnacked().Seconds() }))
+
+	metricsServer := &http.Server{
+		Addr:              cfg.MetricsAddr,
+		Handler:           metricsHandler(consumer, cfg.UnackedThreshold),
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server failed", "error", err)
+		}
+	}()
+	// Runs on return, after the consumer has drained, so scrapes keep
+	// working until the very end.
+	defer func() {
+		if err := shutdownServer(metricsServer, cfg.MetricsShutdownTimeout); err != nil {
+			logger.Error("metrics server shutdown failed", "error", err)
+		}
+	}()
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	go handlePauseSignals(ctx, consumer, logger)
+
 	errCh := make(chan error, 1)
 	go func() {
 		logger.Info("notifications service started")
@@ -83,3 +213,63 @@ func run(logger *slog.Logger) int {
 	logger.Info("notifications service stopped")
 	return 0
 }
+
+// handlePauseSignals pauses the consumer on SIGUSR1 and resumes it on
+// SIGUSR2 until ctx is done.
+func handlePauseSignals(ctx context.Context, consumer eventConsumer, logger *slog.Logger) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigCh:
+			pausable, ok := consumer.(pausableConsumer)
+			if !ok {
+				logger.Warn("consumer cannot be paused, ignoring signal", "signal", sig.String())
+				continue
+			}
+			if sig == syscall.SIGUSR1 {
+				logger.Info("pause requested", "signal", sig.String())
+				pausable.Pause()
+			} else {
+				logger.Info("resume requested", "signal", sig.String())
+				pausable.Resume()
+			}
+		}
+	}
+}
+
+// shutdownServer stops srv gracefully, giving in-flight requests up to
+// timeout to finish before closing whatever connections remain.
+func shutdownServer(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// metricsHandler serves /metrics and /healthz. A paused consumer, or one
+// whose current message has been unacknowledged for longer than
+// unackedThreshold (when positive), is still alive, so /healthz reports it
+// as degraded with a 200 rather than failing.
+func metricsHandler(consumer eventConsumer, unackedThreshold time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status := healthStatusOK
+		stuck := unackedThreshold > 0 && consumer.OldestUnacked() > unackedThreshold
+		if consumer.Paused() || stuck {
+			status = healthStatusDegraded
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+	})
+	return mux
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type stubConsumer struct {
+	paused  bool
+	unacked time.Duration
+}
+
+func (s stubConsumer) Listen(context.Context) error { return nil }
+func (s stubConsumer) Paused() bool                 { return s.paused }
+func (s stubConsumer) OldestUnacked() time.Duration { return s.unacked }
+func (s stubConsumer) Close() error                 { return nil }
+
+func TestMetricsHandler_Health(t *testing.T) {
+	tests := []struct {
+		name       string
+		consumer   stubConsumer
+		threshold  time.Duration
+		wantStatus string
+	}{
+		{name: "idle", threshold: time.Minute, wantStatus: healthStatusOK},
+		{name: "handling within the threshold", consumer: stubConsumer{unacked: time.Second}, threshold: time.Minute, wantStatus: healthStatusOK},
+		{name: "stuck past the threshold", consumer: stubConsumer{unacked: 2 * time.Minute}, threshold: time.Minute, wantStatus: healthStatusDegraded},
+		{name: "check disabled", consumer: stubConsumer{unacked: time.Hour}, wantStatus: healthStatusOK},
+		{name: "paused", consumer: stubConsumer{paused: true}, wantStatus: healthStatusDegraded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			metricsHandler(tt.consumer, tt.threshold).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d", w.Code)
+			}
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if body["status"] != tt.wantStatus {
+				t.Fatalf("want status %q, got %q", tt.wantStatus, body["status"])
+			}
+		})
+	}
+}
+
+func TestShutdownServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   bool
+		wantErr bool
+	}{
+		{
+			name: "idle server stops cleanly",
+		},
+		{
+			name:    "stuck request is cut off at the deadline",
+			block:   true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
+			srv := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					close(entered)
+					<-release
+				}),
+				ReadHeaderTimeout: time.Second,
+			}
+			served := make(chan error, 1)
+			go func() { served <- srv.Serve(ln) }()
+
+			if tt.block {
+				go func() { _, _ = http.Get("http://" + ln.Addr().String()) }()
+				<-entered
+			}
+
+			start := time.Now()
+			err = shutdownServer(srv, 50*time.Millisecond)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("want error=%v, got %v", tt.wantErr, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("shutdown took %v, want it bounded by the timeout", elapsed)
+			}
+			if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+				t.Fatalf("want server closed, got %v", err)
+			}
+		})
+	}
+}
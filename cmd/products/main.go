@@ -8,19 +8,27 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"syscall"
 
 	"product-notifications/internal/config"
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/audit"
+	"product-notifications/internal/products/cache"
+	"product-notifications/internal/products/expiry"
+	"product-notifications/internal/products/heartbeat"
 	producthttp "product-notifications/internal/products/http"
+	"product-notifications/internal/products/jobs"
 	"product-notifications/internal/products/messaging"
+	"product-notifications/internal/products/outbox"
 	"product-notifications/internal/products/repository"
+	"product-notifications/internal/products/reservation"
 	"product-notifications/internal/products/service"
+	"product-notifications/internal/products/webhook"
 
 	_ "product-notifications/docs"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/joho/godotenv"
@@ -30,10 +38,20 @@ import (
 )
 
 const (
-	metricCreatedTotal  = "products_created_total"
-	metricDeletedTotal  = "products_deleted_total"
+	metricCreatedTotal = "products_created_total"
+	metricDeletedTotal = "products_deleted_total"
+
+	metricEventsDroppedTotal   = "products_events_dropped_total"
+	metricCreatedEventLost     = "products_created_event_lost_total"
+	metricListCacheHitsTotal   = "products_list_cache_hits_total"
+	metricListCacheMissesTotal = "products_list_cache_misses_total"
+	metricRequestDuration      = "products_http_request_duration_seconds"
+
 	migrateSourcePrefix = "file://"
 	postgresDriverName  = "postgres"
+	outboxRelayLock     = "outbox-relay"
+	expirySweeperLock   = "expiry-sweeper"
+	migrationsLock      = "schema-migrations"
 )
 
 // @title        Products API
@@ -41,57 +59,143 @@ const (
 // @description  Product management microservice with event notifications.
 // @host         localhost:8080
 // @BasePath     /
+
+// @securityDefinitions.apikey  AdminToken
+// @in                          header
+// @name                        Authorization
+// @description                 "Bearer " followed by ADMIN_TOKEN.
 func main() {
 	_ = godotenv.Load()
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 
-	os.Exit(run(logger))
+	os.Exit(run(logger, logLevel))
 }
 
-func run(logger *slog.Logger) int {
+func run(logger *slog.Logger, logLevel *slog.LevelVar) int {
 	cfg, err := config.LoadProducts()
 	if err != nil {
 		logger.Error("load config", "error", err)
 		return 1
 	}
+	logLevel.Set(cfg.LogLevel)
 
-	if err := runMigrations(cfg.DatabaseURL, cfg.MigrationsPath); err != nil {
-		logger.Error("run migrations", "error", err)
+	if cfg.MigrateOnStart {
+		if err := runMigrations(cfg.DatabaseURL, cfg.MigrationsPath); err != nil {
+			logger.Error("run migrations", "error", err)
+			return 1
+		}
+	} else if err := checkMigrations(cfg.DatabaseURL, cfg.MigrationsPath); err != nil {
+		logger.Error("check migrations", "error", err)
 		return 1
 	}
 
-	db, err := sql.Open(postgresDriverName, cfg.DatabaseURL)
+	db, err := openDatabase(cfg.DatabaseURL, cfg)
 	if err != nil {
 		logger.Error("open database", "error", err)
 		return 1
 	}
 	defer db.Close()
 
-	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
-	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
-	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
-
 	pingCtx, pingCancel := context.WithTimeout(context.Background(), cfg.DBPingTimeout)
 	defer pingCancel()
-	if err := db.PingContext(pingCtx); err != nil {
+	if err := repository.Validate(pingCtx, db); err != nil {
 		logger.Error("ping database", "error", err)
 		return 1
 	}
+	if cfg.DBWarmUp {
+		if err := repository.WarmUp(pingCtx, db, int(cfg.DBWarmUpConns)); err != nil {
+			logger.Error("warm up database", "error", err)
+			return 1
+		}
+	}
 
-	rabbitConn, err := amqp.Dial(cfg.RabbitMQURL)
-	if err != nil {
-		logger.Error("connect rabbitmq", "error", err)
-		return 1
+	var replica *sql.DB
+	if cfg.DatabaseReplicaURL != "" {
+		replica, err = openDatabase(cfg.DatabaseReplicaURL, cfg)
+		if err != nil {
+			logger.Error("open replica database", "error", err)
+			return 1
+		}
+		defer replica.Close()
+
+		// An unreachable replica is not fatal: reads fall back to the primary
+		// until it recovers.
+		if err := repository.Validate(pingCtx, replica); err != nil {
+			logger.Warn("ping replica database", "error", err)
+		} else if cfg.DBWarmUp {
+			if err := repository.WarmUp(pingCtx, replica, int(cfg.DBWarmUpConns)); err != nil {
+				logger.Warn("warm up replica database", "error", err)
+			}
+		}
 	}
-	defer rabbitConn.Close()
 
-	publisher, err := messaging.NewRabbitPublisher(rabbitConn, products.EventsQueue)
-	if err != nil {
-		logger.Error("init publisher", "error", err)
-		return 1
+	var publisher service.Publisher = messaging.NoopPublisher{}
+	switch {
+	case cfg.DisableEvents:
+		logger.Warn("event publishing disabled")
+	case cfg.EventTransport == config.EventTransportKafka:
+		kafkaPublisher := messaging.NewKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic, messaging.PublisherConfig{
+			CompressAbove: int(cfg.PublishCompressAbove),
+			Format:        cfg.EventFormat,
+			Source:        cfg.EventSource,
+			SigningSecret: []byte(cfg.EventSigningSecret),
+		})
+		defer kafkaPublisher.Close()
+		publisher = kafkaPublisher
+	default:
+		rabbitConn, err := messaging.Dial(cfg.RabbitMQURL, cfg.AMQPHeartbeat)
+		if err != nil {
+			logger.Error("connect rabbitmq", "error", err)
+			return 1
+		}
+		defer rabbitConn.Close()
+
+		rabbitPublisher, err := messaging.NewRabbitPublisher(rabbitConn, products.EventsQueue, messaging.PublisherConfig{
+			Mandatory:          cfg.PublishMandatory,
+			CompressAbove:      int(cfg.PublishCompressAbove),
+			Format:             cfg.EventFormat,
+			Source:             cfg.EventSource,
+			Transient:          cfg.PublishDeliveryMode == config.DeliveryModeTransient,
+			MessageTTL:         cfg.PublishMessageTTL,
+			SigningSecret:      []byte(cfg.EventSigningSecret),
+			BatchChunkSize:     int(cfg.PublishBatchChunkSize),
+			Exchange:           cfg.PublishExchange,
+			RoutingKey:         cfg.PublishRoutingKey,
+			SetupTimeout:       cfg.BrokerSetupTimeout,
+			QueueCheckInterval: cfg.PublishQueueCheckInterval,
+			Logger:             logger,
+			LogPayloadMax:      int(cfg.PublishLogPayloadMax),
+			LogRedact:          cfg.PublishLogRedact,
+		})
+		if err != nil {
+			logger.Error("init publisher", "error", err)
+			return 1
+		}
+		defer rabbitPublisher.Close()
+		publisher = rabbitPublisher
+
+		if cfg.SelfTest {
+			if err := runSelfTest(rabbitConn, cfg); err != nil {
+				logger.Error("event round-trip self-test failed", "error", err)
+				if cfg.SelfTestStrict {
+					return 1
+				}
+			} else {
+				logger.Info("event round-trip self-test passed")
+			}
+		}
+	}
+
+	// With METRICS_ENABLED=false the collectors are still created and
+	// updated, but never registered, so nothing exports them and the
+	// global registry is left alone.
+	register := func(cs ...prometheus.Collector) {
+		if cfg.MetricsEnabled {
+			prometheus.MustRegister(cs...)
+		}
 	}
-	defer publisher.Close()
 
 	createdCounter := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: metricCreatedTotal,
@@ -101,17 +205,270 @@ func run(logger *slog.Logger) int {
 		Name: metricDeletedTotal,
 		Help: "Total number of products deleted",
 	})
-	prometheus.MustRegister(createdCounter, deletedCounter)
+	droppedCounter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricEventsDroppedTotal,
+		Help: "Total number of events dropped because the async publish buffer was full",
+	})
+	eventLostCounter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricCreatedEventLost,
+		Help: "Total number of products created whose product_created event failed to publish",
+	})
+	register(createdCounter, deletedCounter, droppedCounter, eventLostCounter)
+
+	if cfg.EventCoalesceWindow > 0 {
+		coalescer := messaging.NewCoalescingPublisher(publisher, messaging.CoalesceConfig{
+			Window:   cfg.EventCoalesceWindow,
+			MaxBatch: int(cfg.EventCoalesceMaxBatch),
+		}, logger)
+		// Deferred after publisher.Close and before the async publisher's
+		// Close, so the async buffer drains into it and its last batch is
+		// flushed while the channel is still open.
+		defer coalescer.Close()
+		publisher = coalescer
+	}
 
-	repo := repository.NewPostgres(db)
-	svc := service.New(repo, publisher, logger, createdCounter, deletedCounter)
-	handler := producthttp.NewHandler(svc)
+	var readOnly producthttp.ReadOnlyMode
+	switch {
+	case cfg.ReadOnly:
+		logger.Warn("read-only mode forced, writes are refused")
+		readOnly = producthttp.ForcedReadOnly{}
+	case cfg.ReadOnlyAfterFailures > 0 && !cfg.DisableEvents:
+		guard := messaging.NewReadOnlyGuard(publisher, messaging.ReadOnlyConfig{
+			Threshold: int(cfg.ReadOnlyAfterFailures),
+			Retry:     cfg.ReadOnlyRetry,
+		}, logger)
+		publisher = guard
+		readOnly = guard
+	}
+
+	eventPublisher := publisher
+	var asyncPublisher *messaging.AsyncPublisher
+	if cfg.PublishMode == config.PublishModeAsync {
+		asyncCfg := messaging.AsyncConfig{
+			BufferSize: int(cfg.PublishBufferSize),
+			Overflow:   cfg.PublishBufferOverflow,
+		}
+		if cfg.PublishBufferOverflow == config.PublishOverflowSpill {
+			spill, err := messaging.OpenSpill(cfg.PublishSpillPath, int(cfg.PublishSpillCapacity))
+			if err != nil {
+				logger.Error("open event spill", "error", err)
+				return 1
+			}
+			if n := spill.Len(); n > 0 {
+				logger.Info("replaying spilled events", "count", n)
+			}
+			asyncCfg.Spill = spill
+		}
+		asyncPublisher = messaging.NewAsyncPublisher(publisher, asyncCfg, logger, droppedCounter)
+		// Deferred after publisher.Close, so it runs first and drains the
+		// buffer while the channel is still open.
+		defer asyncPublisher.Close()
+		eventPublisher = asyncPublisher
+	}
+
+	svcOpts := []service.Option{
+		service.WithMinNameLength(int(cfg.NameMinLength)),
+		service.WithCreatedEventLostCounter(eventLostCounter),
+		service.WithReservationTTL(cfg.ReservationTTL),
+	}
+	if cfg.WebhookURL != "" {
+		svcOpts = append(svcOpts, service.WithCreateWebhook(webhook.New(cfg.WebhookURL, cfg.WebhookTimeout)))
+	}
+	if cfg.NameStripPattern != "" {
+		// Already validated by config.LoadProducts.
+		svcOpts = append(svcOpts, service.WithNameStripPattern(regexp.MustCompile(cfg.NameStripPattern)))
+	}
+	if len(cfg.NameDenylist) > 0 || len(cfg.NameDenylistPatterns) > 0 {
+		denylist, err := products.NewNameDenylist(cfg.NameDenylist, cfg.NameDenylistPatterns)
+		if err != nil {
+			logger.Error("compile name denylist", "error", err)
+			return 1
+		}
+		svcOpts = append(svcOpts, service.WithNameDenylist(denylist))
+	}
+	if cfg.EventOldValues {
+		svcOpts = append(svcOpts, service.WithOldValuesInEvents())
+	}
+	if cfg.EventOrdering {
+		svcOpts = append(svcOpts, service.WithProductEventOrdering())
+	}
+
+	var repoOpts []repository.Option
+	switch cfg.AuditSink {
+	case config.AuditSinkFile:
+		auditFile, err := audit.OpenFile(cfg.AuditFilePath)
+		if err != nil {
+			logger.Error("open audit file", "error", err)
+			return 1
+		}
+		defer auditFile.Close()
+		repoOpts = append(repoOpts, repository.WithAudit(auditFile, cfg.AuditStrict, logger))
+	case config.AuditSinkPostgres:
+		repoOpts = append(repoOpts, repository.WithAudit(repository.NewAuditLog(db), cfg.AuditStrict, logger))
+	}
+	if cfg.NameCaseInsensitive {
+		repoOpts = append(repoOpts, repository.WithCaseInsensitiveNames())
+	}
+	if cfg.SearchNormalized {
+		repoOpts = append(repoOpts, repository.WithNormalizedSearch())
+	}
+	if cfg.ApproxCountAbove > 0 {
+		repoOpts = append(repoOpts, repository.WithApproximateCount(cfg.ApproxCountAbove))
+	}
+	if cfg.DeleteChunkSize > 0 {
+		repoOpts = append(repoOpts, repository.WithDeleteChunkSize(int(cfg.DeleteChunkSize)))
+	}
+	if cfg.OwnerQuota > 0 || len(cfg.OwnerQuotaOverrides) > 0 {
+		repoOpts = append(repoOpts, repository.WithOwnerQuota(products.OwnerQuota{
+			Default:   cfg.OwnerQuota,
+			Overrides: cfg.OwnerQuotaOverrides,
+		}))
+	}
+
+	if cfg.SlugEnabled {
+		repoOpts = append(repoOpts, repository.WithSlugs(products.SlugConfig{
+			Separator: cfg.SlugSeparator,
+			MaxLength: int(cfg.SlugMaxLength),
+			Suffix:    cfg.SlugSuffix,
+		}))
+	}
+
+	repo := repository.NewPostgresWithReplica(db, replica, repoOpts...)
+
+	var svcRepo service.Repository = repo
+	if cfg.ServerTiming {
+		svcRepo = service.Timed(svcRepo)
+	}
+	if cfg.ListCacheSize > 0 {
+		hits := prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricListCacheHitsTotal,
+			Help: "Total number of list/count reads served from the cache",
+		})
+		misses := prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricListCacheMissesTotal,
+			Help: "Total number of list/count reads that went to the database",
+		})
+		register(hits, misses)
+		svcRepo = cache.NewRepository(svcRepo, cache.Config{
+			TTL:        cfg.ListCacheTTL,
+			MaxEntries: int(cfg.ListCacheSize),
+		}, hits, misses)
+	}
+
+	svc := service.New(svcRepo, eventPublisher, logger, createdCounter, deletedCounter, svcOpts...)
+	retryAfter := producthttp.RetryAfterPolicy{
+		Overloaded:  cfg.RetryAfterOverloaded,
+		ReadOnly:    cfg.RetryAfterReadOnly,
+		Unavailable: cfg.RetryAfterUnavailable,
+	}
+	handlerOpts := []producthttp.Option{
+		producthttp.WithRetryAfter(retryAfter),
+		producthttp.WithSuggestMinPrefix(int(cfg.SuggestMinPrefix)),
+		producthttp.WithExportBatchSize(int(cfg.ExportBatchSize)),
+		producthttp.WithExportConcurrency(int(cfg.ExportMaxConcurrent)),
+		producthttp.WithInfo(cfg.Summary()),
+	}
+	if cfg.CreateMode == config.CreateModeAsync {
+		createQueue := jobs.NewQueue(svc.CreateProduct, jobs.Config{
+			QueueSize: int(cfg.CreateQueueSize),
+			Workers:   int(cfg.CreateWorkers),
+		}, logger)
+		// Deferred after the publisher and database closes, so queued
+		// creates finish while both are still open.
+		defer createQueue.Close()
+		handlerOpts = append(handlerOpts, producthttp.WithAsyncCreate(createQueue))
+	}
+
+	if cfg.ProductIDType == config.ProductIDTypeUUID {
+		handlerOpts = append(handlerOpts, producthttp.WithPublicIDs())
+	}
+	if cfg.SearchStatementTimeout > 0 {
+		handlerOpts = append(handlerOpts, producthttp.WithSearchStatementTimeout(cfg.SearchStatementTimeout))
+	}
+	if readOnly != nil {
+		handlerOpts = append(handlerOpts, producthttp.WithReadOnly(readOnly))
+	}
+	if cfg.OwnerQuota > 0 || len(cfg.OwnerQuotaOverrides) > 0 {
+		handlerOpts = append(handlerOpts, producthttp.WithRequiredOwner())
+	}
+	if cfg.StrictQueryParams {
+		handlerOpts = append(handlerOpts, producthttp.WithStrictQuery())
+	}
+	if asyncPublisher != nil {
+		handlerOpts = append(handlerOpts, producthttp.WithPublisherFlush(asyncPublisher))
+	}
+	if cfg.EmptyFilterNotFound {
+		handlerOpts = append(handlerOpts, producthttp.WithEmptyFilterNotFound())
+	}
+	if cfg.SearchMode == config.SearchModeRanked {
+		handlerOpts = append(handlerOpts, producthttp.WithRankedSearch())
+	}
+	if cfg.ListPagination == config.ListPaginationLink {
+		handlerOpts = append(handlerOpts, producthttp.WithLinkPagination())
+	}
+	if !cfg.MetricsEnabled {
+		handlerOpts = append(handlerOpts, producthttp.WithoutMetrics())
+	}
+
+	handler := producthttp.NewHandler(svc, handlerOpts...)
+	if err := producthttp.RegisterValidators(); err != nil {
+		logger.Error("register request validators", "error", err)
+		return 1
+	}
 
 	router := gin.New()
+	// gin trusts every X-Forwarded-For unless told otherwise, which would
+	// let any client pick the IP it is rate limited and logged as.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("set trusted proxies", "error", err)
+		return 1
+	}
 	router.Use(gin.Recovery())
 	router.Use(producthttp.RequestIDMiddleware())
-	router.Use(producthttp.AccessLogMiddleware(logger))
-	producthttp.RegisterRoutes(router, handler, repo)
+	router.Use(producthttp.ActorMiddleware())
+	if cfg.ProblemDetails {
+		router.Use(producthttp.ProblemDetailsMiddleware())
+	}
+	slowRequest := producthttp.NewDurationVar(cfg.SlowRequest)
+	router.Use(producthttp.AccessLogMiddleware(logger, slowRequest, cfg.AccessLogSampleRate))
+	if cfg.MetricsEnabled {
+		requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    metricRequestDuration,
+			Help:    "HTTP request latency by route, method and status",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"})
+		register(requestDuration)
+		router.Use(producthttp.MetricsMiddleware(requestDuration))
+	}
+	if cfg.ServerTiming {
+		router.Use(producthttp.ServerTimingMiddleware())
+	}
+	if len(cfg.FeatureFlags) > 0 {
+		router.Use(producthttp.FeatureFlagsMiddleware(cfg.FeatureFlags))
+	}
+	if len(cfg.RateLimitTiers) > 0 {
+		tiers := make(map[string]producthttp.RateTier, len(cfg.RateLimitTiers))
+		for name, tier := range cfg.RateLimitTiers {
+			tiers[name] = producthttp.RateTier{RPS: tier.RPS, Burst: tier.Burst}
+		}
+		router.Use(producthttp.RateLimitMiddleware(producthttp.RateLimits{
+			Tiers:   tiers,
+			Keys:    cfg.APIKeys,
+			Default: cfg.RateLimitDefaultTier,
+		}))
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		router.Use(producthttp.ConcurrencyLimitMiddleware(cfg.MaxConcurrentRequests, retryAfter))
+	}
+	router.Use(producthttp.RequestTimeoutMiddleware(producthttp.RouteTimeouts{
+		Default: cfg.RequestTimeout,
+		Routes:  cfg.RouteTimeouts,
+	}))
+	var checker producthttp.HealthChecker = repo
+	if cfg.HealthFailureThreshold > 1 || cfg.HealthSuccessThreshold > 1 {
+		checker = producthttp.NewDebouncedHealth(repo, int(cfg.HealthFailureThreshold), int(cfg.HealthSuccessThreshold))
+	}
+	producthttp.RegisterRoutes(router, handler, checker, cfg.AdminToken)
 
 	server := &http.Server{
 		Addr:              cfg.HTTPAddr,
@@ -122,6 +479,95 @@ func run(logger *slog.Logger) int {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	reload := &reloader{
+		logger:      logger,
+		load:        reloadConfig,
+		current:     cfg,
+		logLevel:    logLevel,
+		slowRequest: slowRequest,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			reload.reload()
+		}
+	}()
+
+	relayCfg := outbox.Config{
+		Interval:  cfg.OutboxInterval,
+		BatchSize: int(cfg.OutboxBatchSize),
+	}
+	if cfg.OutboxRelayMode == config.OutboxRelayLeader {
+		relayCfg.Leader = repository.NewAdvisoryLeader(db, outboxRelayLock)
+	}
+	// The relay marks events published once Publish returns, so it gets
+	// the synchronous chain: the async publisher returns as soon as an
+	// event is queued.
+	relay := outbox.NewRelay(repo, publisher, relayCfg, logger)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(ctx)
+	}()
+	// Registered after the publisher's deferred Close, so the relay has
+	// stopped publishing by the time the publisher closes.
+	defer func() {
+		stop()
+		<-relayDone
+	}()
+
+	// Unlike the relay, the sweeper always elects a leader: sweepers on
+	// several instances would each announce every expiry. Like the relay
+	// it moves on once Publish returns, so it publishes synchronously.
+	sweeper := expiry.NewSweeper(repo, publisher, expiry.Config{
+		Interval: cfg.ExpirySweepInterval,
+		Leader:   repository.NewAdvisoryLeader(db, expirySweeperLock),
+	}, logger)
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweeper.Run(ctx)
+	}()
+	defer func() {
+		stop()
+		<-sweeperDone
+	}()
+
+	// The reservation sweeper needs no leader: it claims reservations
+	// with SKIP LOCKED, so each is released and announced once. It commits
+	// a release once its event is published, so it publishes synchronously.
+	reservations := reservation.NewSweeper(repo, publisher, reservation.Config{
+		Interval: cfg.ReservationSweepInterval,
+	}, logger)
+	reservationsDone := make(chan struct{})
+	go func() {
+		defer close(reservationsDone)
+		reservations.Run(ctx)
+	}()
+	defer func() {
+		stop()
+		<-reservationsDone
+	}()
+
+	if cfg.HeartbeatInterval > 0 {
+		instanceID := cfg.HeartbeatInstanceID
+		if instanceID == "" {
+			instanceID, _ = os.Hostname()
+		}
+		beater := heartbeat.New(eventPublisher, cfg.HeartbeatInterval, instanceID, logger)
+		heartbeatDone := make(chan struct{})
+		go func() {
+			defer close(heartbeatDone)
+			beater.Run(ctx)
+		}()
+		defer func() {
+			stop()
+			<-heartbeatDone
+		}()
+	}
+
 	errCh := make(chan error, 1)
 	go func() {
 		logger.Info("products service started", "addr", cfg.HTTPAddr)
@@ -147,16 +593,31 @@ func run(logger *slog.Logger) int {
 	return 0
 }
 
-func runMigrations(databaseURL, migrationsPath string) error {
-	m, err := migrate.New(migrateSourcePrefix+migrationsPath, databaseURL)
+// reloadConfig re-reads .env over the process environment, since the
+// environment of a running process cannot be changed from outside.
+func reloadConfig() (config.Products, error) {
+	_ = godotenv.Overload()
+	return config.LoadProducts()
+}
+
+func openDatabase(url string, cfg config.Products) (*sql.DB, error) {
+	db, err := sql.Open(postgresDriverName, url)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer m.Close()
 
-	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	return db, nil
+}
+
+func runSelfTest(conn *amqp.Connection, cfg config.Products) error {
+	ch, err := conn.Channel()
+	if err != nil {
 		return err
 	}
+	defer ch.Close()
 
-	return nil
+	return messaging.SelfTest(context.Background(), ch, cfg.SelfTestTimeout)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"product-notifications/internal/products/repository"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// runMigrations applies pending migrations. Instances starting together
+// take turns: each holds a session advisory lock while it migrates, so the
+// others wait for it and then find nothing left to do, instead of racing
+// on schema_migrations and leaving it dirty.
+func runMigrations(databaseURL, migrationsPath string) error {
+	ctx := context.Background()
+	db, err := sql.Open(postgresDriverName, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get migrations lock connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1::int, hashtext($2))`, repository.LockClassMigrations, migrationsLock); err != nil {
+		return fmt.Errorf("take migrations lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1::int, hashtext($2))`, repository.LockClassMigrations, migrationsLock)
+
+	m, err := migrate.New(migrateSourcePrefix+migrationsPath, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// checkMigrations refuses to start against a schema that is behind the
+// newest migration in migrationsPath. It stands in for runMigrations when
+// migrations are applied out of band.
+func checkMigrations(databaseURL, migrationsPath string) error {
+	latest, err := latestMigration(migrationsPath)
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+
+	m, err := migrate.New(migrateSourcePrefix+migrationsPath, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	current, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		current, err = 0, nil
+	}
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	return checkSchemaVersion(current, dirty, latest)
+}
+
+// checkSchemaVersion accepts a clean schema at or past latest. A newer
+// schema is fine: it is what a rolling deploy looks like to the old
+// instances.
+func checkSchemaVersion(current uint, dirty bool, latest uint) error {
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty: a migration failed part way and needs fixing", current)
+	}
+	if current < latest {
+		return fmt.Errorf("schema version %d is behind the latest migration %d: apply migrations or set MIGRATE_ON_START=true", current, latest)
+	}
+	return nil
+}
+
+// latestMigration returns the highest migration version in
+// migrationsPath, or 0 when it holds none.
+func latestMigration(migrationsPath string) (uint, error) {
+	src, err := source.Open(migrateSourcePrefix + migrationsPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
@@ -0,0 +1,75 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestRunMigrations_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	pgContainer, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage("postgres:17-alpine"),
+		postgres.WithDatabase("test_products"),
+		postgres.WithUsername("test"),
+		postgres.WithPassword("test"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(30*time.Second),
+		),
+	)
+	if err != nil {
+		t.Fatalf("start postgres container: %v", err)
+	}
+	t.Cleanup(func() { _ = pgContainer.Terminate(ctx) })
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("get connection string: %v", err)
+	}
+	migrationsPath := filepath.Join("..", "..", "migrations", "products")
+
+	const instances = 2
+	var wg sync.WaitGroup
+	errs := make([]error, instances)
+	for i := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runMigrations(connStr, migrationsPath)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("instance %d: run migrations: %v", i, err)
+		}
+	}
+
+	latest, err := latestMigration(migrationsPath)
+	if err != nil {
+		t.Fatalf("read migrations: %v", err)
+	}
+	m, err := migrate.New(migrateSourcePrefix+migrationsPath, connStr)
+	if err != nil {
+		t.Fatalf("init migrate: %v", err)
+	}
+	defer m.Close()
+	version, dirty, err := m.Version()
+	if err != nil {
+		t.Fatalf("read schema version: %v", err)
+	}
+	if dirty || version != latest {
+		t.Fatalf("want clean schema at version %d, got %d (dirty %v)", latest, version, dirty)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		current uint
+		dirty   bool
+		wantErr string
+	}{
+		{name: "up to date", current: 10},
+		{name: "ahead during a rolling deploy", current: 11},
+		{name: "behind", current: 9, wantErr: "schema version 9 is behind the latest migration 10"},
+		{name: "never migrated", current: 0, wantErr: "schema version 0 is behind"},
+		{name: "dirty", current: 10, dirty: true, wantErr: "dirty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchemaVersion(tt.current, tt.dirty, 10)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLatestMigration(t *testing.T) {
+	latest, err := latestMigration(filepath.Join("..", "..", "migrations", "products"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := filepath.Glob(filepath.Join("..", "..", "migrations", "products", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint(len(entries)); latest != want {
+		t.Fatalf("want latest migration %d, got %d", want, latest)
+	}
+
+	empty := t.TempDir()
+	if latest, err := latestMigration(empty); err != nil || latest != 0 {
+		t.Fatalf("want 0 for no migrations, got %d, %v", latest, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(empty, "000042_add_thing.up.sql"), []byte("SELECT 1;"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := latestMigration(empty); err != nil || latest != 42 {
+		t.Fatalf("want 42, got %d, %v", latest, err)
+	}
+}
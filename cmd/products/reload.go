@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+
+	"product-notifications/internal/config"
+	producthttp "product-notifications/internal/products/http"
+)
+
+// reloader applies the hot-reloadable subset of config on SIGHUP. Every
+// setting it touches is backed by an atomic, so request goroutines never
+// need a lock to read them.
+type reloader struct {
+	logger  *slog.Logger
+	load    func() (config.Products, error)
+	current config.Products
+
+	logLevel    *slog.LevelVar
+	slowRequest *producthttp.DurationVar
+}
+
+func (r *reloader) reload() {
+	next, err := r.load()
+	if err != nil {
+		r.logger.Error("reload config", "error", err)
+		return
+	}
+
+	if next.LogLevel != r.current.LogLevel {
+		r.logLevel.Set(next.LogLevel)
+		r.logger.Info("config reloaded", "setting", "LOG_LEVEL",
+			"old", r.current.LogLevel.String(), "new", next.LogLevel.String())
+		r.current.LogLevel = next.LogLevel
+	}
+
+	if next.SlowRequest != r.current.SlowRequest {
+		r.slowRequest.Store(next.SlowRequest)
+		r.logger.Info("config reloaded", "setting", "SLOW_REQUEST_THRESHOLD",
+			"old", r.current.SlowRequest.String(), "new", next.SlowRequest.String())
+		r.current.SlowRequest = next.SlowRequest
+	}
+
+	if changed := changedFields(r.current, next); len(changed) > 0 {
+		r.logger.Warn("ignoring changes to settings that need a restart", "fields", changed)
+	}
+}
+
+// changedFields names the fields that differ between a and b. Reloadable
+// fields have already been copied into a by the time this runs.
+func changedFields(a, b config.Products) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"product-notifications/internal/config"
+	producthttp "product-notifications/internal/products/http"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	current := config.Products{
+		HTTPAddr:    ":8080",
+		LogLevel:    slog.LevelInfo,
+		SlowRequest: time.Second,
+	}
+
+	tests := []struct {
+		name      string
+		next      config.Products
+		loadErr   error
+		wantLevel slog.Level
+		wantSlow  time.Duration
+		wantLog   string
+	}{
+		{
+			name: "applies new log level",
+			next: config.Products{
+				HTTPAddr:    ":8080",
+				LogLevel:    slog.LevelDebug,
+				SlowRequest: time.Second,
+			},
+			wantLevel: slog.LevelDebug,
+			wantSlow:  time.Second,
+			wantLog:   `"setting":"LOG_LEVEL"`,
+		},
+		{
+			name: "applies new slow-request threshold",
+			next: config.Products{
+				HTTPAddr:    ":8080",
+				LogLevel:    slog.LevelInfo,
+				SlowRequest: 250 * time.Millisecond,
+			},
+			wantLevel: slog.LevelInfo,
+			wantSlow:  250 * time.Millisecond,
+			wantLog:   `"setting":"SLOW_REQUEST_THRESHOLD"`,
+		},
+		{
+			name: "ignores non-reloadable settings",
+			next: config.Products{
+				HTTPAddr:    ":9090",
+				LogLevel:    slog.LevelInfo,
+				SlowRequest: time.Second,
+			},
+			wantLevel: slog.LevelInfo,
+			wantSlow:  time.Second,
+			wantLog:   `"fields":["HTTPAddr"]`,
+		},
+		{
+			name:      "keeps current settings on load error",
+			loadErr:   errors.New("invalid LOG_LEVEL"),
+			wantLevel: slog.LevelInfo,
+			wantSlow:  time.Second,
+			wantLog:   "reload config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			level := new(slog.LevelVar)
+			r := &reloader{
+				logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+				load: func() (config.Products, error) {
+					return tt.next, tt.loadErr
+				},
+				current:     current,
+				logLevel:    level,
+				slowRequest: producthttp.NewDurationVar(current.SlowRequest),
+			}
+
+			r.reload()
+
+			if level.Level() != tt.wantLevel {
+				t.Fatalf("want log level %v, got %v", tt.wantLevel, level.Level())
+			}
+			if got := r.slowRequest.Load(); got != tt.wantSlow {
+				t.Fatalf("want slow threshold %v, got %v", tt.wantSlow, got)
+			}
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Fatalf("want log containing %s, got %s", tt.wantLog, buf.String())
+			}
+		})
+	}
+}
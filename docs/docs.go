@@ -15,11 +15,82 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/products": {
+        "/internal/flush": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Counts events taken from the buffer and the spill. Failed buffered events exhausted their retries and are dropped; a failed spilled event stays in the spill. A failed coalesced batch answers 503.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Publish every event waiting in the async publish buffer, the spill and a pending coalesced batch now",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.flushResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/internal/info": {
             "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "For quick debugging without Prometheus: Go version, build version and commit, uptime, goroutines, GC statistics and a summary of the configuration with secrets redacted.",
                 "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Report build and runtime details",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.infoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products": {
+            "get": {
+                "description": "Accept: text/csv or application/x-ndjson streams every product like GET /products/export instead, ignoring pagination; filters are refused with 400.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
                 "tags": [
                     "products"
                 ],
@@ -38,6 +109,77 @@ const docTemplate = `{
                         "description": "Items per page",
                         "name": "limit",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Substring the product name must contain",
+                        "name": "search",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Match search as word prefixes and order by relevance (defaults to SEARCH_MODE=ranked)",
+                        "name": "rank",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "JSON object the product attributes must contain",
+                        "name": "attributes",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count the total exactly even when approximate counts are enabled",
+                        "name": "exact",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also list products whose expires_at has passed",
+                        "name": "include_expired",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "draft",
+                            "published",
+                            "archived"
+                        ],
+                        "type": "string",
+                        "description": "List products in this lifecycle status instead of published ones",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Lowest product id to list, inclusive",
+                        "name": "min_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Highest product id to list, inclusive; not below min_id",
+                        "name": "max_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Reject unknown query parameters with 400 (always on with STRICT_QUERY_PARAMS)",
+                        "name": "strict",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Answer 404 instead of an empty page when search or attributes match nothing (defaults to EMPTY_FILTER_NOT_FOUND)",
+                        "name": "empty_not_found",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Page size as max=N when limit is not given, e.g. return=representation; max=50; pagination=link answers a bare array with Link and X-Total-Count headers, pagination=body the enveloped form",
+                        "name": "Prefer",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -45,6 +187,226 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/http.listProductsResponse"
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Pagination links (first, prev, next, last) of a bare-array response"
+                            },
+                            "Preference-Applied": {
+                                "type": "string",
+                                "description": "The max=N and pagination preferences that were honored"
+                            },
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "Products matching the filters, for a bare-array response"
+                            },
+                            "X-Total-Count-Approximate": {
+                                "type": "boolean",
+                                "description": "Set when X-Total-Count is the planner's estimate"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Create a new product",
+                "parameters": [
+                    {
+                        "description": "Product data",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.createProductRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Owner the product belongs to; required when owner quotas are on",
+                        "name": "X-Owner-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Answer 200 with the product already holding the name instead of 409",
+                        "name": "create_if_absent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "create_if_absent: the name was taken by this product",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "202": {
+                        "description": "Async create mode: poll the Location header",
+                        "schema": {
+                            "$ref": "#/definitions/jobs.Job"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/bulk": {
+            "post": {
+                "description": "By default (mode=atomic) all products are created or none. With mode=partial each item is created on its own and the response is 207 with a result per item. Either way product_created events are published asynchronously through the outbox.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Create several products at once",
+                "parameters": [
+                    {
+                        "enum": [
+                            "atomic",
+                            "partial"
+                        ],
+                        "type": "string",
+                        "default": "atomic",
+                        "description": "atomic or partial",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Owner the products belong to; required when owner quotas are on",
+                        "name": "X-Owner-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Products",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.createProductsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/http.createProductsResponse"
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "$ref": "#/definitions/http.bulkResultsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
                         }
                     },
                     "500": {
@@ -52,37 +414,675 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/http.errorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Deletes every listed product that exists, all or none, and reports how many were deleted; unknown ids are skipped. product_deleted events are published asynchronously through the outbox. Not available with PRODUCT_ID_TYPE=uuid.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Delete several products at once",
+                "parameters": [
+                    {
+                        "description": "Product IDs",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/http.deleteProductsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/http.deleteProductsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/export": {
+            "get": {
+                "description": "Streams every unexpired product in id order, one JSON object per line or one CSV row each. Rows are read and flushed to the client EXPORT_BATCH_SIZE at a time.",
+                "produces": [
+                    "application/x-ndjson",
+                    "text/csv"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Export all products as a stream",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "ndjson",
+                        "description": "ndjson or csv",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/jobs/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get the status of an async create",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/jobs.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/slug/{slug}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get a product by its slug",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product slug, e.g. iphone-16",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/suggest": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Suggest product names starting with a prefix",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name prefix, matched case-insensitively",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Maximum suggestions (at most 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get a product",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "With dry_run=true nothing is deleted or published; the product is only looked up and the response reports what the delete would do.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Delete a product by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "minimal",
+                            "representation"
+                        ],
+                        "type": "string",
+                        "description": "representation to get the deleted product back with 200",
+                        "name": "return",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "report the impact of the delete without deleting",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "with dry_run=true",
+                        "schema": {
+                            "$ref": "#/definitions/http.deleteImpactResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/archive": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Archive a published product",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The product is not published",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/attributes": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Replace a product's attributes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Attributes",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/position": {
+            "get": {
+                "description": "The position is the product's 1-based index in GET /products without filters (published, unexpired products, newest first), and page is the page of limit products holding it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Find where a product sits in the product list",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Items per page",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.ListPosition"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No such product in the list",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/publish": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Publish a draft product",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The product is not a draft",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/release": {
+            "post": {
+                "description": "Only the holder can release a reservation that has not run out. Releasing a product that is not reserved succeeds too.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Release a product's reservation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Who releases the reservation",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/http.reserveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The product is reserved by someone else",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
                     }
                 }
-            },
+            }
+        },
+        "/products/{id}/replay": {
             "post": {
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "admin"
                 ],
-                "summary": "Create a new product",
+                "summary": "Re-publish a product's current state as a replayed product_created event",
                 "parameters": [
                     {
-                        "description": "Product data",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/http.createProductRequest"
-                        }
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
-                        "schema": {
-                            "$ref": "#/definitions/products.Product"
-                        }
+                    "202": {
+                        "description": "Accepted"
                     },
                     "400": {
                         "description": "Bad Request",
@@ -90,36 +1090,69 @@ const docTemplate = `{
                             "$ref": "#/definitions/http.errorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/http.errorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
                     }
                 }
             }
         },
-        "/products/{id}": {
-            "delete": {
+        "/products/{id}/reserve": {
+            "post": {
+                "description": "Holds the product for reserved_by, or the request's actor, until RESERVATION_TTL has passed or it is released. A product whose reservation has run out can be reserved again; the sweeper releases it and publishes product_reservation_expired.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "products"
                 ],
-                "summary": "Delete a product by ID",
+                "summary": "Reserve a product for a while",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Product ID",
+                        "type": "string",
+                        "description": "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Who holds the reservation",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/http.reserveRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/products.Reservation"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -133,35 +1166,257 @@ const docTemplate = `{
                             "$ref": "#/definitions/http.errorResponse"
                         }
                     },
+                    "409": {
+                        "description": "The product is already reserved",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/http.errorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/http.errorResponse"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "http.bulkItemResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "index": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "public_id": {
+                    "type": "string",
+                    "example": "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "created"
+                }
+            }
+        },
+        "http.bulkResultsResponse": {
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/http.bulkItemResult"
+                    }
+                }
+            }
+        },
         "http.createProductRequest": {
             "type": "object",
             "required": [
                 "name"
             ],
             "properties": {
+                "attributes": {
+                    "type": "object"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt hides the product from reads once it passes.",
+                    "type": "string",
+                    "example": "2026-12-31T23:59:59Z"
+                },
                 "name": {
+                    "description": "max mirrors products.MaxNameLength; the service checks it again.",
                     "type": "string",
+                    "maxLength": 200,
+                    "minLength": 1,
                     "example": "iPhone 16"
+                },
+                "status": {
+                    "description": "Status creates the product as a draft, left out of default lists\nuntil it is published; published when empty.",
+                    "type": "string",
+                    "enum": [
+                        "draft",
+                        "published"
+                    ]
+                }
+            }
+        },
+        "http.createProductsRequest": {
+            "type": "object",
+            "required": [
+                "items"
+            ],
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "$ref": "#/definitions/http.createProductRequest"
+                    }
+                }
+            }
+        },
+        "http.createProductsResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/products.Product"
+                    }
+                }
+            }
+        },
+        "http.deleteImpactResponse": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "would_delete": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "http.deleteProductsRequest": {
+            "type": "object",
+            "required": [
+                "ids"
+            ],
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "http.deleteProductsResponse": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "integer"
                 }
             }
         },
         "http.errorResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "description": "Code identifies errors clients may act on, e.g. DUPLICATE_NAME.",
+                    "type": "string",
+                    "example": "DUPLICATE_NAME"
+                },
                 "error": {
                     "type": "string",
                     "example": "product not found"
+                },
+                "existing_id": {
+                    "description": "ExistingID is the product already holding a duplicate name; with\nPRODUCT_ID_TYPE=uuid ExistingPublicID is set instead.",
+                    "type": "integer",
+                    "example": 1
+                },
+                "existing_public_id": {
+                    "type": "string"
+                },
+                "fields": {
+                    "description": "Fields maps JSON paths of invalid request fields to what is wrong\nwith them.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "http.flushResponse": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "flushed": {
+                    "type": "integer",
+                    "example": 12
+                }
+            }
+        },
+        "http.gcInfo": {
+            "type": "object",
+            "properties": {
+                "heap_alloc_bytes": {
+                    "type": "integer",
+                    "example": 4194304
+                },
+                "last_gc": {
+                    "type": "string"
+                },
+                "next_gc_bytes": {
+                    "type": "integer",
+                    "example": 8388608
+                },
+                "num_gc": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "pause_total_ms": {
+                    "type": "number",
+                    "example": 1.5
+                }
+            }
+        },
+        "http.infoResponse": {
+            "type": "object",
+            "properties": {
+                "commit": {
+                    "description": "Commit is the VCS revision the binary was built from, when known.",
+                    "type": "string",
+                    "example": "9bc7eae0c1f2"
+                },
+                "config": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "gc": {
+                    "$ref": "#/definitions/http.gcInfo"
+                },
+                "go_version": {
+                    "type": "string",
+                    "example": "go1.22.5"
+                },
+                "goroutines": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "uptime_seconds": {
+                    "type": "integer",
+                    "example": 3600
+                },
+                "version": {
+                    "description": "Version is the main module's version, \"(devel)\" for local builds.",
+                    "type": "string",
+                    "example": "(devel)"
                 }
             }
         },
@@ -182,6 +1437,14 @@ const docTemplate = `{
         "http.paginationMeta": {
             "type": "object",
             "properties": {
+                "grand_total": {
+                    "type": "integer",
+                    "example": 4532
+                },
+                "grand_total_approximate": {
+                    "type": "boolean",
+                    "example": false
+                },
                 "limit": {
                     "type": "integer",
                     "example": 10
@@ -191,28 +1454,148 @@ const docTemplate = `{
                     "example": 1
                 },
                 "total": {
+                    "description": "Total counts the products matching the search and attribute\nfilters, the count the pages run over; GrandTotal counts every\nproduct regardless of them. They are equal for an unfiltered list.",
                     "type": "integer",
-                    "example": 42
+                    "example": 12
+                },
+                "total_approximate": {
+                    "description": "TotalApproximate and GrandTotalApproximate mark their count as the\nplanner's estimate (APPROX_COUNT_ABOVE). Only unfiltered counts are\never estimated.",
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "http.reserveRequest": {
+            "type": "object",
+            "properties": {
+                "reserved_by": {
+                    "type": "string",
+                    "example": "checkout-42"
+                }
+            }
+        },
+        "jobs.Job": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Error is what a client is told about a failed create. The queue\nleaves it empty; callers fill it in from Err, which may carry\ninternal details.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "9b2f6c1e-3f4a-4b8e-9d3c-2a1b0c9d8e7f"
+                },
+                "product_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "product_public_id": {
+                    "description": "ProductPublicID is the created product's public id.",
+                    "type": "string",
+                    "example": "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "done"
+                }
+            }
+        },
+        "products.ListPosition": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "page": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "position": {
+                    "type": "integer",
+                    "example": 23
                 }
             }
         },
         "products.Product": {
             "type": "object",
             "properties": {
+                "attributes": {
+                    "type": "object"
+                },
                 "created_at": {
                     "type": "string",
                     "example": "2026-02-24T12:00:00Z"
                 },
+                "expires_at": {
+                    "description": "ExpiresAt, when set, hides the product from reads once it passes.",
+                    "type": "string",
+                    "example": "2026-12-31T23:59:59Z"
+                },
                 "id": {
+                    "description": "ID is the internal id. It is left out of API responses when\nproducts are addressed by PublicID.",
                     "type": "integer",
                     "example": 1
                 },
                 "name": {
                     "type": "string",
                     "example": "iPhone 16"
+                },
+                "owner": {
+                    "type": "string",
+                    "example": "acme"
+                },
+                "public_id": {
+                    "type": "string",
+                    "example": "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+                },
+                "slug": {
+                    "description": "Slug is the URL-friendly, unique form of the name; empty for\nproducts created before slugs were introduced or with them off.",
+                    "type": "string",
+                    "example": "iphone-16"
+                },
+                "status": {
+                    "description": "Status is where the product is in its lifecycle: draft, published\nor archived.",
+                    "type": "string",
+                    "example": "published"
+                },
+                "version": {
+                    "description": "Version starts at 1 and goes up by one with every change to the\nproduct.",
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "products.Reservation": {
+            "type": "object",
+            "properties": {
+                "product_id": {
+                    "description": "ProductID is left out of API responses, in favour of\nProductPublicID, when products are addressed by public id.",
+                    "type": "integer",
+                    "example": 1
+                },
+                "product_public_id": {
+                    "type": "string",
+                    "example": "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+                },
+                "reserved_by": {
+                    "type": "string",
+                    "example": "order-1234"
+                },
+                "reserved_until": {
+                    "type": "string",
+                    "example": "2026-02-24T12:15:00Z"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "description": "\"Bearer \" followed by ADMIN_TOKEN.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -3,21 +3,181 @@ package config
 import (
 	"fmt"
 	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	UnsupportedSchemaSkip       = "skip"
+	UnsupportedSchemaDeadLetter = "dead-letter"
+)
+
+const (
+	defaultMetricsAddr             = ":9091"
+	defaultConsumerBreakerFails    = 5
+	defaultConsumerBreakerWindow   = 30 * time.Second
+	defaultConsumerBreakerCooloff  = 30 * time.Second
+	defaultMetricsShutdownTimeout  = 5 * time.Second
+	defaultKafkaGroupID            = "notifications-service"
+	defaultEventVersionMaxProducts = 100000
 )
 
 type Notifications struct {
-	RabbitMQURL     string
+	RabbitMQURL string
+	// EventTransport is EventTransportRabbitMQ or EventTransportKafka; the
+	// latter consumes KafkaTopic on KafkaBrokers as group KafkaGroupID.
+	EventTransport string
+	KafkaBrokers   []string
+	KafkaTopic     string
+	KafkaGroupID   string
+
 	ShutdownTimeout time.Duration
+	MetricsAddr     string
+	// MetricsShutdownTimeout bounds how long in-flight scrapes may finish
+	// once the metrics server starts shutting down.
+	MetricsShutdownTimeout time.Duration
+
+	// BreakerThreshold consecutive handler failures within BreakerWindow
+	// pause consumption for BreakerCooldown; zero disables the breaker.
+	BreakerThreshold int64
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+
+	// ConsumerExclusive makes the RabbitMQ consumer the queue's only one, so
+	// a second instance fails at startup instead of sharing the load.
+	ConsumerExclusive bool
+
+	// BrokerSetupTimeout bounds the RabbitMQ queue declaration and consume
+	// calls, so a hung broker fails startup instead of stalling it.
+	BrokerSetupTimeout time.Duration
+
+	// AMQPHeartbeat is the heartbeat interval proposed to RabbitMQ, which
+	// keeps a consumer's connection alive while no events arrive.
+	AMQPHeartbeat time.Duration
+
+	// EventMaxStaleness skips, unhandled, events timestamped longer ago
+	// than this; zero handles every event.
+	EventMaxStaleness time.Duration
+
+	// EventVersionCheck skips events whose aggregate version is below one
+	// already handled for the same product, remembering versions for the
+	// EventVersionMaxProducts most recently seen products.
+	EventVersionCheck       bool
+	EventVersionMaxProducts int64
+
+	// EventSigningSecret, when set, verifies the x-signature of every
+	// message and rejects those that do not match (Kafka ones are skipped);
+	// unsigned ones are rejected too if EventSignatureRequired.
+	EventSigningSecret     string
+	EventSignatureRequired bool
+
+	// EventSchemaMin and EventSchemaMax bound the event schema versions
+	// handled. Events outside them are UnsupportedSchemaSkip'ed
+	// (acknowledged unhandled) or UnsupportedSchemaDeadLetter'ed (rejected
+	// without requeueing), as EventSchemaUnsupported says.
+	EventSchemaMin         int64
+	EventSchemaMax         int64
+	EventSchemaUnsupported string
+
+	// UnackedThreshold reports the consumer degraded while the message it
+	// is handling has been unacknowledged for longer; zero disables the
+	// check.
+	UnackedThreshold time.Duration
+
+	// DrainTimeout, on shutdown, lets the RabbitMQ consumer finish the
+	// messages already delivered to it for this long instead of handing
+	// them back; zero hands them back at once. It must leave room within
+	// ShutdownTimeout.
+	DrainTimeout time.Duration
 }
 
 func LoadNotifications() (Notifications, error) {
 	cfg := Notifications{
 		RabbitMQURL:     getEnv("RABBITMQ_URL", ""),
+		EventTransport:  getEnv("EVENT_TRANSPORT", EventTransportRabbitMQ),
+		KafkaBrokers:    getEnvList("KAFKA_BROKERS"),
+		KafkaTopic:      getEnv("KAFKA_TOPIC", defaultKafkaTopic),
+		KafkaGroupID:    getEnv("KAFKA_GROUP_ID", defaultKafkaGroupID),
 		ShutdownTimeout: defaultShutdownTimeout,
+		MetricsAddr:     getEnv("METRICS_ADDR", defaultMetricsAddr),
+
+		EventSigningSecret:     getEnv("EVENT_SIGNING_SECRET", ""),
+		EventSchemaUnsupported: getEnv("EVENT_SCHEMA_UNSUPPORTED", UnsupportedSchemaSkip),
 	}
 
-	if cfg.RabbitMQURL == "" {
-		return Notifications{}, fmt.Errorf("RABBITMQ_URL is required")
+	var err error
+	if cfg.BreakerThreshold, err = getEnvInt64("CONSUMER_BREAKER_THRESHOLD", defaultConsumerBreakerFails); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.BreakerWindow, err = getEnvDuration("CONSUMER_BREAKER_WINDOW", defaultConsumerBreakerWindow); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.BreakerCooldown, err = getEnvDuration("CONSUMER_BREAKER_COOLDOWN", defaultConsumerBreakerCooloff); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.MetricsShutdownTimeout, err = getEnvDuration("METRICS_SHUTDOWN_TIMEOUT", defaultMetricsShutdownTimeout); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.ConsumerExclusive, err = getEnvBool("CONSUMER_EXCLUSIVE", false); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.BrokerSetupTimeout, err = getEnvDuration("BROKER_SETUP_TIMEOUT", defaultBrokerSetup); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.AMQPHeartbeat, err = getEnvDuration("AMQP_HEARTBEAT", defaultAMQPHeartbeat); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventMaxStaleness, err = getEnvDuration("EVENT_MAX_STALENESS", 0); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventVersionCheck, err = getEnvBool("EVENT_VERSION_CHECK", true); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventVersionMaxProducts, err = getEnvInt64("EVENT_VERSION_MAX_PRODUCTS", defaultEventVersionMaxProducts); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventVersionMaxProducts < 1 {
+		return Notifications{}, fmt.Errorf("invalid EVENT_VERSION_MAX_PRODUCTS: must be positive")
+	}
+	if cfg.UnackedThreshold, err = getEnvDuration("CONSUMER_UNACKED_THRESHOLD", 0); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.DrainTimeout, err = getEnvDuration("CONSUMER_DRAIN_TIMEOUT", 0); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.DrainTimeout >= cfg.ShutdownTimeout {
+		return Notifications{}, fmt.Errorf("invalid CONSUMER_DRAIN_TIMEOUT: must be below the %s shutdown timeout", cfg.ShutdownTimeout)
+	}
+	if cfg.EventSignatureRequired, err = getEnvBool("EVENT_SIGNATURE_REQUIRED", false); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventSignatureRequired && cfg.EventSigningSecret == "" {
+		return Notifications{}, fmt.Errorf("invalid EVENT_SIGNATURE_REQUIRED: requires EVENT_SIGNING_SECRET")
+	}
+	if cfg.EventSchemaMin, err = getEnvInt64("EVENT_SCHEMA_MIN", 1); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventSchemaMax, err = getEnvInt64("EVENT_SCHEMA_MAX", products.EventSchemaVersion); err != nil {
+		return Notifications{}, err
+	}
+	if cfg.EventSchemaMin < 1 {
+		return Notifications{}, fmt.Errorf("invalid EVENT_SCHEMA_MIN: must be at least 1")
+	}
+	if cfg.EventSchemaMin > cfg.EventSchemaMax {
+		return Notifications{}, fmt.Errorf("invalid EVENT_SCHEMA_MIN: must not be greater than EVENT_SCHEMA_MAX")
+	}
+	if cfg.EventSchemaUnsupported != UnsupportedSchemaSkip && cfg.EventSchemaUnsupported != UnsupportedSchemaDeadLetter {
+		return Notifications{}, fmt.Errorf("invalid EVENT_SCHEMA_UNSUPPORTED: %q", cfg.EventSchemaUnsupported)
+	}
+
+	if err := validateEventTransport(cfg.EventTransport); err != nil {
+		return Notifications{}, err
+	}
+	if err := validateAMQPHeartbeat(cfg.AMQPHeartbeat); err != nil {
+		return Notifications{}, err
+	}
+	if err := requireTransport(cfg.EventTransport, cfg.RabbitMQURL, cfg.KafkaBrokers); err != nil {
+		return Notifications{}, err
 	}
 
 	return cfg, nil
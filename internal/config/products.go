@@ -2,8 +2,56 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	PublishModeSync  = "sync"
+	PublishModeAsync = "async"
+
+	PublishOverflowBlock = "block"
+	PublishOverflowDrop  = "drop"
+	PublishOverflowSpill = "spill"
+
+	CreateModeSync  = "sync"
+	CreateModeAsync = "async"
+
+	EventFormatNative      = "native"
+	EventFormatCloudEvents = "cloudevents"
+
+	ProductIDTypeInt  = "int"
+	ProductIDTypeUUID = "uuid"
+
+	OutboxRelayParallel = "parallel"
+	OutboxRelayLeader   = "leader"
+
+	EventTransportRabbitMQ = "rabbitmq"
+	EventTransportKafka    = "kafka"
+
+	DeliveryModePersistent = "persistent"
+	DeliveryModeTransient  = "transient"
+
+	SlugSuffixCounter = "counter"
+	SlugSuffixRandom  = "random"
+
+	ListPaginationBody = "body"
+	ListPaginationLink = "link"
+
+	SearchModeSubstring = "substring"
+	SearchModeRanked    = "ranked"
+
+	AuditSinkNone     = "none"
+	AuditSinkFile     = "file"
+	AuditSinkPostgres = "postgres"
 )
 
 const (
@@ -16,10 +64,46 @@ const (
 	defaultDBConnMaxLifetime = 5 * time.Minute
 	defaultDBPingTimeout     = 5 * time.Second
 	defaultReadHeaderTimeout = 5 * time.Second
+	defaultSelfTestTimeout   = 5 * time.Second
+	defaultSlowRequest       = time.Second
+	defaultAccessLogSample   = 1
+	defaultWebhookTimeout    = 2 * time.Second
+	defaultPublishBufferSize = 1024
+	defaultPublishSpillCap   = 10000
+	defaultPublishBatchChunk = 100
+	defaultSlugSeparator     = "-"
+	defaultRateLimitTier     = "free"
+	defaultSlugMaxLength     = 80
+	defaultCoalesceMaxBatch  = 100
+	defaultReadOnlyRetry     = 30 * time.Second
+	defaultListCacheTTL      = 5 * time.Second
+	defaultOutboxInterval    = time.Second
+	defaultOutboxBatchSize   = 100
+	defaultExpirySweep       = 30 * time.Second
+	defaultReservationTTL    = 15 * time.Minute
+	defaultReservationSweep  = 30 * time.Second
+	defaultCreateQueueSize   = 1024
+	defaultCreateWorkers     = 4
+	defaultEventSource       = "/products"
+	defaultSuggestMinPrefix  = 2
+	defaultKafkaTopic        = "products.events"
+	defaultBrokerSetup       = 10 * time.Second
+	defaultAMQPHeartbeat     = 10 * time.Second
+	// minAMQPHeartbeat is the shortest heartbeat AMQP can express; the
+	// interval is negotiated in whole seconds.
+	minAMQPHeartbeat         = time.Second
+	defaultNameMinLength     = 1
+	defaultExportBatchSize   = 500
+	defaultExportConcurrency = 4
+
+	defaultRetryAfterOverloaded  = time.Second
+	defaultRetryAfterReadOnly    = 30 * time.Second
+	defaultRetryAfterUnavailable = 5 * time.Second
 )
 
 type Products struct {
 	DatabaseURL        string
+	DatabaseReplicaURL string
 	RabbitMQURL        string
 	HTTPAddr           string
 	MigrationsPath     string
@@ -28,12 +112,298 @@ type Products struct {
 	DBMaxIdleConns     int
 	DBConnMaxLifetime  time.Duration
 	DBPingTimeout      time.Duration
-	ReadHeaderTimeout  time.Duration
+	// DBWarmUp opens DBWarmUpConns connections to each database before
+	// the server starts serving; DBWarmUpConns defaults to DBMaxIdleConns.
+	DBWarmUp          bool
+	DBWarmUpConns     int64
+	ReadHeaderTimeout time.Duration
+	PublishMandatory  bool
+	SelfTest          bool
+	SelfTestStrict    bool
+	SelfTestTimeout   time.Duration
+	SlowRequest       time.Duration
+	WebhookURL        string
+	WebhookTimeout    time.Duration
+
+	// HealthFailureThreshold failed checks in a row turn /healthz
+	// unhealthy, and HealthSuccessThreshold passing ones healthy again, so
+	// a flapping database does not toggle it on every check.
+	HealthFailureThreshold int64
+	HealthSuccessThreshold int64
+
+	// FeatureFlags lists the products.KnownFeatureFlags clients may opt
+	// into per request with the X-Feature-Flags header; empty ignores the
+	// header.
+	FeatureFlags []products.FeatureFlag
+
+	// MigrateOnStart applies pending migrations at startup. When false
+	// they are left to be run out of band, and startup fails if the schema
+	// is behind the newest migration in MigrationsPath.
+	MigrateOnStart bool
+
+	// AccessLogSampleRate logs one in this many fast 2xx requests; 0 or
+	// 1 logs every request.
+	AccessLogSampleRate int64
+
+	// ServerTiming adds a Server-Timing header with the request's db and
+	// total time to every response.
+	ServerTiming bool
+
+	// DisableEvents runs without RabbitMQ: nothing is published and
+	// RABBITMQ_URL is not required.
+	DisableEvents bool
+
+	// EventTransport is EventTransportRabbitMQ or EventTransportKafka; the
+	// latter publishes to KafkaTopic on KafkaBrokers instead of RabbitMQ.
+	EventTransport string
+	KafkaBrokers   []string
+	KafkaTopic     string
+
+	// MaxConcurrentRequests caps in-flight HTTP requests; zero disables
+	// the limit.
+	MaxConcurrentRequests int64
+
+	// RateLimitTiers are the requests per second and burst allowed to each
+	// client of a tier. APIKeys puts the client sending a key in its tier;
+	// other clients get RateLimitDefaultTier per IP. No tiers disables
+	// rate limiting.
+	RateLimitTiers       map[string]RateTier
+	RateLimitDefaultTier string
+	APIKeys              map[string]string
+
+	// TrustedProxies are the IPs and CIDRs whose X-Forwarded-For is
+	// believed when working out a client's IP, for access logs and the
+	// default rate limit tier. Empty trusts no proxy: the peer address is
+	// the client.
+	TrustedProxies []string
+
+	// RetryAfterOverloaded, RetryAfterReadOnly and RetryAfterUnavailable
+	// are the Retry-After delays of 503s caused by load (the concurrency
+	// limit, a full create queue), by read-only mode, and by anything
+	// else.
+	RetryAfterOverloaded  time.Duration
+	RetryAfterReadOnly    time.Duration
+	RetryAfterUnavailable time.Duration
+
+	PublishMode           string
+	PublishBufferSize     int64
+	PublishBufferOverflow string
+
+	// PublishSpillPath is the file async mode overflows events to with
+	// PUBLISH_BUFFER_OVERFLOW=spill, holding at most PublishSpillCapacity.
+	PublishSpillPath     string
+	PublishSpillCapacity int64
+
+	// EventCoalesceWindow, when non-zero, merges product_created events
+	// published within it into products_created_batch events of at most
+	// EventCoalesceMaxBatch products.
+	EventCoalesceWindow   time.Duration
+	EventCoalesceMaxBatch int64
+
+	// PublishBatchChunkSize is how many events a batch publish, such as the
+	// outbox relay's, sends per confirm window.
+	PublishBatchChunkSize int64
+
+	// PublishCompressAbove gzips event bodies larger than this many bytes;
+	// zero disables compression.
+	PublishCompressAbove int64
+
+	// PublishDeliveryMode is DeliveryModePersistent, so RabbitMQ writes
+	// events to disk and they survive a broker restart, or
+	// DeliveryModeTransient, which trades that for throughput.
+	PublishDeliveryMode string
+
+	// PublishMessageTTL makes RabbitMQ drop events left unconsumed this
+	// long; zero keeps them until consumed.
+	PublishMessageTTL time.Duration
+
+	// PublishQueueCheckInterval, when non-zero, checks this often that
+	// the events queue still exists and declares it again if it was
+	// deleted while the service runs.
+	PublishQueueCheckInterval time.Duration
+
+	// PublishExchange, when set, publishes through this RabbitMQ topic
+	// exchange under keys rendered from PublishRoutingKey, empty for the
+	// publisher's default, instead of straight to the queue.
+	PublishExchange   string
+	PublishRoutingKey string
+
+	// BrokerSetupTimeout bounds each RabbitMQ call made while setting up
+	// the publisher, so a hung broker fails startup instead of stalling it.
+	BrokerSetupTimeout time.Duration
+
+	// AMQPHeartbeat is the heartbeat interval proposed to RabbitMQ, short
+	// enough that NATs and load balancers do not drop an idle connection.
+	AMQPHeartbeat time.Duration
+
+	// PublishLogPayloadMax caps how many bytes of each published payload
+	// are logged at debug level; zero keeps the publisher's default.
+	// PublishLogRedact names JSON fields masked in those logs.
+	PublishLogPayloadMax int64
+	PublishLogRedact     []string
+
+	// EventFormat is EventFormatNative or EventFormatCloudEvents; the
+	// latter wraps events in a CloudEvents envelope with EventSource as
+	// its source attribute.
+	EventFormat string
+	EventSource string
+
+	// EventOldValues makes product_updated events carry each changed
+	// field's old value as well as its new one.
+	EventOldValues bool
+
+	// EventOrdering publishes each product's events in the order its
+	// changes were committed, even when requests race on it. The order
+	// holds only within one instance and with coalescing off.
+	EventOrdering bool
+
+	// EventSigningSecret, when set, signs every event body with HMAC-SHA256
+	// in the x-signature header, on RabbitMQ and Kafka alike.
+	EventSigningSecret string
+
+	// ProblemDetails answers every error as RFC 7807
+	// application/problem+json; otherwise only requests accepting it get
+	// that format.
+	ProblemDetails bool
+
+	// MetricsEnabled registers the service's metrics with Prometheus and
+	// serves them on GET /metrics.
+	MetricsEnabled bool
+
+	LogLevel slog.Level
+
+	NameCaseInsensitive bool
+	// SearchNormalized matches searches case- and accent-insensitively
+	// against the search_name column.
+	SearchNormalized bool
+	// NameStripPattern is a regular expression whose matches are removed
+	// from product names before they are stored; empty leaves names as
+	// given.
+	NameStripPattern string
+
+	// SlugEnabled gives every new product a unique slug derived from its
+	// name: SlugSeparator between words, at most SlugMaxLength characters
+	// (zero for no cap) before the suffix SlugSuffix adds on collisions.
+	SlugEnabled   bool
+	SlugSeparator string
+	SlugMaxLength int64
+	SlugSuffix    string
+	// AuditSink is where every mutation is recorded: AuditSinkNone,
+	// AuditSinkFile (JSON lines appended to AuditFilePath) or
+	// AuditSinkPostgres (the audit_log table). AuditStrict rolls back a
+	// mutation whose record cannot be written instead of only logging it.
+	AuditSink     string
+	AuditFilePath string
+	AuditStrict   bool
+	// NameMinLength is the shortest product name accepted, in characters
+	// after normalization.
+	NameMinLength int64
+
+	// ExportBatchSize is how many products GET /products/export reads per
+	// query and streams between flushes.
+	ExportBatchSize int64
+	// ExportMaxConcurrent caps concurrent export streams; zero is
+	// unlimited.
+	ExportMaxConcurrent int64
+
+	// ApproxCountAbove switches unfiltered list totals to the planner's
+	// estimate once the table holds about this many rows; zero disables.
+	ApproxCountAbove int64
+
+	// SearchStatementTimeout caps each statement of a filtered list;
+	// zero leaves the database default in place.
+	SearchStatementTimeout time.Duration
+
+	// SuggestMinPrefix is the shortest prefix name suggestions search for.
+	SuggestMinPrefix int64
+
+	// ProductIDType is ProductIDTypeInt or ProductIDTypeUUID, the latter
+	// addressing products in paths by their public UUID.
+	ProductIDType string
+
+	// OwnerQuota caps products per owner; zero is unlimited.
+	// OwnerQuotaOverrides replaces it for individual owners.
+	OwnerQuota          int64
+	OwnerQuotaOverrides map[string]int64
+
+	// RequestTimeout is the deadline given to each request's context;
+	// RouteTimeouts overrides it per gin route template. Zero means none.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+
+	// ReadOnly refuses every write. ReadOnlyAfterFailures, when non-zero,
+	// refuses writes for ReadOnlyRetry once that many publishes in a row
+	// have failed.
+	ReadOnly              bool
+	ReadOnlyAfterFailures int64
+	ReadOnlyRetry         time.Duration
+
+	// NameDenylist and NameDenylistPatterns reject product names with
+	// 422: terms as whole words, patterns as regular expressions, both
+	// ignoring case.
+	NameDenylist         []string
+	NameDenylistPatterns []string
+
+	// DeleteChunkSize is how many ids each bulk delete statement removes;
+	// zero keeps the repository's default.
+	DeleteChunkSize int64
+
+	// StrictQueryParams rejects unknown query parameters on list requests
+	// with 400 instead of ignoring them.
+	StrictQueryParams bool
+	// EmptyFilterNotFound answers 404 instead of an empty page when a
+	// filtered list matches nothing.
+	EmptyFilterNotFound bool
+	// ListPagination link lists products as a bare array with Link and
+	// X-Total-Count headers; body keeps the pagination envelope.
+	ListPagination string
+	// SearchMode ranked orders searches by full-text relevance unless the
+	// request sets rank=false; substring keeps the plain name match
+	// unless it sets rank=true.
+	SearchMode string
+
+	// AdminToken guards admin endpoints; empty leaves them unregistered.
+	AdminToken string
+
+	// ListCacheSize caps cached list/count results; zero disables the
+	// cache.
+	ListCacheSize int64
+	ListCacheTTL  time.Duration
+
+	OutboxInterval  time.Duration
+	OutboxBatchSize int64
+	// OutboxRelayMode leader lets only the instance holding a Postgres
+	// advisory lock relay the outbox; parallel lets every instance relay.
+	OutboxRelayMode string
+
+	// ExpirySweepInterval is how often the sweeper looks for products
+	// whose expires_at has passed, to publish product_expired for them.
+	ExpirySweepInterval time.Duration
+
+	// ReservationTTL is how long POST /products/:id/reserve holds a
+	// product; every ReservationSweepInterval a sweeper releases the
+	// reservations that ran out and publishes
+	// product_reservation_expired for them.
+	ReservationTTL           time.Duration
+	ReservationSweepInterval time.Duration
+
+	// HeartbeatInterval, when non-zero, publishes a products_heartbeat
+	// event this often. HeartbeatInstanceID names this instance in it;
+	// empty means the hostname.
+	HeartbeatInterval   time.Duration
+	HeartbeatInstanceID string
+
+	// CreateMode async makes POST /products queue creates for
+	// CreateWorkers background workers and answer 202.
+	CreateMode      string
+	CreateQueueSize int64
+	CreateWorkers   int64
 }
 
 func LoadProducts() (Products, error) {
 	cfg := Products{
 		DatabaseURL:        getEnv("DATABASE_URL", ""),
+		DatabaseReplicaURL: getEnv("DATABASE_REPLICA_URL", ""),
 		RabbitMQURL:        getEnv("RABBITMQ_URL", ""),
 		HTTPAddr:           getEnv("HTTP_ADDR", defaultHTTPAddr),
 		MigrationsPath:     getEnv("MIGRATIONS_PATH", defaultMigrationsPath),
@@ -43,18 +413,400 @@ func LoadProducts() (Products, error) {
 		DBConnMaxLifetime:  defaultDBConnMaxLifetime,
 		DBPingTimeout:      defaultDBPingTimeout,
 		ReadHeaderTimeout:  defaultReadHeaderTimeout,
+		WebhookURL:         getEnv("CREATE_WEBHOOK_URL", ""),
+
+		PublishMode:           getEnv("PUBLISH_MODE", PublishModeSync),
+		PublishBufferOverflow: getEnv("PUBLISH_BUFFER_OVERFLOW", PublishOverflowBlock),
+		PublishDeliveryMode:   getEnv("PUBLISH_DELIVERY_MODE", DeliveryModePersistent),
+		PublishSpillPath:      getEnv("PUBLISH_SPILL_PATH", ""),
+		PublishExchange:       getEnv("PUBLISH_EXCHANGE", ""),
+		PublishRoutingKey:     getEnv("PUBLISH_ROUTING_KEY", ""),
+
+		EventFormat:        getEnv("EVENT_FORMAT", EventFormatNative),
+		EventSource:        getEnv("EVENT_SOURCE", defaultEventSource),
+		EventSigningSecret: getEnv("EVENT_SIGNING_SECRET", ""),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+		CreateMode: getEnv("CREATE_MODE", CreateModeSync),
+
+		RateLimitDefaultTier: getEnv("RATE_LIMIT_DEFAULT_TIER", defaultRateLimitTier),
+
+		ListPagination: getEnv("LIST_PAGINATION", ListPaginationBody),
+		SearchMode:     getEnv("SEARCH_MODE", SearchModeSubstring),
+
+		SlugSeparator: getEnv("SLUG_SEPARATOR", defaultSlugSeparator),
+		SlugSuffix:    getEnv("SLUG_SUFFIX", SlugSuffixCounter),
+
+		AuditSink:     getEnv("AUDIT_SINK", AuditSinkNone),
+		AuditFilePath: getEnv("AUDIT_FILE_PATH", ""),
+
+		OutboxRelayMode: getEnv("OUTBOX_RELAY_MODE", OutboxRelayParallel),
+
+		HeartbeatInstanceID: getEnv("HEARTBEAT_INSTANCE_ID", ""),
+
+		NameStripPattern: getEnv("NAME_STRIP_PATTERN", ""),
+		ProductIDType:    getEnv("PRODUCT_ID_TYPE", ProductIDTypeInt),
+
+		EventTransport: getEnv("EVENT_TRANSPORT", EventTransportRabbitMQ),
+		KafkaBrokers:   getEnvList("KAFKA_BROKERS"),
+		KafkaTopic:     getEnv("KAFKA_TOPIC", defaultKafkaTopic),
+	}
+
+	var err error
+	if cfg.MigrateOnStart, err = getEnvBool("MIGRATE_ON_START", true); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishMandatory, err = getEnvBool("RABBITMQ_PUBLISH_MANDATORY", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.DisableEvents, err = getEnvBool("DISABLE_EVENTS", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SelfTest, err = getEnvBool("SELF_TEST", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SelfTestStrict, err = getEnvBool("SELF_TEST_STRICT", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SelfTestTimeout, err = getEnvDuration("SELF_TEST_TIMEOUT", defaultSelfTestTimeout); err != nil {
+		return Products{}, err
+	}
+	if cfg.SlowRequest, err = getEnvDuration("SLOW_REQUEST_THRESHOLD", defaultSlowRequest); err != nil {
+		return Products{}, err
+	}
+	if cfg.AccessLogSampleRate, err = getEnvInt64("ACCESS_LOG_SAMPLE_RATE", defaultAccessLogSample); err != nil {
+		return Products{}, err
+	}
+	if cfg.ServerTiming, err = getEnvBool("SERVER_TIMING", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.WebhookTimeout, err = getEnvDuration("CREATE_WEBHOOK_TIMEOUT", defaultWebhookTimeout); err != nil {
+		return Products{}, err
+	}
+	if cfg.MaxConcurrentRequests, err = getEnvInt64("MAX_CONCURRENT_REQUESTS", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.RateLimitTiers, err = parseRateTiers(getEnv("RATE_LIMIT_TIERS", "")); err != nil {
+		return Products{}, fmt.Errorf("invalid RATE_LIMIT_TIERS: %w", err)
+	}
+	if cfg.APIKeys, err = parseAPIKeys(getEnv("API_KEYS", ""), cfg.RateLimitTiers); err != nil {
+		return Products{}, fmt.Errorf("invalid API_KEYS: %w", err)
+	}
+	if len(cfg.RateLimitTiers) > 0 {
+		if _, ok := cfg.RateLimitTiers[cfg.RateLimitDefaultTier]; !ok {
+			return Products{}, fmt.Errorf("invalid RATE_LIMIT_DEFAULT_TIER: no tier %q in RATE_LIMIT_TIERS", cfg.RateLimitDefaultTier)
+		}
+	}
+	if cfg.RetryAfterOverloaded, err = getEnvDuration("RETRY_AFTER_OVERLOADED", defaultRetryAfterOverloaded); err != nil {
+		return Products{}, err
+	}
+	if cfg.RetryAfterReadOnly, err = getEnvDuration("RETRY_AFTER_READ_ONLY", defaultRetryAfterReadOnly); err != nil {
+		return Products{}, err
+	}
+	if cfg.RetryAfterUnavailable, err = getEnvDuration("RETRY_AFTER_UNAVAILABLE", defaultRetryAfterUnavailable); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishBufferSize, err = getEnvInt64("PUBLISH_BUFFER_SIZE", defaultPublishBufferSize); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishSpillCapacity, err = getEnvInt64("PUBLISH_SPILL_CAPACITY", defaultPublishSpillCap); err != nil {
+		return Products{}, err
+	}
+	if cfg.EventCoalesceWindow, err = getEnvDuration("EVENT_COALESCE_WINDOW", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.EventCoalesceMaxBatch, err = getEnvInt64("EVENT_COALESCE_MAX_BATCH", defaultCoalesceMaxBatch); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishCompressAbove, err = getEnvInt64("PUBLISH_COMPRESS_ABOVE", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.ListCacheSize, err = getEnvInt64("LIST_CACHE_SIZE", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.ListCacheTTL, err = getEnvDuration("LIST_CACHE_TTL", defaultListCacheTTL); err != nil {
+		return Products{}, err
+	}
+	if cfg.OutboxInterval, err = getEnvDuration("OUTBOX_POLL_INTERVAL", defaultOutboxInterval); err != nil {
+		return Products{}, err
+	}
+	if cfg.OutboxBatchSize, err = getEnvInt64("OUTBOX_BATCH_SIZE", defaultOutboxBatchSize); err != nil {
+		return Products{}, err
+	}
+	if cfg.ExpirySweepInterval, err = getEnvDuration("EXPIRY_SWEEP_INTERVAL", defaultExpirySweep); err != nil {
+		return Products{}, err
+	}
+	if cfg.ReservationTTL, err = getEnvDuration("RESERVATION_TTL", defaultReservationTTL); err != nil {
+		return Products{}, err
+	}
+	if cfg.ReservationSweepInterval, err = getEnvDuration("RESERVATION_SWEEP_INTERVAL", defaultReservationSweep); err != nil {
+		return Products{}, err
+	}
+	if cfg.HeartbeatInterval, err = getEnvDuration("HEARTBEAT_INTERVAL", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.CreateQueueSize, err = getEnvInt64("CREATE_QUEUE_SIZE", defaultCreateQueueSize); err != nil {
+		return Products{}, err
+	}
+	if cfg.CreateWorkers, err = getEnvInt64("CREATE_WORKERS", defaultCreateWorkers); err != nil {
+		return Products{}, err
+	}
+	if cfg.ApproxCountAbove, err = getEnvInt64("APPROX_COUNT_ABOVE", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.DeleteChunkSize, err = getEnvInt64("DELETE_CHUNK_SIZE", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.ExportBatchSize, err = getEnvInt64("EXPORT_BATCH_SIZE", defaultExportBatchSize); err != nil {
+		return Products{}, err
+	}
+	if cfg.ExportMaxConcurrent, err = getEnvInt64("EXPORT_MAX_CONCURRENT", defaultExportConcurrency); err != nil {
+		return Products{}, err
+	}
+	if cfg.NameMinLength, err = getEnvInt64("NAME_MIN_LENGTH", defaultNameMinLength); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishMessageTTL, err = getEnvDuration("PUBLISH_MESSAGE_TTL", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishQueueCheckInterval, err = getEnvDuration("PUBLISH_QUEUE_CHECK_INTERVAL", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishBatchChunkSize, err = getEnvInt64("PUBLISH_BATCH_CHUNK_SIZE", defaultPublishBatchChunk); err != nil {
+		return Products{}, err
+	}
+	if cfg.DBWarmUp, err = getEnvBool("DB_WARMUP", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.DBWarmUpConns, err = getEnvInt64("DB_WARMUP_CONNS", int64(cfg.DBMaxIdleConns)); err != nil {
+		return Products{}, err
+	}
+	if cfg.HealthFailureThreshold, err = getEnvInt64("HEALTH_FAILURE_THRESHOLD", 1); err != nil {
+		return Products{}, err
+	}
+	if cfg.HealthSuccessThreshold, err = getEnvInt64("HEALTH_SUCCESS_THRESHOLD", 1); err != nil {
+		return Products{}, err
+	}
+	if cfg.BrokerSetupTimeout, err = getEnvDuration("BROKER_SETUP_TIMEOUT", defaultBrokerSetup); err != nil {
+		return Products{}, err
+	}
+	if cfg.AMQPHeartbeat, err = getEnvDuration("AMQP_HEARTBEAT", defaultAMQPHeartbeat); err != nil {
+		return Products{}, err
+	}
+	if cfg.PublishLogPayloadMax, err = getEnvInt64("PUBLISH_LOG_PAYLOAD_MAX", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.StrictQueryParams, err = getEnvBool("STRICT_QUERY_PARAMS", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.EmptyFilterNotFound, err = getEnvBool("EMPTY_FILTER_NOT_FOUND", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.EventOldValues, err = getEnvBool("EVENT_OLD_VALUES", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.EventOrdering, err = getEnvBool("EVENT_ORDERING", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.MetricsEnabled, err = getEnvBool("METRICS_ENABLED", true); err != nil {
+		return Products{}, err
+	}
+	if cfg.ProblemDetails, err = getEnvBool("PROBLEM_DETAILS", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.AuditStrict, err = getEnvBool("AUDIT_STRICT", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SearchStatementTimeout, err = getEnvDuration("SEARCH_STATEMENT_TIMEOUT", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.SuggestMinPrefix, err = getEnvInt64("SUGGEST_MIN_PREFIX", defaultSuggestMinPrefix); err != nil {
+		return Products{}, err
+	}
+	if cfg.OwnerQuota, err = getEnvInt64("OWNER_QUOTA", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.OwnerQuotaOverrides, err = parseQuotaOverrides(getEnv("OWNER_QUOTA_OVERRIDES", "")); err != nil {
+		return Products{}, fmt.Errorf("invalid OWNER_QUOTA_OVERRIDES: %w", err)
+	}
+	if cfg.RequestTimeout, err = getEnvDuration("REQUEST_TIMEOUT", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.RouteTimeouts, err = parseRouteTimeouts(getEnv("REQUEST_TIMEOUTS", "")); err != nil {
+		return Products{}, fmt.Errorf("invalid REQUEST_TIMEOUTS: %w", err)
+	}
+	if cfg.NameCaseInsensitive, err = getEnvBool("NAME_CASE_INSENSITIVE", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SearchNormalized, err = getEnvBool("SEARCH_NORMALIZED", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SlugEnabled, err = getEnvBool("SLUG_ENABLED", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.SlugMaxLength, err = getEnvInt64("SLUG_MAX_LENGTH", defaultSlugMaxLength); err != nil {
+		return Products{}, err
+	}
+	if cfg.ReadOnly, err = getEnvBool("READ_ONLY", false); err != nil {
+		return Products{}, err
+	}
+	if cfg.ReadOnlyAfterFailures, err = getEnvInt64("READ_ONLY_AFTER_FAILURES", 0); err != nil {
+		return Products{}, err
+	}
+	if cfg.ReadOnlyRetry, err = getEnvDuration("READ_ONLY_RETRY", defaultReadOnlyRetry); err != nil {
+		return Products{}, err
+	}
+	cfg.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return Products{}, fmt.Errorf("invalid TRUSTED_PROXIES: %q is not an IP or CIDR", proxy)
+			}
+		}
+	}
+	cfg.NameDenylist = getEnvList("NAME_DENYLIST")
+	cfg.PublishLogRedact = getEnvList("PUBLISH_LOG_REDACT")
+	if cfg.FeatureFlags, err = getEnvFeatureFlags("FEATURE_FLAGS"); err != nil {
+		return Products{}, err
+	}
+	if path := getEnv("NAME_DENYLIST_FILE", ""); path != "" {
+		terms, patterns, err := readNameDenylist(path)
+		if err != nil {
+			return Products{}, fmt.Errorf("invalid NAME_DENYLIST_FILE: %w", err)
+		}
+		cfg.NameDenylist = append(cfg.NameDenylist, terms...)
+		cfg.NameDenylistPatterns = patterns
+	}
+	if err := cfg.LogLevel.UnmarshalText([]byte(getEnv("LOG_LEVEL", slog.LevelInfo.String()))); err != nil {
+		return Products{}, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	if cfg.PublishMode != PublishModeSync && cfg.PublishMode != PublishModeAsync {
+		return Products{}, fmt.Errorf("invalid PUBLISH_MODE: %q", cfg.PublishMode)
+	}
+	if _, err := regexp.Compile(cfg.NameStripPattern); err != nil {
+		return Products{}, fmt.Errorf("invalid NAME_STRIP_PATTERN: %w", err)
+	}
+	// AMQP expirations are whole milliseconds; less would expire at once.
+	if cfg.PublishMessageTTL > 0 && cfg.PublishMessageTTL < time.Millisecond {
+		return Products{}, fmt.Errorf("invalid PUBLISH_MESSAGE_TTL: must be at least 1ms")
+	}
+	if cfg.PublishRoutingKey != "" && cfg.PublishExchange == "" {
+		return Products{}, fmt.Errorf("invalid PUBLISH_ROUTING_KEY: requires PUBLISH_EXCHANGE")
+	}
+	if cfg.ExportBatchSize == 0 {
+		return Products{}, fmt.Errorf("invalid EXPORT_BATCH_SIZE: must be positive")
+	}
+	if cfg.NameMinLength > products.MaxNameLength {
+		return Products{}, fmt.Errorf("invalid NAME_MIN_LENGTH: %d exceeds the maximum name length %d", cfg.NameMinLength, products.MaxNameLength)
+	}
+	if cfg.CreateMode != CreateModeSync && cfg.CreateMode != CreateModeAsync {
+		return Products{}, fmt.Errorf("invalid CREATE_MODE: %q", cfg.CreateMode)
+	}
+	switch cfg.AuditSink {
+	case AuditSinkNone, AuditSinkPostgres:
+	case AuditSinkFile:
+		if cfg.AuditFilePath == "" {
+			return Products{}, fmt.Errorf("invalid AUDIT_SINK: file requires AUDIT_FILE_PATH")
+		}
+	default:
+		return Products{}, fmt.Errorf("invalid AUDIT_SINK: %q", cfg.AuditSink)
+	}
+	if cfg.SlugSuffix != SlugSuffixCounter && cfg.SlugSuffix != SlugSuffixRandom {
+		return Products{}, fmt.Errorf("invalid SLUG_SUFFIX: %q", cfg.SlugSuffix)
+	}
+	if cfg.SlugSeparator != "-" && cfg.SlugSeparator != "_" && cfg.SlugSeparator != "." {
+		return Products{}, fmt.Errorf("invalid SLUG_SEPARATOR: %q, want one of - _ .", cfg.SlugSeparator)
+	}
+	if cfg.ListPagination != ListPaginationBody && cfg.ListPagination != ListPaginationLink {
+		return Products{}, fmt.Errorf("invalid LIST_PAGINATION: %q", cfg.ListPagination)
+	}
+	if cfg.SearchMode != SearchModeSubstring && cfg.SearchMode != SearchModeRanked {
+		return Products{}, fmt.Errorf("invalid SEARCH_MODE: %q", cfg.SearchMode)
+	}
+	if cfg.OutboxRelayMode != OutboxRelayParallel && cfg.OutboxRelayMode != OutboxRelayLeader {
+		return Products{}, fmt.Errorf("invalid OUTBOX_RELAY_MODE: %q", cfg.OutboxRelayMode)
+	}
+	if cfg.PublishBufferOverflow != PublishOverflowBlock && cfg.PublishBufferOverflow != PublishOverflowDrop && cfg.PublishBufferOverflow != PublishOverflowSpill {
+		return Products{}, fmt.Errorf("invalid PUBLISH_BUFFER_OVERFLOW: %q", cfg.PublishBufferOverflow)
+	}
+	if cfg.PublishBufferOverflow == PublishOverflowSpill {
+		if cfg.PublishSpillPath == "" {
+			return Products{}, fmt.Errorf("invalid PUBLISH_BUFFER_OVERFLOW: spill requires PUBLISH_SPILL_PATH")
+		}
+		if cfg.PublishSpillCapacity == 0 {
+			return Products{}, fmt.Errorf("invalid PUBLISH_SPILL_CAPACITY: must be positive")
+		}
+	}
+	if cfg.PublishBatchChunkSize == 0 {
+		return Products{}, fmt.Errorf("invalid PUBLISH_BATCH_CHUNK_SIZE: must be positive")
+	}
+	if err := validateAMQPHeartbeat(cfg.AMQPHeartbeat); err != nil {
+		return Products{}, err
+	}
+	if cfg.DBWarmUpConns > int64(cfg.DBMaxIdleConns) {
+		return Products{}, fmt.Errorf("invalid DB_WARMUP_CONNS: must not exceed the %d idle connections the pool keeps", cfg.DBMaxIdleConns)
+	}
+	if cfg.HealthFailureThreshold == 0 {
+		return Products{}, fmt.Errorf("invalid HEALTH_FAILURE_THRESHOLD: must be at least 1")
+	}
+	if cfg.HealthSuccessThreshold == 0 {
+		return Products{}, fmt.Errorf("invalid HEALTH_SUCCESS_THRESHOLD: must be at least 1")
+	}
+	if cfg.PublishDeliveryMode != DeliveryModePersistent && cfg.PublishDeliveryMode != DeliveryModeTransient {
+		return Products{}, fmt.Errorf("invalid PUBLISH_DELIVERY_MODE: %q", cfg.PublishDeliveryMode)
+	}
+	if cfg.EventFormat != EventFormatNative && cfg.EventFormat != EventFormatCloudEvents {
+		return Products{}, fmt.Errorf("invalid EVENT_FORMAT: %q", cfg.EventFormat)
+	}
+	if cfg.ProductIDType != ProductIDTypeInt && cfg.ProductIDType != ProductIDTypeUUID {
+		return Products{}, fmt.Errorf("invalid PRODUCT_ID_TYPE: %q", cfg.ProductIDType)
+	}
+	if err := validateEventTransport(cfg.EventTransport); err != nil {
+		return Products{}, err
 	}
 
 	if cfg.DatabaseURL == "" {
 		return Products{}, fmt.Errorf("DATABASE_URL is required")
 	}
-	if cfg.RabbitMQURL == "" {
-		return Products{}, fmt.Errorf("RABBITMQ_URL is required")
+	if !cfg.DisableEvents {
+		if err := requireTransport(cfg.EventTransport, cfg.RabbitMQURL, cfg.KafkaBrokers); err != nil {
+			return Products{}, err
+		}
 	}
 
 	return cfg, nil
 }
 
+func validateEventTransport(transport string) error {
+	if transport != EventTransportRabbitMQ && transport != EventTransportKafka {
+		return fmt.Errorf("invalid EVENT_TRANSPORT: %q", transport)
+	}
+	return nil
+}
+
+// validateAMQPHeartbeat rejects a heartbeat under a second, which the AMQP
+// client would silently replace with the broker's interval.
+func validateAMQPHeartbeat(heartbeat time.Duration) error {
+	if heartbeat < minAMQPHeartbeat {
+		return fmt.Errorf("invalid AMQP_HEARTBEAT: must be at least %s", minAMQPHeartbeat)
+	}
+	return nil
+}
+
+// requireTransport checks that the settings transport needs are present.
+func requireTransport(transport, rabbitMQURL string, kafkaBrokers []string) error {
+	if transport == EventTransportKafka {
+		if len(kafkaBrokers) == 0 {
+			return fmt.Errorf("KAFKA_BROKERS is required")
+		}
+		return nil
+	}
+	if rabbitMQURL == "" {
+		return fmt.Errorf("RABBITMQ_URL is required")
+	}
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -62,3 +814,194 @@ func getEnv(key, fallback string) string {
 	}
 	return value
 }
+
+// getEnvFeatureFlags reads a comma-separated list of feature flags,
+// rejecting any the service does not know.
+func getEnvFeatureFlags(key string) ([]products.FeatureFlag, error) {
+	var flags []products.FeatureFlag
+	for _, name := range getEnvList(key) {
+		flag := products.FeatureFlag(strings.ToLower(name))
+		if !slices.Contains(products.KnownFeatureFlags, flag) {
+			return nil, fmt.Errorf("invalid %s: unknown flag %q", key, name)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if parsed <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be positive", key)
+	}
+	return parsed, nil
+}
+
+func getEnvInt64(key string, fallback int64) (int64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return parsed, nil
+}
+
+// parseQuotaOverrides reads a comma-separated list of owner=limit pairs.
+func parseQuotaOverrides(raw string) (map[string]int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	overrides := make(map[string]int64)
+	for _, pair := range strings.Split(raw, ",") {
+		owner, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || owner == "" {
+			return nil, fmt.Errorf("want owner=limit, got %q", pair)
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("limit for %q must be a non-negative integer", owner)
+		}
+		overrides[owner] = limit
+	}
+	return overrides, nil
+}
+
+// RateTier is one of RATE_LIMIT_TIERS.
+type RateTier struct {
+	RPS   float64
+	Burst int
+}
+
+// parseRateTiers reads a comma-separated list of tier=rps:burst entries,
+// such as free=5:10.
+func parseRateTiers(raw string) (map[string]RateTier, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	tiers := make(map[string]RateTier)
+	for _, entry := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		rps, burst, hasBurst := strings.Cut(value, ":")
+		if !ok || name == "" || !hasBurst {
+			return nil, fmt.Errorf("want tier=rps:burst, got %q", entry)
+		}
+		r, err := strconv.ParseFloat(rps, 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("rps for %q must be a positive number", name)
+		}
+		b, err := strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("burst for %q must be at least 1", name)
+		}
+		tiers[name] = RateTier{RPS: r, Burst: b}
+	}
+	return tiers, nil
+}
+
+// parseAPIKeys reads a comma-separated list of key=tier pairs, each tier
+// one of tiers.
+func parseAPIKeys(raw string, tiers map[string]RateTier) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string]string)
+	for i, pair := range strings.Split(raw, ",") {
+		key, tier, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			// The entry is not echoed: it holds a key.
+			return nil, fmt.Errorf("want key=tier in entry %d", i+1)
+		}
+		if _, ok := tiers[tier]; !ok {
+			return nil, fmt.Errorf("no tier %q in RATE_LIMIT_TIERS", tier)
+		}
+		keys[key] = tier
+	}
+	return keys, nil
+}
+
+// parseRouteTimeouts reads a comma-separated list of route=duration pairs,
+// where route is a gin route template such as /products/:id.
+func parseRouteTimeouts(raw string) (map[string]time.Duration, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(raw, ",") {
+		route, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("want /route=duration, got %q", pair)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout for %q must be a positive duration", route)
+		}
+		timeouts[route] = d
+	}
+	return timeouts, nil
+}
+
+// readNameDenylist reads one denied term per line. Lines wrapped in slashes,
+// such as /^free\s/, are regular expressions; blank lines and lines starting
+// with # are skipped. Patterns are compiled here so a bad one stops startup.
+func readNameDenylist(path string) (terms, patterns []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			pattern := line[1 : len(line)-1]
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			patterns = append(patterns, pattern)
+		default:
+			terms = append(terms, line)
+		}
+	}
+	return terms, patterns, nil
+}
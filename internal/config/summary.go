@@ -0,0 +1,78 @@
+package config
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// redacted stands in for secrets in Summary.
+const redacted = "[redacted]"
+
+// Summary lists the settings worth knowing when debugging a running
+// instance, keyed by environment variable. Passwords in URLs are masked,
+// the webhook URL shows only its scheme and host, secrets only show whether
+// they are set and API keys are only counted.
+func (c Products) Summary() map[string]string {
+	return map[string]string{
+		"HTTP_ADDR":               c.HTTPAddr,
+		"DATABASE_URL":            redactURL(c.DatabaseURL),
+		"DATABASE_REPLICA_URL":    redactURL(c.DatabaseReplicaURL),
+		"RABBITMQ_URL":            redactURL(c.RabbitMQURL),
+		"EVENT_TRANSPORT":         c.EventTransport,
+		"KAFKA_BROKERS":           strings.Join(c.KafkaBrokers, ","),
+		"DISABLE_EVENTS":          strconv.FormatBool(c.DisableEvents),
+		"PUBLISH_MODE":            c.PublishMode,
+		"EVENT_FORMAT":            c.EventFormat,
+		"CREATE_MODE":             c.CreateMode,
+		"CREATE_WEBHOOK_URL":      redactEndpoint(c.WebhookURL),
+		"PRODUCT_ID_TYPE":         c.ProductIDType,
+		"SEARCH_MODE":             c.SearchMode,
+		"LIST_PAGINATION":         c.ListPagination,
+		"READ_ONLY":               strconv.FormatBool(c.ReadOnly),
+		"MAX_CONCURRENT_REQUESTS": strconv.FormatInt(c.MaxConcurrentRequests, 10),
+		"RATE_LIMIT_DEFAULT_TIER": c.RateLimitDefaultTier,
+		"OUTBOX_RELAY_MODE":       c.OutboxRelayMode,
+		"EVENT_SIGNING_SECRET":    redactSecret(c.EventSigningSecret),
+		"ADMIN_TOKEN":             redactSecret(c.AdminToken),
+		"API_KEYS":                strconv.Itoa(len(c.APIKeys)),
+	}
+}
+
+// redactURL masks the password of a URL. Anything that does not parse as
+// one, such as a key=value Postgres DSN, is masked whole.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+	// Credentials can also be passed as query parameters.
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.Redacted()
+}
+
+// redactEndpoint reduces a URL to its scheme and host. Webhook URLs often
+// carry their token in the path, as Slack's do, where redactURL would leave
+// it.
+func redactEndpoint(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
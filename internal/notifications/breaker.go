@@ -0,0 +1,36 @@
+package notifications
+
+import "time"
+
+// breaker counts consecutive handler failures. It trips once threshold
+// failures land within window of the first one; a success, or a failure
+// after the window has passed, starts the count over.
+type breaker struct {
+	threshold int
+	window    time.Duration
+
+	failures int
+	first    time.Time
+}
+
+func (b *breaker) success() {
+	b.failures = 0
+}
+
+// failure records a failed message and reports whether the breaker tripped.
+// A zero threshold never trips.
+func (b *breaker) failure(now time.Time) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	if b.failures == 0 || now.Sub(b.first) > b.window {
+		b.failures = 0
+		b.first = now
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return false
+	}
+	b.failures = 0
+	return true
+}
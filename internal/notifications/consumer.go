@@ -1,98 +1,694 @@
 package notifications
 
 import (
+	"container/list"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/messaging"
 
+	"github.com/prometheus/client_golang/prometheus"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 const consumerTag = "notifications-service"
 
+// ErrQueueInUse is returned by Listen when the broker refuses the consumer
+// because another one holds the queue exclusively, or because this one asked
+// for exclusivity while others are attached.
+var ErrQueueInUse = errors.New("queue is already consumed by another instance")
+
+// errUnsupportedSchema is returned by handle for an event outside the
+// supported schema range when such events are dead-lettered.
+var errUnsupportedSchema = errors.New("unsupported event schema version")
+
+// amqpChannel is the subset of *amqp.Channel the consumer uses, so tests can
+// substitute a fake.
+type amqpChannel interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Close() error
+}
+
+// queueDeclarer is split from amqpChannel because only NewConsumer
+// declares.
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+}
+
+type BreakerConfig struct {
+	// Threshold is how many consecutive handler failures within Window
+	// pause consumption; zero disables the breaker.
+	Threshold int
+	Window    time.Duration
+	// Cooldown is how long consumption stays paused once tripped.
+	Cooldown time.Duration
+}
+
 type Consumer struct {
-	channel *amqp.Channel
-	queue   string
-	logger  *slog.Logger
+	eventHandler
+
+	channel   amqpChannel
+	queue     string
+	exclusive bool
+	// setupTimeout bounds QueueDeclare and Consume; zero waits forever.
+	setupTimeout time.Duration
+	// drainTimeout, when positive, has Listen handle the deliveries
+	// already buffered when ctx is done, for up to this long.
+	drainTimeout time.Duration
+
+	breaker     breaker
+	cooldown    time.Duration
+	breakerOpen prometheus.Gauge
+	paused      atomic.Bool
+	inFlight    inFlightClock
+
+	// held is set by Pause and cleared by Resume; holdChanged wakes Listen
+	// when it flips. heldGauge, when set, mirrors it.
+	held        atomic.Bool
+	holdChanged chan struct{}
+	heldGauge   prometheus.Gauge
+}
+
+// eventHandler decodes and handles event messages, whichever transport
+// delivered them.
+type eventHandler struct {
+	logger    *slog.Logger
+	eventAge  prometheus.Observer
+	clockSkew prometheus.Counter
+
+	// signingSecret, when set, rejects messages whose HeaderSignature does
+	// not match their body, and unsigned ones too if signatureRequired;
+	// badSignature counts them.
+	signingSecret     []byte
+	signatureRequired bool
+	badSignature      prometheus.Counter
+
+	// maxStaleness, when positive, skips events older than it; stale
+	// counts them.
+	maxStaleness time.Duration
+	stale        prometheus.Counter
+
+	// versions, when set, drops events older than the product's latest
+	// handled version; outOfOrder counts them.
+	versions   *versionTracker
+	outOfOrder prometheus.Counter
+
+	// minSchema and maxSchema, when set, bound the schema versions
+	// handled; other events are acknowledged unhandled, or rejected if
+	// deadLetterSchema, and counted in unsupportedSchema.
+	minSchema         int
+	maxSchema         int
+	deadLetterSchema  bool
+	unsupportedSchema prometheus.Counter
+}
+
+// inFlightClock remembers when the message being handled was received,
+// until it is acknowledged. Consumers handle one message at a time, so that
+// message is always the oldest unacknowledged one.
+type inFlightClock struct {
+	since atomic.Int64 // unix nanoseconds; zero when idle
+}
+
+func (c *inFlightClock) start(now time.Time) {
+	c.since.Store(now.UnixNano())
+}
+
+func (c *inFlightClock) stop() {
+	c.since.Store(0)
+}
+
+// age is how long the current message has been in flight at now, or zero
+// when there is none.
+func (c *inFlightClock) age(now time.Time) time.Duration {
+	since := c.since.Load()
+	if since == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, since))
+}
+
+// versionTracker remembers the highest aggregate version handled per
+// product, for at most max products. Past that the least recently seen
+// product is forgotten, so a late event for it is handled again; only a
+// product idle while max others came and went can be affected.
+type versionTracker struct {
+	mu      sync.Mutex
+	max     int
+	latest  map[int64]*list.Element
+	recency *list.List // of *versionEntry, most recently seen first
+}
+
+type versionEntry struct {
+	productID, version int64
+}
+
+func newVersionTracker(max int) *versionTracker {
+	return &versionTracker{max: max, latest: make(map[int64]*list.Element), recency: list.New()}
+}
+
+// accept records version for productID and reports whether it is at least
+// the highest seen so far. Equal versions pass, so replays and redeliveries
+// are handled again.
+func (t *versionTracker) accept(productID, version int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.latest[productID]; ok {
+		t.recency.MoveToFront(el)
+		entry := el.Value.(*versionEntry)
+		if version < entry.version {
+			return false
+		}
+		entry.version = version
+		return true
+	}
+
+	t.latest[productID] = t.recency.PushFront(&versionEntry{productID: productID, version: version})
+	for t.recency.Len() > t.max {
+		oldest := t.recency.Back()
+		t.recency.Remove(oldest)
+		delete(t.latest, oldest.Value.(*versionEntry).productID)
+	}
+	return true
+}
+
+type Option func(*Consumer)
+
+// WithBreaker pauses consumption for cfg.Cooldown after cfg.Threshold
+// consecutive failures, instead of redelivering failing messages in a hot
+// loop. open is set to 1 while paused.
+func WithBreaker(cfg BreakerConfig, open prometheus.Gauge) Option {
+	return func(c *Consumer) {
+		c.breaker = breaker{threshold: cfg.Threshold, window: cfg.Window}
+		c.cooldown = cfg.Cooldown
+		c.breakerOpen = open
+	}
+}
+
+// WithEventAge observes, for every event handled, the seconds between the
+// event's timestamp and now. Timestamps in the future (clock skew between
+// producer and consumer) are observed as zero and counted in clockSkew.
+func WithEventAge(age prometheus.Observer, clockSkew prometheus.Counter) Option {
+	return func(c *Consumer) {
+		c.eventAge = age
+		c.clockSkew = clockSkew
+	}
+}
+
+// WithMaxStaleness acknowledges events timestamped more than window ago
+// without handling them and counts them in stale, e.g. a product_deleted
+// that waited in the queue while the consumer was down. Events without a
+// timestamp are always handled.
+func WithMaxStaleness(window time.Duration, stale prometheus.Counter) Option {
+	return func(c *Consumer) {
+		c.maxStaleness = window
+		c.stale = stale
+	}
 }
 
-func NewConsumer(conn *amqp.Connection, queue string, logger *slog.Logger) (*Consumer, error) {
+// WithVersionCheck acknowledges, without handling, events whose
+// aggregate_version is below one already handled for the same product, and
+// counts them in outOfOrder. Events without a version are always handled.
+// Versions are remembered for the maxProducts most recently seen products.
+func WithVersionCheck(maxProducts int, outOfOrder prometheus.Counter) Option {
+	return func(c *Consumer) {
+		c.versions = newVersionTracker(maxProducts)
+		c.outOfOrder = outOfOrder
+	}
+}
+
+// WithSchemaRange handles only events whose schema version is between min
+// and max inclusive, so an instance that predates a new layout does not
+// act on a partial reading of it. Other events are acknowledged without
+// handling, or, with deadLetter, rejected without requeueing so the broker
+// dead-letters them; either way they are counted in unsupported. Kafka has
+// no rejection, so there they are always skipped.
+func WithSchemaRange(min, max int, deadLetter bool, unsupported prometheus.Counter) Option {
+	return func(c *Consumer) {
+		c.minSchema = min
+		c.maxSchema = max
+		c.deadLetterSchema = deadLetter
+		c.unsupportedSchema = unsupported
+	}
+}
+
+// WithExclusive consumes the queue exclusively, so a second instance fails
+// with ErrQueueInUse instead of sharing the messages round-robin.
+func WithExclusive() Option {
+	return func(c *Consumer) {
+		c.exclusive = true
+	}
+}
+
+// WithSetupTimeout fails the queue declaration and each Consume call with
+// messaging.ErrSetupTimeout if the broker has not answered within timeout,
+// rather than blocking startup forever.
+func WithSetupTimeout(timeout time.Duration) Option {
+	return func(c *Consumer) {
+		c.setupTimeout = timeout
+	}
+}
+
+// WithShutdownDrain makes Listen, once its context is done, cancel the
+// consumer so the broker sends nothing more, then finish and acknowledge
+// the deliveries it had already sent, for up to timeout. Deliveries left
+// after that are requeued. Without it Listen returns at once and the
+// broker redelivers everything unacknowledged.
+func WithShutdownDrain(timeout time.Duration) Option {
+	return func(c *Consumer) {
+		c.drainTimeout = timeout
+	}
+}
+
+// WithSignatureCheck verifies each message's HMAC signature under secret
+// before handling it. Messages with a wrong signature, or with none when
+// required is set, are counted in invalid and not handled: RabbitMQ
+// messages are rejected without requeueing, so the broker dead-letters them
+// if the queue has a dead-letter exchange, and Kafka messages are skipped.
+func WithSignatureCheck(secret []byte, required bool, invalid prometheus.Counter) Option {
+	return func(c *Consumer) {
+		c.signingSecret = secret
+		c.signatureRequired = required
+		c.badSignature = invalid
+	}
+}
+
+// WithPauseGauge sets held to 1 while consumption is paused by Pause.
+func WithPauseGauge(held prometheus.Gauge) Option {
+	return func(c *Consumer) {
+		c.heldGauge = held
+	}
+}
+
+func NewConsumer(conn *amqp.Connection, queue string, logger *slog.Logger, opts ...Option) (*Consumer, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("open channel: %w", err)
 	}
 
-	_, err = ch.QueueDeclare(
-		queue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
+	c := newConsumer(ch, queue, logger, opts...)
+	if err := c.declare(ch); err != nil {
+		// Closing the channel also unblocks a declare that timed out.
 		_ = ch.Close()
-		return nil, fmt.Errorf("declare queue %q: %w", queue, err)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Consumer) declare(ch queueDeclarer) error {
+	_, err := messaging.CallWithTimeout(c.setupTimeout, func() (amqp.Queue, error) {
+		return ch.QueueDeclare(
+			c.queue,
+			true,
+			false,
+			false,
+			false,
+			nil,
+		)
+	})
+	if err != nil {
+		return fmt.Errorf("declare queue %q: %w", c.queue, err)
+	}
+	return nil
+}
+
+func newConsumer(ch amqpChannel, queue string, logger *slog.Logger, opts ...Option) *Consumer {
+	c := &Consumer{
+		eventHandler: eventHandler{logger: logger},
+		channel:      ch,
+		queue:        queue,
+		holdChanged:  make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
+}
+
+// Paused reports whether consumption is paused, by the breaker or by Pause.
+func (c *Consumer) Paused() bool {
+	return c.paused.Load() || c.held.Load()
+}
+
+// OldestUnacked is how long the message being handled has gone without
+// an acknowledgement, or zero when the consumer is idle. A value that keeps
+// growing means the handler is stuck.
+func (c *Consumer) OldestUnacked() time.Duration {
+	return c.inFlight.age(time.Now())
+}
+
+// Pause stops consumption until Resume: the message being handled, if any,
+// is finished and acknowledged, then the consumer is cancelled and the
+// deliveries still buffered are handed back to the broker.
+func (c *Consumer) Pause() {
+	c.setHeld(true)
+}
 
-	return &Consumer{
-		channel: ch,
-		queue:   queue,
-		logger:  logger,
-	}, nil
+// Resume subscribes to the queue again after Pause.
+func (c *Consumer) Resume() {
+	c.setHeld(false)
+}
+
+func (c *Consumer) setHeld(held bool) {
+	if c.held.Swap(held) == held {
+		return
+	}
+	if c.heldGauge != nil {
+		value := 0.0
+		if held {
+			value = 1
+		}
+		c.heldGauge.Set(value)
+	}
+	select {
+	case c.holdChanged <- struct{}{}:
+	default:
+	}
 }
 
 func (c *Consumer) Listen(ctx context.Context) error {
-	msgs, err := c.channel.Consume(
-		c.queue,
-		consumerTag,
-		false, // manual ack
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("consume queue %q: %w", c.queue, err)
+	for {
+		if !c.waitResumed(ctx) {
+			return nil
+		}
+
+		msgs, err := messaging.CallWithTimeout(c.setupTimeout, func() (<-chan amqp.Delivery, error) {
+			return c.channel.Consume(
+				c.queue,
+				consumerTag,
+				false, // manual ack
+				c.exclusive,
+				false,
+				false,
+				nil,
+			)
+		})
+		if err != nil {
+			return c.consumeError(err)
+		}
+
+		switch c.consume(ctx, msgs) {
+		case stopDone:
+			if ctx.Err() != nil && c.drainTimeout > 0 {
+				return c.drain(msgs)
+			}
+			return nil
+		case stopHeld:
+			if err := c.cancel(msgs); err != nil {
+				return err
+			}
+			c.logger.Info("consumer paused")
+		case stopBreaker:
+			if err := c.coolOff(ctx, msgs); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
 	}
+}
+
+// waitResumed blocks while the consumer is held by Pause, and reports false
+// if ctx was done first.
+func (c *Consumer) waitResumed(ctx context.Context) bool {
+	for c.held.Load() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.holdChanged:
+		}
+	}
+	return true
+}
+
+// consumeError explains an access-refused consume, which RabbitMQ answers
+// when exclusivity is in conflict, and wraps any other error as is.
+func (c *Consumer) consumeError(err error) error {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.AccessRefused {
+		return fmt.Errorf("consume queue %q: %w (%s)", c.queue, ErrQueueInUse, amqpErr.Reason)
+	}
+	return fmt.Errorf("consume queue %q: %w", c.queue, err)
+}
+
+// stopReason is why consume returned.
+type stopReason int
+
+const (
+	// stopDone: ctx is done or the delivery channel closed.
+	stopDone stopReason = iota
+	// stopBreaker: the breaker tripped.
+	stopBreaker
+	// stopHeld: Pause was called.
+	stopHeld
+)
 
+// consume handles deliveries until ctx is done, the channel closes, the
+// breaker trips or the consumer is paused. A delivery being handled is
+// always finished first.
+func (c *Consumer) consume(ctx context.Context, msgs <-chan amqp.Delivery) stopReason {
 	for {
+		if c.held.Load() {
+			return stopHeld
+		}
+
 		select {
 		case <-ctx.Done():
-			return nil
+			return stopDone
+		case <-c.holdChanged:
+			continue
 		case msg, ok := <-msgs:
 			if !ok {
-				return nil
+				return stopDone
+			}
+			if c.deliver(msg) {
+				return stopBreaker
 			}
+		}
+	}
+}
+
+// deliver handles msg and acknowledges, rejects or requeues it, and
+// reports whether a handler failure tripped the breaker.
+func (c *Consumer) deliver(msg amqp.Delivery) (tripped bool) {
+	c.inFlight.start(time.Now())
+	defer c.inFlight.stop()
+
+	if err := c.verify(msg.Headers, msg.Body); err != nil {
+		c.badSignature.Inc()
+		c.logger.Warn("rejecting message with invalid signature", "message_id", msg.MessageId, "error", err)
+		_ = msg.Nack(false, false)
+		return false
+	}
+	err := c.handleMessage(&msg)
+	if errors.Is(err, errUnsupportedSchema) {
+		_ = msg.Nack(false, false)
+		return false
+	}
+	if err != nil {
+		c.logger.Error("handle message failed", "error", err)
+		_ = msg.Nack(false, true)
+		return c.breaker.failure(time.Now())
+	}
+
+	c.breaker.success()
+	_ = msg.Ack(false)
+	return false
+}
+
+// drain cancels the consumer on shutdown and handles the deliveries the
+// broker already sent until none are left or drainTimeout has passed, then
+// hands the rest back. Unlike cancel, which requeues everything buffered,
+// it spares them a redelivery to another instance after the restart.
+func (c *Consumer) drain(msgs <-chan amqp.Delivery) error {
+	if err := c.channel.Cancel(consumerTag, false); err != nil {
+		return fmt.Errorf("cancel consumer: %w", err)
+	}
 
-			if err := c.handleMessage(&msg); err != nil {
-				c.logger.Error("handle message failed", "error", err)
+	timer := time.NewTimer(c.drainTimeout)
+	defer timer.Stop()
+	drained := 0
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				c.logger.Info("consumer drained", "handled", drained)
+				return nil
+			}
+			// The breaker no longer matters: nothing is consumed after
+			// this.
+			c.deliver(msg)
+			drained++
+		case <-timer.C:
+			returned := 0
+			for msg := range msgs {
 				_ = msg.Nack(false, true)
-				continue
+				returned++
 			}
-
-			_ = msg.Ack(false)
+			c.logger.Warn("consumer drain timed out", "handled", drained, "requeued", returned)
+			return nil
 		}
 	}
 }
 
+// cancel cancels the consumer and hands back any deliveries still buffered
+// on msgs.
+func (c *Consumer) cancel(msgs <-chan amqp.Delivery) error {
+	if err := c.channel.Cancel(consumerTag, false); err != nil {
+		return fmt.Errorf("cancel consumer: %w", err)
+	}
+	for msg := range msgs {
+		_ = msg.Nack(false, true)
+	}
+	return nil
+}
+
+// coolOff cancels the consumer and waits out the cooldown (or ctx) before
+// Listen consumes again.
+func (c *Consumer) coolOff(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	if err := c.cancel(msgs); err != nil {
+		return err
+	}
+
+	c.logger.Warn("consumer paused after repeated handler failures", "cooldown", c.cooldown.String())
+	c.breakerOpen.Set(1)
+	c.paused.Store(true)
+	defer func() {
+		c.breakerOpen.Set(0)
+		c.paused.Store(false)
+	}()
+
+	timer := time.NewTimer(c.cooldown)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		c.logger.Info("consumer resumed")
+	}
+	return nil
+}
+
+// verify checks the signature of a message body, as sent, when a signing
+// secret is configured. Unsigned messages pass unless signatures are
+// required.
+func (h *eventHandler) verify(headers amqp.Table, body []byte) error {
+	if len(h.signingSecret) == 0 {
+		return nil
+	}
+	err := messaging.VerifySignature(h.signingSecret, headers, body)
+	if errors.Is(err, messaging.ErrUnsigned) && !h.signatureRequired {
+		return nil
+	}
+	return err
+}
+
 func (c *Consumer) handleMessage(msg *amqp.Delivery) error {
-	var event products.ProductEvent
-	if err := json.Unmarshal(msg.Body, &event); err != nil {
-		return fmt.Errorf("unmarshal event: %w", err)
+	return c.handle(msg.ContentType, msg.ContentEncoding, msg.Body)
+}
+
+func (h *eventHandler) handle(contentType, contentEncoding string, raw []byte) error {
+	body, err := messaging.DecodeBody(contentEncoding, raw)
+	if err != nil {
+		return err
+	}
+
+	event, err := messaging.DecodeEvent(contentType, body)
+	if err != nil {
+		return err
+	}
+
+	if !h.supportsSchema(event.Schema()) {
+		h.unsupportedSchema.Inc()
+		h.logger.Warn("skipping event with unsupported schema version",
+			"event_type", event.EventType,
+			"product_id", event.ProductID,
+			"schema_version", event.Schema(),
+			"dead_letter", h.deadLetterSchema,
+		)
+		if h.deadLetterSchema {
+			return fmt.Errorf("%w: %d", errUnsupportedSchema, event.Schema())
+		}
+		return nil
+	}
+
+	if event.EventType == products.EventCreatedBatch {
+		for _, created := range event.Products {
+			h.handleEvent(created)
+		}
+		return nil
+	}
+	h.handleEvent(event)
+	return nil
+}
+
+// handleEvent handles one product's event, after its message has been
+// decoded and its schema checked. The product_created events of a
+// products_created_batch are handled one by one, each against its own
+// product's version.
+func (h *eventHandler) handleEvent(event products.ProductEvent) {
+	h.observeAge(event.Timestamp)
+
+	if h.isStale(event.Timestamp) {
+		h.stale.Inc()
+		h.logger.Warn("skipping stale event",
+			"event_type", event.EventType,
+			"product_id", event.ProductID,
+			"timestamp", event.Timestamp,
+			"max_staleness", h.maxStaleness,
+		)
+		return
 	}
 
-	c.logger.Info("notification event",
+	if h.versions != nil && event.AggregateVersion > 0 && !h.versions.accept(event.ProductID, event.AggregateVersion) {
+		h.outOfOrder.Inc()
+		h.logger.Warn("skipping out-of-order event",
+			"event_type", event.EventType,
+			"product_id", event.ProductID,
+			"aggregate_version", event.AggregateVersion,
+		)
+		return
+	}
+
+	h.logger.Info("notification event",
 		"event_type", event.EventType,
 		"product_id", event.ProductID,
 		"name", event.Name,
 		"timestamp", event.Timestamp,
+		"aggregate_version", event.AggregateVersion,
 	)
+}
 
-	return nil
+func (h *eventHandler) observeAge(ts time.Time) {
+	if h.eventAge == nil || ts.IsZero() {
+		return
+	}
+
+	age := time.Since(ts)
+	if age < 0 {
+		h.clockSkew.Inc()
+		age = 0
+	}
+	h.eventAge.Observe(age.Seconds())
+}
+
+// supportsSchema reports whether version is within the configured range.
+func (h *eventHandler) supportsSchema(version int) bool {
+	if h.unsupportedSchema == nil {
+		return true
+	}
+	return version >= h.minSchema && version <= h.maxSchema
+}
+
+func (h *eventHandler) isStale(ts time.Time) bool {
+	return h.maxStaleness > 0 && !ts.IsZero() && time.Since(ts) > h.maxStaleness
 }
 
 func (c *Consumer) Close() error {
@@ -0,0 +1,747 @@
+package notifications
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products/messaging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type countingAcknowledger struct {
+	mu    sync.Mutex
+	acks  int
+	nacks int
+	// dropped counts the nacks that did not requeue.
+	dropped int
+}
+
+func (a *countingAcknowledger) Ack(uint64, bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acks++
+	return nil
+}
+
+func (a *countingAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacks++
+	if !requeue {
+		a.dropped++
+	}
+	return nil
+}
+
+func (a *countingAcknowledger) Reject(uint64, bool) error { return nil }
+
+func (a *countingAcknowledger) counts() (acks, nacks int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.acks, a.nacks
+}
+
+// fakeChannel hands out each batch in pending on successive Consume calls,
+// already buffered, and closes the current delivery channel on Cancel.
+type fakeChannel struct {
+	mu        sync.Mutex
+	pending   [][]amqp.Delivery
+	current   chan amqp.Delivery
+	consumes  chan struct{}
+	exclusive bool
+	err       error
+}
+
+func (f *fakeChannel) Consume(_, _ string, _, exclusive, _, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.exclusive = exclusive
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	ch := make(chan amqp.Delivery, 16)
+	if len(f.pending) > 0 {
+		for _, d := range f.pending[0] {
+			ch <- d
+		}
+		f.pending = f.pending[1:]
+	}
+	f.current = ch
+	f.consumes <- struct{}{}
+	return ch, nil
+}
+
+func (f *fakeChannel) Cancel(string, bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.current)
+	return nil
+}
+
+func (f *fakeChannel) Close() error { return nil }
+
+func TestConsumer_BreakerPausesAfterConsecutiveFailures(t *testing.T) {
+	ack := &countingAcknowledger{}
+	bad := amqp.Delivery{Acknowledger: ack, Body: []byte("not json")}
+	good := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"event_type":"product_created","product_id":1}`)}
+
+	ch := &fakeChannel{
+		pending:  [][]amqp.Delivery{{bad, bad, bad, good}},
+		consumes: make(chan struct{}, 2),
+	}
+	open := prometheus.NewGauge(prometheus.GaugeOpts{Name: "t_breaker_open", Help: "t"})
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	consumer := newConsumer(ch, "q", logger, WithBreaker(BreakerConfig{
+		Threshold: 3,
+		Window:    time.Minute,
+		Cooldown:  100 * time.Millisecond,
+	}, open))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	<-ch.consumes
+	waitFor(t, consumer.Paused)
+	if got := testutil.ToFloat64(open); got != 1 {
+		t.Fatalf("want breaker gauge 1 while paused, got %v", got)
+	}
+	// Three failures nacked by the handler path, plus the good message that
+	// was still buffered when the breaker tripped, handed back by the drain.
+	if acks, nacks := ack.counts(); acks != 0 || nacks != 4 {
+		t.Fatalf("want 0 acks and 4 nacks while paused, got %d and %d", acks, nacks)
+	}
+
+	select {
+	case <-ch.consumes:
+	case <-time.After(time.Second):
+		t.Fatal("consumer never resumed after the cooldown")
+	}
+	waitFor(t, func() bool { return !consumer.Paused() })
+	if got := testutil.ToFloat64(open); got != 0 {
+		t.Fatalf("want breaker gauge 0 after resuming, got %v", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConsumer_PauseAndResume(t *testing.T) {
+	ack := &countingAcknowledger{}
+	good := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"event_type":"product_created","product_id":1}`)}
+
+	ch := &fakeChannel{
+		pending:  [][]amqp.Delivery{{good}, {good}},
+		consumes: make(chan struct{}, 2),
+	}
+	held := prometheus.NewGauge(prometheus.GaugeOpts{Name: "t_paused", Help: "t"})
+	consumer := newConsumer(ch, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)), WithPauseGauge(held))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	<-ch.consumes
+	waitFor(t, func() bool { acks, _ := ack.counts(); return acks == 1 })
+
+	consumer.Pause()
+	waitFor(t, consumer.Paused)
+	if got := testutil.ToFloat64(held); got != 1 {
+		t.Fatalf("want paused gauge 1 while paused, got %v", got)
+	}
+	select {
+	case <-ch.consumes:
+		t.Fatal("want no new subscription while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	consumer.Resume()
+	select {
+	case <-ch.consumes:
+	case <-time.After(time.Second):
+		t.Fatal("consumer never subscribed again after Resume")
+	}
+	waitFor(t, func() bool { acks, _ := ack.counts(); return acks == 2 })
+	if consumer.Paused() {
+		t.Fatal("want the consumer running after Resume")
+	}
+	if got := testutil.ToFloat64(held); got != 0 {
+		t.Fatalf("want paused gauge 0 after resuming, got %v", got)
+	}
+	if _, nacks := ack.counts(); nacks != 0 {
+		t.Fatalf("want no nacks, got %d", nacks)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// stuckAcknowledger blocks every Ack until release is closed, like a
+// handler hung on an external call.
+type stuckAcknowledger struct {
+	countingAcknowledger
+	release chan struct{}
+}
+
+func (a *stuckAcknowledger) Ack(tag uint64, multiple bool) error {
+	<-a.release
+	return a.countingAcknowledger.Ack(tag, multiple)
+}
+
+func TestConsumer_OldestUnacked(t *testing.T) {
+	ack := &stuckAcknowledger{release: make(chan struct{})}
+	msg := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"event_type":"product_created","product_id":1}`)}
+	ch := &fakeChannel{pending: [][]amqp.Delivery{{msg}}, consumes: make(chan struct{}, 1)}
+	consumer := newConsumer(ch, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	if got := consumer.OldestUnacked(); got != 0 {
+		t.Fatalf("want 0 before any message, got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	<-ch.consumes
+	waitFor(t, func() bool { return consumer.OldestUnacked() > 20*time.Millisecond })
+
+	close(ack.release)
+	waitFor(t, func() bool { return consumer.OldestUnacked() == 0 })
+	if acks, _ := ack.counts(); acks != 1 {
+		t.Fatalf("want the message acked, got %d acks", acks)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConsumer_ShutdownDrain(t *testing.T) {
+	ack := &stuckAcknowledger{release: make(chan struct{})}
+	msg := amqp.Delivery{Acknowledger: ack, Body: []byte(`{"event_type":"product_created","product_id":1}`)}
+	ch := &fakeChannel{pending: [][]amqp.Delivery{{msg, msg, msg}}, consumes: make(chan struct{}, 1)}
+	consumer := newConsumer(ch, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)), WithShutdownDrain(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	<-ch.consumes
+	// Shut down while the first message is being handled and the other two
+	// are still buffered.
+	waitFor(t, func() bool { return consumer.OldestUnacked() > 0 })
+	cancel()
+	close(ack.release)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Listen did not return after draining")
+	}
+	if acks, nacks := ack.counts(); acks != 3 || nacks != 0 {
+		t.Fatalf("want all 3 buffered messages acked, got %d acks and %d nacks", acks, nacks)
+	}
+}
+
+func TestConsumer_HandleMessage_ContentEncoding(t *testing.T) {
+	event := []byte(`{"event_type":"product_created","product_id":1}`)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write(event)
+	_ = zw.Close()
+
+	tests := []struct {
+		name    string
+		msg     amqp.Delivery
+		wantErr bool
+	}{
+		{
+			name: "plain body",
+			msg:  amqp.Delivery{Body: event},
+		},
+		{
+			name: "gzipped body",
+			msg:  amqp.Delivery{ContentEncoding: messaging.ContentEncodingGzip, Body: gzipped.Bytes()},
+		},
+		{
+			name: "cloudevents envelope",
+			msg: amqp.Delivery{
+				ContentType: "application/cloudevents+json",
+				Body:        []byte(`{"specversion":"1.0","type":"product_created","source":"/products","id":"1","data":{"event_type":"product_created","product_id":1}}`),
+			},
+		},
+		{
+			name:    "malformed body",
+			msg:     amqp.Delivery{Body: []byte("not json")},
+			wantErr: true,
+		},
+		{
+			name:    "unknown encoding",
+			msg:     amqp.Delivery{ContentEncoding: "br", Body: event},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := newConsumer(&fakeChannel{}, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+			err := consumer.handleMessage(&tt.msg)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("want error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConsumer_Listen_Exclusive(t *testing.T) {
+	refused := &amqp.Error{
+		Code:   amqp.AccessRefused,
+		Reason: "ACCESS_REFUSED - queue 'products.events' in vhost '/' in exclusive use",
+	}
+	tests := []struct {
+		name       string
+		err        error
+		wantInUse  bool
+		wantReason bool
+	}{
+		{name: "access refused", err: refused, wantInUse: true, wantReason: true},
+		{name: "other broker error", err: &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{err: tt.err}
+			consumer := newConsumer(ch, "products.events", slog.New(slog.NewJSONHandler(os.Stdout, nil)), WithExclusive())
+
+			err := consumer.Listen(context.Background())
+			if !ch.exclusive {
+				t.Fatal("want the consume declared exclusive")
+			}
+			if errors.Is(err, ErrQueueInUse) != tt.wantInUse {
+				t.Fatalf("want ErrQueueInUse=%v, got %v", tt.wantInUse, err)
+			}
+			if !errors.Is(err, tt.err) && !tt.wantInUse {
+				t.Fatalf("want the broker error wrapped, got %v", err)
+			}
+			if tt.wantReason && !strings.Contains(err.Error(), "exclusive use") {
+				t.Fatalf("want the broker's reason in the error, got %q", err)
+			}
+		})
+	}
+}
+
+// blockingChannel never answers, like a broker that accepted the
+// connection but hangs on setup.
+type blockingChannel struct {
+	fakeChannel
+	release chan struct{}
+}
+
+func (b *blockingChannel) QueueDeclare(string, bool, bool, bool, bool, amqp.Table) (amqp.Queue, error) {
+	<-b.release
+	return amqp.Queue{}, nil
+}
+
+func (b *blockingChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	<-b.release
+	return nil, errors.New("channel closed")
+}
+
+func TestConsumer_SetupTimeout(t *testing.T) {
+	ch := &blockingChannel{release: make(chan struct{})}
+	defer close(ch.release)
+	consumer := newConsumer(ch, "products.events", slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		WithSetupTimeout(20*time.Millisecond))
+
+	if err := consumer.declare(ch); !errors.Is(err, messaging.ErrSetupTimeout) {
+		t.Fatalf("want declare to fail with ErrSetupTimeout, got %v", err)
+	}
+	if err := consumer.Listen(context.Background()); !errors.Is(err, messaging.ErrSetupTimeout) {
+		t.Fatalf("want Listen to fail with ErrSetupTimeout, got %v", err)
+	}
+}
+
+type recordingObserver struct {
+	values []float64
+}
+
+func (o *recordingObserver) Observe(v float64) {
+	o.values = append(o.values, v)
+}
+
+func TestConsumer_HandleMessage_EventAge(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		wantMin   float64
+		wantMax   float64
+		wantSkew  float64
+	}{
+		{
+			name:      "past event",
+			timestamp: time.Now().Add(-90 * time.Second),
+			wantMin:   90,
+			wantMax:   95,
+		},
+		{
+			name:      "future event clamped to zero",
+			timestamp: time.Now().Add(time.Minute),
+			wantSkew:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			age := &recordingObserver{}
+			skew := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_skew", Help: "t"})
+			consumer := newConsumer(&fakeChannel{}, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+				WithEventAge(age, skew))
+
+			body := fmt.Sprintf(`{"event_type":"product_created","product_id":1,"timestamp":%q}`,
+				tt.timestamp.Format(time.RFC3339Nano))
+			if err := consumer.handleMessage(&amqp.Delivery{Body: []byte(body)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(age.values) != 1 {
+				t.Fatalf("want one age observation, got %v", age.values)
+			}
+			if got := age.values[0]; got < tt.wantMin || got > tt.wantMax {
+				t.Fatalf("want age in [%v, %v], got %v", tt.wantMin, tt.wantMax, got)
+			}
+			if got := testutil.ToFloat64(skew); got != tt.wantSkew {
+				t.Fatalf("want clock skew count %v, got %v", tt.wantSkew, got)
+			}
+		})
+	}
+}
+
+func TestConsumer_HandleMessage_MaxStaleness(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp string
+		wantStale bool
+	}{
+		{name: "fresh event handled", timestamp: time.Now().Add(-time.Second).Format(time.RFC3339Nano)},
+		{name: "stale event skipped", timestamp: time.Now().Add(-2 * time.Hour).Format(time.RFC3339Nano), wantStale: true},
+		{name: "event without timestamp handled", timestamp: "0001-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			stale := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_stale", Help: "t"})
+			consumer := newConsumer(&fakeChannel{}, "q", slog.New(slog.NewJSONHandler(&logs, nil)),
+				WithMaxStaleness(time.Hour, stale))
+
+			body := fmt.Sprintf(`{"event_type":"product_deleted","product_id":1,"timestamp":%q}`, tt.timestamp)
+			// A nil error acknowledges the message, stale or not.
+			if err := consumer.handleMessage(&amqp.Delivery{Body: []byte(body)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wantCount := 0.0
+			if tt.wantStale {
+				wantCount = 1
+			}
+			if got := testutil.ToFloat64(stale); got != wantCount {
+				t.Fatalf("want stale count %v, got %v", wantCount, got)
+			}
+			if handled := strings.Contains(logs.String(), "notification event"); handled == tt.wantStale {
+				t.Fatalf("want handled=%v, logs: %s", !tt.wantStale, logs.String())
+			}
+		})
+	}
+}
+
+func TestConsumer_HandleMessage_VersionCheck(t *testing.T) {
+	var logs bytes.Buffer
+	outOfOrder := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_out_of_order", Help: "t"})
+	consumer := newConsumer(&fakeChannel{}, "q", slog.New(slog.NewJSONHandler(&logs, nil)),
+		WithVersionCheck(10, outOfOrder))
+
+	// Product 1 is created, updated and deleted, but the update arrives
+	// last; product 2 is unaffected by product 1's versions.
+	deliveries := []struct {
+		event       string
+		productID   int64
+		version     int64
+		wantHandled bool
+	}{
+		{event: "product_created", productID: 1, version: 1, wantHandled: true},
+		{event: "product_deleted", productID: 1, version: 3, wantHandled: true},
+		{event: "product_updated", productID: 1, version: 2},
+		{event: "product_created", productID: 1, version: 3, wantHandled: true},
+		{event: "product_created", productID: 2, version: 1, wantHandled: true},
+		{event: "product_created", productID: 1, wantHandled: true},
+	}
+
+	var wantDropped float64
+	for _, d := range deliveries {
+		logs.Reset()
+		body := fmt.Sprintf(`{"event_type":%q,"product_id":%d,"aggregate_version":%d}`, d.event, d.productID, d.version)
+		if err := consumer.handleMessage(&amqp.Delivery{Body: []byte(body)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", body, err)
+		}
+		if !d.wantHandled {
+			wantDropped++
+		}
+		if handled := strings.Contains(logs.String(), "notification event"); handled != d.wantHandled {
+			t.Fatalf("%s: want handled=%v, logs: %s", body, d.wantHandled, logs.String())
+		}
+	}
+	if got := testutil.ToFloat64(outOfOrder); got != wantDropped {
+		t.Fatalf("want %v out-of-order events counted, got %v", wantDropped, got)
+	}
+}
+
+func TestVersionTracker_EvictsLeastRecentlySeen(t *testing.T) {
+	tracker := newVersionTracker(2)
+	tracker.accept(1, 5)
+	tracker.accept(2, 5)
+	// Seeing product 1 again makes product 2 the one to go.
+	tracker.accept(1, 6)
+	tracker.accept(3, 1)
+
+	if tracker.accept(1, 5) {
+		t.Fatal("want product 1's older version still rejected")
+	}
+	if !tracker.accept(2, 4) {
+		t.Fatal("want product 2 forgotten, so any version passes")
+	}
+	if got := len(tracker.latest); got != 2 {
+		t.Fatalf("want 2 products remembered, got %d", got)
+	}
+}
+
+func TestConsumer_HandleMessage_CreatedBatch(t *testing.T) {
+	var logs bytes.Buffer
+	outOfOrder := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_out_of_order", Help: "t"})
+	consumer := newConsumer(&fakeChannel{}, "q", slog.New(slog.NewJSONHandler(&logs, nil)),
+		WithVersionCheck(10, outOfOrder))
+
+	// Product 2 was already deleted, so its create in the batch is stale.
+	if err := consumer.handleMessage(&amqp.Delivery{Body: []byte(`{"event_type":"product_deleted","product_id":2,"aggregate_version":2}`)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logs.Reset()
+
+	body := `{"event_type":"products_created_batch","products":[` +
+		`{"event_type":"product_created","product_id":1,"aggregate_version":1},` +
+		`{"event_type":"product_created","product_id":2,"aggregate_version":1},` +
+		`{"event_type":"product_created","product_id":3,"aggregate_version":1}]}`
+	if err := consumer.handleMessage(&amqp.Delivery{Body: []byte(body)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.Count(logs.String(), `"msg":"notification event"`); got != 2 {
+		t.Fatalf("want products 1 and 3 handled, got %d, logs: %s", got, logs.String())
+	}
+	for _, id := range []string{`"product_id":1,`, `"product_id":3,`} {
+		if !strings.Contains(logs.String(), id) {
+			t.Fatalf("want %s handled, logs: %s", id, logs.String())
+		}
+	}
+	if got := testutil.ToFloat64(outOfOrder); got != 1 {
+		t.Fatalf("want product 2's create counted out of order, got %v", got)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		failures []time.Duration
+		success  int // index before which a success is recorded; -1 for none
+		wantTrip bool
+	}{
+		{
+			name:     "trips at threshold within window",
+			failures: []time.Duration{0, time.Second, 2 * time.Second},
+			success:  -1,
+			wantTrip: true,
+		},
+		{
+			name:     "failures spread past the window start over",
+			failures: []time.Duration{0, time.Second, 20 * time.Second},
+			success:  -1,
+		},
+		{
+			name:     "success resets the count",
+			failures: []time.Duration{0, time.Second, 2 * time.Second},
+			success:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := breaker{threshold: 3, window: 10 * time.Second}
+			var tripped bool
+			for i, offset := range tt.failures {
+				if i == tt.success {
+					b.success()
+				}
+				tripped = b.failure(start.Add(offset))
+			}
+			if tripped != tt.wantTrip {
+				t.Fatalf("want tripped=%v, got %v", tt.wantTrip, tripped)
+			}
+		})
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumer_SignatureCheck(t *testing.T) {
+	secret := []byte("shared-secret")
+	body := []byte(`{"event_type":"product_created","product_id":1}`)
+	signed := amqp.Table{messaging.HeaderSignature: messaging.Sign(secret, body)}
+
+	tests := []struct {
+		name        string
+		required    bool
+		headers     amqp.Table
+		body        []byte
+		wantAcks    int
+		wantDropped int
+	}{
+		{name: "valid signature", headers: signed, body: body, wantAcks: 1},
+		{
+			name:        "tampered body",
+			headers:     signed,
+			body:        []byte(`{"event_type":"product_deleted","product_id":1}`),
+			wantDropped: 1,
+		},
+		{name: "unsigned accepted", body: body, wantAcks: 1},
+		{name: "unsigned when required", required: true, body: body, wantDropped: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &countingAcknowledger{}
+			ch := &fakeChannel{
+				pending:  [][]amqp.Delivery{{{Acknowledger: ack, Headers: tt.headers, Body: tt.body}}},
+				consumes: make(chan struct{}, 1),
+			}
+			invalid := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_invalid_signature", Help: "t"})
+			consumer := newConsumer(ch, "q", slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+				WithSignatureCheck(secret, tt.required, invalid))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- consumer.Listen(ctx) }()
+			<-ch.consumes
+			waitFor(t, func() bool {
+				acks, nacks := ack.counts()
+				return acks+nacks == 1
+			})
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			acks, _ := ack.counts()
+			if acks != tt.wantAcks || ack.dropped != tt.wantDropped {
+				t.Fatalf("want %d acks and %d dropped, got %d and %d", tt.wantAcks, tt.wantDropped, acks, ack.dropped)
+			}
+			if got := testutil.ToFloat64(invalid); got != float64(tt.wantDropped) {
+				t.Fatalf("want %d invalid signatures counted, got %v", tt.wantDropped, got)
+			}
+		})
+	}
+}
+
+func TestConsumer_SchemaRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		deadLetter  bool
+		wantAcks    int
+		wantDropped int
+		wantSkipped float64
+	}{
+		{name: "in range", body: `{"event_type":"product_created","product_id":1,"schema_version":2}`, wantAcks: 1},
+		{name: "unversioned reads as 1", body: `{"event_type":"product_created","product_id":1}`, wantAcks: 1, wantSkipped: 1},
+		{name: "below min", body: `{"event_type":"product_created","product_id":1,"schema_version":1}`, wantAcks: 1, wantSkipped: 1},
+		{name: "above max", body: `{"event_type":"product_created","product_id":1,"schema_version":4}`, wantAcks: 1, wantSkipped: 1},
+		{
+			name:        "above max dead-lettered",
+			body:        `{"event_type":"product_created","product_id":1,"schema_version":4}`,
+			deadLetter:  true,
+			wantDropped: 1,
+			wantSkipped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &countingAcknowledger{}
+			ch := &fakeChannel{
+				pending:  [][]amqp.Delivery{{{Acknowledger: ack, Body: []byte(tt.body)}}},
+				consumes: make(chan struct{}, 1),
+			}
+			var logs bytes.Buffer
+			unsupported := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_unsupported_schema", Help: "t"})
+			consumer := newConsumer(ch, "q", slog.New(slog.NewJSONHandler(&logs, nil)),
+				WithSchemaRange(2, 3, tt.deadLetter, unsupported))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- consumer.Listen(ctx) }()
+			<-ch.consumes
+			waitFor(t, func() bool {
+				acks, nacks := ack.counts()
+				return acks+nacks == 1
+			})
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			acks, _ := ack.counts()
+			if acks != tt.wantAcks || ack.dropped != tt.wantDropped {
+				t.Fatalf("want %d acks and %d dropped, got %d and %d", tt.wantAcks, tt.wantDropped, acks, ack.dropped)
+			}
+			if got := testutil.ToFloat64(unsupported); got != tt.wantSkipped {
+				t.Fatalf("want %v unsupported events counted, got %v", tt.wantSkipped, got)
+			}
+			if handled := strings.Contains(logs.String(), "notification event"); handled == (tt.wantSkipped > 0) {
+				t.Fatalf("want handled=%v, logs: %s", tt.wantSkipped == 0, logs.String())
+			}
+		})
+	}
+}
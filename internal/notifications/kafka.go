@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products/messaging"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaReader is the subset of *kafka.Reader the consumer uses, so tests can
+// substitute a fake.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaConsumer handles events from a Kafka topic as a member of a consumer
+// group. Kafka has no per-message nack, so a message that cannot be handled,
+// or fails the signature check, is logged and committed rather than
+// blocking its partition; the breaker therefore does not apply and Paused
+// is always false.
+type KafkaConsumer struct {
+	eventHandler
+
+	reader   kafkaReader
+	topic    string
+	inFlight inFlightClock
+}
+
+func NewKafkaConsumer(brokers []string, topic, groupID string, logger *slog.Logger, opts ...Option) *KafkaConsumer {
+	return newKafkaConsumer(kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: groupID,
+	}), topic, logger, opts...)
+}
+
+func newKafkaConsumer(r kafkaReader, topic string, logger *slog.Logger, opts ...Option) *KafkaConsumer {
+	// Options are written against Consumer; only the event handling they
+	// configure carries over.
+	base := newConsumer(nil, "", logger, opts...)
+	return &KafkaConsumer{eventHandler: base.eventHandler, reader: r, topic: topic}
+}
+
+// Paused is always false: the Kafka consumer never pauses.
+func (c *KafkaConsumer) Paused() bool {
+	return false
+}
+
+// OldestUnacked is how long the message being handled has gone without
+// its offset being committed, or zero when the consumer is idle.
+func (c *KafkaConsumer) OldestUnacked() time.Duration {
+	return c.inFlight.age(time.Now())
+}
+
+// Listen handles messages until ctx is done, committing each one after it
+// has been handled.
+func (c *KafkaConsumer) Listen(ctx context.Context) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fetch from topic %q: %w", c.topic, err)
+		}
+
+		c.inFlight.start(time.Now())
+		if err := c.verify(kafkaSignature(msg.Headers), msg.Value); err != nil {
+			c.badSignature.Inc()
+			c.logger.Warn("skipping message with invalid signature",
+				"partition", msg.Partition,
+				"offset", msg.Offset,
+				"error", err,
+			)
+		} else if err := c.handle(
+			messaging.KafkaHeader(msg.Headers, messaging.HeaderContentType),
+			messaging.KafkaHeader(msg.Headers, messaging.HeaderContentEncoding),
+			msg.Value,
+		); err != nil {
+			c.logger.Error("handle message failed, skipping it",
+				"partition", msg.Partition,
+				"offset", msg.Offset,
+				"error", err,
+			)
+		}
+
+		err = c.reader.CommitMessages(ctx, msg)
+		c.inFlight.stop()
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("commit offset %d: %w", msg.Offset, err)
+		}
+	}
+}
+
+// kafkaSignature carries msg's signature header, if any, over into the
+// table VerifySignature reads.
+func kafkaSignature(headers []kafka.Header) amqp.Table {
+	for _, h := range headers {
+		if h.Key == messaging.HeaderSignature {
+			return amqp.Table{messaging.HeaderSignature: string(h.Value)}
+		}
+	}
+	return nil
+}
+
+func (c *KafkaConsumer) Close() error {
+	return c.reader.Close()
+}
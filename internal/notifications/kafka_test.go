@@ -0,0 +1,124 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"product-notifications/internal/products/messaging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader hands out the buffered messages in order, then blocks until ctx
+// is done.
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  chan kafka.Message
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-r.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func (r *fakeReader) committedOffsets() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.committed...)
+}
+
+func TestKafkaConsumer_CommitsEveryMessage(t *testing.T) {
+	reader := &fakeReader{messages: make(chan kafka.Message, 3)}
+	reader.messages <- kafka.Message{Offset: 0, Value: []byte(`{"event_type":"product_created","product_id":1}`)}
+	reader.messages <- kafka.Message{Offset: 1, Value: []byte("not json")}
+	reader.messages <- kafka.Message{
+		Offset:  2,
+		Headers: []kafka.Header{{Key: messaging.HeaderContentType, Value: []byte("application/cloudevents+json")}},
+		Value:   []byte(`{"specversion":"1.0","type":"product_created","source":"/products","id":"1","data":{"event_type":"product_created","product_id":2}}`),
+	}
+
+	consumer := newKafkaConsumer(reader, "products.events", slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	waitFor(t, func() bool { return len(reader.committedOffsets()) == 3 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The malformed message is skipped but still committed so it cannot
+	// block its partition.
+	if got := reader.committedOffsets(); got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Fatalf("want offsets 0, 1, 2 committed in order, got %v", got)
+	}
+	if consumer.Paused() {
+		t.Fatal("kafka consumer should never pause")
+	}
+}
+
+func TestKafkaConsumer_SignatureCheck(t *testing.T) {
+	secret := []byte("shared")
+	body := []byte(`{"event_type":"product_created","product_id":1}`)
+	reader := &fakeReader{messages: make(chan kafka.Message, 3)}
+	reader.messages <- kafka.Message{
+		Offset:  0,
+		Headers: []kafka.Header{{Key: messaging.HeaderSignature, Value: []byte(messaging.Sign(secret, body))}},
+		Value:   body,
+	}
+	reader.messages <- kafka.Message{
+		Offset:  1,
+		Headers: []kafka.Header{{Key: messaging.HeaderSignature, Value: []byte(messaging.Sign([]byte("other"), body))}},
+		Value:   body,
+	}
+	reader.messages <- kafka.Message{Offset: 2, Value: body}
+
+	var logs bytes.Buffer
+	invalid := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_invalid", Help: "t"})
+	consumer := newKafkaConsumer(reader, "products.events", slog.New(slog.NewJSONHandler(&logs, nil)),
+		WithSignatureCheck(secret, true, invalid))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- consumer.Listen(ctx) }()
+
+	waitFor(t, func() bool { return len(reader.committedOffsets()) == 3 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(invalid); got != 2 {
+		t.Fatalf("want the forged and the unsigned message counted, got %v", got)
+	}
+	if got := strings.Count(logs.String(), `"msg":"notification event"`); got != 1 {
+		t.Fatalf("want only the signed message handled, got %d", got)
+	}
+}
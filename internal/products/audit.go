@@ -0,0 +1,43 @@
+package products
+
+import (
+	"errors"
+	"time"
+)
+
+// Audit actions name the mutation an AuditRecord describes.
+const (
+	AuditCreate           = "create"
+	AuditUpdateAttributes = "update_attributes"
+	AuditChangeStatus     = "change_status"
+	AuditDelete           = "delete"
+	AuditDeleteBatch      = "delete_batch"
+	AuditReserve          = "reserve"
+	AuditRelease          = "release"
+)
+
+// ErrAuditFailed fails a mutation, which is rolled back, when auditing is
+// strict and its audit record could not be written.
+var ErrAuditFailed = errors.New("audit record could not be written")
+
+// AuditRecord describes one mutation for the audit trail. Before and After
+// are the product as it was and as it became: Before is nil for a create,
+// After for a delete. Reserve and release records carry the reservation
+// instead.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	ProductID int64     `json:"product_id,omitempty"`
+	// ProductIDs lists the ids a delete_batch was asked for; Count is how
+	// many of them existed and were deleted.
+	ProductIDs []int64 `json:"product_ids,omitempty"`
+	Count      int64   `json:"count,omitempty"`
+	// Actor is who made the change, as the X-Actor-ID header named them;
+	// empty when nobody did.
+	Actor     string   `json:"actor,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+	Before    *Product `json:"before,omitempty"`
+	After     *Product `json:"after,omitempty"`
+	// Reservation is the reservation made, or the one released.
+	Reservation *Reservation `json:"reservation,omitempty"`
+}
@@ -0,0 +1,54 @@
+// Package audit writes product audit records to a file of their own, apart
+// from the operational logs.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"product-notifications/internal/products"
+)
+
+// File appends audit records to a file, one JSON object per line. The file
+// is only ever opened for appending, and every record is synced to disk
+// before Audit returns.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens the audit file at path for appending, creating it if
+// needed.
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &File{f: f}, nil
+}
+
+// Audit appends rec as one JSON line.
+func (a *File) Audit(_ context.Context, rec products.AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	if err := a.f.Sync(); err != nil {
+		return fmt.Errorf("sync audit file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit file.
+func (a *File) Close() error {
+	return a.f.Close()
+}
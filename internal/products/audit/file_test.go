@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+func TestFile_AppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	at := time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC)
+	records := []products.AuditRecord{
+		{
+			Time: at, Action: products.AuditCreate, ProductID: 1, Actor: "alice", RequestID: "req-1",
+			After: &products.Product{ID: 1, Name: "Widget", Version: 1},
+		},
+		{
+			Time: at, Action: products.AuditDeleteBatch, ProductIDs: []int64{1, 2}, Count: 2, Actor: "bob",
+		},
+	}
+
+	// Reopening the file must append, not truncate.
+	for _, rec := range records {
+		f, err := OpenFile(path)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if err := f.Audit(context.Background(), rec); err != nil {
+			t.Fatalf("audit: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var got []products.AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec products.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q is not a JSON record: %v", scanner.Text(), err)
+		}
+		got = append(got, rec)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("want %+v, got %+v", records, got)
+	}
+}
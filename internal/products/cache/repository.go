@@ -0,0 +1,234 @@
+// Package cache provides an in-process cache in front of the product
+// repository for list and count reads.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"product-notifications/internal/products"
+	"product-notifications/internal/products/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Config struct {
+	// TTL is how long a cached result may be served.
+	TTL time.Duration
+	// MaxEntries bounds the cache; the least recently used entry is
+	// evicted first.
+	MaxEntries int
+}
+
+// Repository caches List and Count results of the wrapped repository. Any
+// successful write through it empties the cache, so a reader never sees a
+// result older than the last write this process made. Writes made by other
+// instances are only picked up once entries expire.
+type Repository struct {
+	service.Repository
+
+	cfg    Config
+	hits   prometheus.Counter
+	misses prometheus.Counter
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation is bumped on every invalidation. A read only stores its
+	// result if no write happened while it was in flight.
+	generation uint64
+}
+
+type entry struct {
+	key       string
+	value     any
+	expiresAt time.Time
+}
+
+func NewRepository(next service.Repository, cfg Config, hits, misses prometheus.Counter) *Repository {
+	return &Repository{
+		Repository: next,
+		cfg:        cfg,
+		hits:       hits,
+		misses:     misses,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (r *Repository) Create(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	p, err := r.Repository.Create(ctx, in)
+	if err == nil {
+		r.invalidate()
+	}
+	return p, err
+}
+
+func (r *Repository) CreateIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error) {
+	p, created, err := r.Repository.CreateIfAbsent(ctx, in)
+	if created {
+		r.invalidate()
+	}
+	return p, created, err
+}
+
+func (r *Repository) CreateBatch(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	created, err := r.Repository.CreateBatch(ctx, inputs)
+	if err == nil {
+		r.invalidate()
+	}
+	return created, err
+}
+
+func (r *Repository) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+	p, previous, err := r.Repository.UpdateAttributes(ctx, id, attributes)
+	if err == nil {
+		r.invalidate()
+	}
+	return p, previous, err
+}
+
+func (r *Repository) UpdateStatus(ctx context.Context, id int64, status string) (products.Product, string, error) {
+	p, previous, err := r.Repository.UpdateStatus(ctx, id, status)
+	if err == nil {
+		r.invalidate()
+	}
+	return p, previous, err
+}
+
+func (r *Repository) DeleteReturning(ctx context.Context, id int64) (products.Product, error) {
+	p, err := r.Repository.DeleteReturning(ctx, id)
+	if err == nil {
+		r.invalidate()
+	}
+	return p, err
+}
+
+func (r *Repository) DeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	p, err := r.Repository.DeleteByPublicID(ctx, publicID)
+	if err == nil {
+		r.invalidate()
+	}
+	return p, err
+}
+
+func (r *Repository) DeleteBatch(ctx context.Context, ids []int64) (int64, error) {
+	deleted, err := r.Repository.DeleteBatch(ctx, ids)
+	if err == nil {
+		r.invalidate()
+	}
+	return deleted, err
+}
+
+func (r *Repository) List(ctx context.Context, opts products.ListOptions, limit, offset int) ([]products.Product, error) {
+	key, err := cacheKey("list", opts, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := r.get(key); ok {
+		return v.([]products.Product), nil
+	}
+
+	gen := r.currentGeneration()
+	list, err := r.Repository.List(ctx, opts, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	r.put(key, list, gen)
+	return list, nil
+}
+
+// cachedCount is a cached Count result.
+type cachedCount struct {
+	total       int64
+	approximate bool
+}
+
+func (r *Repository) Count(ctx context.Context, opts products.ListOptions) (int64, bool, error) {
+	key, err := cacheKey("count", opts)
+	if err != nil {
+		return 0, false, err
+	}
+	if v, ok := r.get(key); ok {
+		c := v.(cachedCount)
+		return c.total, c.approximate, nil
+	}
+
+	gen := r.currentGeneration()
+	total, approximate, err := r.Repository.Count(ctx, opts)
+	if err != nil {
+		return 0, false, err
+	}
+	r.put(key, cachedCount{total, approximate}, gen)
+	return total, approximate, nil
+}
+
+func (r *Repository) get(key string) (any, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	el, ok := r.entries[key]
+	if !ok {
+		r.misses.Inc()
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !r.now().Before(e.expiresAt) {
+		r.lru.Remove(el)
+		delete(r.entries, key)
+		r.misses.Inc()
+		return nil, false
+	}
+	r.lru.MoveToFront(el)
+	r.hits.Inc()
+	return e.value, true
+}
+
+func (r *Repository) put(key string, value any, gen uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if gen != r.generation {
+		return
+	}
+	if el, ok := r.entries[key]; ok {
+		r.lru.Remove(el)
+		delete(r.entries, key)
+	}
+	r.entries[key] = r.lru.PushFront(&entry{key: key, value: value, expiresAt: r.now().Add(r.cfg.TTL)})
+	for r.lru.Len() > r.cfg.MaxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*entry).key)
+	}
+}
+
+func (r *Repository) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+func (r *Repository) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	r.entries = make(map[string]*list.Element)
+	r.lru.Init()
+}
+
+// cacheKey renders the query parameters as JSON; encoding/json sorts map
+// keys, so equal attribute filters give equal keys.
+func cacheKey(op string, parts ...any) (string, error) {
+	b, err := json.Marshal(append([]any{op}, parts...))
+	if err != nil {
+		return "", fmt.Errorf("cache key: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type countingRepo struct {
+	lists  int
+	counts int
+}
+
+func (c *countingRepo) Create(_ context.Context, in products.CreateInput) (products.Product, error) {
+	return products.Product{ID: 1, Name: in.Name}, nil
+}
+func (c *countingRepo) CreateIfAbsent(_ context.Context, in products.CreateInput) (products.Product, bool, error) {
+	return products.Product{ID: 1, Name: in.Name}, true, nil
+}
+func (c *countingRepo) CreateBatch(_ context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	return make([]products.Product, len(inputs)), nil
+}
+func (c *countingRepo) Get(_ context.Context, id int64) (products.Product, error) {
+	return products.Product{ID: id}, nil
+}
+func (c *countingRepo) GetByPublicID(_ context.Context, publicID string) (products.Product, error) {
+	return products.Product{PublicID: publicID}, nil
+}
+func (c *countingRepo) GetBySlug(_ context.Context, slug string) (products.Product, error) {
+	return products.Product{Slug: slug}, nil
+}
+func (c *countingRepo) GetIncludingExpired(_ context.Context, id int64) (products.Product, error) {
+	return products.Product{ID: id}, nil
+}
+func (c *countingRepo) GetByPublicIDIncludingExpired(_ context.Context, publicID string) (products.Product, error) {
+	return products.Product{PublicID: publicID}, nil
+}
+func (c *countingRepo) UpdateAttributes(_ context.Context, id int64, _ map[string]any) (products.Product, map[string]any, error) {
+	return products.Product{ID: id}, nil, nil
+}
+func (c *countingRepo) UpdateStatus(_ context.Context, id int64, status string) (products.Product, string, error) {
+	return products.Product{ID: id, Status: status}, products.StatusDraft, nil
+}
+func (c *countingRepo) DeleteReturning(_ context.Context, _ int64) (products.Product, error) {
+	return products.Product{}, products.ErrNotFound
+}
+func (c *countingRepo) DeleteByPublicID(_ context.Context, _ string) (products.Product, error) {
+	return products.Product{}, products.ErrNotFound
+}
+func (c *countingRepo) DeleteBatch(_ context.Context, ids []int64) (int64, error) {
+	return int64(len(ids)), nil
+}
+func (c *countingRepo) List(_ context.Context, _ products.ListOptions, _, _ int) ([]products.Product, error) {
+	c.lists++
+	return []products.Product{{ID: int64(c.lists)}}, nil
+}
+func (c *countingRepo) ListAfter(_ context.Context, _ int64, _ int) ([]products.Product, error) {
+	return nil, nil
+}
+func (c *countingRepo) SuggestNames(_ context.Context, _ string, _ int) ([]string, error) {
+	return nil, nil
+}
+func (c *countingRepo) Count(_ context.Context, _ products.ListOptions) (int64, bool, error) {
+	c.counts++
+	return int64(c.counts), false, nil
+}
+func (c *countingRepo) Reserve(_ context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error) {
+	return products.Reservation{ProductID: id, ReservedBy: by, ReservedUntil: time.Now().Add(ttl)}, nil
+}
+func (c *countingRepo) Release(_ context.Context, _ int64, _ string) error {
+	return nil
+}
+
+func (c *countingRepo) Position(_ context.Context, _ int64) (int64, error) {
+	return 0, nil
+}
+
+func (c *countingRepo) QueueBehindOutbox(_ context.Context, _ products.ProductEvent) (bool, error) {
+	return false, nil
+}
+
+func newTestCache(next *countingRepo, cfg Config) (*Repository, prometheus.Counter, prometheus.Counter) {
+	hits := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_hits", Help: "t"})
+	misses := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_misses", Help: "t"})
+	return NewRepository(next, cfg, hits, misses), hits, misses
+}
+
+func TestRepository_HitAndMiss(t *testing.T) {
+	next := &countingRepo{}
+	repo, hits, misses := newTestCache(next, Config{TTL: time.Minute, MaxEntries: 10})
+	ctx := context.Background()
+
+	red := products.ListOptions{Attributes: map[string]any{"color": "red", "size": "m"}}
+	sameRed := products.ListOptions{Attributes: map[string]any{"size": "m", "color": "red"}}
+
+	_, _ = repo.List(ctx, red, 10, 0)
+	_, _ = repo.List(ctx, sameRed, 10, 0)
+	_, _ = repo.List(ctx, red, 10, 10)
+	_, _, _ = repo.Count(ctx, red)
+	_, _, _ = repo.Count(ctx, red)
+
+	if next.lists != 2 || next.counts != 1 {
+		t.Fatalf("want 2 list and 1 count queries, got %d and %d", next.lists, next.counts)
+	}
+	if got := testutil.ToFloat64(hits); got != 2 {
+		t.Fatalf("want 2 hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(misses); got != 3 {
+		t.Fatalf("want 3 misses, got %v", got)
+	}
+}
+
+func TestRepository_Expiry(t *testing.T) {
+	next := &countingRepo{}
+	repo, _, _ := newTestCache(next, Config{TTL: time.Minute, MaxEntries: 10})
+	now := time.Now()
+	repo.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 0)
+	now = now.Add(2 * time.Minute)
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 0)
+
+	if next.lists != 2 {
+		t.Fatalf("want expired entry re-queried, got %d list queries", next.lists)
+	}
+}
+
+func TestRepository_EvictsLeastRecentlyUsed(t *testing.T) {
+	next := &countingRepo{}
+	repo, _, _ := newTestCache(next, Config{TTL: time.Minute, MaxEntries: 2})
+	ctx := context.Background()
+
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 0)  // a
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 10) // b
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 0)  // a is now most recent
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 20) // c evicts b
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 0)  // a still cached
+
+	if next.lists != 3 {
+		t.Fatalf("want 3 list queries, got %d", next.lists)
+	}
+	_, _ = repo.List(ctx, products.ListOptions{}, 10, 10)
+	if next.lists != 4 {
+		t.Fatalf("want evicted entry re-queried, got %d list queries", next.lists)
+	}
+}
+
+func TestRepository_Invalidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		write          func(ctx context.Context, r *Repository)
+		wantInvalidate bool
+	}{
+		{
+			name: "create",
+			write: func(ctx context.Context, r *Repository) {
+				_, _ = r.Create(ctx, products.CreateInput{Name: "New"})
+			},
+			wantInvalidate: true,
+		},
+		{
+			name: "create batch",
+			write: func(ctx context.Context, r *Repository) {
+				_, _ = r.CreateBatch(ctx, []products.CreateInput{{Name: "New"}})
+			},
+			wantInvalidate: true,
+		},
+		{
+			name: "update attributes",
+			write: func(ctx context.Context, r *Repository) {
+				_, _, _ = r.UpdateAttributes(ctx, 1, map[string]any{"color": "blue"})
+			},
+			wantInvalidate: true,
+		},
+		{
+			name: "failed delete keeps the cache",
+			write: func(ctx context.Context, r *Repository) {
+				_, _ = r.DeleteReturning(ctx, 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingRepo{}
+			repo, _, _ := newTestCache(next, Config{TTL: time.Minute, MaxEntries: 10})
+			ctx := context.Background()
+
+			before, _ := repo.List(ctx, products.ListOptions{}, 10, 0)
+			tt.write(ctx, repo)
+			after, _ := repo.List(ctx, products.ListOptions{}, 10, 0)
+
+			if invalidated := after[0].ID != before[0].ID; invalidated != tt.wantInvalidate {
+				t.Fatalf("want invalidated=%v, got %v", tt.wantInvalidate, invalidated)
+			}
+		})
+	}
+}
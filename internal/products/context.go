@@ -0,0 +1,152 @@
+package products
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type statementTimeoutKey struct{}
+
+// WithStatementTimeout asks the repository to cancel any single statement
+// run on behalf of ctx that takes longer than d, independent of the
+// database-wide setting.
+func WithStatementTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, statementTimeoutKey{}, d)
+}
+
+// StatementTimeout returns the timeout set by WithStatementTimeout, if any.
+func StatementTimeout(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(statementTimeoutKey{}).(time.Duration)
+	return d, ok && d > 0
+}
+
+type timingsKey struct{}
+
+// Timings accumulates how long a request spent in the database, for the
+// Server-Timing response header. Time in calls that overlap is counted
+// once, so concurrent queries add the wall time they span rather than their
+// sum and the total never exceeds the request's. It is safe for concurrent
+// use; a nil *Timings ignores everything added to it.
+type Timings struct {
+	mu sync.Mutex
+	db time.Duration
+	// active counts the calls started with StartDB and not yet done, the
+	// first of which started at since.
+	active int
+	since  time.Time
+}
+
+// WithTimings returns a context carrying a fresh Timings.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// TimingsFrom returns the Timings set by WithTimings, or nil.
+func TimingsFrom(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// AddDB adds d to the time spent in the database.
+func (t *Timings) AddDB(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.db += d
+}
+
+// StartDB starts timing a database call; the returned func ends it. While
+// any call is running the time counts once, however many there are.
+func (t *Timings) StartDB() (done func()) {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == 0 {
+		t.since = time.Now()
+	}
+	t.active++
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.active--; t.active == 0 {
+			t.db += time.Since(t.since)
+		}
+	}
+}
+
+// DB returns the time spent in the database so far.
+func (t *Timings) DB() time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active > 0 {
+		return t.db + time.Since(t.since)
+	}
+	return t.db
+}
+
+// FeatureFlag names an experimental behavior a request can opt into, via
+// the X-Feature-Flags header, when the server allows it.
+type FeatureFlag string
+
+const (
+	// FlagStrictQuery rejects unknown query parameters, as ?strict=true
+	// does.
+	FlagStrictQuery FeatureFlag = "strict-query"
+	// FlagExactCount counts list totals exactly even where they would be
+	// estimated, as ?exact=true does.
+	FlagExactCount FeatureFlag = "exact-count"
+)
+
+// KnownFeatureFlags lists every flag the service understands.
+var KnownFeatureFlags = []FeatureFlag{FlagStrictQuery, FlagExactCount}
+
+// FeatureFlags is the set of flags a request opted into.
+type FeatureFlags map[FeatureFlag]bool
+
+type featureFlagsKey struct{}
+
+// WithFeatureFlags returns a context carrying flags.
+func WithFeatureFlags(ctx context.Context, flags FeatureFlags) context.Context {
+	return context.WithValue(ctx, featureFlagsKey{}, flags)
+}
+
+// FeatureEnabled reports whether the request behind ctx opted into flag.
+func FeatureEnabled(ctx context.Context, flag FeatureFlag) bool {
+	flags, _ := ctx.Value(featureFlagsKey{}).(FeatureFlags)
+	return flags[flag]
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the id of the request it serves.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id set by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying who the request acts for.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor set by WithActor, or "".
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -0,0 +1,53 @@
+package products
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// NameDenylist rejects product names containing a denied term or matching a
+// denied pattern, ignoring case. Terms are single words matched against
+// whole words of the name, so denying "ass" does not reject "Glass Vase";
+// patterns are regular expressions matched anywhere in the name.
+type NameDenylist struct {
+	terms    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// NewNameDenylist compiles patterns once, so a bad one fails at startup
+// rather than on the first create.
+func NewNameDenylist(terms, patterns []string) (*NameDenylist, error) {
+	d := &NameDenylist{terms: make(map[string]struct{}, len(terms))}
+	for _, term := range terms {
+		d.terms[strings.ToLower(term)] = struct{}{}
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+// Check returns ErrNameNotAllowed when name is denied. It expects the name
+// as it will be stored, i.e. after normalization.
+func (d *NameDenylist) Check(name string) error {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if _, ok := d.terms[word]; ok {
+			return ErrNameNotAllowed
+		}
+	}
+	for _, re := range d.patterns {
+		if re.MatchString(name) {
+			return ErrNameNotAllowed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package expiry announces products whose expiry time has passed.
+package expiry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	defaultInterval  = 30 * time.Second
+	defaultBatchSize = 100
+)
+
+type Store interface {
+	ExpiryCursor(ctx context.Context) (products.ExpiryCursor, error)
+	SaveExpiryCursor(ctx context.Context, cursor products.ExpiryCursor) error
+	ListExpired(ctx context.Context, after products.ExpiryCursor, limit int) ([]products.Product, error)
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event products.ProductEvent) error
+}
+
+// Leader elects the one sweeper allowed to publish when several run.
+type Leader interface {
+	Lead(ctx context.Context) (bool, error)
+	Resign()
+}
+
+type Config struct {
+	// Interval is how long the sweeper sleeps once it has caught up.
+	Interval time.Duration
+	// BatchSize is how many expired products are read per sweep.
+	BatchSize int
+	// Leader, when set, lets only the elected sweeper publish. Unset,
+	// sweepers on several instances would announce the same expiries.
+	Leader Leader
+}
+
+// Sweeper publishes a product_expired event for each product once its
+// expires_at passes. It walks expiries in (expires_at, id) order behind a
+// cursor that it saves after every sweep, so an expiry is announced once
+// even across restarts; only a crash between publish and save repeats one.
+type Sweeper struct {
+	store     Store
+	publisher Publisher
+	cfg       Config
+	logger    *slog.Logger
+
+	cursor  products.ExpiryCursor
+	loaded  bool
+	leading bool
+}
+
+func NewSweeper(store Store, publisher Publisher, cfg Config, logger *slog.Logger) *Sweeper {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	return &Sweeper{store: store, publisher: publisher, cfg: cfg, logger: logger}
+}
+
+// Run sweeps until ctx is done. A full batch is followed straight away by
+// the next one; otherwise the sweeper waits Interval.
+func (s *Sweeper) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	if s.cfg.Leader != nil {
+		defer s.cfg.Leader.Resign()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := s.cfg.Interval
+		if !s.lead(ctx) {
+			timer.Reset(wait)
+			continue
+		}
+		n, err := s.Sweep(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			s.logger.Error("sweep expired products", "published", n, "error", err)
+		case err == nil && n == s.cfg.BatchSize:
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Sweep publishes up to BatchSize expiries past the cursor and reports how
+// many it published. It stops at the first publish error, keeping the
+// cursor on the last published product so the rest are retried next time.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	if !s.loaded {
+		cursor, err := s.store.ExpiryCursor(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.cursor, s.loaded = cursor, true
+	}
+
+	expired, err := s.store.ListExpired(ctx, s.cursor, s.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		published  int
+		publishErr error
+	)
+	for _, p := range expired {
+		if publishErr = s.publisher.Publish(ctx, products.ProductEvent{
+			EventType: products.EventExpired,
+			ProductID: p.ID,
+			Name:      p.Name,
+			Owner:     p.Owner,
+			Timestamp: p.ExpiresAt.UTC(),
+			// Expiry changes nothing stored, so the version stays.
+			AggregateVersion: p.Version,
+		}); publishErr != nil {
+			publishErr = fmt.Errorf("publish expiry of product %d: %w", p.ID, publishErr)
+			break
+		}
+		s.cursor = products.ExpiryCursor{ExpiresAt: *p.ExpiresAt, ProductID: p.ID}
+		published++
+	}
+
+	if published > 0 {
+		// The in-memory cursor has moved on regardless, so this process
+		// will not repeat the events even if saving fails.
+		if err := s.store.SaveExpiryCursor(ctx, s.cursor); err != nil {
+			return published, err
+		}
+	}
+	return published, publishErr
+}
+
+// lead reports whether the sweeper may publish, logging leadership changes.
+// A sweeper that loses leadership reloads the cursor when it regains it,
+// since the leader in between has moved it on.
+func (s *Sweeper) lead(ctx context.Context) bool {
+	if s.cfg.Leader == nil {
+		return true
+	}
+	leading, err := s.cfg.Leader.Lead(ctx)
+	if err != nil && ctx.Err() == nil {
+		s.logger.Error("expiry sweeper leader election", "error", err)
+	}
+	if leading != s.leading {
+		s.leading = leading
+		s.loaded = false
+		s.logger.Info("expiry sweeper leadership changed", "leader", leading)
+	}
+	return leading
+}
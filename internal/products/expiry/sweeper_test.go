@@ -0,0 +1,174 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+// fakeStore holds products that have all expired and serves them past a
+// cursor the way ListExpired does.
+type fakeStore struct {
+	mu      sync.Mutex
+	expired []products.Product
+	cursor  products.ExpiryCursor
+	saves   int
+}
+
+func (f *fakeStore) ExpiryCursor(context.Context) (products.ExpiryCursor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cursor, nil
+}
+
+func (f *fakeStore) SaveExpiryCursor(_ context.Context, cursor products.ExpiryCursor) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cursor = cursor
+	f.saves++
+	return nil
+}
+
+func (f *fakeStore) ListExpired(_ context.Context, after products.ExpiryCursor, limit int) ([]products.Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []products.Product
+	for _, p := range f.expired {
+		if len(list) == limit {
+			break
+		}
+		if p.ExpiresAt.After(after.ExpiresAt) || p.ExpiresAt.Equal(after.ExpiresAt) && p.ID > after.ProductID {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  int
+	published []int64
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, event products.ProductEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker down")
+	}
+	if event.EventType != products.EventExpired {
+		return errors.New("unexpected event type " + event.EventType)
+	}
+	p.published = append(p.published, event.ProductID)
+	return nil
+}
+
+// newStore returns products 1-5 in expiry order; 2 and 3 expire at the
+// same instant so the cursor has to break the tie by id.
+func newStore(start time.Time) *fakeStore {
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+	return &fakeStore{
+		cursor: products.ExpiryCursor{ExpiresAt: start},
+		expired: []products.Product{
+			{ID: 1, ExpiresAt: at(time.Second)},
+			{ID: 2, ExpiresAt: at(2 * time.Second)},
+			{ID: 3, ExpiresAt: at(2 * time.Second)},
+			{ID: 4, ExpiresAt: at(3 * time.Second)},
+			{ID: 5, ExpiresAt: at(4 * time.Second)},
+		},
+	}
+}
+
+func newTestSweeper(store Store, pub Publisher) *Sweeper {
+	return NewSweeper(store, pub, Config{Interval: time.Millisecond, BatchSize: 2}, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+func TestSweeper_PublishesEachExpiryOnce(t *testing.T) {
+	store := newStore(time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC))
+	pub := &flakyPublisher{failures: 1}
+	sweeper := newTestSweeper(store, pub)
+	ctx := context.Background()
+
+	if _, err := sweeper.Sweep(ctx); err == nil {
+		t.Fatal("want the publish failure reported")
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := sweeper.Sweep(ctx); err != nil {
+			t.Fatalf("sweep %d: %v", i, err)
+		}
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(pub.published, want) {
+		t.Fatalf("want each expiry published once in order %v, got %v", want, pub.published)
+	}
+	if n, _ := sweeper.Sweep(ctx); n != 0 {
+		t.Fatalf("want nothing left to sweep, published %d", n)
+	}
+}
+
+func TestSweeper_ResumesFromSavedCursor(t *testing.T) {
+	store := newStore(time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC))
+	first := &flakyPublisher{}
+	if n, err := newTestSweeper(store, first).Sweep(context.Background()); err != nil || n != 2 {
+		t.Fatalf("first sweep: published %d, err %v", n, err)
+	}
+
+	// A restarted sweeper picks up the saved cursor.
+	second := &flakyPublisher{}
+	sweeper := newTestSweeper(store, second)
+	for i := 0; i < 3; i++ {
+		if _, err := sweeper.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []int64{3, 4, 5}; !reflect.DeepEqual(second.published, want) {
+		t.Fatalf("want only unswept expiries %v after restart, got %v", want, second.published)
+	}
+}
+
+func TestSweeper_Run(t *testing.T) {
+	store := newStore(time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC))
+	pub := &flakyPublisher{}
+	sweeper := newTestSweeper(store, pub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		pub.mu.Lock()
+		n := len(pub.published)
+		pub.mu.Unlock()
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want 5 expiries published, got %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.cursor.ProductID != 5 {
+		t.Fatalf("want cursor saved at product 5, got %+v", store.cursor)
+	}
+}
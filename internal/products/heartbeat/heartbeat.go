@@ -0,0 +1,55 @@
+// Package heartbeat publishes a periodic liveness event, so consumers can
+// tell the products service is up even while the catalog does not change.
+package heartbeat
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, event products.ProductEvent) error
+}
+
+// Heartbeat publishes a products_heartbeat event carrying its instance id
+// every interval. Unlike the sweeper it elects no leader: every instance
+// beats, so consumers can tell them apart.
+type Heartbeat struct {
+	publisher  Publisher
+	interval   time.Duration
+	instanceID string
+	logger     *slog.Logger
+}
+
+func New(publisher Publisher, interval time.Duration, instanceID string, logger *slog.Logger) *Heartbeat {
+	return &Heartbeat{publisher: publisher, interval: interval, instanceID: instanceID, logger: logger}
+}
+
+// Run beats every interval until ctx is done. A failed beat is logged and
+// not retried; the next one is due soon enough.
+func (h *Heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := h.beat(ctx, now); err != nil && ctx.Err() == nil {
+				h.logger.Error("publish heartbeat", "error", err)
+			}
+		}
+	}
+}
+
+func (h *Heartbeat) beat(ctx context.Context, now time.Time) error {
+	return h.publisher.Publish(ctx, products.ProductEvent{
+		EventType:  products.EventHeartbeat,
+		InstanceID: h.instanceID,
+		Timestamp:  now.UTC(),
+	})
+}
@@ -0,0 +1,101 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	err    error
+	events []products.ProductEvent
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event products.ProductEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return p.err
+}
+
+func (p *recordingPublisher) snapshot() []products.ProductEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]products.ProductEvent(nil), p.events...)
+}
+
+func newTestHeartbeat(pub Publisher, interval time.Duration) *Heartbeat {
+	return New(pub, interval, "products-1", slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+func TestHeartbeat_Cadence(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	pub := &recordingPublisher{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newTestHeartbeat(pub, interval).Run(ctx)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(pub.snapshot()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	events := pub.snapshot()
+	if len(events) < 3 {
+		t.Fatalf("want at least 3 heartbeats, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.EventType != products.EventHeartbeat || event.InstanceID != "products-1" || event.Timestamp.IsZero() {
+			t.Fatalf("heartbeat %d: unexpected event %+v", i, event)
+		}
+		if i == 0 {
+			continue
+		}
+		// Ticker ticks are spaced by interval, though a slow receiver may
+		// see them bunched up; they are never closer than half of it.
+		if gap := event.Timestamp.Sub(events[i-1].Timestamp); gap < interval/2 {
+			t.Fatalf("heartbeat %d: want ~%v after the previous, got %v", i, interval, gap)
+		}
+	}
+
+	after := len(events)
+	time.Sleep(3 * interval)
+	if got := len(pub.snapshot()); got != after {
+		t.Fatalf("want no heartbeats after Run returns, got %d more", got-after)
+	}
+}
+
+func TestHeartbeat_KeepsBeatingAfterFailure(t *testing.T) {
+	pub := &recordingPublisher{err: errors.New("broker down")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newTestHeartbeat(pub, time.Millisecond).Run(ctx)
+	}()
+
+	for len(pub.snapshot()) < 2 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := len(pub.snapshot()); got < 2 {
+		t.Fatalf("want heartbeats to continue after a failure, got %d attempts", got)
+	}
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultExportBatchSize = 500
+
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+
+	mimeNDJSON = "application/x-ndjson"
+	mimeCSV    = "text/csv"
+)
+
+// streamFormats maps the media types GET /products can stream to their
+// export format.
+var streamFormats = map[string]string{
+	mimeNDJSON: exportFormatNDJSON,
+	mimeCSV:    exportFormatCSV,
+}
+
+// exportCSVHeader leads with id, the column dropped under public ids.
+var exportCSVHeader = []string{"id", "public_id", "name", "owner", "created_at", "expires_at", "attributes"}
+
+// exportEncoder writes products in one export format. Writes may be
+// buffered until flush.
+type exportEncoder interface {
+	contentType() string
+	begin() error
+	write(p products.Product) error
+	flush() error
+}
+
+// newExportEncoder returns an encoder for format. With publicIDs the CSV
+// has no id column; NDJSON leaves the id out like any other response under
+// WithPublicIDs.
+func newExportEncoder(format string, w io.Writer, publicIDs bool) (exportEncoder, bool) {
+	switch format {
+	case exportFormatNDJSON:
+		buf := bufio.NewWriter(w)
+		return &ndjsonEncoder{buf: buf, enc: json.NewEncoder(buf)}, true
+	case exportFormatCSV:
+		return &csvEncoder{w: csv.NewWriter(w), omitID: publicIDs}, true
+	default:
+		return nil, false
+	}
+}
+
+type ndjsonEncoder struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) contentType() string { return mimeNDJSON }
+
+func (e *ndjsonEncoder) begin() error { return nil }
+
+// write relies on json.Encoder ending every value with a newline.
+func (e *ndjsonEncoder) write(p products.Product) error { return e.enc.Encode(p) }
+
+func (e *ndjsonEncoder) flush() error { return e.buf.Flush() }
+
+type csvEncoder struct {
+	w      *csv.Writer
+	omitID bool
+}
+
+func (e *csvEncoder) contentType() string { return mimeCSV + "; charset=utf-8" }
+
+func (e *csvEncoder) begin() error {
+	if e.omitID {
+		return e.w.Write(exportCSVHeader[1:])
+	}
+	return e.w.Write(exportCSVHeader)
+}
+
+func (e *csvEncoder) write(p products.Product) error {
+	var expiresAt, attrs string
+	if p.ExpiresAt != nil {
+		expiresAt = p.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	if len(p.Attributes) > 0 {
+		raw, err := json.Marshal(p.Attributes)
+		if err != nil {
+			return err
+		}
+		attrs = string(raw)
+	}
+	record := []string{
+		strconv.FormatInt(p.ID, 10),
+		p.PublicID,
+		p.Name,
+		p.Owner,
+		p.CreatedAt.UTC().Format(time.RFC3339Nano),
+		expiresAt,
+		attrs,
+	}
+	if e.omitID {
+		record = record[1:]
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ExportProducts godoc
+// @Summary      Export all products as a stream
+// @Description  Streams every unexpired product in id order, one JSON object per line or one CSV row each. Rows are read and flushed to the client EXPORT_BATCH_SIZE at a time.
+// @Tags         products
+// @Produce      application/x-ndjson,text/csv
+// @Param        format  query     string  false  "ndjson or csv"  default(ndjson)
+// @Success      200
+// @Failure      400     {object}  errorResponse
+// @Failure      500     {object}  errorResponse
+// @Failure      503     {object}  errorResponse
+// @Router       /products/export [get]
+func (h *Handler) ExportProducts(c *gin.Context) {
+	enc, ok := newExportEncoder(c.DefaultQuery("format", exportFormatNDJSON), c.Writer, h.publicIDs)
+	if !ok {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid export format"})
+		return
+	}
+	h.streamProducts(c, enc)
+}
+
+// streamProducts writes every unexpired product with enc, flushing after
+// each batch.
+func (h *Handler) streamProducts(c *gin.Context, enc exportEncoder) {
+	if h.exportSlots != nil {
+		if !h.exportSlots.TryAcquire(1) {
+			h.retryAfter.respond503(c, reasonOverloaded, "too many exports in progress")
+			return
+		}
+		defer h.exportSlots.Release(1)
+	}
+
+	// The status and headers go out with the first batch, so a failure
+	// before it can still answer 500.
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", enc.contentType())
+		c.Status(http.StatusOK)
+		return enc.begin()
+	}
+
+	err := h.service.ExportProducts(c.Request.Context(), h.exportBatchSize, func(batch []products.Product) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for _, p := range batch {
+			if err := enc.write(h.present(p)); err != nil {
+				return err
+			}
+		}
+		if err := enc.flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil && !started {
+		if err = start(); err == nil {
+			err = enc.flush()
+		}
+	}
+	if err != nil {
+		if !started {
+			respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to export products"})
+			return
+		}
+		// The response is under way; stopping leaves the client with a
+		// truncated body.
+		_ = c.Error(err)
+	}
+}
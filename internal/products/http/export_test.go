@@ -0,0 +1,280 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flushRecorder counts flushes and what had been written at each one.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushedAt = append(r.flushedAt, strings.Count(r.Body.String(), "\n"))
+	r.ResponseRecorder.Flush()
+}
+
+// pagingService serves products 1..total in batches of the size it is
+// asked for, like Service.ExportProducts.
+func pagingService(total int64, gotBatchSize *int) *stubService {
+	return &stubService{
+		exportFn: func(_ context.Context, batchSize int, emit func([]products.Product) error) error {
+			*gotBatchSize = batchSize
+			var batch []products.Product
+			for id := int64(1); id <= total; id++ {
+				batch = append(batch, products.Product{ID: id, Name: "p", CreatedAt: time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC)})
+				if len(batch) == batchSize || id == total {
+					if err := emit(batch); err != nil {
+						return err
+					}
+					batch = nil
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func serveExport(h *Handler, url string) *flushRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/products/export", h.ExportProducts)
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, http.NoBody))
+	return w
+}
+
+func TestHandler_ExportProducts_FlushesPerBatch(t *testing.T) {
+	var batchSize int
+	w := serveExport(NewHandler(pagingService(7, &batchSize), WithExportBatchSize(3)), "/products/export")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if batchSize != 3 {
+		t.Fatalf("want batch size 3 passed to the service, got %d", batchSize)
+	}
+	// One flush per batch, each after the whole batch was written.
+	if want := []int{3, 6, 7}; !reflect.DeepEqual(w.flushedAt, want) {
+		t.Fatalf("want flushes after lines %v, got %v", want, w.flushedAt)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Fatalf("want ndjson content type, got %q", got)
+	}
+
+	scanner := bufio.NewScanner(w.Body)
+	var next int64 = 1
+	for scanner.Scan() {
+		var p products.Product
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			t.Fatalf("decode line %q: %v", scanner.Text(), err)
+		}
+		if p.ID != next {
+			t.Fatalf("want product %d next, got %d", next, p.ID)
+		}
+		next++
+	}
+	if next != 8 {
+		t.Fatalf("want 7 products exported, got %d", next-1)
+	}
+}
+
+func TestHandler_ExportProducts(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		svcErr     error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "csv",
+			url:        "/products/export?format=csv",
+			wantStatus: http.StatusOK,
+			wantBody: "id,public_id,name,owner,created_at,expires_at,attributes\n" +
+				"1,,p,,2026-02-24T12:00:00Z,,\n",
+		},
+		{
+			name:       "unknown format",
+			url:        "/products/export?format=xml",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure before the first batch",
+			url:        "/products/export",
+			svcErr:     errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batchSize int
+			svc := pagingService(1, &batchSize)
+			if tt.svcErr != nil {
+				svc.exportFn = func(context.Context, int, func([]products.Product) error) error { return tt.svcErr }
+			}
+
+			w := serveExport(NewHandler(svc), tt.url)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Fatalf("want body %q, got %q", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_Accept(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "no accept header",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"items":[{"id":9,"name":"json","created_at":"0001-01-01T00:00:00Z"}],"pagination":{"page":1,"limit":10,"total":1,"grand_total":1}}`,
+		},
+		{
+			name:            "json",
+			accept:          "application/json",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"items":[{"id":9,"name":"json","created_at":"0001-01-01T00:00:00Z"}],"pagination":{"page":1,"limit":10,"total":1,"grand_total":1}}`,
+		},
+		{
+			name:            "csv",
+			accept:          "text/csv",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "id,public_id,name,owner,created_at,expires_at,attributes\n" +
+				"1,,p,,2026-02-24T12:00:00Z,,\n",
+		},
+		{
+			name:            "ndjson",
+			accept:          "application/x-ndjson",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"id":1,"name":"p","created_at":"2026-02-24T12:00:00Z"}` + "\n",
+		},
+		{
+			name:       "csv refuses a filter",
+			accept:     "text/csv",
+			query:      "?search=phone",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ndjson refuses a filter",
+			accept:     "application/x-ndjson",
+			query:      "?status=archived",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported",
+			accept:     "application/xml",
+			wantStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batchSize int
+			svc := pagingService(1, &batchSize)
+			svc.listFn = func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+				return []products.Product{{ID: 9, Name: "json"}}, 1, nil
+			}
+			r := setupRouter(svc)
+			req := httptest.NewRequest(http.MethodGet, "/products"+tt.query, http.NoBody)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Vary"); got != "Accept" {
+				t.Fatalf("want Vary: Accept, got %q", got)
+			}
+			if tt.wantContentType != "" && w.Header().Get("Content-Type") != tt.wantContentType {
+				t.Fatalf("want content type %q, got %q", tt.wantContentType, w.Header().Get("Content-Type"))
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Fatalf("want body %q, got %q", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ExportProducts_ConcurrencyLimit(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	svc := &stubService{
+		exportFn: func(_ context.Context, _ int, emit func([]products.Product) error) error {
+			entered <- struct{}{}
+			<-release
+			return emit([]products.Product{{ID: 1, Name: "p"}})
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewHandler(svc, WithExportConcurrency(1), WithRetryAfter(RetryAfterPolicy{Overloaded: 3 * time.Second}))
+	r.GET("/products/export", h.ExportProducts)
+	r.GET("/products", h.ListProducts)
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/export", http.NoBody))
+		done <- w.Code
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/export?format=csv", http.NoBody))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get(retryAfterHeader) != "3" {
+		t.Fatalf("want 503 with Retry-After 3 while the slot is taken, got %d %q", w.Code, w.Header().Get(retryAfterHeader))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/products", http.NoBody)
+	req.Header.Set("Accept", mimeNDJSON)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want a streamed list to share the export slots, got %d", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("want the running export to finish with 200, got %d", code)
+	}
+
+	go func() { <-entered }()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/export", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want exports served again once the slot frees up, got %d", w.Code)
+	}
+}
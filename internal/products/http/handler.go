@@ -2,40 +2,384 @@ package http
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/jobs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
 	defaultPage  = 1
 	defaultLimit = 10
+	// maxLimit mirrors the service's page size cap, so pagination links
+	// and echoed limits describe the page actually served.
+	maxLimit = 100
+
+	jobsPath = "/products/jobs/"
+
+	bulkModeAtomic  = "atomic"
+	bulkModePartial = "partial"
+
+	itemStatusCreated = "created"
+	itemStatusFailed  = "failed"
+
+	returnMinimal        = "minimal"
+	returnRepresentation = "representation"
+
+	// ownerHeader names the tenant a created product belongs to. It is
+	// expected to be set by an authenticating proxy, not by end clients.
+	ownerHeader    = "X-Owner-ID"
+	maxOwnerLength = 100
+
+	defaultSuggestMinPrefix = 2
+
+	// strictQueryParam turns on strict query checking for one request.
+	strictQueryParam = "strict"
+	// emptyNotFoundParam picks 404 or 200 for one filtered list that
+	// matches nothing.
+	emptyNotFoundParam = "empty_not_found"
+	// createIfAbsentParam makes one create return the product already
+	// holding the name instead of answering 409.
+	createIfAbsentParam = "create_if_absent"
+	// paginationLink and paginationBody are the values of the pagination
+	// preference in a Prefer header.
+	paginationLink = "link"
+	paginationBody = "body"
+
+	codeDuplicateName = "DUPLICATE_NAME"
+	codeNameRequired  = "NAME_REQUIRED"
 )
 
+// listQueryParams are the query parameters GET /products understands; in
+// strict mode any other one is rejected.
+var listQueryParams = map[string]bool{
+	"page":             true,
+	"limit":            true,
+	"search":           true,
+	"rank":             true,
+	"attributes":       true,
+	"exact":            true,
+	"include_expired":  true,
+	"status":           true,
+	"min_id":           true,
+	"max_id":           true,
+	strictQueryParam:   true,
+	emptyNotFoundParam: true,
+}
+
+// listFilterParams are the GET /products query parameters that narrow the
+// list. A streamed list cannot apply them, so they are refused there rather
+// than silently dropped.
+var listFilterParams = []string{"search", "attributes", "include_expired", "status", "min_id", "max_id"}
+
 type ProductService interface {
-	CreateProduct(ctx context.Context, name string) (products.Product, error)
-	DeleteProduct(ctx context.Context, id int64) error
-	ListProducts(ctx context.Context, page, limit int) ([]products.Product, int64, error)
+	CreateProduct(ctx context.Context, in products.CreateInput) (products.Product, error)
+	CreateProductIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error)
+	CreateProducts(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error)
+	CreateProductsPartial(ctx context.Context, inputs []products.CreateInput) ([]products.CreateResult, error)
+	UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, error)
+	PublishProduct(ctx context.Context, id int64) (products.Product, error)
+	ArchiveProduct(ctx context.Context, id int64) (products.Product, error)
+	DeleteProduct(ctx context.Context, id int64) (products.Product, error)
+	DeleteProductByPublicID(ctx context.Context, publicID string) (products.Product, error)
+	DeleteProducts(ctx context.Context, ids []int64) (int64, error)
+	PreviewDelete(ctx context.Context, id int64) (products.Product, error)
+	PreviewDeleteByPublicID(ctx context.Context, publicID string) (products.Product, error)
+	GetProduct(ctx context.Context, id int64) (products.Product, error)
+	GetProductByPublicID(ctx context.Context, publicID string) (products.Product, error)
+	GetProductBySlug(ctx context.Context, slug string) (products.Product, error)
+	ProductPosition(ctx context.Context, id int64, limit int) (products.ListPosition, error)
+	ReplayProduct(ctx context.Context, id int64) error
+	ReserveProduct(ctx context.Context, id int64, by string) (products.Reservation, error)
+	ReleaseProduct(ctx context.Context, id int64, by string) error
+	ListProducts(ctx context.Context, opts products.ListOptions, page, limit int) ([]products.Product, products.ListTotals, error)
+	SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error)
+	ExportProducts(ctx context.Context, batchSize int, emit func([]products.Product) error) error
+}
+
+// CreateQueue runs creates in the background for the async create mode.
+type CreateQueue interface {
+	Submit(ctx context.Context, in products.CreateInput) (jobs.Job, error)
+	Get(id string) (jobs.Job, bool)
+}
+
+// ReadOnlyMode reports whether writes are currently refused, either because
+// an operator forced it or because publishing keeps failing.
+type ReadOnlyMode interface {
+	ReadOnly() bool
 }
 
+// PublisherFlusher delivers every event waiting to be published,
+// reporting how many were published and how many failed.
+type PublisherFlusher interface {
+	Flush(ctx context.Context) (flushed, failed int, err error)
+}
+
+// ForcedReadOnly refuses writes for as long as the service runs.
+type ForcedReadOnly struct{}
+
+func (ForcedReadOnly) ReadOnly() bool { return true }
+
 type Handler struct {
-	service ProductService
+	service          ProductService
+	jobs             CreateQueue
+	searchTimeout    time.Duration
+	publicIDs        bool
+	suggestMinPrefix int
+	readOnly         ReadOnlyMode
+	flusher          PublisherFlusher
+	retryAfter       RetryAfterPolicy
+	strictQuery      bool
+	exportBatchSize  int
+	// exportSlots, when set, caps concurrent export streams.
+	exportSlots *semaphore.Weighted
+	// emptyNotFound answers 404 instead of an empty page when a filtered
+	// list matches nothing.
+	emptyNotFound bool
+	// rankSearch orders searches by relevance unless the request sets
+	// rank=false.
+	rankSearch bool
+	// linkPagination lists products as a bare array, with pagination in
+	// the Link and X-Total-Count headers, unless the request prefers the
+	// envelope.
+	linkPagination bool
+	// requireOwner refuses creates that name no owner.
+	requireOwner bool
+	// noMetrics leaves GET /metrics unregistered.
+	noMetrics bool
+	// info, when set, registers the admin GET /internal/info endpoint.
+	info *runtimeInfo
+}
+
+type Option func(*Handler)
+
+// WithAsyncCreate makes POST /products queue the create on q and answer
+// 202 with a job to poll, instead of creating the product inline.
+func WithAsyncCreate(q CreateQueue) Option {
+	return func(h *Handler) {
+		h.jobs = q
+	}
+}
+
+// WithSearchStatementTimeout caps each database statement of a filtered
+// list (search or attributes) at d, so one expensive search cannot hold a
+// connection for the global statement timeout.
+func WithSearchStatementTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.searchTimeout = d
+	}
+}
+
+// WithPublicIDs makes the {id} path parameter a product's public UUID
+// instead of its internal integer id.
+func WithPublicIDs() Option {
+	return func(h *Handler) {
+		h.publicIDs = true
+	}
+}
+
+// WithSuggestMinPrefix sets how many characters GET /products/suggest
+// needs before it searches; shorter prefixes answer 400.
+func WithSuggestMinPrefix(n int) Option {
+	return func(h *Handler) {
+		h.suggestMinPrefix = n
+	}
+}
+
+// WithReadOnly makes writes answer 503 while mode reports read-only, and
+// /healthz report the service as degraded.
+func WithReadOnly(mode ReadOnlyMode) Option {
+	return func(h *Handler) {
+		h.readOnly = mode
+	}
+}
+
+// WithRetryAfter sets the Retry-After delays of the handler's 503
+// responses, including those of the read-only middleware RegisterRoutes
+// installs and of /healthz.
+func WithRetryAfter(p RetryAfterPolicy) Option {
+	return func(h *Handler) {
+		h.retryAfter = p
+	}
+}
+
+// WithPublisherFlush registers the admin POST /internal/flush endpoint,
+// which drains f's buffer on demand.
+func WithPublisherFlush(f PublisherFlusher) Option {
+	return func(h *Handler) {
+		h.flusher = f
+	}
+}
+
+// WithEmptyFilterNotFound makes GET /products answer 404 when its search or
+// attributes filter matches no product, unless the request sets
+// empty_not_found=false. Unfiltered lists of an empty table stay 200.
+func WithEmptyFilterNotFound() Option {
+	return func(h *Handler) {
+		h.emptyNotFound = true
+	}
+}
+
+// WithRankedSearch makes GET /products match search against the words of
+// product names and order the matches by relevance, unless the request
+// sets rank=false.
+func WithRankedSearch() Option {
+	return func(h *Handler) {
+		h.rankSearch = true
+	}
+}
+
+// WithLinkPagination makes GET /products answer a bare array of products
+// and carry its pagination in Link and X-Total-Count headers, unless the
+// request sends Prefer: pagination=body.
+func WithLinkPagination() Option {
+	return func(h *Handler) {
+		h.linkPagination = true
+	}
+}
+
+// WithExportBatchSize sets how many products GET /products/export reads per
+// query and writes between flushes to the client.
+func WithExportBatchSize(n int) Option {
+	return func(h *Handler) {
+		h.exportBatchSize = n
+	}
+}
+
+// WithExportConcurrency caps concurrent export streams, from
+// GET /products/export or a streamed GET /products, at n. Streams past the
+// cap get 503 with the Overloaded Retry-After rather than competing with
+// regular traffic for database connections. Zero or less is unlimited.
+func WithExportConcurrency(n int) Option {
+	return func(h *Handler) {
+		if n > 0 {
+			h.exportSlots = semaphore.NewWeighted(int64(n))
+		}
+	}
+}
+
+// WithStrictQuery makes GET /products reject unknown query parameters with
+// 400 instead of ignoring them, as ?strict=true does for a single request.
+func WithStrictQuery() Option {
+	return func(h *Handler) {
+		h.strictQuery = true
+	}
 }
 
-func NewHandler(svc ProductService) *Handler {
-	return &Handler{service: svc}
+// WithRequiredOwner makes creates without an X-Owner-ID header answer 400.
+// Owner quotas count products per owner, so with quotas on an ownerless
+// create would otherwise escape them.
+func WithRequiredOwner() Option {
+	return func(h *Handler) {
+		h.requireOwner = true
+	}
+}
+
+// WithoutMetrics leaves GET /metrics unregistered, for a service that
+// does not register its metrics with Prometheus.
+func WithoutMetrics() Option {
+	return func(h *Handler) {
+		h.noMetrics = true
+	}
+}
+
+func NewHandler(svc ProductService, opts ...Option) *Handler {
+	h := &Handler{service: svc, suggestMinPrefix: defaultSuggestMinPrefix, exportBatchSize: defaultExportBatchSize}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 type createProductRequest struct {
-	Name string `json:"name" binding:"required" example:"iPhone 16"`
+	// max mirrors products.MaxNameLength; the service checks it again.
+	Name       string         `json:"name" binding:"required,notblank,min=1,max=200,productname" example:"iPhone 16"`
+	Attributes map[string]any `json:"attributes" swaggertype:"object"`
+	// ExpiresAt hides the product from reads once it passes.
+	ExpiresAt *time.Time `json:"expires_at" example:"2026-12-31T23:59:59Z"`
+	// Status creates the product as a draft, left out of default lists
+	// until it is published; published when empty.
+	Status string `json:"status" binding:"omitempty,oneof=draft published" enums:"draft,published"`
+}
+
+type createProductsRequest struct {
+	Items []createProductRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type createProductsResponse struct {
+	Items []products.Product `json:"items"`
+}
+
+type deleteProductsRequest struct {
+	IDs []int64 `json:"ids" binding:"required,min=1,dive,min=1"`
+}
+
+type deleteProductsResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// deleteImpactResponse is what a dry-run delete reports instead of deleting.
+type deleteImpactResponse struct {
+	WouldDelete bool     `json:"would_delete"`
+	Events      []string `json:"events"`
+}
+
+// reserveRequest names who holds a reservation, or who releases it;
+// without it the request's actor does.
+type reserveRequest struct {
+	ReservedBy string `json:"reserved_by" example:"checkout-42"`
+}
+
+// createProductsPartialRequest leaves items unvalidated at binding, so an
+// invalid item fails on its own in the results instead of failing the
+// request.
+type createProductsPartialRequest struct {
+	Items []struct {
+		Name       string         `json:"name"`
+		Attributes map[string]any `json:"attributes"`
+		ExpiresAt  *time.Time     `json:"expires_at"`
+		Status     string         `json:"status"`
+	} `json:"items" binding:"required,min=1"`
+}
+
+type bulkItemResult struct {
+	Index    int    `json:"index" example:"0"`
+	ID       int64  `json:"id,omitempty" example:"1"`
+	PublicID string `json:"public_id,omitempty" example:"0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"`
+	Status   string `json:"status" example:"created"`
+	Error    string `json:"error,omitempty"`
+}
+
+type bulkResultsResponse struct {
+	Results []bulkItemResult `json:"results"`
 }
 
 type errorResponse struct {
 	Error string `json:"error" example:"product not found"`
+	// Fields maps JSON paths of invalid request fields to what is wrong
+	// with them.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Code identifies errors clients may act on, e.g. DUPLICATE_NAME.
+	Code string `json:"code,omitempty" example:"DUPLICATE_NAME"`
+	// ExistingID is the product already holding a duplicate name; with
+	// PRODUCT_ID_TYPE=uuid ExistingPublicID is set instead.
+	ExistingID       int64  `json:"existing_id,omitempty" example:"1"`
+	ExistingPublicID string `json:"existing_public_id,omitempty"`
+
+	// problemType is the RFC 7807 type of the error when it is sent as
+	// problem+json; see errorFor.
+	problemType string
 }
 
 type listProductsResponse struct {
@@ -43,10 +387,24 @@ type listProductsResponse struct {
 	Pagination paginationMeta     `json:"pagination"`
 }
 
+type flushResponse struct {
+	Flushed int `json:"flushed" example:"12"`
+	Failed  int `json:"failed" example:"0"`
+}
+
 type paginationMeta struct {
-	Page  int   `json:"page" example:"1"`
-	Limit int   `json:"limit" example:"10"`
-	Total int64 `json:"total" example:"42"`
+	Page  int `json:"page" example:"1"`
+	Limit int `json:"limit" example:"10"`
+	// Total counts the products matching the search and attribute
+	// filters, the count the pages run over; GrandTotal counts every
+	// product regardless of them. They are equal for an unfiltered list.
+	Total      int64 `json:"total" example:"12"`
+	GrandTotal int64 `json:"grand_total" example:"4532"`
+	// TotalApproximate and GrandTotalApproximate mark their count as the
+	// planner's estimate (APPROX_COUNT_ABOVE). Only unfiltered counts are
+	// ever estimated.
+	TotalApproximate      bool `json:"total_approximate,omitempty" example:"false"`
+	GrandTotalApproximate bool `json:"grand_total_approximate,omitempty" example:"false"`
 }
 
 // CreateProduct godoc
@@ -55,88 +413,1065 @@ type paginationMeta struct {
 // @Accept       json
 // @Produce      json
 // @Param        body  body      createProductRequest  true  "Product data"
+// @Param        X-Owner-ID  header  string  false  "Owner the product belongs to; required when owner quotas are on"
+// @Param        create_if_absent  query  bool  false  "Answer 200 with the product already holding the name instead of 409"
+// @Success      200   {object}  products.Product  "create_if_absent: the name was taken by this product"
 // @Success      201   {object}  products.Product
+// @Success      202   {object}  jobs.Job  "Async create mode: poll the Location header"
 // @Failure      400   {object}  errorResponse
+// @Failure      403   {object}  errorResponse
+// @Failure      409   {object}  errorResponse
+// @Failure      422   {object}  errorResponse
 // @Failure      500   {object}  errorResponse
+// @Failure      503   {object}  errorResponse
 // @Router       /products [post]
 func (h *Handler) CreateProduct(c *gin.Context) {
+	owner, ok := h.parseOwner(c)
+	if !ok {
+		return
+	}
+
 	var req createProductRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		respondError(c, http.StatusBadRequest, bindErrorResponse(err))
+		return
+	}
+
+	ifAbsent := false
+	if raw := c.Query(createIfAbsentParam); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid create_if_absent flag"})
+			return
+		}
+		ifAbsent = parsed
+	}
+
+	in := products.CreateInput{Name: req.Name, Owner: owner, Attributes: req.Attributes, ExpiresAt: req.ExpiresAt, Status: req.Status}
+	// A create-if-absent answers with the product either way, so it is
+	// never queued.
+	if ifAbsent {
+		h.createIfAbsent(c, in)
+		return
+	}
+	if h.jobs != nil {
+		h.submitCreate(c, in)
+		return
+	}
+
+	product, err := h.service.CreateProduct(c.Request.Context(), in)
+	if err != nil {
+		h.createError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.present(product))
+}
+
+// createIfAbsent answers 201 with the created product, or 200 with the
+// product that already holds the name.
+func (h *Handler) createIfAbsent(c *gin.Context, in products.CreateInput) {
+	product, created, err := h.service.CreateProductIfAbsent(c.Request.Context(), in)
+	if err != nil {
+		h.createError(c, err)
 		return
 	}
 
-	product, err := h.service.CreateProduct(c.Request.Context(), req.Name)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, h.present(product))
+}
+
+// createError answers a failed single create.
+func (h *Handler) createError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, products.ErrInvalidName):
+		respondError(c, http.StatusBadRequest, nameRequiredResponse())
+	case isValidationError(err):
+		respondError(c, http.StatusBadRequest, errorFor(err))
+	case errors.Is(err, products.ErrDuplicateName):
+		respondError(c, http.StatusConflict, h.duplicateNameResponse(err))
+	case errors.Is(err, products.ErrWebhookRejected):
+		respondError(c, http.StatusUnprocessableEntity, errorFor(products.ErrWebhookRejected))
+	case errors.Is(err, products.ErrNameNotAllowed):
+		respondError(c, http.StatusUnprocessableEntity, errorFor(products.ErrNameNotAllowed))
+	case errors.Is(err, products.ErrQuotaExceeded):
+		respondError(c, http.StatusForbidden, errorFor(products.ErrQuotaExceeded))
+	default:
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to create product"})
+	}
+}
+
+// duplicateNameResponse points the client at the product that already has
+// the name, when the repository could tell which one it is.
+func (h *Handler) duplicateNameResponse(err error) errorResponse {
+	resp := errorFor(products.ErrDuplicateName)
+	resp.Code = codeDuplicateName
+	var dup *products.DuplicateNameError
+	if errors.As(err, &dup) {
+		if h.publicIDs {
+			resp.ExistingPublicID = dup.ExistingPublicID
+		} else {
+			resp.ExistingID = dup.ExistingID
+		}
+	}
+	return resp
+}
+
+func (h *Handler) submitCreate(c *gin.Context, in products.CreateInput) {
+	job, err := h.jobs.Submit(c.Request.Context(), in)
 	if err != nil {
-		if errors.Is(err, products.ErrInvalidName) {
-			c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		h.retryAfter.respond503(c, reasonOverloaded, err.Error())
+		return
+	}
+
+	c.Header("Location", jobsPath+job.ID)
+	c.JSON(http.StatusAccepted, h.presentJob(job))
+}
+
+// GetCreateJob godoc
+// @Summary      Get the status of an async create
+// @Tags         products
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200  {object}  jobs.Job
+// @Failure      404  {object}  errorResponse
+// @Router       /products/jobs/{id} [get]
+func (h *Handler) GetCreateJob(c *gin.Context) {
+	job, ok := h.jobs.Get(c.Param("id"))
+	if !ok {
+		respondError(c, http.StatusNotFound, errorResponse{Error: "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.presentJob(job))
+}
+
+// CreateProducts godoc
+// @Summary      Create several products at once
+// @Description  By default (mode=atomic) all products are created or none. With mode=partial each item is created on its own and the response is 207 with a result per item. Either way product_created events are published asynchronously through the outbox.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        mode  query     string                 false  "atomic or partial"  Enums(atomic, partial)  default(atomic)
+// @Param        X-Owner-ID  header  string             false  "Owner the products belong to; required when owner quotas are on"
+// @Param        body  body      createProductsRequest  true   "Products"
+// @Success      201   {object}  createProductsResponse
+// @Success      207   {object}  bulkResultsResponse
+// @Failure      400   {object}  errorResponse
+// @Failure      403   {object}  errorResponse
+// @Failure      409   {object}  errorResponse
+// @Failure      422   {object}  errorResponse
+// @Failure      500   {object}  errorResponse
+// @Failure      503   {object}  errorResponse
+// @Router       /products/bulk [post]
+func (h *Handler) CreateProducts(c *gin.Context) {
+	owner, ok := h.parseOwner(c)
+	if !ok {
+		return
+	}
+
+	switch c.DefaultQuery("mode", bulkModeAtomic) {
+	case bulkModeAtomic:
+	case bulkModePartial:
+		h.createProductsPartial(c, owner)
+		return
+	default:
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid bulk mode"})
+		return
+	}
+
+	var req createProductsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, bindErrorResponse(err))
+		return
+	}
+
+	inputs := make([]products.CreateInput, len(req.Items))
+	for i, item := range req.Items {
+		inputs[i] = products.CreateInput{Name: item.Name, Owner: owner, Attributes: item.Attributes, ExpiresAt: item.ExpiresAt, Status: item.Status}
+	}
+
+	created, err := h.service.CreateProducts(c.Request.Context(), inputs)
+	if err != nil {
+		switch {
+		case isValidationError(err), errors.Is(err, products.ErrBatchTooLarge):
+			respondError(c, http.StatusBadRequest, errorFor(err))
+		case errors.Is(err, products.ErrDuplicateName):
+			respondError(c, http.StatusConflict, errorFor(err))
+		case errors.Is(err, products.ErrWebhookRejected), errors.Is(err, products.ErrNameNotAllowed):
+			respondError(c, http.StatusUnprocessableEntity, errorFor(err))
+		case errors.Is(err, products.ErrQuotaExceeded):
+			respondError(c, http.StatusForbidden, errorFor(err))
+		default:
+			respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to create products"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, createProductsResponse{Items: h.presentAll(created)})
+}
+
+func (h *Handler) createProductsPartial(c *gin.Context, owner string) {
+	var req createProductsPartialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, bindErrorResponse(err))
+		return
+	}
+
+	inputs := make([]products.CreateInput, len(req.Items))
+	for i, item := range req.Items {
+		inputs[i] = products.CreateInput{Name: item.Name, Owner: owner, Attributes: item.Attributes, ExpiresAt: item.ExpiresAt, Status: item.Status}
+	}
+
+	results, err := h.service.CreateProductsPartial(c.Request.Context(), inputs)
+	if err != nil {
+		if errors.Is(err, products.ErrBatchTooLarge) {
+			respondError(c, http.StatusBadRequest, errorFor(err))
 			return
 		}
-		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to create product"})
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to create products"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, product)
+	resp := bulkResultsResponse{Results: make([]bulkItemResult, len(results))}
+	for i, r := range results {
+		item := bulkItemResult{Index: r.Index, ID: r.Product.ID, PublicID: r.Product.PublicID, Status: itemStatusCreated}
+		if h.publicIDs {
+			item.ID = 0
+		}
+		if r.Err != nil {
+			item = bulkItemResult{Index: r.Index, Status: itemStatusFailed, Error: itemError(r.Err)}
+		}
+		resp.Results[i] = item
+	}
+	c.JSON(http.StatusMultiStatus, resp)
+}
+
+// itemError is what a client is told about a failed bulk item or async
+// create. Internal failures are not spelled out.
+func itemError(err error) string {
+	switch {
+	case errors.Is(err, products.ErrDuplicateName):
+		return products.ErrDuplicateName.Error()
+	case errors.Is(err, products.ErrWebhookRejected):
+		return products.ErrWebhookRejected.Error()
+	case errors.Is(err, products.ErrNameNotAllowed):
+		return products.ErrNameNotAllowed.Error()
+	case errors.Is(err, products.ErrQuotaExceeded):
+		return products.ErrQuotaExceeded.Error()
+	case isValidationError(err):
+		return err.Error()
+	default:
+		return "failed to create product"
+	}
+}
+
+// UpdateAttributes godoc
+// @Summary      Replace a product's attributes
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string  true  "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Param        body  body      object  true  "Attributes"
+// @Success      200   {object}  products.Product
+// @Failure      400   {object}  errorResponse
+// @Failure      404   {object}  errorResponse
+// @Failure      500   {object}  errorResponse
+// @Failure      503   {object}  errorResponse
+// @Router       /products/{id}/attributes [put]
+func (h *Handler) UpdateAttributes(c *gin.Context) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+
+	var attributes map[string]any
+	if err := c.ShouldBindJSON(&attributes); err != nil {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+
+	product, err := h.service.UpdateAttributes(c.Request.Context(), id, attributes)
+	if err != nil {
+		if errors.Is(err, products.ErrNotFound) {
+			respondError(c, http.StatusNotFound, errorFor(err))
+			return
+		}
+		if errors.Is(err, products.ErrAttributesTooLarge) {
+			respondError(c, http.StatusBadRequest, errorFor(err))
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to update product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.present(product))
+}
+
+// PublishProduct godoc
+// @Summary      Publish a draft product
+// @Tags         products
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Success      200  {object}  products.Product
+// @Failure      400  {object}  errorResponse
+// @Failure      404  {object}  errorResponse
+// @Failure      409  {object}  errorResponse  "The product is not a draft"
+// @Failure      500  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
+// @Router       /products/{id}/publish [post]
+func (h *Handler) PublishProduct(c *gin.Context) {
+	h.changeStatus(c, h.service.PublishProduct)
+}
+
+// ArchiveProduct godoc
+// @Summary      Archive a published product
+// @Tags         products
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Success      200  {object}  products.Product
+// @Failure      400  {object}  errorResponse
+// @Failure      404  {object}  errorResponse
+// @Failure      409  {object}  errorResponse  "The product is not published"
+// @Failure      500  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
+// @Router       /products/{id}/archive [post]
+func (h *Handler) ArchiveProduct(c *gin.Context) {
+	h.changeStatus(c, h.service.ArchiveProduct)
+}
+
+// changeStatus answers a lifecycle move made by change.
+func (h *Handler) changeStatus(c *gin.Context, change func(context.Context, int64) (products.Product, error)) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+
+	product, err := change(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, products.ErrNotFound):
+		respondError(c, http.StatusNotFound, errorFor(err))
+	case errors.Is(err, products.ErrInvalidTransition):
+		respondError(c, http.StatusConflict, errorFor(err))
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to change product status"})
+	default:
+		c.JSON(http.StatusOK, h.present(product))
+	}
+}
+
+// ReserveProduct godoc
+// @Summary      Reserve a product for a while
+// @Description  Holds the product for reserved_by, or the request's actor, until RESERVATION_TTL has passed or it is released. A product whose reservation has run out can be reserved again; the sweeper releases it and publishes product_reservation_expired.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string          true   "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Param        body  body      reserveRequest  false  "Who holds the reservation"
+// @Success      200   {object}  products.Reservation
+// @Failure      400   {object}  errorResponse
+// @Failure      404   {object}  errorResponse
+// @Failure      409   {object}  errorResponse  "The product is already reserved"
+// @Failure      500   {object}  errorResponse
+// @Failure      503   {object}  errorResponse
+// @Router       /products/{id}/reserve [post]
+func (h *Handler) ReserveProduct(c *gin.Context) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+
+	by, ok := reservationHolder(c)
+	if !ok {
+		return
+	}
+
+	reservation, err := h.service.ReserveProduct(c.Request.Context(), id, by)
+	switch {
+	case errors.Is(err, products.ErrNotFound):
+		respondError(c, http.StatusNotFound, errorFor(err))
+	case errors.Is(err, products.ErrAlreadyReserved):
+		respondError(c, http.StatusConflict, errorFor(err))
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to reserve product"})
+	default:
+		c.JSON(http.StatusOK, h.presentReservation(reservation))
+	}
+}
+
+// ReleaseProduct godoc
+// @Summary      Release a product's reservation
+// @Description  Only the holder can release a reservation that has not run out. Releasing a product that is not reserved succeeds too.
+// @Tags         products
+// @Accept       json
+// @Param        id    path      string          true   "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Param        body  body      reserveRequest  false  "Who releases the reservation"
+// @Success      204
+// @Failure      400  {object}  errorResponse
+// @Failure      404  {object}  errorResponse
+// @Failure      409  {object}  errorResponse  "The product is reserved by someone else"
+// @Failure      500  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
+// @Router       /products/{id}/release [post]
+func (h *Handler) ReleaseProduct(c *gin.Context) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+	by, ok := reservationHolder(c)
+	if !ok {
+		return
+	}
+
+	err := h.service.ReleaseProduct(c.Request.Context(), id, by)
+	switch {
+	case errors.Is(err, products.ErrNotFound):
+		respondError(c, http.StatusNotFound, errorFor(err))
+	case errors.Is(err, products.ErrReservedByOther):
+		respondError(c, http.StatusConflict, errorFor(err))
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to release product"})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// reservationHolder returns who a reserve or release acts for: the body's
+// reserved_by, or else the request's actor. When it returns false it has
+// already answered the request.
+func reservationHolder(c *gin.Context) (string, bool) {
+	var req reserveRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+			return "", false
+		}
+	}
+	by := req.ReservedBy
+	if by == "" {
+		by = products.Actor(c.Request.Context())
+	}
+	if by == "" {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "reserved_by is required"})
+		return "", false
+	}
+	return by, true
 }
 
 // DeleteProduct godoc
 // @Summary      Delete a product by ID
+// @Description  With dry_run=true nothing is deleted or published; the product is only looked up and the response reports what the delete would do.
 // @Tags         products
 // @Produce      json
-// @Param        id   path      int  true  "Product ID"
+// @Param        id       path      string  true   "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Param        return   query     string  false  "representation to get the deleted product back with 200"  Enums(minimal, representation)
+// @Param        dry_run  query     bool    false  "report the impact of the delete without deleting"
+// @Success      200  {object}  products.Product
+// @Success      200  {object}  deleteImpactResponse  "with dry_run=true"
 // @Success      204
 // @Failure      400  {object}  errorResponse
 // @Failure      404  {object}  errorResponse
 // @Failure      500  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
 // @Router       /products/{id} [delete]
 func (h *Handler) DeleteProduct(c *gin.Context) {
-	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	ret := c.DefaultQuery("return", returnMinimal)
+	if ret != returnMinimal && ret != returnRepresentation {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid return mode"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid product id"})
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid dry_run flag"})
 		return
 	}
 
-	if err := h.service.DeleteProduct(c.Request.Context(), id); err != nil {
+	// A dry run only looks the product up, by the delete's own condition:
+	// the delete is a single statement, so existence, expired or not, is
+	// all that decides whether it would happen.
+	deleteByID, deleteByPublicID := h.service.DeleteProduct, h.service.DeleteProductByPublicID
+	if dryRun {
+		deleteByID, deleteByPublicID = h.service.PreviewDelete, h.service.PreviewDeleteByPublicID
+	}
+
+	var product products.Product
+	if h.publicIDs {
+		publicID, ok := parsePublicID(c)
+		if !ok {
+			return
+		}
+		product, err = deleteByPublicID(c.Request.Context(), publicID)
+	} else {
+		id, ok := parseID(c)
+		if !ok {
+			return
+		}
+		product, err = deleteByID(c.Request.Context(), id)
+	}
+
+	if err != nil {
 		if errors.Is(err, products.ErrNotFound) {
-			c.JSON(http.StatusNotFound, errorResponse{Error: err.Error()})
+			respondError(c, http.StatusNotFound, errorFor(err))
 			return
 		}
-		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to delete product"})
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to delete product"})
 		return
 	}
 
+	if dryRun {
+		c.JSON(http.StatusOK, deleteImpactResponse{WouldDelete: true, Events: []string{products.EventDeleted}})
+		return
+	}
+	if ret == returnRepresentation {
+		c.JSON(http.StatusOK, h.present(product))
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
 
+// DeleteProducts godoc
+// @Summary      Delete several products at once
+// @Description  Deletes every listed product that exists, all or none, and reports how many were deleted; unknown ids are skipped. product_deleted events are published asynchronously through the outbox. Not available with PRODUCT_ID_TYPE=uuid.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        body  body      deleteProductsRequest  true  "Product IDs"
+// @Success      200   {object}  deleteProductsResponse
+// @Failure      400   {object}  errorResponse
+// @Failure      500   {object}  errorResponse
+// @Failure      503   {object}  errorResponse
+// @Router       /products/bulk [delete]
+func (h *Handler) DeleteProducts(c *gin.Context) {
+	var req deleteProductsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, bindErrorResponse(err))
+		return
+	}
+
+	deleted, err := h.service.DeleteProducts(c.Request.Context(), req.IDs)
+	if err != nil {
+		if errors.Is(err, products.ErrBatchTooLarge) {
+			respondError(c, http.StatusBadRequest, errorFor(err))
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to delete products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deleteProductsResponse{Deleted: deleted})
+}
+
+// ReplayProduct godoc
+// @Summary      Re-publish a product's current state as a replayed product_created event
+// @Tags         admin
+// @Produce      json
+// @Security     AdminToken
+// @Param        id   path      string  true  "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Success      202
+// @Failure      400  {object}  errorResponse
+// @Failure      401  {object}  errorResponse
+// @Failure      404  {object}  errorResponse
+// @Failure      500  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
+// @Router       /products/{id}/replay [post]
+func (h *Handler) ReplayProduct(c *gin.Context) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ReplayProduct(c.Request.Context(), id); err != nil {
+		if errors.Is(err, products.ErrNotFound) {
+			respondError(c, http.StatusNotFound, errorFor(err))
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to replay product"})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// FlushPublisher godoc
+// @Summary      Publish every event waiting in the async publish buffer, the spill and a pending coalesced batch now
+// @Description  Counts events taken from the buffer and the spill. Failed buffered events exhausted their retries and are dropped; a failed spilled event stays in the spill. A failed coalesced batch answers 503.
+// @Tags         admin
+// @Produce      json
+// @Security     AdminToken
+// @Success      200  {object}  flushResponse
+// @Failure      401  {object}  errorResponse
+// @Failure      503  {object}  errorResponse
+// @Router       /internal/flush [post]
+func (h *Handler) FlushPublisher(c *gin.Context) {
+	flushed, failed, err := h.flusher.Flush(c.Request.Context())
+	if err != nil {
+		// The publisher is shutting down, the flush outlived the request
+		// or a held back batch failed; any of them may work on a retry.
+		h.retryAfter.respond503(c, reasonUnavailable, "failed to flush publisher")
+		return
+	}
+
+	c.JSON(http.StatusOK, flushResponse{Flushed: flushed, Failed: failed})
+}
+
 // ListProducts godoc
 // @Summary      List products with pagination
+// @Description  Accept: text/csv or application/x-ndjson streams every product like GET /products/export instead, ignoring pagination; filters are refused with 400.
 // @Tags         products
-// @Produce      json
+// @Produce      json,text/csv,application/x-ndjson
 // @Param        page   query     int  false  "Page number"   default(1)
 // @Param        limit  query     int  false  "Items per page" default(10)
+// @Param        search      query  string  false  "Substring the product name must contain"
+// @Param        rank        query  bool    false  "Match search as word prefixes and order by relevance (defaults to SEARCH_MODE=ranked)"
+// @Param        attributes  query  string  false  "JSON object the product attributes must contain"
+// @Param        exact       query  bool    false  "Count the total exactly even when approximate counts are enabled"
+// @Param        include_expired  query  bool  false  "Also list products whose expires_at has passed"
+// @Param        status  query  string  false  "List products in this lifecycle status instead of published ones"  Enums(draft, published, archived)
+// @Param        min_id  query  int  false  "Lowest product id to list, inclusive"
+// @Param        max_id  query  int  false  "Highest product id to list, inclusive; not below min_id"
+// @Param        strict      query  bool    false  "Reject unknown query parameters with 400 (always on with STRICT_QUERY_PARAMS)"
+// @Param        empty_not_found  query  bool  false  "Answer 404 instead of an empty page when search or attributes match nothing (defaults to EMPTY_FILTER_NOT_FOUND)"
+// @Param        Prefer      header string  false  "Page size as max=N when limit is not given, e.g. return=representation; max=50; pagination=link answers a bare array with Link and X-Total-Count headers, pagination=body the enveloped form"
 // @Success      200    {object}  listProductsResponse
+// @Header       200    {string}  Preference-Applied  "The max=N and pagination preferences that were honored"
+// @Header       200    {string}  Link  "Pagination links (first, prev, next, last) of a bare-array response"
+// @Header       200    {integer}  X-Total-Count  "Products matching the filters, for a bare-array response"
+// @Header       200    {boolean}  X-Total-Count-Approximate  "Set when X-Total-Count is the planner's estimate"
+// @Failure      400    {object}  errorResponse
+// @Failure      404    {object}  errorResponse
+// @Failure      406    {object}  errorResponse
 // @Failure      500    {object}  errorResponse
+// @Failure      503    {object}  errorResponse
 // @Router       /products [get]
 func (h *Handler) ListProducts(c *gin.Context) {
+	if !h.checkQueryParams(c, listQueryParams) {
+		return
+	}
+
+	c.Header("Vary", "Accept")
+	switch accepted := c.NegotiateFormat(gin.MIMEJSON, mimeCSV, mimeNDJSON); accepted {
+	case gin.MIMEJSON:
+	case "":
+		respondError(c, http.StatusNotAcceptable, errorResponse{Error: "acceptable types are application/json, text/csv and application/x-ndjson"})
+		return
+	default:
+		for _, param := range listFilterParams {
+			if _, ok := c.GetQuery(param); ok {
+				respondError(c, http.StatusBadRequest, errorResponse{Error: param + " is not supported with " + accepted + "; request application/json to filter"})
+				return
+			}
+		}
+		enc, _ := newExportEncoder(streamFormats[accepted], c.Writer, h.publicIDs)
+		h.streamProducts(c, enc)
+		return
+	}
+
 	page := parseQueryInt(c.Query("page"), defaultPage)
 	limit := parseQueryInt(c.Query("limit"), defaultLimit)
+	if c.Query("limit") == "" {
+		if preferred, ok := preferredLimit(c.Request.Header.Values("Prefer")); ok {
+			// Echo the max actually applied, not the one asked for.
+			limit = min(preferred, maxLimit)
+			c.Writer.Header().Add("Preference-Applied", "max="+strconv.Itoa(limit))
+		}
+	}
+	limit = min(limit, maxLimit)
+	linkPagination := h.linkPagination
+	if mode, ok := preferredPagination(c.Request.Header.Values("Prefer")); ok {
+		linkPagination = mode == paginationLink
+		c.Writer.Header().Add("Preference-Applied", "pagination="+mode)
+	}
 
-	items, total, err := h.service.ListProducts(c.Request.Context(), page, limit)
+	opts := products.ListOptions{Search: c.Query("search"), Rank: h.rankSearch}
+	if raw := c.Query("rank"); raw != "" {
+		rank, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid rank flag"})
+			return
+		}
+		opts.Rank = rank
+	}
+	if raw := c.Query("exact"); raw != "" {
+		exact, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid exact flag"})
+			return
+		}
+		opts.ExactCount = exact
+	} else {
+		opts.ExactCount = products.FeatureEnabled(c.Request.Context(), products.FlagExactCount)
+	}
+	if raw := c.Query("include_expired"); raw != "" {
+		includeExpired, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid include_expired flag"})
+			return
+		}
+		opts.IncludeExpired = includeExpired
+	}
+	if raw := c.Query("status"); raw != "" {
+		if !products.ValidStatus(raw) {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid status filter"})
+			return
+		}
+		opts.Status = raw
+	}
+	for _, bound := range []struct {
+		param string
+		dst   *int64
+	}{{"min_id", &opts.MinID}, {"max_id", &opts.MaxID}} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid " + bound.param})
+			return
+		}
+		*bound.dst = id
+	}
+	if opts.MinID > 0 && opts.MaxID > 0 && opts.MinID > opts.MaxID {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "min_id must not be greater than max_id"})
+		return
+	}
+	if raw := c.Query("attributes"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.Attributes); err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid attributes filter"})
+			return
+		}
+	}
+	emptyNotFound := h.emptyNotFound
+	if raw := c.Query(emptyNotFoundParam); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid empty_not_found flag"})
+			return
+		}
+		emptyNotFound = parsed
+	}
+	filtered := opts.Filtered()
+
+	ctx := c.Request.Context()
+	if h.searchTimeout > 0 && filtered {
+		ctx = products.WithStatementTimeout(ctx, h.searchTimeout)
+	}
+
+	items, totals, err := h.service.ListProducts(ctx, opts, page, limit)
+	if errors.Is(err, products.ErrQueryTimeout) {
+		h.retryAfter.respond503(c, reasonUnavailable, "search timed out")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errorResponse{Error: "failed to get products"})
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to get products"})
+		return
+	}
+	// The total, not items, so a page past the end of a match is still
+	// 200.
+	if emptyNotFound && filtered && totals.Filtered == 0 {
+		respondError(c, http.StatusNotFound, errorResponse{Error: "no products match the filter"})
+		return
+	}
+
+	if linkPagination {
+		setPaginationHeaders(c, page, limit, totals.Filtered)
+		if totals.Approximate && !filtered {
+			c.Header("X-Total-Count-Approximate", "true")
+		}
+		c.JSON(http.StatusOK, h.presentAll(items))
 		return
 	}
 
 	c.JSON(http.StatusOK, listProductsResponse{
-		Items: items,
+		Items: h.presentAll(items),
 		Pagination: paginationMeta{
-			Page:  page,
-			Limit: limit,
-			Total: total,
+			Page:                  page,
+			Limit:                 limit,
+			Total:                 totals.Filtered,
+			GrandTotal:            totals.All,
+			TotalApproximate:      totals.Approximate && !filtered,
+			GrandTotalApproximate: totals.Approximate,
 		},
 	})
 }
 
+// checkQueryParams answers 400 listing every query parameter not in
+// allowed, and reports false, when strict mode is on for the handler or
+// the request. Lenient requests always pass.
+func (h *Handler) checkQueryParams(c *gin.Context, allowed map[string]bool) bool {
+	strict := h.strictQuery || products.FeatureEnabled(c.Request.Context(), products.FlagStrictQuery)
+	if raw := c.Query(strictQueryParam); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid strict flag"})
+			return false
+		}
+		strict = strict || parsed
+	}
+	if !strict {
+		return true
+	}
+
+	unknown := make(map[string]string)
+	for key := range c.Request.URL.Query() {
+		if !allowed[key] {
+			unknown[key] = "unknown query parameter"
+		}
+	}
+	if len(unknown) > 0 {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "unknown query parameters", Fields: unknown})
+		return false
+	}
+	return true
+}
+
+// SuggestNames godoc
+// @Summary      Suggest product names starting with a prefix
+// @Tags         products
+// @Produce      json
+// @Param        q      query     string  true   "Name prefix, matched case-insensitively"
+// @Param        limit  query     int     false  "Maximum suggestions (at most 50)"  default(10)
+// @Success      200    {array}   string
+// @Failure      400    {object}  errorResponse
+// @Failure      500    {object}  errorResponse
+// @Router       /products/suggest [get]
+func (h *Handler) SuggestNames(c *gin.Context) {
+	prefix := c.Query("q")
+	if utf8.RuneCountInString(prefix) < h.suggestMinPrefix {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("q must be at least %d characters", h.suggestMinPrefix)})
+		return
+	}
+	limit := parseQueryInt(c.Query("limit"), defaultLimit)
+
+	names, err := h.service.SuggestNames(c.Request.Context(), prefix, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to suggest names"})
+		return
+	}
+
+	c.JSON(http.StatusOK, names)
+}
+
+// GetProduct godoc
+// @Summary      Get a product
+// @Tags         products
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Success      200  {object}  products.Product
+// @Failure      400  {object}  errorResponse
+// @Failure      404  {object}  errorResponse
+// @Failure      500  {object}  errorResponse
+// @Router       /products/{id} [get]
+func (h *Handler) GetProduct(c *gin.Context) {
+	var (
+		product products.Product
+		err     error
+	)
+	if h.publicIDs {
+		publicID, ok := parsePublicID(c)
+		if !ok {
+			return
+		}
+		product, err = h.service.GetProductByPublicID(c.Request.Context(), publicID)
+	} else {
+		id, ok := parseID(c)
+		if !ok {
+			return
+		}
+		product, err = h.service.GetProduct(c.Request.Context(), id)
+	}
+
+	if errors.Is(err, products.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errorFor(err))
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to get product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.present(product))
+}
+
+// GetProductBySlug godoc
+// @Summary      Get a product by its slug
+// @Tags         products
+// @Produce      json
+// @Param        slug  path      string  true  "Product slug, e.g. iphone-16"
+// @Success      200   {object}  products.Product
+// @Failure      404   {object}  errorResponse
+// @Failure      500   {object}  errorResponse
+// @Router       /products/slug/{slug} [get]
+func (h *Handler) GetProductBySlug(c *gin.Context) {
+	product, err := h.service.GetProductBySlug(c.Request.Context(), c.Param("slug"))
+	if errors.Is(err, products.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errorFor(err))
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to get product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.present(product))
+}
+
+// GetProductPosition godoc
+// @Summary      Find where a product sits in the product list
+// @Description  The position is the product's 1-based index in GET /products without filters (published, unexpired products, newest first), and page is the page of limit products holding it.
+// @Tags         products
+// @Produce      json
+// @Param        id     path      string  true   "Product ID (a UUID when PRODUCT_ID_TYPE=uuid)"
+// @Param        limit  query     int     false  "Items per page" default(10)
+// @Success      200    {object}  products.ListPosition
+// @Failure      400    {object}  errorResponse
+// @Failure      404    {object}  errorResponse  "No such product in the list"
+// @Failure      500    {object}  errorResponse
+// @Router       /products/{id}/position [get]
+func (h *Handler) GetProductPosition(c *gin.Context) {
+	id, ok := h.productID(c)
+	if !ok {
+		return
+	}
+
+	position, err := h.service.ProductPosition(c.Request.Context(), id, parseQueryInt(c.Query("limit"), defaultLimit))
+	if errors.Is(err, products.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errorFor(err))
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to get product position"})
+		return
+	}
+
+	c.JSON(http.StatusOK, position)
+}
+
+// present returns p as a response shows it: without its internal id under
+// WithPublicIDs, where clients address products by public id alone.
+func (h *Handler) present(p products.Product) products.Product {
+	if h.publicIDs {
+		p.ID = 0
+	}
+	return p
+}
+
+// presentAll is present for a list, copying it rather than changing the
+// caller's products.
+func (h *Handler) presentAll(items []products.Product) []products.Product {
+	if !h.publicIDs {
+		return items
+	}
+	out := make([]products.Product, len(items))
+	for i, p := range items {
+		out[i] = h.present(p)
+	}
+	return out
+}
+
+func (h *Handler) presentJob(job jobs.Job) jobs.Job {
+	if h.publicIDs {
+		job.ProductID = 0
+	}
+	if job.Err != nil {
+		job.Error = itemError(job.Err)
+	}
+	return job
+}
+
+func (h *Handler) presentReservation(r products.Reservation) products.Reservation {
+	if h.publicIDs {
+		r.ProductID = 0
+	}
+	return r
+}
+
+// productID resolves the {id} path parameter to an internal id, looking
+// public ids up under WithPublicIDs. When it returns false it has already
+// answered the request.
+func (h *Handler) productID(c *gin.Context) (int64, bool) {
+	if !h.publicIDs {
+		return parseID(c)
+	}
+
+	publicID, ok := parsePublicID(c)
+	if !ok {
+		return 0, false
+	}
+	product, err := h.service.GetProductByPublicID(c.Request.Context(), publicID)
+	if errors.Is(err, products.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errorFor(err))
+		return 0, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errorResponse{Error: "failed to get product"})
+		return 0, false
+	}
+	return product.ID, true
+}
+
+// parseID returns the {id} path parameter as a product id. Only plain
+// decimal digits without a sign or leading zeros are accepted, so every id
+// has exactly one spelling; the 400 says whether the id is not a number,
+// out of range or not positive.
+func parseID(c *gin.Context) (int64, bool) {
+	id, err := validateID(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errorFor(err))
+		return 0, false
+	}
+	return id, true
+}
+
+var (
+	errIDNotNumber   = errors.New("invalid product id: not a number")
+	errIDOutOfRange  = errors.New("invalid product id: out of range")
+	errIDNotPositive = errors.New("invalid product id: must be positive")
+)
+
+func validateID(raw string) (int64, error) {
+	digits, negative := strings.CutPrefix(raw, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, errIDNotNumber
+	}
+	if negative || strings.Trim(digits, "0") == "" {
+		return 0, errIDNotPositive
+	}
+	if digits[0] == '0' {
+		return 0, errIDNotNumber
+	}
+	id, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, errIDOutOfRange
+	}
+	return id, nil
+}
+
+// parsePublicID returns the {id} path parameter in canonical UUID form.
+func parsePublicID(c *gin.Context) (string, bool) {
+	publicID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid product id"})
+		return "", false
+	}
+	return publicID.String(), true
+}
+
+func (h *Handler) parseOwner(c *gin.Context) (string, bool) {
+	owner := strings.TrimSpace(c.GetHeader(ownerHeader))
+	if len(owner) > maxOwnerLength {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: "invalid owner"})
+		return "", false
+	}
+	if owner == "" && h.requireOwner {
+		respondError(c, http.StatusBadRequest, errorResponse{Error: ownerHeader + " header is required"})
+		return "", false
+	}
+	return owner, true
+}
+
 func parseQueryInt(raw string, fallback int) int {
 	if raw == "" {
 		return fallback
@@ -147,3 +1482,78 @@ func parseQueryInt(raw string, fallback int) int {
 	}
 	return value
 }
+
+// preferredLimit finds a max=N preference among Prefer header values such
+// as "return=representation; max=50".
+func preferredLimit(values []string) (int, bool) {
+	for _, raw := range preferences(values, "max") {
+		if limit := parseQueryInt(raw, 0); limit > 0 {
+			return limit, true
+		}
+	}
+	return 0, false
+}
+
+// preferredPagination finds a pagination=link or pagination=body
+// preference among Prefer header values.
+func preferredPagination(values []string) (string, bool) {
+	for _, raw := range preferences(values, "pagination") {
+		if mode := strings.ToLower(raw); mode == paginationLink || mode == paginationBody {
+			return mode, true
+		}
+	}
+	return "", false
+}
+
+// preferences returns the values of every name=value preference among
+// Prefer header values, in order. Preferences are separated by commas and
+// their parameters by semicolons; name is matched wherever it appears.
+func preferences(values []string, name string) []string {
+	var found []string
+	for _, value := range values {
+		for _, pref := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+			key, raw, ok := strings.Cut(strings.TrimSpace(pref), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+				found = append(found, strings.Trim(strings.TrimSpace(raw), `"`))
+			}
+		}
+	}
+	return found
+}
+
+// setPaginationHeaders describes a bare-array list page the way GitHub
+// does: X-Total-Count is the number of products matching the filters and
+// Link points at the first, previous, next and last pages, keeping the
+// request's other query parameters.
+func setPaginationHeaders(c *gin.Context, page, limit int, total int64) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+
+	last := max(1, int((total+int64(limit)-1)/int64(limit)))
+	link := func(target int, rel string) string {
+		query := c.Request.URL.Query()
+		query.Set("page", strconv.Itoa(target))
+		query.Set("limit", strconv.Itoa(limit))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Request.URL.Path, query.Encode(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(min(page-1, last), "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// isValidationError reports whether the service rejected the input itself.
+func isValidationError(err error) bool {
+	return errors.Is(err, products.ErrInvalidName) ||
+		errors.Is(err, products.ErrNameTooLong) ||
+		errors.Is(err, products.ErrNameTooShort) ||
+		errors.Is(err, products.ErrNameControlChars) ||
+		errors.Is(err, products.ErrAttributesTooLarge) ||
+		errors.Is(err, products.ErrExpiryInPast) ||
+		errors.Is(err, products.ErrInvalidStatus)
+}
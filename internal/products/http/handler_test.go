@@ -4,38 +4,149 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/jobs"
 
 	"github.com/gin-gonic/gin"
 )
 
 type stubService struct {
-	createFn func(ctx context.Context, name string) (products.Product, error)
-	deleteFn func(ctx context.Context, id int64) error
-	listFn   func(ctx context.Context, page, limit int) ([]products.Product, int64, error)
+	createFn     func(ctx context.Context, in products.CreateInput) (products.Product, error)
+	ifAbsentFn   func(ctx context.Context, in products.CreateInput) (products.Product, bool, error)
+	bulkFn       func(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error)
+	partialFn    func(ctx context.Context, inputs []products.CreateInput) ([]products.CreateResult, error)
+	updateAttrFn func(ctx context.Context, id int64, attributes map[string]any) (products.Product, error)
+	statusFn     func(ctx context.Context, id int64, status string) (products.Product, error)
+	deleteFn     func(ctx context.Context, id int64) (products.Product, error)
+	deletePubFn  func(ctx context.Context, publicID string) (products.Product, error)
+	deleteBulkFn func(ctx context.Context, ids []int64) (int64, error)
+	previewFn    func(ctx context.Context, id int64) (products.Product, error)
+	previewPubFn func(ctx context.Context, publicID string) (products.Product, error)
+	getFn        func(ctx context.Context, id int64) (products.Product, error)
+	getPubFn     func(ctx context.Context, publicID string) (products.Product, error)
+	getSlugFn    func(ctx context.Context, slug string) (products.Product, error)
+	replayFn     func(ctx context.Context, id int64) error
+	reserveFn    func(ctx context.Context, id int64, by string) (products.Reservation, error)
+	positionFn   func(ctx context.Context, id int64, limit int) (products.ListPosition, error)
+	releaseFn    func(ctx context.Context, id int64, by string) error
+	listFn       func(ctx context.Context, opts products.ListOptions, page, limit int) ([]products.Product, int64, error)
+	suggestFn    func(ctx context.Context, prefix string, limit int) ([]string, error)
+	exportFn     func(ctx context.Context, batchSize int, emit func([]products.Product) error) error
+	allTotal     int64
+	approximate  bool
 }
 
-func (s *stubService) CreateProduct(ctx context.Context, name string) (products.Product, error) {
-	return s.createFn(ctx, name)
+func (s *stubService) CreateProduct(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	return s.createFn(ctx, in)
 }
-func (s *stubService) DeleteProduct(ctx context.Context, id int64) error {
+func (s *stubService) CreateProductIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error) {
+	return s.ifAbsentFn(ctx, in)
+}
+func (s *stubService) CreateProducts(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	return s.bulkFn(ctx, inputs)
+}
+func (s *stubService) CreateProductsPartial(ctx context.Context, inputs []products.CreateInput) ([]products.CreateResult, error) {
+	return s.partialFn(ctx, inputs)
+}
+func (s *stubService) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, error) {
+	return s.updateAttrFn(ctx, id, attributes)
+}
+func (s *stubService) PublishProduct(ctx context.Context, id int64) (products.Product, error) {
+	return s.statusFn(ctx, id, products.StatusPublished)
+}
+func (s *stubService) ArchiveProduct(ctx context.Context, id int64) (products.Product, error) {
+	return s.statusFn(ctx, id, products.StatusArchived)
+}
+func (s *stubService) DeleteProduct(ctx context.Context, id int64) (products.Product, error) {
 	return s.deleteFn(ctx, id)
 }
-func (s *stubService) ListProducts(ctx context.Context, page, limit int) ([]products.Product, int64, error) {
-	return s.listFn(ctx, page, limit)
+func (s *stubService) DeleteProductByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	return s.deletePubFn(ctx, publicID)
+}
+func (s *stubService) DeleteProducts(ctx context.Context, ids []int64) (int64, error) {
+	return s.deleteBulkFn(ctx, ids)
+}
+func (s *stubService) GetProduct(ctx context.Context, id int64) (products.Product, error) {
+	return s.getFn(ctx, id)
+}
+func (s *stubService) PreviewDelete(ctx context.Context, id int64) (products.Product, error) {
+	return s.previewFn(ctx, id)
+}
+func (s *stubService) PreviewDeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	return s.previewPubFn(ctx, publicID)
+}
+func (s *stubService) GetProductByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	return s.getPubFn(ctx, publicID)
+}
+func (s *stubService) GetProductBySlug(ctx context.Context, slug string) (products.Product, error) {
+	return s.getSlugFn(ctx, slug)
+}
+func (s *stubService) ReplayProduct(ctx context.Context, id int64) error {
+	return s.replayFn(ctx, id)
+}
+func (s *stubService) ProductPosition(ctx context.Context, id int64, limit int) (products.ListPosition, error) {
+	return s.positionFn(ctx, id, limit)
+}
+func (s *stubService) ReserveProduct(ctx context.Context, id int64, by string) (products.Reservation, error) {
+	return s.reserveFn(ctx, id, by)
+}
+func (s *stubService) ReleaseProduct(ctx context.Context, id int64, by string) error {
+	return s.releaseFn(ctx, id, by)
 }
 
+// ListProducts reports listFn's total as the filtered total, and as the
+// grand total too unless allTotal is set, and marks the totals
+// approximate when approximate is set.
+func (s *stubService) ListProducts(ctx context.Context, opts products.ListOptions, page, limit int) ([]products.Product, products.ListTotals, error) {
+	items, total, err := s.listFn(ctx, opts, page, limit)
+	totals := products.ListTotals{Filtered: total, All: total, Approximate: s.approximate}
+	if s.allTotal != 0 {
+		totals.All = s.allTotal
+	}
+	return items, totals, err
+}
+func (s *stubService) SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return s.suggestFn(ctx, prefix, limit)
+}
+func (s *stubService) ExportProducts(ctx context.Context, batchSize int, emit func([]products.Product) error) error {
+	return s.exportFn(ctx, batchSize, emit)
+}
+
+const testAdminToken = "s3cret"
+
 func setupRouter(svc ProductService) *gin.Engine {
 	gin.SetMode(gin.TestMode)
+	if err := RegisterValidators(); err != nil {
+		panic(err)
+	}
 	r := gin.New()
 	h := NewHandler(svc)
 	r.POST("/products", h.CreateProduct)
+	r.POST("/products/bulk", h.CreateProducts)
 	r.GET("/products", h.ListProducts)
+	r.GET("/products/suggest", h.SuggestNames)
+	r.GET("/products/slug/:slug", h.GetProductBySlug)
+	r.GET("/products/:id", h.GetProduct)
+	r.GET("/products/:id/position", h.GetProductPosition)
 	r.DELETE("/products/:id", h.DeleteProduct)
+	r.DELETE("/products/bulk", h.DeleteProducts)
+	r.PUT("/products/:id/attributes", h.UpdateAttributes)
+	r.POST("/products/:id/publish", h.PublishProduct)
+	r.POST("/products/:id/archive", h.ArchiveProduct)
+	r.POST("/products/:id/reserve", ActorMiddleware(), h.ReserveProduct)
+	r.POST("/products/:id/release", ActorMiddleware(), h.ReleaseProduct)
+	r.POST("/products/:id/replay", AdminAuthMiddleware(testAdminToken), h.ReplayProduct)
 	return r
 }
 
@@ -43,6 +154,7 @@ func TestHandler_CreateProduct(t *testing.T) {
 	tests := []struct {
 		name       string
 		body       string
+		owner      string
 		svcProduct products.Product
 		svcErr     error
 		wantStatus int
@@ -69,12 +181,52 @@ func TestHandler_CreateProduct(t *testing.T) {
 			svcErr:     products.ErrInvalidName,
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:       "duplicate name",
+			body:       `{"name":"Laptop"}`,
+			svcErr:     fmt.Errorf("repo create: %w", products.ErrDuplicateName),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "webhook rejected",
+			body:       `{"name":"Laptop"}`,
+			svcErr:     fmt.Errorf("create webhook: %w", products.ErrWebhookRejected),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "name not allowed",
+			body:       `{"name":"Scam Watch"}`,
+			svcErr:     products.ErrNameNotAllowed,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "owner passed through",
+			body:       `{"name":"Laptop"}`,
+			owner:      "acme",
+			svcProduct: products.Product{ID: 1, Name: "Laptop", Owner: "acme"},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "owner over quota",
+			body:       `{"name":"Laptop"}`,
+			owner:      "acme",
+			svcErr:     fmt.Errorf("repo create: %w", products.ErrQuotaExceeded),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "owner too long",
+			body:       `{"name":"Laptop"}`,
+			owner:      strings.Repeat("a", maxOwnerLength+1),
+			wantStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			var gotOwner string
 			svc := &stubService{
-				createFn: func(_ context.Context, name string) (products.Product, error) {
+				createFn: func(_ context.Context, in products.CreateInput) (products.Product, error) {
+					gotOwner = in.Owner
 					if tt.svcErr != nil {
 						return products.Product{}, tt.svcErr
 					}
@@ -86,30 +238,262 @@ func TestHandler_CreateProduct(t *testing.T) {
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(tt.body))
 			req.Header.Set("Content-Type", "application/json")
+			if tt.owner != "" {
+				req.Header.Set(ownerHeader, tt.owner)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusBadRequest && gotOwner != tt.owner {
+				t.Fatalf("want owner %q passed to the service, got %q", tt.owner, gotOwner)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProduct_RequiredOwner(t *testing.T) {
+	called := false
+	svc := &stubService{
+		createFn: func(context.Context, products.CreateInput) (products.Product, error) {
+			called = true
+			return products.Product{ID: 1, Name: "Laptop"}, nil
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewHandler(svc, WithRequiredOwner())
+	r.POST("/products", h.CreateProduct)
+	r.POST("/products/bulk", h.CreateProducts)
+
+	for _, url := range []string{"/products", "/products/bulk"} {
+		body := `{"name":"Laptop"}`
+		if url == "/products/bulk" {
+			body = `[` + body + `]`
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: want status 400 without an owner, got %d", url, w.Code)
+		}
+	}
+	if called {
+		t.Fatal("want no create without an owner")
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"Laptop"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ownerHeader, "acme")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status 201 with an owner, got %d", w.Code)
+	}
+}
+
+func TestHandler_CreateProduct_IfAbsent(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		created    bool
+		svcErr     error
+		wantStatus int
+		wantCalled bool
+	}{
+		{name: "absent is created", url: "/products?create_if_absent=true", created: true, wantStatus: http.StatusCreated, wantCalled: true},
+		{name: "present is returned", url: "/products?create_if_absent=true", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "errors map like a create", url: "/products?create_if_absent=true", svcErr: products.ErrNameNotAllowed, wantStatus: http.StatusUnprocessableEntity, wantCalled: true},
+		{name: "off keeps create-or-409", url: "/products?create_if_absent=false", wantStatus: http.StatusConflict},
+		{name: "invalid flag", url: "/products?create_if_absent=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			svc := &stubService{
+				createFn: func(context.Context, products.CreateInput) (products.Product, error) {
+					return products.Product{}, products.ErrDuplicateName
+				},
+				ifAbsentFn: func(_ context.Context, in products.CreateInput) (products.Product, bool, error) {
+					called = true
+					if tt.svcErr != nil {
+						return products.Product{}, false, tt.svcErr
+					}
+					return products.Product{ID: 7, Name: in.Name}, tt.created, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(`{"name":"Laptop"}`))
+			req.Header.Set("Content-Type", "application/json")
 			r.ServeHTTP(w, req)
 
 			if w.Code != tt.wantStatus {
 				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
 			}
+			if called != tt.wantCalled {
+				t.Fatalf("want CreateProductIfAbsent called %v, got %v", tt.wantCalled, called)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProduct_DuplicateName(t *testing.T) {
+	dup := fmt.Errorf("repo create: %w", &products.DuplicateNameError{ExistingID: 42, ExistingPublicID: "0b6a1c1e"})
+
+	tests := []struct {
+		name      string
+		svcErr    error
+		publicIDs bool
+		want      errorResponse
+	}{
+		{
+			name:   "existing id reported",
+			svcErr: dup,
+			want:   errorResponse{Error: products.ErrDuplicateName.Error(), Code: codeDuplicateName, ExistingID: 42},
+		},
+		{
+			name:      "existing public id reported with public ids",
+			svcErr:    dup,
+			publicIDs: true,
+			want:      errorResponse{Error: products.ErrDuplicateName.Error(), Code: codeDuplicateName, ExistingPublicID: "0b6a1c1e"},
+		},
+		{
+			name:   "existing product unknown",
+			svcErr: products.ErrDuplicateName,
+			want:   errorResponse{Error: products.ErrDuplicateName.Error(), Code: codeDuplicateName},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				createFn: func(context.Context, products.CreateInput) (products.Product, error) {
+					return products.Product{}, tt.svcErr
+				},
+			}
+			var opts []Option
+			if tt.publicIDs {
+				opts = append(opts, WithPublicIDs())
+			}
+
+			gin.SetMode(gin.TestMode)
+			if err := RegisterValidators(); err != nil {
+				t.Fatal(err)
+			}
+			r := gin.New()
+			r.POST("/products", NewHandler(svc, opts...).CreateProduct)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"Laptop"}`))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusConflict {
+				t.Fatalf("want status 409, got %d: %s", w.Code, w.Body.String())
+			}
+			var got errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want body %+v, got %+v", tt.want, got)
+			}
 		})
 	}
 }
 
+func TestHandler_PublicIDs_HideInternalID(t *testing.T) {
+	const publicID = "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+	product := products.Product{ID: 7, PublicID: publicID, Name: "Laptop"}
+	svc := &stubService{
+		createFn: func(context.Context, products.CreateInput) (products.Product, error) {
+			return product, nil
+		},
+		listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+			return []products.Product{product}, 1, nil
+		},
+		exportFn: func(_ context.Context, _ int, emit func([]products.Product) error) error {
+			return emit([]products.Product{product})
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	if err := RegisterValidators(); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	h := NewHandler(svc, WithPublicIDs())
+	r.POST("/products", h.CreateProduct)
+	r.GET("/products", h.ListProducts)
+	r.GET("/products/export", h.ExportProducts)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"Laptop"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	var created map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode created: %v", err)
+	}
+	if _, ok := created["id"]; ok || created["public_id"] != publicID {
+		t.Fatalf("want only the public id in the created product, got %v", created)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", http.NoBody))
+	if strings.Contains(w.Body.String(), `"id":`) {
+		t.Fatalf("want no internal id in the list, got %s", w.Body.String())
+	}
+	if product.ID != 7 {
+		t.Fatal("want the service's product left unchanged")
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/export?format=csv", http.NoBody))
+	want := "public_id,name,owner,created_at,expires_at,attributes\n" +
+		publicID + ",Laptop,,0001-01-01T00:00:00Z,,\n"
+	if w.Body.String() != want {
+		t.Fatalf("want csv %q, got %q", want, w.Body.String())
+	}
+}
+
 func TestHandler_DeleteProduct(t *testing.T) {
 	tests := []struct {
 		name       string
 		url        string
 		svcErr     error
 		wantStatus int
+		wantBody   bool
 	}{
 		{
 			name:       "success",
 			url:        "/products/1",
 			wantStatus: http.StatusNoContent,
 		},
+		{
+			name:       "return minimal",
+			url:        "/products/1?return=minimal",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "return representation",
+			url:        "/products/1?return=representation",
+			wantStatus: http.StatusOK,
+			wantBody:   true,
+		},
+		{
+			name:       "invalid return mode",
+			url:        "/products/1?return=everything",
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:       "not found",
-			url:        "/products/999",
+			url:        "/products/999?return=representation",
 			svcErr:     products.ErrNotFound,
 			wantStatus: http.StatusNotFound,
 		},
@@ -123,8 +507,71 @@ func TestHandler_DeleteProduct(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			svc := &stubService{
-				deleteFn: func(_ context.Context, _ int64) error {
-					return tt.svcErr
+				deleteFn: func(_ context.Context, id int64) (products.Product, error) {
+					if tt.svcErr != nil {
+						return products.Product{}, tt.svcErr
+					}
+					return products.Product{ID: id, Name: "Laptop"}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, tt.url, http.NoBody)
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if !tt.wantBody {
+				return
+			}
+			var got products.Product
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.ID != 1 || got.Name != "Laptop" {
+				t.Fatalf("want the deleted product, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandler_DeleteProduct_DryRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{
+			name:       "existing product",
+			url:        "/products/1?dry_run=true",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing product",
+			url:        "/products/999?dry_run=true",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid flag",
+			url:        "/products/1?dry_run=maybe",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				previewFn: func(_ context.Context, id int64) (products.Product, error) {
+					if id == 999 {
+						return products.Product{}, products.ErrNotFound
+					}
+					return products.Product{ID: id, Name: "Laptop"}, nil
+				},
+				deleteFn: func(context.Context, int64) (products.Product, error) {
+					t.Fatal("a dry run must not delete")
+					return products.Product{}, nil
 				},
 			}
 
@@ -136,6 +583,16 @@ func TestHandler_DeleteProduct(t *testing.T) {
 			if w.Code != tt.wantStatus {
 				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
 			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got deleteImpactResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !got.WouldDelete || len(got.Events) != 1 || got.Events[0] != products.EventDeleted {
+				t.Fatalf("want would_delete with a product_deleted event, got %+v", got)
+			}
 		})
 	}
 }
@@ -173,7 +630,7 @@ func TestHandler_ListProducts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			svc := &stubService{
-				listFn: func(_ context.Context, _, _ int) ([]products.Product, int64, error) {
+				listFn: func(_ context.Context, _ products.ListOptions, _, _ int) ([]products.Product, int64, error) {
 					return tt.items, tt.total, nil
 				},
 			}
@@ -197,3 +654,1761 @@ func TestHandler_ListProducts(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_ListProducts_PreferHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		prefer      string
+		wantLimit   int
+		wantApplied string
+	}{
+		{name: "max sets the limit", url: "/products", prefer: "return=representation; max=50", wantLimit: 50, wantApplied: "max=50"},
+		{name: "max above the cap", url: "/products", prefer: "max=500", wantLimit: maxLimit, wantApplied: "max=100"},
+		{name: "query limit takes precedence", url: "/products?limit=5", prefer: "max=50", wantLimit: 5},
+		{name: "invalid max is ignored", url: "/products", prefer: "max=lots", wantLimit: defaultLimit},
+		{name: "no max preference", url: "/products", prefer: "return=minimal", wantLimit: defaultLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLimit int
+			svc := &stubService{
+				listFn: func(_ context.Context, _ products.ListOptions, _, limit int) ([]products.Product, int64, error) {
+					gotLimit = limit
+					return []products.Product{}, 0, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, http.NoBody)
+			req.Header.Set("Prefer", tt.prefer)
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d", w.Code)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Fatalf("want limit %d, got %d", tt.wantLimit, gotLimit)
+			}
+			if got := w.Header().Get("Preference-Applied"); got != tt.wantApplied {
+				t.Fatalf("want Preference-Applied %q, got %q", tt.wantApplied, got)
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_LinkPagination(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		url        string
+		prefer     string
+		wantBare   bool
+		wantLink   string
+		wantTotal  string
+		wantPrefer string
+	}{
+		{
+			name:       "preferred per request",
+			url:        "/products?page=2&limit=2&search=phone",
+			prefer:     "pagination=link",
+			wantBare:   true,
+			wantLink:   `</products?limit=2&page=1&search=phone>; rel="first", </products?limit=2&page=1&search=phone>; rel="prev", </products?limit=2&page=3&search=phone>; rel="next", </products?limit=2&page=3&search=phone>; rel="last"`,
+			wantTotal:  "5",
+			wantPrefer: "pagination=link",
+		},
+		{
+			name:      "configured default",
+			opts:      []Option{WithLinkPagination()},
+			url:       "/products?limit=2",
+			wantBare:  true,
+			wantLink:  `</products?limit=2&page=1>; rel="first", </products?limit=2&page=2>; rel="next", </products?limit=2&page=3>; rel="last"`,
+			wantTotal: "5",
+		},
+		{
+			name:       "envelope preferred over the default",
+			opts:       []Option{WithLinkPagination()},
+			url:        "/products",
+			prefer:     "pagination=body",
+			wantPrefer: "pagination=body",
+		},
+		{
+			name: "envelope by default",
+			url:  "/products",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+					return []products.Product{{ID: 3, Name: "Phone"}, {ID: 4, Name: "Phone 2"}}, 5, nil
+				},
+			}
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/products", NewHandler(svc, tt.opts...).ListProducts)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, http.NoBody)
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Link"); got != tt.wantLink {
+				t.Fatalf("want Link %q, got %q", tt.wantLink, got)
+			}
+			if got := w.Header().Get("X-Total-Count"); got != tt.wantTotal {
+				t.Fatalf("want X-Total-Count %q, got %q", tt.wantTotal, got)
+			}
+			if got := w.Header().Get("Preference-Applied"); got != tt.wantPrefer {
+				t.Fatalf("want Preference-Applied %q, got %q", tt.wantPrefer, got)
+			}
+
+			if !tt.wantBare {
+				var resp listProductsResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Items) != 2 {
+					t.Fatalf("want an enveloped page of 2, got %s", w.Body.String())
+				}
+				return
+			}
+			var items []products.Product
+			if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+				t.Fatalf("want a bare array, got %s", w.Body.String())
+			}
+			if len(items) != 2 || items[0].ID != 3 {
+				t.Fatalf("want the page's 2 products, got %+v", items)
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_LinkPaginationCapsLimit(t *testing.T) {
+	var gotLimit int
+	svc := &stubService{
+		listFn: func(_ context.Context, _ products.ListOptions, _, limit int) ([]products.Product, int64, error) {
+			gotLimit = limit
+			return []products.Product{}, 1000, nil
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/products", NewHandler(svc, WithLinkPagination()).ListProducts)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products?limit=500", http.NoBody))
+
+	if gotLimit != maxLimit {
+		t.Fatalf("want the limit capped at %d, got %d", maxLimit, gotLimit)
+	}
+	want := `</products?limit=100&page=1>; rel="first", </products?limit=100&page=2>; rel="next", </products?limit=100&page=10>; rel="last"`
+	if got := w.Header().Get("Link"); got != want {
+		t.Fatalf("want Link %q, got %q", want, got)
+	}
+}
+
+func TestHandler_ListProducts_StrictQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		strict      bool
+		url         string
+		wantStatus  int
+		wantUnknown []string
+	}{
+		{name: "lenient ignores a typo", url: "/products?limt=5", wantStatus: http.StatusOK},
+		{name: "strict request rejects a typo", url: "/products?limt=5&strict=true", wantStatus: http.StatusBadRequest, wantUnknown: []string{"limt"}},
+		{name: "strict handler rejects a typo", strict: true, url: "/products?limt=5&serach=x", wantStatus: http.StatusBadRequest, wantUnknown: []string{"limt", "serach"}},
+		{name: "strict handler accepts known params", strict: true, url: "/products?page=2&limit=5&search=x&exact=true", wantStatus: http.StatusOK},
+		{name: "request cannot relax a strict handler", strict: true, url: "/products?limt=5&strict=false", wantStatus: http.StatusBadRequest, wantUnknown: []string{"limt"}},
+		{name: "invalid strict flag", url: "/products?strict=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+					return []products.Product{}, 0, nil
+				},
+			}
+			var opts []Option
+			if tt.strict {
+				opts = append(opts, WithStrictQuery())
+			}
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/products", NewHandler(svc, opts...).ListProducts)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantUnknown == nil {
+				return
+			}
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(resp.Fields) != len(tt.wantUnknown) {
+				t.Fatalf("want unknown params %v, got %v", tt.wantUnknown, resp.Fields)
+			}
+			for _, key := range tt.wantUnknown {
+				if _, ok := resp.Fields[key]; !ok {
+					t.Fatalf("want %q reported as unknown, got %v", key, resp.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_Totals(t *testing.T) {
+	svc := &stubService{
+		listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+			return []products.Product{{ID: 1, Name: "iPhone 16"}}, 12, nil
+		},
+		allTotal: 4532,
+	}
+	r := setupRouter(svc)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products?search=iphone", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", w.Code)
+	}
+	var resp listProductsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Pagination.Total != 12 || resp.Pagination.GrandTotal != 4532 {
+		t.Fatalf("want 12 of 4532, got %d of %d", resp.Pagination.Total, resp.Pagination.GrandTotal)
+	}
+}
+
+func TestHandler_ListProducts_ApproximateTotal(t *testing.T) {
+	svc := &stubService{
+		listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+			return []products.Product{{ID: 1, Name: "iPhone 16"}}, 250000, nil
+		},
+		approximate: true,
+	}
+	r := setupRouter(svc)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products", http.NoBody))
+	var resp listProductsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !resp.Pagination.TotalApproximate || !resp.Pagination.GrandTotalApproximate {
+		t.Fatalf("want both totals approximate, got %+v", resp.Pagination)
+	}
+
+	// A filtered count is exact even when the grand total is not.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products?search=iphone", http.NoBody))
+	resp = listProductsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Pagination.TotalApproximate || !resp.Pagination.GrandTotalApproximate {
+		t.Fatalf("want only grand_total approximate, got %+v", resp.Pagination)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/products", http.NoBody)
+	req.Header.Set("Prefer", "pagination=link")
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Total-Count-Approximate"); got != "true" {
+		t.Fatalf("want X-Total-Count-Approximate true, got %q", got)
+	}
+}
+
+func TestHandler_ListProducts_EmptyFilter(t *testing.T) {
+	tests := []struct {
+		name          string
+		emptyNotFound bool
+		url           string
+		total         int64
+		wantStatus    int
+	}{
+		{name: "default answers an empty page", url: "/products?search=nope", wantStatus: http.StatusOK},
+		{name: "option answers 404 for search", emptyNotFound: true, url: "/products?search=nope", wantStatus: http.StatusNotFound},
+		{name: "option answers 404 for attributes", emptyNotFound: true, url: `/products?attributes={"color":"plaid"}`, wantStatus: http.StatusNotFound},
+		{name: "option keeps an unfiltered empty table 200", emptyNotFound: true, url: "/products", wantStatus: http.StatusOK},
+		{name: "option keeps a page past the matches 200", emptyNotFound: true, url: "/products?search=x&page=9", total: 3, wantStatus: http.StatusOK},
+		{name: "request asks for 404", url: "/products?search=nope&empty_not_found=true", wantStatus: http.StatusNotFound},
+		{name: "request opts out of 404", emptyNotFound: true, url: "/products?search=nope&empty_not_found=false", wantStatus: http.StatusOK},
+		{name: "invalid flag", url: "/products?search=nope&empty_not_found=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+					return []products.Product{}, tt.total, nil
+				},
+			}
+			var opts []Option
+			if tt.emptyNotFound {
+				opts = append(opts, WithEmptyFilterNotFound())
+			}
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/products", NewHandler(svc, opts...).ListProducts)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_GetProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		svcErr     error
+		wantStatus int
+	}{
+		{name: "found", url: "/products/7", wantStatus: http.StatusOK},
+		{name: "unknown product", url: "/products/7", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound},
+		{name: "invalid id", url: "/products/abc", wantStatus: http.StatusBadRequest},
+		{name: "repository failure", url: "/products/7", svcErr: errors.New("db down"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				getFn: func(_ context.Context, id int64) (products.Product, error) {
+					if tt.svcErr != nil {
+						return products.Product{}, tt.svcErr
+					}
+					return products.Product{ID: id, Name: "iPhone 16"}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var resp errorResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error == "" {
+					t.Fatalf("want an error body, got %q (%v)", w.Body.String(), err)
+				}
+				return
+			}
+			var got products.Product
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.ID != 7 || got.Name != "iPhone 16" {
+				t.Fatalf("want product 7, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandler_GetProductBySlug(t *testing.T) {
+	tests := []struct {
+		name       string
+		svcErr     error
+		wantStatus int
+	}{
+		{name: "found", wantStatus: http.StatusOK},
+		{name: "unknown slug", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound},
+		{name: "repository failure", svcErr: errors.New("db down"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSlug string
+			svc := &stubService{
+				getSlugFn: func(_ context.Context, slug string) (products.Product, error) {
+					gotSlug = slug
+					if tt.svcErr != nil {
+						return products.Product{}, tt.svcErr
+					}
+					return products.Product{ID: 7, Name: "iPhone 16", Slug: slug}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/slug/iphone-16-2", http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if gotSlug != "iphone-16-2" {
+				t.Fatalf("want slug iphone-16-2 looked up, got %q", gotSlug)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got products.Product
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.ID != 7 || got.Slug != "iphone-16-2" {
+				t.Fatalf("want product 7 with its slug, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandler_ChangeStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		svcErr     error
+		wantStatus int
+		wantTarget string
+	}{
+		{name: "publish", url: "/products/7/publish", wantStatus: http.StatusOK, wantTarget: products.StatusPublished},
+		{name: "archive", url: "/products/7/archive", wantStatus: http.StatusOK, wantTarget: products.StatusArchived},
+		{name: "invalid transition", url: "/products/7/archive", svcErr: products.ErrInvalidTransition, wantStatus: http.StatusConflict, wantTarget: products.StatusArchived},
+		{name: "unknown product", url: "/products/7/publish", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound, wantTarget: products.StatusPublished},
+		{name: "invalid id", url: "/products/abc/publish", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTarget string
+			svc := &stubService{
+				statusFn: func(_ context.Context, id int64, status string) (products.Product, error) {
+					gotTarget = status
+					if tt.svcErr != nil {
+						return products.Product{}, tt.svcErr
+					}
+					return products.Product{ID: id, Name: "Widget", Status: status}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if gotTarget != tt.wantTarget {
+				t.Fatalf("want a move to %q, got %q", tt.wantTarget, gotTarget)
+			}
+		})
+	}
+}
+
+func TestHandler_ReserveProduct(t *testing.T) {
+	until := time.Date(2026, 3, 1, 12, 15, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		url        string
+		body       string
+		actor      string
+		svcErr     error
+		wantStatus int
+		wantBy     string
+	}{
+		{name: "reserved by the body", url: "/products/7/reserve", body: `{"reserved_by":"checkout-42"}`, actor: "alice", wantStatus: http.StatusOK, wantBy: "checkout-42"},
+		{name: "reserved by the actor", url: "/products/7/reserve", actor: "alice", wantStatus: http.StatusOK, wantBy: "alice"},
+		{name: "nobody to reserve for", url: "/products/7/reserve", wantStatus: http.StatusBadRequest},
+		{name: "invalid body", url: "/products/7/reserve", body: `{`, actor: "alice", wantStatus: http.StatusBadRequest},
+		{name: "already reserved", url: "/products/7/reserve", actor: "alice", svcErr: products.ErrAlreadyReserved, wantStatus: http.StatusConflict, wantBy: "alice"},
+		{name: "unknown product", url: "/products/7/reserve", actor: "alice", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound, wantBy: "alice"},
+		{name: "invalid id", url: "/products/abc/reserve", actor: "alice", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBy string
+			svc := &stubService{
+				reserveFn: func(_ context.Context, id int64, by string) (products.Reservation, error) {
+					gotBy = by
+					if tt.svcErr != nil {
+						return products.Reservation{}, tt.svcErr
+					}
+					return products.Reservation{ProductID: id, ReservedBy: by, ReservedUntil: until}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			if tt.actor != "" {
+				req.Header.Set(actorHeader, tt.actor)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if gotBy != tt.wantBy {
+				t.Fatalf("want a reservation for %q, got %q", tt.wantBy, gotBy)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got products.Reservation
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.ProductID != 7 || got.ReservedBy != tt.wantBy || !got.ReservedUntil.Equal(until) {
+				t.Fatalf("want the reservation back, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandler_GetProductPosition(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		svcErr     error
+		wantStatus int
+		wantLimit  int
+	}{
+		{name: "position", url: "/products/7/position?limit=5", wantStatus: http.StatusOK, wantLimit: 5},
+		{name: "default limit", url: "/products/7/position", wantStatus: http.StatusOK, wantLimit: defaultLimit},
+		{name: "unknown product", url: "/products/7/position", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound, wantLimit: defaultLimit},
+		{name: "invalid id", url: "/products/abc/position", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLimit int
+			svc := &stubService{
+				positionFn: func(_ context.Context, _ int64, limit int) (products.ListPosition, error) {
+					gotLimit = limit
+					if tt.svcErr != nil {
+						return products.ListPosition{}, tt.svcErr
+					}
+					return products.ListPosition{Position: 12, Page: 3, Limit: limit}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if gotLimit != tt.wantLimit {
+				t.Fatalf("want limit %d, got %d", tt.wantLimit, gotLimit)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got products.ListPosition
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if want := (products.ListPosition{Position: 12, Page: 3, Limit: tt.wantLimit}); got != want {
+				t.Fatalf("want %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestHandler_ReleaseProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		actor      string
+		body       string
+		svcErr     error
+		wantStatus int
+		wantBy     string
+	}{
+		{name: "released by the actor", url: "/products/7/release", actor: "alice", wantStatus: http.StatusNoContent, wantBy: "alice"},
+		{name: "released by the body's holder", url: "/products/7/release", actor: "gateway", body: `{"reserved_by":"order-1"}`, wantStatus: http.StatusNoContent, wantBy: "order-1"},
+		{name: "no holder", url: "/products/7/release", wantStatus: http.StatusBadRequest},
+		{name: "held by someone else", url: "/products/7/release", actor: "bob", svcErr: products.ErrReservedByOther, wantStatus: http.StatusConflict, wantBy: "bob"},
+		{name: "unknown product", url: "/products/7/release", actor: "alice", svcErr: products.ErrNotFound, wantStatus: http.StatusNotFound, wantBy: "alice"},
+		{name: "invalid id", url: "/products/abc/release", actor: "alice", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBy string
+			svc := &stubService{
+				releaseFn: func(_ context.Context, _ int64, by string) error {
+					gotBy = by
+					return tt.svcErr
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.actor != "" {
+				req.Header.Set(actorHeader, tt.actor)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if gotBy != tt.wantBy {
+				t.Fatalf("want release by %q, got %q", tt.wantBy, gotBy)
+			}
+		})
+	}
+}
+
+func TestHandler_SuggestNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantPrefix string
+		wantLimit  int
+		wantNames  []string
+	}{
+		{
+			name:       "matches the prefix",
+			url:        "/products/suggest?q=ip&limit=5",
+			wantStatus: http.StatusOK,
+			wantPrefix: "ip",
+			wantLimit:  5,
+			wantNames:  []string{"iPad Air", "iPhone 16"},
+		},
+		{
+			name:       "default limit",
+			url:        "/products/suggest?q=pix",
+			wantStatus: http.StatusOK,
+			wantPrefix: "pix",
+			wantLimit:  defaultLimit,
+			wantNames:  []string{},
+		},
+		{
+			name:       "prefix too short",
+			url:        "/products/suggest?q=i",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "prefix missing",
+			url:        "/products/suggest",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "multibyte prefix counts characters",
+			url:        "/products/suggest?q=%C5%BC%C3%B3",
+			wantStatus: http.StatusOK,
+			wantPrefix: "żó",
+			wantLimit:  defaultLimit,
+			wantNames:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPrefix string
+			var gotLimit int
+			svc := &stubService{
+				suggestFn: func(_ context.Context, prefix string, limit int) ([]string, error) {
+					gotPrefix, gotLimit = prefix, limit
+					return tt.wantNames, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if gotPrefix != "" {
+					t.Fatalf("want no search below the minimum prefix, got %q", gotPrefix)
+				}
+				return
+			}
+			if gotPrefix != tt.wantPrefix || gotLimit != tt.wantLimit {
+				t.Fatalf("want prefix %q limit %d, got %q %d", tt.wantPrefix, tt.wantLimit, gotPrefix, gotLimit)
+			}
+			var names []string
+			if err := json.NewDecoder(w.Body).Decode(&names); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("want %v, got %v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProduct_Attributes(t *testing.T) {
+	var got products.CreateInput
+	svc := &stubService{
+		createFn: func(_ context.Context, in products.CreateInput) (products.Product, error) {
+			got = in
+			return products.Product{ID: 1, Name: in.Name, Attributes: in.Attributes}, nil
+		},
+	}
+
+	r := setupRouter(svc)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products",
+		bytes.NewBufferString(`{"name":"Laptop","attributes":{"color":"red","ram_gb":16}}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d, got %d, body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if got.Attributes["color"] != "red" || got.Attributes["ram_gb"] != float64(16) {
+		t.Fatalf("attributes not passed to service: %v", got.Attributes)
+	}
+
+	var resp products.Product
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Attributes["color"] != "red" {
+		t.Fatalf("want attributes echoed, got %v", resp.Attributes)
+	}
+}
+
+func TestHandler_UpdateAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		body       string
+		svcErr     error
+		wantStatus int
+	}{
+		{
+			name:       "success",
+			url:        "/products/1/attributes",
+			body:       `{"color":"blue"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "not found",
+			url:        "/products/999/attributes",
+			body:       `{"color":"blue"}`,
+			svcErr:     products.ErrNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "too large",
+			url:        "/products/1/attributes",
+			body:       `{"blob":"x"}`,
+			svcErr:     products.ErrAttributesTooLarge,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not an object",
+			url:        "/products/1/attributes",
+			body:       `["color"]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid id",
+			url:        "/products/abc/attributes",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				updateAttrFn: func(_ context.Context, id int64, attributes map[string]any) (products.Product, error) {
+					if tt.svcErr != nil {
+						return products.Product{}, tt.svcErr
+					}
+					return products.Product{ID: id, Attributes: attributes}, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, tt.url, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_RankedSearch(t *testing.T) {
+	tests := []struct {
+		name       string
+		ranked     bool
+		url        string
+		wantStatus int
+		wantRank   bool
+	}{
+		{name: "default matches substrings", url: "/products?search=lap", wantStatus: http.StatusOK},
+		{name: "request asks for ranking", url: "/products?search=lap&rank=true&strict=true", wantStatus: http.StatusOK, wantRank: true},
+		{name: "option ranks by default", ranked: true, url: "/products?search=lap", wantStatus: http.StatusOK, wantRank: true},
+		{name: "request opts out of ranking", ranked: true, url: "/products?search=lap&rank=false", wantStatus: http.StatusOK},
+		{name: "invalid flag", url: "/products?search=lap&rank=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got products.ListOptions
+			svc := &stubService{
+				listFn: func(_ context.Context, opts products.ListOptions, _, _ int) ([]products.Product, int64, error) {
+					got = opts
+					return []products.Product{}, 0, nil
+				},
+			}
+			var opts []Option
+			if tt.ranked {
+				opts = append(opts, WithRankedSearch())
+			}
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/products", NewHandler(svc, opts...).ListProducts)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if got.Rank != tt.wantRank {
+				t.Fatalf("want rank %v, got %v", tt.wantRank, got.Rank)
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_Filters(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantSearch string
+		wantExact  bool
+		wantFilter map[string]any
+		// wantExpired is whether expired products are included.
+		wantExpired      bool
+		wantStatusFilter string
+		wantMinID        int64
+		wantMaxID        int64
+	}{
+		{
+			name:       "exact count requested",
+			url:        "/products?exact=true",
+			wantStatus: http.StatusOK,
+			wantExact:  true,
+		},
+		{
+			name:       "invalid exact flag",
+			url:        "/products?exact=maybe",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "search passed to service",
+			url:        "/products?search=iphone",
+			wantStatus: http.StatusOK,
+			wantSearch: "iphone",
+		},
+		{
+			name:       "filter passed to service",
+			url:        `/products?attributes={"color":"red"}`,
+			wantStatus: http.StatusOK,
+			wantFilter: map[string]any{"color": "red"},
+		},
+		{
+			name:       "malformed filter",
+			url:        `/products?attributes={color}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "expired products included",
+			url:         "/products?include_expired=true&strict=true",
+			wantStatus:  http.StatusOK,
+			wantExpired: true,
+		},
+		{
+			name:       "invalid include_expired flag",
+			url:        "/products?include_expired=sometimes",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:             "status filter passed to service",
+			url:              "/products?status=draft&strict=true",
+			wantStatus:       http.StatusOK,
+			wantStatusFilter: products.StatusDraft,
+		},
+		{
+			name:       "unknown status filter",
+			url:        "/products?status=deleted",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "id window passed to service",
+			url:        "/products?min_id=100&max_id=199&strict=true",
+			wantStatus: http.StatusOK,
+			wantMinID:  100,
+			wantMaxID:  199,
+		},
+		{
+			name:       "single-id window",
+			url:        "/products?min_id=7&max_id=7",
+			wantStatus: http.StatusOK,
+			wantMinID:  7,
+			wantMaxID:  7,
+		},
+		{
+			name:       "open-ended window",
+			url:        "/products?min_id=100",
+			wantStatus: http.StatusOK,
+			wantMinID:  100,
+		},
+		{
+			name:       "min_id above max_id",
+			url:        "/products?min_id=200&max_id=100",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid max_id",
+			url:        "/products?max_id=-1",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got products.ListOptions
+			svc := &stubService{
+				listFn: func(_ context.Context, opts products.ListOptions, _, _ int) ([]products.Product, int64, error) {
+					got = opts
+					return []products.Product{}, 0, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, http.NoBody)
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if got.Search != tt.wantSearch {
+				t.Fatalf("want search %q, got %q", tt.wantSearch, got.Search)
+			}
+			if got.ExactCount != tt.wantExact {
+				t.Fatalf("want exact count %v, got %v", tt.wantExact, got.ExactCount)
+			}
+			if got.IncludeExpired != tt.wantExpired {
+				t.Fatalf("want include expired %v, got %v", tt.wantExpired, got.IncludeExpired)
+			}
+			if got.Status != tt.wantStatusFilter {
+				t.Fatalf("want status filter %q, got %q", tt.wantStatusFilter, got.Status)
+			}
+			if got.MinID != tt.wantMinID || got.MaxID != tt.wantMaxID {
+				t.Fatalf("want id window [%d, %d], got [%d, %d]", tt.wantMinID, tt.wantMaxID, got.MinID, got.MaxID)
+			}
+			for k, v := range tt.wantFilter {
+				if got.Attributes[k] != v {
+					t.Fatalf("want filter %v, got %v", tt.wantFilter, got.Attributes)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_ListProducts_SearchStatementTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		svcErr      error
+		wantTimeout bool
+		wantStatus  int
+	}{
+		{
+			name:       "unfiltered list keeps the default",
+			url:        "/products",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "search gets the tighter timeout",
+			url:         "/products?search=lap",
+			wantTimeout: true,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "timed out search",
+			url:         "/products?search=lap",
+			svcErr:      fmt.Errorf("repo list: %w", products.ErrQueryTimeout),
+			wantTimeout: true,
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTimeout time.Duration
+			svc := &stubService{
+				listFn: func(ctx context.Context, _ products.ListOptions, _, _ int) ([]products.Product, int64, error) {
+					gotTimeout, _ = products.StatementTimeout(ctx)
+					return []products.Product{}, 0, tt.svcErr
+				},
+			}
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/products", NewHandler(svc, WithSearchStatementTimeout(time.Second)).ListProducts)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantTimeout != (gotTimeout == time.Second) {
+				t.Fatalf("want statement timeout set=%v, got %v", tt.wantTimeout, gotTimeout)
+			}
+		})
+	}
+}
+
+func TestHandler_PublicIDs(t *testing.T) {
+	const (
+		knownID   = "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+		unknownID = "9f0e1d2c-3b4a-4c5d-8e6f-7a8b9c0d1e2f"
+	)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		wantStatus int
+		wantID     int64
+	}{
+		{
+			name:       "delete by uuid",
+			method:     http.MethodDelete,
+			url:        "/products/" + knownID,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete accepts upper-case uuid",
+			method:     http.MethodDelete,
+			url:        "/products/" + strings.ToUpper(knownID),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete unknown uuid",
+			method:     http.MethodDelete,
+			url:        "/products/" + unknownID,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "integer id rejected",
+			method:     http.MethodDelete,
+			url:        "/products/42",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "replay resolves the internal id",
+			method:     http.MethodPost,
+			url:        "/products/" + knownID + "/replay",
+			wantStatus: http.StatusAccepted,
+			wantID:     7,
+		},
+		{
+			name:       "replay unknown uuid",
+			method:     http.MethodPost,
+			url:        "/products/" + unknownID + "/replay",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "malformed uuid",
+			method:     http.MethodPost,
+			url:        "/products/not-a-uuid/replay",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var replayed int64
+			svc := &stubService{
+				deletePubFn: func(_ context.Context, publicID string) (products.Product, error) {
+					if publicID != knownID {
+						return products.Product{}, products.ErrNotFound
+					}
+					return products.Product{ID: 7, PublicID: publicID}, nil
+				},
+				getPubFn: func(_ context.Context, publicID string) (products.Product, error) {
+					if publicID != knownID {
+						return products.Product{}, products.ErrNotFound
+					}
+					return products.Product{ID: 7, PublicID: publicID}, nil
+				},
+				replayFn: func(_ context.Context, id int64) error {
+					replayed = id
+					return nil
+				},
+			}
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			h := NewHandler(svc, WithPublicIDs())
+			r.DELETE("/products/:id", h.DeleteProduct)
+			r.POST("/products/:id/replay", h.ReplayProduct)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if replayed != tt.wantID {
+				t.Fatalf("want replay of id %d, got %d", tt.wantID, replayed)
+			}
+		})
+	}
+}
+
+func TestHandler_ReplayProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		token      string
+		svcErr     error
+		wantStatus int
+	}{
+		{
+			name:       "success",
+			url:        "/products/1/replay",
+			token:      testAdminToken,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "missing token",
+			url:        "/products/1/replay",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			url:        "/products/1/replay",
+			token:      "guess",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not found",
+			url:        "/products/999/replay",
+			token:      testAdminToken,
+			svcErr:     products.ErrNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid id",
+			url:        "/products/abc/replay",
+			token:      testAdminToken,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				replayFn: func(_ context.Context, _ int64) error {
+					return tt.svcErr
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, http.NoBody)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProducts(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		svcErr     error
+		wantStatus int
+		wantItems  int
+	}{
+		{
+			name:       "success",
+			body:       `{"items":[{"name":"Laptop"},{"name":"Phone","attributes":{"color":"red"}}]}`,
+			wantStatus: http.StatusCreated,
+			wantItems:  2,
+		},
+		{
+			name:       "empty items",
+			body:       `{"items":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "item without name",
+			body:       `{"items":[{"name":"Laptop"},{}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too many items",
+			body:       `{"items":[{"name":"Laptop"}]}`,
+			svcErr:     products.ErrBatchTooLarge,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "duplicate name",
+			body:       `{"items":[{"name":"Laptop"}]}`,
+			svcErr:     fmt.Errorf("repo create batch: item 0: %w", products.ErrDuplicateName),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "service error",
+			body:       `{"items":[{"name":"Laptop"}]}`,
+			svcErr:     errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				bulkFn: func(_ context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+					if tt.svcErr != nil {
+						return nil, tt.svcErr
+					}
+					created := make([]products.Product, len(inputs))
+					for i, in := range inputs {
+						created[i] = products.Product{ID: int64(i + 1), Name: in.Name, Attributes: in.Attributes}
+					}
+					return created, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/products/bulk", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+			var resp createProductsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(resp.Items) != tt.wantItems {
+				t.Fatalf("want %d items, got %d", tt.wantItems, len(resp.Items))
+			}
+		})
+	}
+}
+
+func TestHandler_DeleteProducts(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		svcErr      error
+		wantStatus  int
+		wantDeleted int64
+	}{
+		{
+			name:        "success",
+			body:        `{"ids":[1,2,3]}`,
+			wantStatus:  http.StatusOK,
+			wantDeleted: 3,
+		},
+		{
+			name:       "empty ids",
+			body:       `{"ids":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "non-positive id",
+			body:       `{"ids":[1,0]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too many ids",
+			body:       `{"ids":[1]}`,
+			svcErr:     products.ErrBatchTooLarge,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "service error",
+			body:       `{"ids":[1]}`,
+			svcErr:     errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				deleteBulkFn: func(_ context.Context, ids []int64) (int64, error) {
+					return int64(len(ids)), tt.svcErr
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/products/bulk", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp deleteProductsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Deleted != tt.wantDeleted {
+				t.Fatalf("want %d deleted, got %d", tt.wantDeleted, resp.Deleted)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProducts_Partial(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		body        string
+		wantStatus  int
+		wantResults []bulkItemResult
+	}{
+		{
+			name:       "mixed outcome",
+			url:        "/products/bulk?mode=partial",
+			body:       `{"items":[{"name":"Laptop"},{"name":""},{"name":"Taken"},{"name":"Broken"}]}`,
+			wantStatus: http.StatusMultiStatus,
+			wantResults: []bulkItemResult{
+				{Index: 0, ID: 1, Status: itemStatusCreated},
+				{Index: 1, Status: itemStatusFailed, Error: products.ErrInvalidName.Error()},
+				{Index: 2, Status: itemStatusFailed, Error: products.ErrDuplicateName.Error()},
+				{Index: 3, Status: itemStatusFailed, Error: "failed to create product"},
+			},
+		},
+		{
+			name:       "atomic mode rejects the same batch outright",
+			url:        "/products/bulk?mode=atomic",
+			body:       `{"items":[{"name":"Laptop"},{"name":""}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown mode",
+			url:        "/products/bulk?mode=best-effort",
+			body:       `{"items":[{"name":"Laptop"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "empty items",
+			url:        "/products/bulk?mode=partial",
+			body:       `{"items":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				partialFn: func(_ context.Context, inputs []products.CreateInput) ([]products.CreateResult, error) {
+					results := make([]products.CreateResult, len(inputs))
+					for i, in := range inputs {
+						results[i].Index = i
+						switch in.Name {
+						case "":
+							results[i].Err = products.ErrInvalidName
+						case "Taken":
+							results[i].Err = fmt.Errorf("repo create batch: %w", products.ErrDuplicateName)
+						case "Broken":
+							results[i].Err = errors.New("db down")
+						default:
+							results[i].Product = products.Product{ID: int64(i + 1), Name: in.Name}
+						}
+					}
+					return results, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d, body: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantResults == nil {
+				return
+			}
+			var resp bulkResultsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(resp.Results) != len(tt.wantResults) {
+				t.Fatalf("want %d results, got %+v", len(tt.wantResults), resp.Results)
+			}
+			for i, want := range tt.wantResults {
+				if resp.Results[i] != want {
+					t.Fatalf("result %d: want %+v, got %+v", i, want, resp.Results[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProduct_Binding(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		body      string
+		wantField string
+		wantMsg   string
+	}{
+		{
+			name:      "missing name",
+			url:       "/products",
+			body:      `{}`,
+			wantField: "name",
+			wantMsg:   "is required",
+		},
+		{
+			name:      "blank name",
+			url:       "/products",
+			body:      `{"name":"   "}`,
+			wantField: "name",
+			wantMsg:   "is required",
+		},
+		{
+			name:      "control characters",
+			url:       "/products",
+			body:      `{"name":"Lap\u0007top"}`,
+			wantField: "name",
+			wantMsg:   "must not be blank or contain control characters",
+		},
+		{
+			name:      "too long",
+			url:       "/products",
+			body:      `{"name":"` + strings.Repeat("a", products.MaxNameLength+1) + `"}`,
+			wantField: "name",
+			wantMsg:   "must be at most 200 characters",
+		},
+		{
+			name:      "unknown status",
+			url:       "/products",
+			body:      `{"name":"Laptop","status":"archived"}`,
+			wantField: "status",
+			wantMsg:   "must be one of draft, published",
+		},
+		{
+			name:      "bulk item reported by path",
+			url:       "/products/bulk",
+			body:      `{"items":[{"name":"Laptop"},{"name":""}]}`,
+			wantField: "items[1].name",
+			wantMsg:   "is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{
+				createFn: func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+					t.Fatal("service must not be called for an invalid body")
+					return products.Product{}, nil
+				},
+				bulkFn: func(_ context.Context, _ []products.CreateInput) ([]products.Product, error) {
+					t.Fatal("service must not be called for an invalid body")
+					return nil, nil
+				},
+			}
+
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("want status %d, got %d, body: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got := resp.Fields[tt.wantField]; got != tt.wantMsg {
+				t.Fatalf("want %s error %q, got fields %v", tt.wantField, tt.wantMsg, resp.Fields)
+			}
+		})
+	}
+}
+
+func TestHandler_CreateProduct_NameRequired(t *testing.T) {
+	bodies := map[string]string{
+		"missing":    `{}`,
+		"null":       `{"name":null}`,
+		"empty":      `{"name":""}`,
+		"whitespace": `{"name":" \t "}`,
+	}
+
+	var want string
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			svc := &stubService{
+				createFn: func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+					t.Fatal("service must not be called without a name")
+					return products.Product{}, nil
+				},
+			}
+			r := setupRouter(svc)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("want status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Code != codeNameRequired || resp.Fields["name"] != "is required" {
+				t.Fatalf("want code %s and name \"is required\", got %+v", codeNameRequired, resp)
+			}
+			if want == "" {
+				want = w.Body.String()
+			}
+			if w.Body.String() != want {
+				t.Fatalf("want the same body for every missing name, got %s and %s", want, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("rejected by the service", func(t *testing.T) {
+		svc := &stubService{
+			createFn: func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+				return products.Product{}, products.ErrInvalidName
+			},
+		}
+		r := setupRouter(svc)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"x"}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != want {
+			t.Fatalf("want status %d and %s, got %d and %s", http.StatusBadRequest, want, w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestHandler_AsyncCreate(t *testing.T) {
+	gate := make(chan struct{})
+	queue := jobs.NewQueue(func(_ context.Context, in products.CreateInput) (products.Product, error) {
+		<-gate
+		return products.Product{ID: 7, Name: in.Name}, nil
+	}, jobs.Config{QueueSize: 1, Workers: 1}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewHandler(&stubService{}, WithAsyncCreate(queue))
+	r.POST("/products", h.CreateProduct)
+	r.GET("/products/jobs/:id", h.GetCreateJob)
+
+	getJob := func(path string) (int, jobs.Job) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		var job jobs.Job
+		_ = json.Unmarshal(w.Body.Bytes(), &job)
+		return w.Code, job
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"Laptop"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("want status %d, got %d, body: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+
+	if code, job := getJob(location); code != http.StatusOK || job.Status != jobs.StatusPending {
+		t.Fatalf("want pending job, got %d %+v", code, job)
+	}
+
+	close(gate)
+	_ = queue.Close()
+
+	if code, job := getJob(location); code != http.StatusOK || job.Status != jobs.StatusDone || job.ProductID != 7 {
+		t.Fatalf("want done job with product 7, got %d %+v", code, job)
+	}
+	if code, _ := getJob("/products/jobs/unknown"); code != http.StatusNotFound {
+		t.Fatalf("want status %d for unknown job, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestHandler_AsyncCreate_FailedJobError(t *testing.T) {
+	tests := []struct {
+		name      string
+		createErr error
+		wantError string
+	}{
+		{name: "duplicate", createErr: fmt.Errorf("repo create: %w", products.ErrDuplicateName), wantError: products.ErrDuplicateName.Error()},
+		{name: "internal", createErr: errors.New("repo create: pq: connection refused"), wantError: "failed to create product"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := jobs.NewQueue(func(context.Context, products.CreateInput) (products.Product, error) {
+				return products.Product{}, tt.createErr
+			}, jobs.Config{QueueSize: 1, Workers: 1}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			h := NewHandler(&stubService{}, WithAsyncCreate(queue))
+			r.GET("/products/jobs/:id", h.GetCreateJob)
+
+			job, err := queue.Submit(context.Background(), products.CreateInput{Name: "Laptop"})
+			if err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+			_ = queue.Close()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/jobs/"+job.ID, http.NoBody))
+			var got jobs.Job
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode job: %v", err)
+			}
+			if got.Status != jobs.StatusFailed || got.Error != tt.wantError {
+				t.Fatalf("want failed job with error %q, got %s", tt.wantError, w.Body.String())
+			}
+		})
+	}
+}
+
+type fullQueue struct{}
+
+func (fullQueue) Submit(context.Context, products.CreateInput) (jobs.Job, error) {
+	return jobs.Job{}, jobs.ErrQueueFull
+}
+func (fullQueue) Get(string) (jobs.Job, bool) { return jobs.Job{}, false }
+
+func TestHandler_AsyncCreate_QueueFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/products", NewHandler(&stubService{}, WithAsyncCreate(fullQueue{})).CreateProduct)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(`{"name":"Laptop"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get(retryAfterHeader) == "" {
+		t.Fatal("want Retry-After header when the queue is full")
+	}
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr string
+	}{
+		{name: "not a number", id: "abc", wantErr: errIDNotNumber.Error()},
+		{name: "mixed digits", id: "12abc", wantErr: errIDNotNumber.Error()},
+		{name: "plus sign", id: "+7", wantErr: errIDNotNumber.Error()},
+		{name: "leading zero", id: "007", wantErr: errIDNotNumber.Error()},
+		{name: "decimal", id: "7.0", wantErr: errIDNotNumber.Error()},
+		{name: "zero", id: "0", wantErr: errIDNotPositive.Error()},
+		{name: "negative", id: "-7", wantErr: errIDNotPositive.Error()},
+		{name: "overflow", id: "9223372036854775808", wantErr: errIDOutOfRange.Error()},
+		{name: "max int64", id: "9223372036854775807"},
+		{name: "valid", id: "7"},
+	}
+
+	svc := &stubService{
+		deleteFn: func(_ context.Context, id int64) (products.Product, error) {
+			return products.Product{ID: id}, nil
+		},
+		updateAttrFn: func(_ context.Context, id int64, attrs map[string]any) (products.Product, error) {
+			return products.Product{ID: id}, nil
+		},
+	}
+	r := setupRouter(svc)
+
+	for _, tt := range tests {
+		for _, route := range []struct{ method, url, body string }{
+			{http.MethodDelete, "/products/" + tt.id, ""},
+			{http.MethodPut, "/products/" + tt.id + "/attributes", `{"color":"red"}`},
+		} {
+			t.Run(tt.name+" "+route.method, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(route.method, route.url, strings.NewReader(route.body))
+				req.Header.Set("Content-Type", "application/json")
+				r.ServeHTTP(w, req)
+
+				if tt.wantErr == "" {
+					if w.Code == http.StatusBadRequest {
+						t.Fatalf("want id %q accepted, got 400 %s", tt.id, w.Body.String())
+					}
+					return
+				}
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("want status %d, got %d", http.StatusBadRequest, w.Code)
+				}
+				var resp errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("decode response: %v", err)
+				}
+				if resp.Error != tt.wantErr {
+					t.Fatalf("want error %q, got %q", tt.wantErr, resp.Error)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import "sync"
+
+// DebouncedHealth steadies a HealthChecker whose dependency flaps, so
+// /healthz does not toggle load balancers on every blip: a healthy service
+// turns unhealthy only after failures failed checks in a row, and an
+// unhealthy one healthy again only after successes passing checks in a row.
+// It starts out healthy.
+type DebouncedHealth struct {
+	checker   HealthChecker
+	failures  int
+	successes int
+
+	mu      sync.Mutex
+	lastErr error // the failure being reported; nil while healthy
+	streak  int   // checks in a row that disagreed with what is reported
+}
+
+// NewDebouncedHealth wraps checker. Thresholds below 1 count as 1, which
+// reports every check as it is.
+func NewDebouncedHealth(checker HealthChecker, failures, successes int) *DebouncedHealth {
+	return &DebouncedHealth{
+		checker:   checker,
+		failures:  max(failures, 1),
+		successes: max(successes, 1),
+	}
+}
+
+// Health runs the wrapped check and reports the debounced outcome. While
+// unhealthy it returns the failure that made it so, or a later one.
+func (d *DebouncedHealth) Health() error {
+	err := d.checker.Health()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lastErr == nil {
+		if err == nil {
+			d.streak = 0
+			return nil
+		}
+		if d.streak++; d.streak < d.failures {
+			return nil
+		}
+		d.lastErr, d.streak = err, 0
+		return err
+	}
+
+	if err != nil {
+		d.lastErr, d.streak = err, 0
+		return err
+	}
+	if d.streak++; d.streak < d.successes {
+		return d.lastErr
+	}
+	d.lastErr, d.streak = nil, 0
+	return nil
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"errors"
+	"testing"
+)
+
+// scriptedChecker answers each Health call with the next of results.
+type scriptedChecker struct {
+	results []bool
+	calls   int
+}
+
+func (c *scriptedChecker) Health() error {
+	up := c.results[c.calls]
+	c.calls++
+	if up {
+		return nil
+	}
+	return errors.New("db down")
+}
+
+func TestDebouncedHealth(t *testing.T) {
+	const (
+		up   = true
+		down = false
+	)
+	tests := []struct {
+		name      string
+		failures  int
+		successes int
+		checks    []bool
+		want      []bool
+	}{
+		{
+			name:      "thresholds of one report every check",
+			failures:  1,
+			successes: 1,
+			checks:    []bool{up, down, up, down},
+			want:      []bool{up, down, up, down},
+		},
+		{
+			name:      "flapping never reaches either threshold",
+			failures:  3,
+			successes: 3,
+			checks:    []bool{down, down, up, down, down, up, down},
+			want:      []bool{up, up, up, up, up, up, up},
+		},
+		{
+			name:      "failures in a row turn unhealthy",
+			failures:  3,
+			successes: 2,
+			checks:    []bool{down, down, down, up, down, up, up, up},
+			want:      []bool{up, up, down, down, down, down, up, up},
+		},
+		{
+			name:   "zero thresholds count as one",
+			checks: []bool{down, up},
+			want:   []bool{down, up},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDebouncedHealth(&scriptedChecker{results: tt.checks}, tt.failures, tt.successes)
+			for i, want := range tt.want {
+				if got := d.Health() == nil; got != want {
+					t.Fatalf("check %d: want healthy=%v, got %v", i+1, want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runtimeInfo is what GET /internal/info reports besides live runtime
+// figures, fixed at startup.
+type runtimeInfo struct {
+	started time.Time
+	version string
+	commit  string
+	config  map[string]string
+}
+
+// WithInfo registers the admin GET /internal/info endpoint, reporting
+// build and runtime details and config, an already redacted summary of
+// the service's settings.
+func WithInfo(config map[string]string) Option {
+	info := &runtimeInfo{started: time.Now(), version: "unknown", config: config}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.version = build.Main.Version
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.commit = setting.Value
+			}
+		}
+	}
+	return func(h *Handler) {
+		h.info = info
+	}
+}
+
+type infoResponse struct {
+	GoVersion string `json:"go_version" example:"go1.22.5"`
+	// Version is the main module's version, "(devel)" for local builds.
+	Version string `json:"version" example:"(devel)"`
+	// Commit is the VCS revision the binary was built from, when known.
+	Commit        string            `json:"commit,omitempty" example:"9bc7eae0c1f2"`
+	UptimeSeconds int64             `json:"uptime_seconds" example:"3600"`
+	Goroutines    int               `json:"goroutines" example:"42"`
+	GC            gcInfo            `json:"gc"`
+	Config        map[string]string `json:"config"`
+}
+
+type gcInfo struct {
+	NumGC        uint32     `json:"num_gc" example:"12"`
+	PauseTotalMS float64    `json:"pause_total_ms" example:"1.5"`
+	LastGC       *time.Time `json:"last_gc,omitempty"`
+	HeapAlloc    uint64     `json:"heap_alloc_bytes" example:"4194304"`
+	NextGC       uint64     `json:"next_gc_bytes" example:"8388608"`
+}
+
+// GetInfo godoc
+// @Summary      Report build and runtime details
+// @Description  For quick debugging without Prometheus: Go version, build version and commit, uptime, goroutines, GC statistics and a summary of the configuration with secrets redacted.
+// @Tags         admin
+// @Produce      json
+// @Security     AdminToken
+// @Success      200  {object}  infoResponse
+// @Failure      401  {object}  errorResponse
+// @Router       /internal/info [get]
+func (h *Handler) GetInfo(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	gc := gcInfo{
+		NumGC:        mem.NumGC,
+		PauseTotalMS: millis(time.Duration(mem.PauseTotalNs)),
+		HeapAlloc:    mem.HeapAlloc,
+		NextGC:       mem.NextGC,
+	}
+	if mem.LastGC > 0 {
+		last := time.Unix(0, int64(mem.LastGC)).UTC()
+		gc.LastGC = &last
+	}
+
+	c.JSON(http.StatusOK, infoResponse{
+		GoVersion:     runtime.Version(),
+		Version:       h.info.version,
+		Commit:        h.info.commit,
+		UptimeSeconds: int64(time.Since(h.info.started).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		GC:            gc,
+		Config:        h.info.config,
+	})
+}
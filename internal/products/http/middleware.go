@@ -1,14 +1,41 @@
 package http
 
 import (
+	"context"
+	"crypto/subtle"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"product-notifications/internal/products"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
 )
 
-const requestIDHeader = "X-Request-ID"
+const (
+	requestIDHeader     = "X-Request-ID"
+	retryAfterHeader    = "Retry-After"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	serverTimingHeader  = "Server-Timing"
+	featureFlagsHeader  = "X-Feature-Flags"
+	actorHeader         = "X-Actor-ID"
+
+	// traceIDLabel names the exemplar label linking a latency observation
+	// to its trace.
+	traceIDLabel = "trace_id"
+	// unmatchedRoute labels requests that matched no route, so probes for
+	// arbitrary paths cannot grow the route label without bound.
+	unmatchedRoute = "unmatched"
+)
 
 func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -18,23 +45,279 @@ func RequestIDMiddleware() gin.HandlerFunc {
 		}
 		c.Header(requestIDHeader, requestID)
 		c.Set(requestIDHeader, requestID)
+		c.Request = c.Request.WithContext(products.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// ActorMiddleware records the X-Actor-ID header, set by the gateway that
+// authenticated the caller, as the actor of the request for audit records.
+func ActorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := strings.TrimSpace(c.GetHeader(actorHeader)); actor != "" {
+			c.Request = c.Request.WithContext(products.WithActor(c.Request.Context(), actor))
+		}
 		c.Next()
 	}
 }
 
-func AccessLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
+// DurationVar is a time.Duration that can be changed while requests are
+// reading it, in the spirit of slog.LevelVar.
+type DurationVar struct {
+	ns atomic.Int64
+}
+
+func NewDurationVar(d time.Duration) *DurationVar {
+	v := &DurationVar{}
+	v.Store(d)
+	return v
+}
+
+func (v *DurationVar) Load() time.Duration {
+	return time.Duration(v.ns.Load())
+}
+
+func (v *DurationVar) Store(d time.Duration) {
+	v.ns.Store(int64(d))
+}
+
+// AccessLogMiddleware logs requests at info level, or at warn level with
+// slow=true when they took longer than slowThreshold. With sampleRate above
+// one only every sampleRate-th fast 2xx request is logged; slow and non-2xx
+// requests are always logged.
+func AccessLogMiddleware(logger *slog.Logger, slowThreshold *DurationVar, sampleRate int64) gin.HandlerFunc {
+	var successes atomic.Int64
 	return func(c *gin.Context) {
 		start := time.Now()
 		c.Next()
+		latency := time.Since(start)
 
 		requestID, _ := c.Get(requestIDHeader)
-		logger.Info("http request",
+		attrs := []any{
 			"method", c.Request.Method,
 			"path", c.Request.URL.Path,
 			"status", c.Writer.Status(),
-			"latency_ms", time.Since(start).Milliseconds(),
+			"latency_ms", latency.Milliseconds(),
 			"request_id", requestID,
 			"client_ip", c.ClientIP(),
-		)
+		}
+
+		if latency > slowThreshold.Load() {
+			logger.Warn("http request", append(attrs, "slow", true)...)
+			return
+		}
+		status := c.Writer.Status()
+		if sampleRate > 1 && status >= 200 && status < 300 && (successes.Add(1)-1)%sampleRate != 0 {
+			return
+		}
+		logger.Info("http request", attrs...)
+	}
+}
+
+// MetricsMiddleware observes each request's latency in duration, labelled
+// by route template, method and status. When the request context carries a
+// sampled trace span, the observation gets a trace_id exemplar so a slow
+// bucket links to the trace that landed in it; without tracing it is a
+// plain observation.
+func MetricsMiddleware(duration *prometheus.HistogramVec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		observer := duration.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		observeWithTrace(c.Request.Context(), observer, time.Since(start).Seconds())
+	}
+}
+
+func observeWithTrace(ctx context.Context, observer prometheus.Observer, value float64) {
+	span := trace.SpanContextFromContext(ctx)
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && span.IsValid() && span.IsSampled() {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{traceIDLabel: span.TraceID().String()})
+		return
+	}
+	observer.Observe(value)
+}
+
+// RouteTimeouts picks a request deadline by the matched gin route template,
+// such as /products/:id, falling back to Default.
+type RouteTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// For returns the timeout for route; zero means no deadline.
+func (t RouteTimeouts) For(route string) time.Duration {
+	if d, ok := t.Routes[route]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// RequestTimeoutMiddleware gives each request's context the deadline its
+// route gets from timeouts, so database calls and publishes made for it
+// are cancelled once it expires.
+func RequestTimeoutMiddleware(timeouts RouteTimeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := timeouts.For(c.FullPath())
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// probeRoutes are left out of load shedding and rate limiting: a saturated
+// instance must still answer its health checks and metrics scrapes, or it
+// is pulled from the load balancer and goes dark exactly when it matters.
+var probeRoutes = map[string]bool{
+	"/healthz": true,
+	"/metrics": true,
+}
+
+func isProbe(c *gin.Context) bool {
+	return probeRoutes[c.FullPath()]
+}
+
+// ConcurrencyLimitMiddleware caps in-flight requests at limit. Requests over
+// the cap are rejected with 503 immediately instead of queueing, so a spike
+// cannot pile up on an exhausted DB pool. Rejections carry retryAfter's
+// Overloaded delay. Health checks and metrics scrapes are not counted.
+func ConcurrencyLimitMiddleware(limit int64, retryAfter RetryAfterPolicy) gin.HandlerFunc {
+	sem := semaphore.NewWeighted(limit)
+	return func(c *gin.Context) {
+		if isProbe(c) {
+			c.Next()
+			return
+		}
+		if !sem.TryAcquire(1) {
+			retryAfter.respond503(c, reasonOverloaded, "server is at capacity")
+			return
+		}
+		defer sem.Release(1)
+		c.Next()
+	}
+}
+
+// ReadOnlyMiddleware rejects the request with 503 and retryAfter's ReadOnly
+// delay while mode reports read-only. It is only installed on write routes.
+func ReadOnlyMiddleware(mode ReadOnlyMode, retryAfter RetryAfterPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mode.ReadOnly() {
+			retryAfter.respond503(c, reasonReadOnly, "service is read-only")
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminAuthMiddleware only lets through requests carrying
+// "Authorization: Bearer <token>".
+func AdminAuthMiddleware(token string) gin.HandlerFunc {
+	want := []byte(bearerPrefix + token)
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader(authorizationHeader))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			abortError(c, http.StatusUnauthorized, errorResponse{Error: "admin token required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ServerTimingMiddleware adds a Server-Timing header with the request's
+// repository time as db, concurrent calls counted once, and its handler
+// time as total, both in milliseconds, e.g. "db;dur=3.1, total;dur=4.7".
+// The header goes out with the first byte of the response, so for a
+// streamed response both cover only the work done before it started.
+func ServerTimingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, timings := products.WithTimings(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		w := &serverTimingWriter{ResponseWriter: c.Writer, timings: timings, start: time.Now()}
+		c.Writer = w
+		c.Next()
+		// Responses without a body, like 204, are written by gin after
+		// the handler chain returns.
+		w.setHeader()
+	}
+}
+
+type serverTimingWriter struct {
+	gin.ResponseWriter
+	timings *products.Timings
+	start   time.Time
+	set     bool
+}
+
+func (w *serverTimingWriter) setHeader() {
+	if w.set || w.ResponseWriter.Written() {
+		return
+	}
+	w.set = true
+	w.Header().Set(serverTimingHeader, fmt.Sprintf("db;dur=%.3f, total;dur=%.3f",
+		millis(w.timings.DB()), millis(time.Since(w.start))))
+}
+
+func (w *serverTimingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *serverTimingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *serverTimingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *serverTimingWriter) Flush() {
+	w.setHeader()
+	w.ResponseWriter.Flush()
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// FeatureFlagsMiddleware stores the flags named in the comma-separated
+// X-Feature-Flags header in the request context, for handlers and services
+// to consult with products.FeatureEnabled. Flags not in allowed are
+// ignored, so clients cannot opt into anything the server has not rolled
+// out.
+func FeatureFlagsMiddleware(allowed []products.FeatureFlag) gin.HandlerFunc {
+	allow := make(map[products.FeatureFlag]bool, len(allowed))
+	for _, flag := range allowed {
+		allow[flag] = true
+	}
+	return func(c *gin.Context) {
+		var flags products.FeatureFlags
+		for _, header := range c.Request.Header.Values(featureFlagsHeader) {
+			for _, name := range strings.Split(header, ",") {
+				flag := products.FeatureFlag(strings.ToLower(strings.TrimSpace(name)))
+				if !allow[flag] {
+					continue
+				}
+				if flags == nil {
+					flags = make(products.FeatureFlags)
+				}
+				flags[flag] = true
+			}
+		}
+		if flags != nil {
+			c.Request = c.Request.WithContext(products.WithFeatureFlags(c.Request.Context(), flags))
+		}
+		c.Next()
 	}
 }
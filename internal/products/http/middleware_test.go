@@ -0,0 +1,344 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAccessLogMiddleware_SlowRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		wantLevel string
+		wantSlow  bool
+	}{
+		{
+			name:      "fast request logged at info",
+			wantLevel: slog.LevelInfo.String(),
+		},
+		{
+			name:      "slow request logged at warn",
+			delay:     30 * time.Millisecond,
+			wantLevel: slog.LevelWarn.String(),
+			wantSlow:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(AccessLogMiddleware(logger, NewDurationVar(10*time.Millisecond), 1))
+			r.GET("/stub", func(c *gin.Context) {
+				time.Sleep(tt.delay)
+				c.Status(http.StatusOK)
+			})
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stub", http.NoBody))
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("decode log entry: %v", err)
+			}
+			if entry["level"] != tt.wantLevel {
+				t.Fatalf("want level %q, got %v", tt.wantLevel, entry["level"])
+			}
+			slow, ok := entry["slow"]
+			if tt.wantSlow && slow != true {
+				t.Fatalf("want slow=true, got %v", slow)
+			}
+			if !tt.wantSlow && ok {
+				t.Fatalf("want no slow field, got %v", slow)
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware_Sampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AccessLogMiddleware(logger, NewDurationVar(time.Second), 5))
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", http.NoBody))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", http.NoBody))
+	}
+
+	logged := map[string]int{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry map[string]any
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("decode log entry: %v", err)
+		}
+		path, _ := entry["path"].(string)
+		logged[path]++
+	}
+	if logged["/fail"] != 10 {
+		t.Fatalf("want every error logged, got %d of 10", logged["/fail"])
+	}
+	if logged["/ok"] != 2 {
+		t.Fatalf("want 1 in 5 successes logged, got %d of 10", logged["/ok"])
+	}
+}
+
+func TestRequestTimeoutMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestTimeoutMiddleware(RouteTimeouts{
+		Default: time.Second,
+		Routes: map[string]time.Duration{
+			"/products/bulk": 2 * time.Minute,
+			"/products/:id":  0,
+		},
+	}))
+
+	deadlines := map[string]time.Duration{}
+	record := func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		if ok {
+			deadlines[c.FullPath()] = time.Until(deadline)
+		} else {
+			deadlines[c.FullPath()] = -1
+		}
+		c.Status(http.StatusOK)
+	}
+	r.GET("/products", record)
+	r.POST("/products/bulk", record)
+	r.DELETE("/products/:id", record)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/products", http.NoBody))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/products/bulk", http.NoBody))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/products/7", http.NoBody))
+
+	if d := deadlines["/products"]; d <= 0 || d > time.Second {
+		t.Fatalf("want the default 1s deadline, got %v", d)
+	}
+	if d := deadlines["/products/bulk"]; d <= time.Minute || d > 2*time.Minute {
+		t.Fatalf("want the 2m route deadline, got %v", d)
+	}
+	if d := deadlines["/products/:id"]; d != -1 {
+		t.Fatalf("want no deadline for a zero route timeout, got %v", d)
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	const limit = 2
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ConcurrencyLimitMiddleware(limit, DefaultRetryAfter))
+
+	entered := make(chan struct{}, limit)
+	release := make(chan struct{})
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/stub", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, limit)
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stub", http.NoBody))
+			codes[i] = w.Code
+		}(i)
+	}
+	for i := 0; i < limit; i++ {
+		<-entered
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stub", http.NoBody))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want status %d when saturated, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get(retryAfterHeader) == "" {
+		t.Fatal("want Retry-After header on rejection")
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want health checks answered when saturated, got %d", w.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("in-flight request %d: want status %d, got %d", i, http.StatusOK, code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stub", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status %d after slots freed, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestMetricsMiddleware_Exemplars(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "t_request_duration_seconds",
+		Help: "t",
+	}, []string{"route", "method", "status"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(duration)
+
+	r := gin.New()
+	r.Use(MetricsMiddleware(duration))
+	r.GET("/products", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/products/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	span := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{0, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	traced := httptest.NewRequest(http.MethodGet, "/products", http.NoBody)
+	traced = traced.WithContext(trace.ContextWithSpanContext(traced.Context(), span))
+	r.ServeHTTP(httptest.NewRecorder(), traced)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/products/7", http.NoBody))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	exemplars := map[string]string{}
+	for _, metric := range families[0].GetMetric() {
+		var route string
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "route" {
+				route = label.GetValue()
+			}
+		}
+		exemplars[route] = ""
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			for _, label := range bucket.GetExemplar().GetLabel() {
+				if label.GetName() == traceIDLabel {
+					exemplars[route] = label.GetValue()
+				}
+			}
+		}
+	}
+
+	if got := exemplars["/products"]; got != traceID.String() {
+		t.Fatalf("want exemplar trace_id %s on the traced route, got %q", traceID, got)
+	}
+	if got, ok := exemplars["/products/:id"]; !ok || got != "" {
+		t.Fatalf("want an observation without exemplar on the untraced route, got %q (observed=%v)", got, ok)
+	}
+}
+
+func TestServerTimingMiddleware(t *testing.T) {
+	svc := &stubService{
+		listFn: func(ctx context.Context, _ products.ListOptions, _, _ int) ([]products.Product, int64, error) {
+			time.Sleep(2 * time.Millisecond)
+			products.TimingsFrom(ctx).AddDB(2 * time.Millisecond)
+			return nil, 0, nil
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ServerTimingMiddleware())
+	r.GET("/products", NewHandler(svc).ListProducts)
+	r.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	pattern := regexp.MustCompile(`^db;dur=(\d+\.\d{3}), total;dur=(\d+\.\d{3})$`)
+	for _, path := range []string{"/products", "/empty"} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+
+			header := w.Header().Get("Server-Timing")
+			m := pattern.FindStringSubmatch(header)
+			if m == nil {
+				t.Fatalf("want a db and total Server-Timing, got %q", header)
+			}
+			db, _ := strconv.ParseFloat(m[1], 64)
+			total, _ := strconv.ParseFloat(m[2], 64)
+			if path == "/products" && (db != 2 || total < db) {
+				t.Fatalf("want db=2ms within total, got db=%v total=%v", db, total)
+			}
+		})
+	}
+}
+
+func TestFeatureFlagsMiddleware(t *testing.T) {
+	svc := &stubService{
+		listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+			return nil, 0, nil
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(FeatureFlagsMiddleware([]products.FeatureFlag{products.FlagStrictQuery}))
+	r.GET("/products", NewHandler(svc).ListProducts)
+	r.GET("/flags", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.JSON(http.StatusOK, gin.H{
+			"strict": products.FeatureEnabled(ctx, products.FlagStrictQuery),
+			"exact":  products.FeatureEnabled(ctx, products.FlagExactCount),
+		})
+	})
+
+	tests := []struct {
+		name       string
+		url        string
+		flags      string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "no header stays lenient", url: "/products?limt=5", wantStatus: http.StatusOK},
+		{name: "allowlisted flag makes the list strict", url: "/products?limt=5", flags: "Strict-Query", wantStatus: http.StatusBadRequest},
+		{name: "unknown flag ignored", url: "/products?limt=5", flags: "warp-speed", wantStatus: http.StatusOK},
+		{name: "known but not allowlisted flag ignored", url: "/flags", flags: "exact-count, strict-query", wantStatus: http.StatusOK, wantBody: `{"exact":false,"strict":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, http.NoBody)
+			if tt.flags != "" {
+				req.Header.Set(featureFlagsHeader, tt.flags)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Fatalf("want body %s, got %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
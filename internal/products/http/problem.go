@@ -0,0 +1,146 @@
+package http
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mimeProblemJSON = "application/problem+json"
+
+	// problemTypePrefix starts every problem type URI. The URIs identify
+	// the kind of error and are not meant to be dereferenced.
+	problemTypePrefix = "urn:product-notifications:problem:"
+	// problemTypeBlank is RFC 7807's type for a problem that is no more
+	// than its status code.
+	problemTypeBlank = "about:blank"
+
+	// problemDetailsKey marks a request that gets problem+json errors
+	// whatever it accepts.
+	problemDetailsKey = "problem_details"
+)
+
+// problemTypes gives each domain error a stable problem type, checked in
+// order with errors.Is.
+var problemTypes = []struct {
+	err  error
+	name string
+}{
+	{products.ErrNotFound, "not-found"},
+	{products.ErrInvalidName, "invalid-name"},
+	{products.ErrNameTooLong, "name-too-long"},
+	{products.ErrNameTooShort, "name-too-short"},
+	{products.ErrNameControlChars, "name-control-characters"},
+	{products.ErrNameNotAllowed, "name-not-allowed"},
+	{products.ErrDuplicateName, "duplicate-name"},
+	{products.ErrWebhookRejected, "webhook-rejected"},
+	{products.ErrAttributesTooLarge, "attributes-too-large"},
+	{products.ErrBatchTooLarge, "batch-too-large"},
+	{products.ErrQueryTimeout, "query-timeout"},
+	{products.ErrQuotaExceeded, "quota-exceeded"},
+	{products.ErrExpiryInPast, "expiry-in-past"},
+	{products.ErrInvalidStatus, "invalid-status"},
+	{products.ErrInvalidTransition, "invalid-transition"},
+	{products.ErrAlreadyReserved, "already-reserved"},
+	{products.ErrReservedByOther, "reserved-by-other"},
+}
+
+// problemResponse is an RFC 7807 problem details body. The members after
+// Instance are extensions carrying what errorResponse holds besides its
+// message.
+type problemResponse struct {
+	Type     string `json:"type" example:"urn:product-notifications:problem:not-found"`
+	Title    string `json:"title" example:"Not Found"`
+	Status   int    `json:"status" example:"404"`
+	Detail   string `json:"detail" example:"product not found"`
+	Instance string `json:"instance,omitempty" example:"/products/42"`
+
+	Fields           map[string]string `json:"fields,omitempty"`
+	Code             string            `json:"code,omitempty"`
+	ExistingID       int64             `json:"existing_id,omitempty"`
+	ExistingPublicID string            `json:"existing_public_id,omitempty"`
+}
+
+// errorFor is the response for err, a domain error or one wrapping it,
+// with the error's message and its problem type.
+func errorFor(err error) errorResponse {
+	resp := errorResponse{Error: err.Error()}
+	for _, pt := range problemTypes {
+		if errors.Is(err, pt.err) {
+			resp.problemType = problemTypePrefix + pt.name
+			break
+		}
+	}
+	return resp
+}
+
+// respondError writes resp with status, as problem+json when the request
+// asks for it, or in the flat {"error": ...} shape.
+func respondError(c *gin.Context, status int, resp errorResponse) {
+	if !wantsProblem(c) {
+		c.JSON(status, resp)
+		return
+	}
+
+	problem := problemResponse{
+		Type:             resp.problemType,
+		Title:            http.StatusText(status),
+		Status:           status,
+		Detail:           resp.Error,
+		Fields:           resp.Fields,
+		Code:             resp.Code,
+		ExistingID:       resp.ExistingID,
+		ExistingPublicID: resp.ExistingPublicID,
+	}
+	if problem.Type == "" {
+		problem.Type = problemTypeBlank
+	}
+	if c.Request != nil {
+		problem.Instance = c.Request.URL.RequestURI()
+	}
+	// gin keeps a Content-Type that is already set.
+	c.Header("Content-Type", mimeProblemJSON)
+	c.JSON(status, problem)
+}
+
+// abortError is respondError for middleware: it also stops the chain.
+func abortError(c *gin.Context, status int, resp errorResponse) {
+	c.Abort()
+	respondError(c, status, resp)
+}
+
+// wantsProblem reports whether the request gets problem+json errors: every
+// request does behind ProblemDetailsMiddleware, others when their Accept
+// header lists application/problem+json.
+func wantsProblem(c *gin.Context) bool {
+	if c.GetBool(problemDetailsKey) {
+		return true
+	}
+	if c.Request == nil {
+		return false
+	}
+	for _, accept := range c.Request.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == mimeProblemJSON {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProblemDetailsMiddleware answers every error as RFC 7807
+// application/problem+json, whatever the request accepts.
+func ProblemDetailsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(problemDetailsKey, true)
+		c.Next()
+	}
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondError_ProblemDetails(t *testing.T) {
+	svc := &stubService{
+		deleteFn: func(context.Context, int64) (products.Product, error) {
+			return products.Product{}, fmt.Errorf("delete: %w", products.ErrNotFound)
+		},
+		createFn: func(context.Context, products.CreateInput) (products.Product, error) {
+			return products.Product{}, &products.DuplicateNameError{ExistingID: 7}
+		},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		accept      string
+		forced      bool
+		wantProblem *problemResponse
+	}{
+		{
+			name:   "flat by default",
+			method: http.MethodDelete,
+			url:    "/products/42",
+		},
+		{
+			name:   "asked for in Accept",
+			method: http.MethodDelete,
+			url:    "/products/42",
+			accept: "application/json, application/problem+json;q=0.9",
+			wantProblem: &problemResponse{
+				Type:     "urn:product-notifications:problem:not-found",
+				Title:    "Not Found",
+				Status:   http.StatusNotFound,
+				Detail:   "delete: product not found",
+				Instance: "/products/42",
+			},
+		},
+		{
+			name:   "forced by middleware",
+			method: http.MethodDelete,
+			url:    "/products/abc",
+			forced: true,
+			wantProblem: &problemResponse{
+				Type:     "about:blank",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "invalid product id: not a number",
+				Instance: "/products/abc",
+			},
+		},
+		{
+			name:   "extensions kept",
+			method: http.MethodPost,
+			url:    "/products",
+			body:   `{"name":"Taken"}`,
+			forced: true,
+			wantProblem: &problemResponse{
+				Type:       "urn:product-notifications:problem:duplicate-name",
+				Title:      "Conflict",
+				Status:     http.StatusConflict,
+				Detail:     "product with this name already exists",
+				Instance:   "/products",
+				Code:       codeDuplicateName,
+				ExistingID: 7,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			if tt.forced {
+				r.Use(ProblemDetailsMiddleware())
+			}
+			h := NewHandler(svc)
+			r.POST("/products", h.CreateProduct)
+			r.DELETE("/products/:id", h.DeleteProduct)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			r.ServeHTTP(w, req)
+
+			if tt.wantProblem == nil {
+				if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+					t.Fatalf("want application/json, got %q", got)
+				}
+				var resp errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+					t.Fatalf("want flat error body, got %s", w.Body.String())
+				}
+				return
+			}
+
+			if got := w.Header().Get("Content-Type"); got != mimeProblemJSON {
+				t.Fatalf("want Content-Type %s, got %q", mimeProblemJSON, got)
+			}
+			if w.Code != tt.wantProblem.Status {
+				t.Fatalf("want status %d, got %d", tt.wantProblem.Status, w.Code)
+			}
+			var got problemResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, *tt.wantProblem) {
+				t.Fatalf("want %+v, got %+v", *tt.wantProblem, got)
+			}
+		})
+	}
+}
+
+func TestErrorFor_ProblemTypesAreDistinct(t *testing.T) {
+	seen := make(map[string]error)
+	for _, pt := range problemTypes {
+		uri := errorFor(pt.err).problemType
+		if other, ok := seen[uri]; ok {
+			t.Fatalf("%v and %v share problem type %s", other, pt.err, uri)
+		}
+		seen[uri] = pt.err
+	}
+}
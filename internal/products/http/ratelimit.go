@@ -0,0 +1,115 @@
+package http
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiKeyHeader             = "X-API-Key"
+	rateLimitLimitHeader     = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitTierHeader      = "X-RateLimit-Tier"
+
+	// rateLimitIdle is how long a client's bucket outlives its last
+	// request, so per-IP buckets cannot grow without bound.
+	rateLimitIdle = 10 * time.Minute
+)
+
+// RateTier is the sustained rate, in requests per second, and the burst
+// each client in a tier is allowed.
+type RateTier struct {
+	RPS   float64
+	Burst int
+}
+
+// RateLimits maps API keys to named tiers. Requests without an X-API-Key,
+// or with one not in Keys, get the Default tier, limited per client IP.
+type RateLimits struct {
+	Tiers   map[string]RateTier
+	Keys    map[string]string
+	Default string
+}
+
+type rateClient struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*rateClient
+	lastSweep time.Time
+}
+
+// limiter returns client's bucket, creating it at tier's rate, and drops
+// the buckets of clients idle for rateLimitIdle.
+func (l *rateLimiter) limiter(client string, tier RateTier, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		for name, rc := range l.clients {
+			if now.Sub(rc.seen) > rateLimitIdle {
+				delete(l.clients, name)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	rc, ok := l.clients[client]
+	if !ok {
+		rc = &rateClient{limiter: rate.NewLimiter(rate.Limit(tier.RPS), tier.Burst)}
+		l.clients[client] = rc
+	}
+	rc.seen = now
+	return rc.limiter
+}
+
+// RateLimitMiddleware holds each client to its tier's token bucket and
+// rejects requests over it with 429 and a Retry-After. Every response
+// carries the client's tier, its burst as X-RateLimit-Limit and the
+// requests it has left as X-RateLimit-Remaining. Health checks and metrics
+// scrapes are not limited.
+//
+// Keyless clients are told apart by c.ClientIP, so the engine's trusted
+// proxies must be set: otherwise a client can dodge its bucket with a new
+// X-Forwarded-For on every request.
+func RateLimitMiddleware(limits RateLimits) gin.HandlerFunc {
+	l := &rateLimiter{clients: make(map[string]*rateClient)}
+	return func(c *gin.Context) {
+		if isProbe(c) {
+			c.Next()
+			return
+		}
+		tierName, client := limits.Default, "ip:"+c.ClientIP()
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			if name, ok := limits.Keys[key]; ok {
+				tierName, client = name, "key:"+key
+			}
+		}
+		tier := limits.Tiers[tierName]
+
+		now := time.Now()
+		lim := l.limiter(client, tier, now)
+		allowed := lim.AllowN(now, 1)
+		tokens := lim.TokensAt(now)
+
+		c.Header(rateLimitTierHeader, tierName)
+		c.Header(rateLimitLimitHeader, strconv.Itoa(tier.Burst))
+		c.Header(rateLimitRemainingHeader, strconv.Itoa(max(int(tokens), 0)))
+		if !allowed {
+			wait := math.Ceil((1 - tokens) / tier.RPS)
+			c.Header(retryAfterHeader, strconv.Itoa(max(int(wait), 1)))
+			abortError(c, http.StatusTooManyRequests, errorResponse{Error: "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitMiddleware_Tiers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Rates low enough that no token comes back while the test runs.
+	r.Use(RateLimitMiddleware(RateLimits{
+		Tiers: map[string]RateTier{
+			"free": {RPS: 0.01, Burst: 2},
+			"pro":  {RPS: 0.01, Burst: 5},
+		},
+		Keys:    map[string]string{"free-key": "free", "pro-key": "pro"},
+		Default: "free",
+	}))
+	r.GET("/stub", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name     string
+		key      string
+		clientIP string
+		wantTier string
+		wantOK   int
+	}{
+		{name: "free key", key: "free-key", clientIP: "192.0.2.1", wantTier: "free", wantOK: 2},
+		{name: "pro key", key: "pro-key", clientIP: "192.0.2.1", wantTier: "pro", wantOK: 5},
+		// Keyless clients share the default tier by IP, so this one gets
+		// an address of its own.
+		{name: "unknown key", key: "stolen", clientIP: "192.0.2.2", wantTier: "free", wantOK: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i <= tt.wantOK; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/stub", http.NoBody)
+				req.Header.Set(apiKeyHeader, tt.key)
+				req.RemoteAddr = tt.clientIP + ":1234"
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if got := w.Header().Get(rateLimitTierHeader); got != tt.wantTier {
+					t.Fatalf("request %d: want tier %q, got %q", i, tt.wantTier, got)
+				}
+				if i < tt.wantOK {
+					if w.Code != http.StatusOK {
+						t.Fatalf("request %d: want status %d within the burst, got %d", i, http.StatusOK, w.Code)
+					}
+					continue
+				}
+				if w.Code != http.StatusTooManyRequests {
+					t.Fatalf("request %d: want status %d past the burst, got %d", i, http.StatusTooManyRequests, w.Code)
+				}
+				if w.Header().Get(retryAfterHeader) == "" {
+					t.Fatal("want Retry-After header on rejection")
+				}
+				if got := w.Header().Get(rateLimitRemainingHeader); got != "0" {
+					t.Fatalf("want %s 0 once limited, got %q", rateLimitRemainingHeader, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_KeylessClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(RateLimitMiddleware(RateLimits{
+		Tiers:   map[string]RateTier{"free": {RPS: 0.01, Burst: 1}},
+		Default: "free",
+	}))
+	r.GET("/stub", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve("/stub", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("want the first request let through, got %d", code)
+	}
+	if code := serve("/stub", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("want a forged X-Forwarded-For to share the peer's bucket, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := serve("/healthz", ""); code != http.StatusOK {
+			t.Fatalf("health check %d: want it exempt from the limit, got %d", i, code)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetryAfterPolicy is how long clients are told, through Retry-After, to
+// wait before retrying a 503, by why it was returned. Zero fields use
+// DefaultRetryAfter's.
+type RetryAfterPolicy struct {
+	// Overloaded covers the concurrency limit and a full create queue.
+	Overloaded time.Duration
+	// ReadOnly covers writes refused in read-only mode.
+	ReadOnly time.Duration
+	// Unavailable covers everything else: failed health checks, search
+	// timeouts and a publisher that cannot flush.
+	Unavailable time.Duration
+}
+
+// DefaultRetryAfter is the policy used unless one is configured.
+var DefaultRetryAfter = RetryAfterPolicy{
+	Overloaded:  time.Second,
+	ReadOnly:    30 * time.Second,
+	Unavailable: 5 * time.Second,
+}
+
+type unavailableReason int
+
+const (
+	reasonOverloaded unavailableReason = iota
+	reasonReadOnly
+	reasonUnavailable
+)
+
+func (p RetryAfterPolicy) delay(reason unavailableReason) time.Duration {
+	switch reason {
+	case reasonOverloaded:
+		return orDefault(p.Overloaded, DefaultRetryAfter.Overloaded)
+	case reasonReadOnly:
+		return orDefault(p.ReadOnly, DefaultRetryAfter.ReadOnly)
+	default:
+		return orDefault(p.Unavailable, DefaultRetryAfter.Unavailable)
+	}
+}
+
+// orDefault returns d, or fallback when d is not positive.
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// respond503 aborts the request with 503, message as its error and the
+// policy's Retry-After for reason.
+func (p RetryAfterPolicy) respond503(c *gin.Context, reason unavailableReason, message string) {
+	p.setRetryAfter(c, reason)
+	abortError(c, http.StatusServiceUnavailable, errorResponse{Error: message})
+}
+
+// setRetryAfter sets the Retry-After header for reason, in whole seconds
+// rounded up.
+func (p RetryAfterPolicy) setRetryAfter(c *gin.Context, reason unavailableReason) {
+	seconds := int(math.Ceil(p.delay(reason).Seconds()))
+	c.Header(retryAfterHeader, strconv.Itoa(seconds))
+}
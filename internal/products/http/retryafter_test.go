@@ -0,0 +1,90 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetryAfterPolicy(t *testing.T) {
+	policy := RetryAfterPolicy{
+		Overloaded: 2500 * time.Millisecond,
+		ReadOnly:   time.Minute,
+	}
+
+	tests := []struct {
+		name   string
+		reason unavailableReason
+		want   string
+	}{
+		{"overloaded rounds up", reasonOverloaded, "3"},
+		{"read-only", reasonReadOnly, "60"},
+		{"unset falls back to default", reasonUnavailable, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			policy.respond503(c, tt.reason, "unavailable")
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("want status %d, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+			if got := w.Header().Get(retryAfterHeader); got != tt.want {
+				t.Fatalf("want Retry-After %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+type downChecker struct{}
+
+func (downChecker) Health() error { return errors.New("db down") }
+
+func TestRetryAfterPolicy_Paths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := RetryAfterPolicy{Overloaded: 7 * time.Second, ReadOnly: 11 * time.Second, Unavailable: 13 * time.Second}
+
+	r := gin.New()
+	r.Use(ConcurrencyLimitMiddleware(1, policy))
+	block := make(chan struct{})
+	entered := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(entered)
+		<-block
+	})
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", http.NoBody))
+		close(done)
+	}()
+	<-entered
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", http.NoBody))
+	close(block)
+	<-done
+	if got := w.Header().Get(retryAfterHeader); w.Code != http.StatusServiceUnavailable || got != "7" {
+		t.Fatalf("concurrency limit: want 503 with Retry-After 7, got %d %q", w.Code, got)
+	}
+
+	ro := gin.New()
+	RegisterRoutes(ro, NewHandler(&stubService{}, WithReadOnly(&switchableReadOnly{on: true}), WithRetryAfter(policy)), stubChecker{}, "")
+	w = httptest.NewRecorder()
+	ro.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/products/1", http.NoBody))
+	if got := w.Header().Get(retryAfterHeader); w.Code != http.StatusServiceUnavailable || got != "11" {
+		t.Fatalf("read-only: want 503 with Retry-After 11, got %d %q", w.Code, got)
+	}
+
+	down := gin.New()
+	RegisterRoutes(down, NewHandler(&stubService{}, WithRetryAfter(policy)), downChecker{}, "")
+	w = httptest.NewRecorder()
+	down.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	if got := w.Header().Get(retryAfterHeader); w.Code != http.StatusServiceUnavailable || got != "13" {
+		t.Fatalf("healthz: want 503 with Retry-After 13, got %d %q", w.Code, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -12,22 +13,84 @@ import (
 const (
 	healthStatusOK        = "ok"
 	healthStatusUnhealthy = "unhealthy"
+	healthStatusDegraded  = "degraded"
 )
 
 type HealthChecker interface {
 	Health() error
 }
 
-func RegisterRoutes(router *gin.Engine, handler *Handler, checker HealthChecker) {
-	router.POST("/products", handler.CreateProduct)
+// RegisterRoutes wires the public API. Admin routes are only registered when
+// adminToken is set, so they stay unreachable by default.
+//
+// A path that only differs by a trailing slash redirects to the registered
+// one, and a known path requested with the wrong method answers 405 with an
+// Allow header rather than 404.
+//
+// When the handler has a read-only mode, write routes answer 503 while it
+// is on and /healthz reports "degraded" with 200, since reads still work.
+func RegisterRoutes(router *gin.Engine, handler *Handler, checker HealthChecker, adminToken string) {
+	router.RedirectTrailingSlash = true
+	router.HandleMethodNotAllowed = true
+	router.NoMethod(func(c *gin.Context) {
+		respondError(c, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	})
+	router.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, errorResponse{Error: "not found"})
+	})
+
+	writes := router.Group("")
+	if handler.readOnly != nil {
+		writes.Use(ReadOnlyMiddleware(handler.readOnly, handler.retryAfter))
+	}
+
+	writes.POST("/products", handler.CreateProduct)
+	writes.POST("/products/bulk", handler.CreateProducts)
+	if handler.jobs != nil {
+		router.GET(jobsPath+":id", handler.GetCreateJob)
+	}
 	router.GET("/products", handler.ListProducts)
-	router.DELETE("/products/:id", handler.DeleteProduct)
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/products/suggest", handler.SuggestNames)
+	router.GET("/products/export", handler.ExportProducts)
+	router.GET("/products/slug/:slug", handler.GetProductBySlug)
+	router.GET("/products/:id", handler.GetProduct)
+	router.GET("/products/:id/position", handler.GetProductPosition)
+	writes.DELETE("/products/:id", handler.DeleteProduct)
+	if !handler.publicIDs {
+		writes.DELETE("/products/bulk", handler.DeleteProducts)
+	}
+	writes.PUT("/products/:id/attributes", handler.UpdateAttributes)
+	writes.POST("/products/:id/publish", handler.PublishProduct)
+	writes.POST("/products/:id/archive", handler.ArchiveProduct)
+	writes.POST("/products/:id/reserve", handler.ReserveProduct)
+	writes.POST("/products/:id/release", handler.ReleaseProduct)
+	if adminToken != "" {
+		writes.POST("/products/:id/replay", AdminAuthMiddleware(adminToken), handler.ReplayProduct)
+		if handler.flusher != nil {
+			router.POST("/internal/flush", AdminAuthMiddleware(adminToken), handler.FlushPublisher)
+		}
+		if handler.info != nil {
+			router.GET("/internal/info", AdminAuthMiddleware(adminToken), handler.GetInfo)
+		}
+	}
+	if !handler.noMetrics {
+		// OpenMetrics is negotiated when the scraper asks for it, since the
+		// classic text format cannot carry the latency exemplars.
+		router.GET("/metrics", gin.WrapH(promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+		)))
+	}
 	router.GET("/healthz", func(c *gin.Context) {
 		if err := checker.Health(); err != nil {
+			handler.retryAfter.setRetryAfter(c, reasonUnavailable)
 			c.JSON(http.StatusServiceUnavailable, gin.H{"status": healthStatusUnhealthy})
 			return
 		}
+		if handler.readOnly != nil && handler.readOnly.ReadOnly() {
+			c.JSON(http.StatusOK, gin.H{"status": healthStatusDegraded})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"status": healthStatusOK})
 	})
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
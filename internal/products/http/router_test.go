@@ -0,0 +1,292 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubChecker struct{}
+
+func (stubChecker) Health() error { return nil }
+
+func TestRegisterRoutes_UnmatchedRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		wantStatus   int
+		wantLocation string
+		wantAllow    string
+	}{
+		{
+			name:         "trailing slash redirects",
+			method:       http.MethodGet,
+			url:          "/products/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/products",
+		},
+		{
+			name:       "unsupported method on a known path",
+			method:     http.MethodPatch,
+			url:        "/healthz",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  http.MethodGet,
+		},
+		{
+			name:       "unknown path",
+			method:     http.MethodGet,
+			url:        "/nope",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			svc := &stubService{
+				listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+					return nil, 0, nil
+				},
+			}
+			RegisterRoutes(r, NewHandler(svc), stubChecker{}, "")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, http.NoBody))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Fatalf("want Location %q, got %q", tt.wantLocation, got)
+			}
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Fatalf("want Allow %q, got %q", tt.wantAllow, got)
+			}
+			if tt.wantStatus >= http.StatusBadRequest {
+				var resp errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+					t.Fatalf("want JSON error body, got %q", w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+type switchableReadOnly struct {
+	on bool
+}
+
+func (s *switchableReadOnly) ReadOnly() bool { return s.on }
+
+func TestRegisterRoutes_ReadOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	svc := &stubService{
+		createFn: func(_ context.Context, in products.CreateInput) (products.Product, error) {
+			return products.Product{ID: 1, Name: in.Name}, nil
+		},
+		listFn: func(context.Context, products.ListOptions, int, int) ([]products.Product, int64, error) {
+			return nil, 0, nil
+		},
+	}
+	mode := &switchableReadOnly{on: true}
+	RegisterRoutes(r, NewHandler(svc, WithReadOnly(mode)), stubChecker{}, "")
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	healthStatus := func() string {
+		w := serve(http.MethodGet, "/healthz", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("want /healthz 200, got %d", w.Code)
+		}
+		var resp map[string]string
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp["status"]
+	}
+
+	for _, req := range []struct{ method, url string }{
+		{http.MethodPost, "/products"},
+		{http.MethodPost, "/products/bulk"},
+		{http.MethodDelete, "/products/1"},
+		{http.MethodPut, "/products/1/attributes"},
+	} {
+		w := serve(req.method, req.url, `{"name":"iPhone 16"}`)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s %s: want 503 with Retry-After while read-only, got %d", req.method, req.url, w.Code)
+		}
+	}
+	if w := serve(http.MethodGet, "/products", ""); w.Code != http.StatusOK {
+		t.Fatalf("want reads served while read-only, got %d", w.Code)
+	}
+	if got := healthStatus(); got != healthStatusDegraded {
+		t.Fatalf("want health %q while read-only, got %q", healthStatusDegraded, got)
+	}
+
+	mode.on = false
+	if w := serve(http.MethodPost, "/products", `{"name":"iPhone 16"}`); w.Code != http.StatusCreated {
+		t.Fatalf("want writes accepted after leaving read-only, got %d", w.Code)
+	}
+	if got := healthStatus(); got != healthStatusOK {
+		t.Fatalf("want health %q after leaving read-only, got %q", healthStatusOK, got)
+	}
+}
+
+type stubFlusher struct {
+	flushed, failed int
+	err             error
+}
+
+func (f stubFlusher) Flush(context.Context) (int, int, error) { return f.flushed, f.failed, f.err }
+
+func TestRegisterRoutes_FlushPublisher(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		flusher    stubFlusher
+		auth       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "unregistered without an admin token", flusher: stubFlusher{flushed: 2}, wantStatus: http.StatusNotFound},
+		{name: "requires the admin token", adminToken: testAdminToken, wantStatus: http.StatusUnauthorized},
+		{
+			name:       "reports flushed and failed events",
+			adminToken: testAdminToken,
+			flusher:    stubFlusher{flushed: 2, failed: 1},
+			auth:       "Bearer " + testAdminToken,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"flushed":2,"failed":1}`,
+		},
+		{
+			name:       "publisher closed",
+			adminToken: testAdminToken,
+			flusher:    stubFlusher{err: errors.New("publisher closed")},
+			auth:       "Bearer " + testAdminToken,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			RegisterRoutes(r, NewHandler(&stubService{}, WithPublisherFlush(tt.flusher)), stubChecker{}, tt.adminToken)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/internal/flush", http.NoBody)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Fatalf("want body %s, got %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRegisterRoutes_Metrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantStatus int
+	}{
+		{name: "enabled", wantStatus: http.StatusOK},
+		{name: "disabled", opts: []Option{WithoutMetrics()}, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			RegisterRoutes(r, NewHandler(&stubService{}, tt.opts...), stubChecker{}, "")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want /metrics status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+			if w.Code != http.StatusOK {
+				t.Fatalf("want the service healthy either way, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRegisterRoutes_Info(t *testing.T) {
+	config := map[string]string{"ADMIN_TOKEN": "[redacted]", "HTTP_ADDR": ":8080"}
+	tests := []struct {
+		name       string
+		adminToken string
+		auth       string
+		wantStatus int
+	}{
+		{name: "unregistered without an admin token", wantStatus: http.StatusNotFound},
+		{name: "requires the admin token", adminToken: testAdminToken, wantStatus: http.StatusUnauthorized},
+		{name: "reports runtime info", adminToken: testAdminToken, auth: "Bearer " + testAdminToken, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			RegisterRoutes(r, NewHandler(&stubService{}, WithInfo(config)), stubChecker{}, tt.adminToken)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/internal/info", http.NoBody)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			for _, key := range []string{"go_version", "version", "uptime_seconds", "goroutines", "gc", "config"} {
+				if _, ok := body[key]; !ok {
+					t.Fatalf("want %q in the response, got %s", key, w.Body.String())
+				}
+			}
+			var got infoResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.Goroutines == 0 || !strings.HasPrefix(got.GoVersion, "go") {
+				t.Fatalf("want live runtime figures, got %+v", got)
+			}
+			if got.Config["ADMIN_TOKEN"] != "[redacted]" || got.Config["HTTP_ADDR"] != ":8080" {
+				t.Fatalf("want the config summary as given, got %v", got.Config)
+			}
+		})
+	}
+}
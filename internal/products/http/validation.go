@@ -0,0 +1,125 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"product-notifications/internal/products"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// productNameTag rejects names that are blank after trimming or
+	// contain control characters. Length is left to the min/max tags.
+	productNameTag = "productname"
+	// notBlankTag rejects strings that are empty after trimming, reported
+	// the same as a missing field.
+	notBlankTag = "notblank"
+)
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+)
+
+// RegisterValidators installs the custom binding rules on gin's validator
+// and makes field errors use JSON field names. It only does the work once.
+func RegisterValidators() error {
+	registerOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			registerErr = errors.New("gin validator is not go-playground/validator")
+			return
+		}
+		v.RegisterTagNameFunc(jsonFieldName)
+		if registerErr = v.RegisterValidation(notBlankTag, notBlank); registerErr != nil {
+			return
+		}
+		registerErr = v.RegisterValidation(productNameTag, validProductName)
+	})
+	return registerErr
+}
+
+func validProductName(fl validator.FieldLevel) bool {
+	name := strings.TrimSpace(fl.Field().String())
+	return name != "" && !strings.ContainsFunc(name, unicode.IsControl)
+}
+
+func notBlank(fl validator.FieldLevel) bool {
+	return strings.TrimSpace(fl.Field().String()) != ""
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// bindErrorResponse turns a ShouldBindJSON error into a 400 body, listing
+// each failed field by its JSON path when the body parsed but broke a rule.
+// A body whose only fault is a missing, null or blank top-level name gets
+// nameRequiredResponse, the answer the service's ErrInvalidName gets too.
+func bindErrorResponse(err error) errorResponse {
+	resp := errorResponse{Error: "invalid request body"}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return resp
+	}
+	if len(verrs) == 1 && isMissingName(verrs[0]) {
+		return nameRequiredResponse()
+	}
+
+	resp.Fields = make(map[string]string, len(verrs))
+	for _, fe := range verrs {
+		// Namespace is "<struct>.<json path>"; clients only know the path.
+		_, path, _ := strings.Cut(fe.Namespace(), ".")
+		resp.Fields[path] = fieldErrorMessage(fe)
+	}
+	return resp
+}
+
+func isMissingName(fe validator.FieldError) bool {
+	_, path, _ := strings.Cut(fe.Namespace(), ".")
+	return path == "name" && (fe.Tag() == "required" || fe.Tag() == notBlankTag)
+}
+
+// nameRequiredResponse is the 400 for a product name that is missing, null,
+// empty or only whitespace, whether binding or the service caught it.
+func nameRequiredResponse() errorResponse {
+	resp := errorFor(products.ErrInvalidName)
+	resp.Fields = map[string]string{"name": "is required"}
+	resp.Code = codeNameRequired
+	return resp
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", notBlankTag:
+		return "is required"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must have at least %s items", fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must have at most %s items", fe.Param())
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case productNameTag:
+		return "must not be blank or contain control characters"
+	default:
+		return fmt.Sprintf("failed %q validation", fe.Tag())
+	}
+}
@@ -0,0 +1,192 @@
+// Package jobs runs product creates in the background for the async create
+// mode and tracks their outcome.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/google/uuid"
+)
+
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	defaultWorkers   = 4
+	defaultRetention = 10 * time.Minute
+	createTimeout    = 30 * time.Second
+)
+
+var (
+	ErrQueueFull   = errors.New("create queue full")
+	ErrQueueClosed = errors.New("create queue closed")
+)
+
+// Job is the state of one queued create.
+type Job struct {
+	ID        string `json:"id" example:"9b2f6c1e-3f4a-4b8e-9d3c-2a1b0c9d8e7f"`
+	Status    string `json:"status" example:"done"`
+	ProductID int64  `json:"product_id,omitempty" example:"1"`
+	// ProductPublicID is the created product's public id.
+	ProductPublicID string `json:"product_public_id,omitempty" example:"0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"`
+	// Error is what a client is told about a failed create. The queue
+	// leaves it empty; callers fill it in from Err, which may carry
+	// internal details.
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+
+	finishedAt time.Time
+}
+
+type CreateFunc func(ctx context.Context, in products.CreateInput) (products.Product, error)
+
+type Config struct {
+	// QueueSize is how many creates may wait for a worker; Submit fails
+	// with ErrQueueFull beyond that.
+	QueueSize int
+	Workers   int
+	// Retention is how long finished jobs stay queryable.
+	Retention time.Duration
+}
+
+type Queue struct {
+	create CreateFunc
+	cfg    Config
+	logger *slog.Logger
+	now    func() time.Time
+
+	// mu guards jobs, finished, and closing pending against concurrent
+	// sends.
+	mu     sync.RWMutex
+	closed bool
+	jobs   map[string]*Job
+	// finished lists finished job ids oldest first, for pruning.
+	finished []string
+	pending  chan queued
+	wg       sync.WaitGroup
+}
+
+type queued struct {
+	id string
+	// ctx carries the submitting request's values, such as its actor and
+	// request id, but not its cancellation.
+	ctx context.Context
+	in  products.CreateInput
+}
+
+func NewQueue(create CreateFunc, cfg Config, logger *slog.Logger) *Queue {
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = defaultRetention
+	}
+
+	q := &Queue{
+		create:  create,
+		cfg:     cfg,
+		logger:  logger,
+		now:     time.Now,
+		jobs:    make(map[string]*Job),
+		pending: make(chan queued, cfg.QueueSize),
+	}
+	q.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues a create and returns its pending job without waiting. The
+// create runs with ctx's values, so it is audited for the request that
+// submitted it, but it outlives the request.
+func (q *Queue) Submit(ctx context.Context, in products.CreateInput) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return Job{}, ErrQueueClosed
+	}
+	q.prune()
+
+	job := &Job{ID: uuid.NewString(), Status: StatusPending}
+	select {
+	case q.pending <- queued{id: job.ID, ctx: context.WithoutCancel(ctx), in: in}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	return *job, nil
+}
+
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for item := range q.pending {
+		ctx, cancel := context.WithTimeout(item.ctx, createTimeout)
+		product, err := q.create(ctx, item.in)
+		cancel()
+
+		q.mu.Lock()
+		job := q.jobs[item.id]
+		job.finishedAt = q.now()
+		q.finished = append(q.finished, item.id)
+		if err != nil {
+			job.Status = StatusFailed
+			job.Err = err
+		} else {
+			job.Status = StatusDone
+			job.ProductID = product.ID
+			job.ProductPublicID = product.PublicID
+		}
+		q.mu.Unlock()
+
+		if err != nil {
+			q.logger.Error("async create failed", "job_id", item.id, "error", err)
+		}
+	}
+}
+
+// prune drops finished jobs older than the retention period. Callers hold
+// mu.
+func (q *Queue) prune() {
+	cutoff := q.now().Add(-q.cfg.Retention)
+	n := 0
+	for n < len(q.finished) && q.jobs[q.finished[n]].finishedAt.Before(cutoff) {
+		delete(q.jobs, q.finished[n])
+		n++
+	}
+	q.finished = q.finished[n:]
+}
+
+// Close stops accepting creates and waits for the queued ones to finish.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	return nil
+}
@@ -0,0 +1,162 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+func newTestQueue(create CreateFunc, cfg Config) *Queue {
+	return NewQueue(create, cfg, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+func TestQueue_Lifecycle(t *testing.T) {
+	tests := []struct {
+		name          string
+		createErr     error
+		wantStatus    string
+		wantProductID int64
+		wantError     string
+	}{
+		{
+			name:          "done",
+			wantStatus:    StatusDone,
+			wantProductID: 42,
+		},
+		{
+			name:       "failed",
+			createErr:  errors.New("repo create: db down"),
+			wantStatus: StatusFailed,
+			wantError:  "repo create: db down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := make(chan struct{})
+			q := newTestQueue(func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+				<-gate
+				if tt.createErr != nil {
+					return products.Product{}, tt.createErr
+				}
+				return products.Product{ID: 42}, nil
+			}, Config{QueueSize: 1, Workers: 1})
+
+			job, err := q.Submit(context.Background(), products.CreateInput{Name: "Laptop"})
+			if err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+			if got, _ := q.Get(job.ID); got.Status != StatusPending {
+				t.Fatalf("want %q before the worker finishes, got %q", StatusPending, got.Status)
+			}
+
+			close(gate)
+			_ = q.Close()
+
+			got, ok := q.Get(job.ID)
+			if !ok {
+				t.Fatal("job not found after finishing")
+			}
+			if got.Status != tt.wantStatus || got.ProductID != tt.wantProductID || errString(got.Err) != tt.wantError {
+				t.Fatalf("want status %q, product %d, error %q; got %+v",
+					tt.wantStatus, tt.wantProductID, tt.wantError, got)
+			}
+		})
+	}
+}
+
+func TestQueue_CarriesRequestContext(t *testing.T) {
+	type seen struct{ actor, requestID string }
+	got := make(chan seen, 1)
+	q := newTestQueue(func(ctx context.Context, _ products.CreateInput) (products.Product, error) {
+		got <- seen{products.Actor(ctx), products.RequestID(ctx)}
+		return products.Product{ID: 1}, ctx.Err()
+	}, Config{QueueSize: 1, Workers: 1})
+
+	ctx, cancel := context.WithCancel(products.WithRequestID(products.WithActor(context.Background(), "alice"), "req-1"))
+	// The request is over before the create runs.
+	cancel()
+	job, err := q.Submit(ctx, products.CreateInput{Name: "Laptop"})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	_ = q.Close()
+
+	if s := <-got; s.actor != "alice" || s.requestID != "req-1" {
+		t.Fatalf("want the create to run as alice for req-1, got %+v", s)
+	}
+	if done, _ := q.Get(job.ID); done.Status != StatusDone {
+		t.Fatalf("want the create to outlive the request, got %+v", done)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	gate := make(chan struct{})
+	started := make(chan struct{}, 1)
+	q := newTestQueue(func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+		started <- struct{}{}
+		<-gate
+		return products.Product{ID: 1}, nil
+	}, Config{QueueSize: 1, Workers: 1})
+	defer func() {
+		close(gate)
+		_ = q.Close()
+	}()
+
+	_, _ = q.Submit(context.Background(), products.CreateInput{Name: "a"})
+	<-started
+	if _, err := q.Submit(context.Background(), products.CreateInput{Name: "b"}); err != nil {
+		t.Fatalf("second create should fit in the queue: %v", err)
+	}
+	if _, err := q.Submit(context.Background(), products.CreateInput{Name: "c"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("want ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueue_PrunesFinishedJobs(t *testing.T) {
+	q := newTestQueue(func(_ context.Context, _ products.CreateInput) (products.Product, error) {
+		return products.Product{ID: 1}, nil
+	}, Config{QueueSize: 2, Workers: 1, Retention: time.Minute})
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	old, _ := q.Submit(context.Background(), products.CreateInput{Name: "old"})
+	waitForStatus(t, q, old.ID, StatusDone)
+
+	now = now.Add(2 * time.Minute)
+	fresh, _ := q.Submit(context.Background(), products.CreateInput{Name: "fresh"})
+	_ = q.Close()
+
+	if _, ok := q.Get(old.ID); ok {
+		t.Fatal("want job finished before the retention period pruned")
+	}
+	if _, ok := q.Get(fresh.ID); !ok {
+		t.Fatal("want recent job kept")
+	}
+}
+
+func waitForStatus(t *testing.T, q *Queue, id, status string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if job, _ := q.Get(id); job.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s never reached %q", id, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
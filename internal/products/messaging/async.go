@@ -0,0 +1,388 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	OverflowBlock = "block"
+	OverflowDrop  = "drop"
+	OverflowSpill = "spill"
+)
+
+const (
+	defaultAsyncRetries = 3
+	defaultAsyncBackoff = 200 * time.Millisecond
+	asyncPublishTimeout = 5 * time.Second
+	spillReplayBatch    = 100
+)
+
+// spillRetryInterval is how long the worker waits before replaying the
+// spill again after the broker rejected a spilled event.
+var spillRetryInterval = 5 * time.Second
+
+var (
+	ErrBufferFull      = errors.New("event buffer full")
+	ErrPublisherClosed = errors.New("publisher closed")
+)
+
+type eventPublisher interface {
+	Publish(ctx context.Context, event products.ProductEvent) error
+	Health(ctx context.Context) error
+}
+
+// heldEventsFlusher is a publisher that holds events back, such as
+// CoalescingPublisher, and publishes them on demand.
+type heldEventsFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+type AsyncConfig struct {
+	// BufferSize is how many events may wait for the background worker.
+	BufferSize int
+	// Overflow decides what Publish does when the buffer is full:
+	// OverflowBlock waits for room (bounded by the caller's context),
+	// OverflowDrop discards the event and returns ErrBufferFull,
+	// OverflowSpill appends it to Spill, which the worker replays once the
+	// buffer has been delivered (dropping like OverflowDrop only when the
+	// spill is full too).
+	Overflow string
+	Spill    *Spill
+	// Retries is how many extra attempts a failed publish gets, with
+	// exponential backoff starting at Backoff. Zero values use defaults.
+	Retries int
+	Backoff time.Duration
+}
+
+// AsyncPublisher takes publishing off the request path: Publish only
+// enqueues, and a single background worker hands events to the wrapped
+// publisher, retrying transient failures.
+type AsyncPublisher struct {
+	next    eventPublisher
+	cfg     AsyncConfig
+	logger  *slog.Logger
+	dropped prometheus.Counter
+
+	// mu guards closing events against concurrent sends.
+	mu      sync.RWMutex
+	closed  bool
+	events  chan products.ProductEvent
+	flushes chan chan flushResult
+	done    chan struct{}
+	// spilled wakes the worker to replay the spill.
+	spilled chan struct{}
+}
+
+type flushResult struct {
+	flushed, failed int
+	err             error
+}
+
+func NewAsyncPublisher(next eventPublisher, cfg AsyncConfig, logger *slog.Logger, dropped prometheus.Counter) *AsyncPublisher {
+	if cfg.Overflow == "" {
+		cfg.Overflow = OverflowBlock
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = defaultAsyncRetries
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultAsyncBackoff
+	}
+
+	p := &AsyncPublisher{
+		next:    next,
+		cfg:     cfg,
+		logger:  logger,
+		dropped: dropped,
+		events:  make(chan products.ProductEvent, cfg.BufferSize),
+		flushes: make(chan chan flushResult, 1),
+		done:    make(chan struct{}),
+		spilled: make(chan struct{}, 1),
+	}
+	if cfg.Spill != nil && cfg.Spill.Len() > 0 {
+		// Left over from a previous run.
+		p.signalSpill()
+	}
+	go p.run()
+	return p
+}
+
+func (p *AsyncPublisher) Publish(ctx context.Context, event products.ProductEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	if p.cfg.Overflow == OverflowSpill {
+		return p.enqueueOrSpill(event)
+	}
+
+	if p.cfg.Overflow == OverflowDrop {
+		select {
+		case p.events <- event:
+			return nil
+		default:
+			p.dropped.Inc()
+			return ErrBufferFull
+		}
+	}
+
+	select {
+	case p.events <- event:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("enqueue event: %w", ctx.Err())
+	}
+}
+
+// enqueueOrSpill buffers event in memory unless the buffer is full or
+// earlier events are already waiting in the spill, in which case it goes to
+// the spill so events are still published in order.
+func (p *AsyncPublisher) enqueueOrSpill(event products.ProductEvent) error {
+	spill := p.cfg.Spill
+	spill.mu.Lock()
+	defer spill.mu.Unlock()
+
+	if spill.count == 0 {
+		select {
+		case p.events <- event:
+			return nil
+		default:
+		}
+	}
+
+	if err := spill.pushLocked(event); err != nil {
+		p.dropped.Inc()
+		return fmt.Errorf("%w: %w", ErrBufferFull, err)
+	}
+	p.signalSpill()
+	return nil
+}
+
+func (p *AsyncPublisher) signalSpill() {
+	select {
+	case p.spilled <- struct{}{}:
+	default:
+	}
+}
+
+func (p *AsyncPublisher) run() {
+	defer close(p.done)
+	for {
+		// A waiting flush goes first, so it drains the buffer as it was
+		// when requested rather than racing the loop for events.
+		select {
+		case reply := <-p.flushes:
+			reply <- p.flush()
+			continue
+		default:
+		}
+
+		select {
+		case reply := <-p.flushes:
+			reply <- p.flush()
+		case event, ok := <-p.events:
+			if !ok {
+				return
+			}
+			p.deliver(event)
+		case <-p.spilled:
+			p.replaySpill()
+		}
+	}
+}
+
+// replaySpill publishes the spill oldest first, after the events buffered
+// in memory, which all predate it.
+func (p *AsyncPublisher) replaySpill() {
+	p.drain()
+	p.drainSpill()
+}
+
+// drainSpill publishes the spill oldest first, counting the outcomes. A
+// spilled event that fails all its retries is counted as failed but stays
+// in the spill, and the replay is tried again after spillRetryInterval.
+func (p *AsyncPublisher) drainSpill() flushResult {
+	var res flushResult
+	spill := p.cfg.Spill
+	if spill == nil {
+		return res
+	}
+	for {
+		events, err := spill.peek(spillReplayBatch)
+		if err != nil {
+			p.logger.Error("read event spill failed", "error", err)
+			p.retrySpillLater()
+			return res
+		}
+		if len(events) == 0 {
+			return res
+		}
+
+		published := 0
+		for _, event := range events {
+			if err := p.publishWithRetry(event); err != nil {
+				p.logger.Warn("replay spilled event failed, retrying later",
+					"event_type", event.EventType,
+					"product_id", event.ProductID,
+					"spilled", spill.Len(),
+					"error", err,
+				)
+				res.failed++
+				break
+			}
+			published++
+		}
+		res.flushed += published
+
+		if err := spill.drop(published); err != nil {
+			p.logger.Error("trim event spill failed", "error", err)
+			p.retrySpillLater()
+			return res
+		}
+		if published < len(events) {
+			p.retrySpillLater()
+			return res
+		}
+	}
+}
+
+func (p *AsyncPublisher) retrySpillLater() {
+	time.AfterFunc(spillRetryInterval, p.signalSpill)
+}
+
+// flush delivers the buffer, then the spill, and then has next publish
+// whatever it holds back, such as a pending coalesced batch.
+func (p *AsyncPublisher) flush() flushResult {
+	res := p.drain()
+	spilled := p.drainSpill()
+	res.flushed += spilled.flushed
+	res.failed += spilled.failed
+
+	if held, ok := p.next.(heldEventsFlusher); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), asyncPublishTimeout)
+		defer cancel()
+		if err := held.Flush(ctx); err != nil {
+			res.err = fmt.Errorf("flush held events: %w", err)
+		}
+	}
+	return res
+}
+
+// drain delivers every event buffered right now, counting the outcomes.
+func (p *AsyncPublisher) drain() flushResult {
+	var res flushResult
+	for {
+		select {
+		case event, ok := <-p.events:
+			if !ok {
+				return res
+			}
+			if p.deliver(event) {
+				res.flushed++
+			} else {
+				res.failed++
+			}
+		default:
+			return res
+		}
+	}
+}
+
+func (p *AsyncPublisher) deliver(event products.ProductEvent) bool {
+	err := p.publishWithRetry(event)
+	if err != nil {
+		p.logger.Error("async publish failed",
+			"event_type", event.EventType,
+			"product_id", event.ProductID,
+			"error", err,
+		)
+	}
+	return err == nil
+}
+
+// Flush has the worker deliver every buffered event, then every spilled
+// one, before it takes on anything else, and reports how many were
+// published and how many failed all their retries. Failed buffered events
+// are dropped; a failed spilled event stays in the spill and ends its
+// replay. Last, a wrapped publisher that holds events back, such as a
+// CoalescingPublisher, is flushed too, and its failure is returned. An
+// event the worker was already publishing when Flush was called is
+// finished first but not counted.
+func (p *AsyncPublisher) Flush(ctx context.Context) (flushed, failed int, err error) {
+	reply := make(chan flushResult, 1)
+	select {
+	case p.flushes <- reply:
+	case <-p.done:
+		return 0, 0, ErrPublisherClosed
+	case <-ctx.Done():
+		return 0, 0, fmt.Errorf("request flush: %w", ctx.Err())
+	}
+
+	select {
+	case res := <-reply:
+		return res.flushed, res.failed, res.err
+	case <-p.done:
+		return 0, 0, ErrPublisherClosed
+	case <-ctx.Done():
+		return 0, 0, fmt.Errorf("wait for flush: %w", ctx.Err())
+	}
+}
+
+func (p *AsyncPublisher) publishWithRetry(event products.ProductEvent) error {
+	backoff := p.cfg.Backoff
+	var err error
+	for attempt := 0; attempt <= p.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), asyncPublishTimeout)
+		err = p.next.Publish(ctx, event)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Health reports ErrPublisherClosed after Close, and otherwise the health of
+// the wrapped publisher.
+func (p *AsyncPublisher) Health(ctx context.Context) error {
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+
+	if closed {
+		return ErrPublisherClosed
+	}
+	return p.next.Health(ctx)
+}
+
+// Close stops accepting events and waits until everything already buffered
+// has been handed to the wrapped publisher. Events still in the spill stay
+// there for the next run.
+func (p *AsyncPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+	return nil
+}
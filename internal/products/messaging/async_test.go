@@ -0,0 +1,370 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	events   []products.ProductEvent
+	failures int
+	gate     chan struct{}
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, event products.ProductEvent) error {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("broker blip")
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingPublisher) Health(context.Context) error {
+	return nil
+}
+
+func (r *recordingPublisher) published() []products.ProductEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]products.ProductEvent(nil), r.events...)
+}
+
+func newTestAsync(next eventPublisher, cfg AsyncConfig) (*AsyncPublisher, prometheus.Counter) {
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_dropped", Help: "t"})
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	cfg.Backoff = time.Millisecond
+	return NewAsyncPublisher(next, cfg, logger, dropped), dropped
+}
+
+func TestAsyncPublisher_DeliversAndRetries(t *testing.T) {
+	next := &recordingPublisher{failures: 2}
+	pub, _ := newTestAsync(next, AsyncConfig{BufferSize: 4})
+
+	for id := int64(1); id <= 3; id++ {
+		if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: id}); err != nil {
+			t.Fatalf("enqueue %d: %v", id, err)
+		}
+	}
+	_ = pub.Close()
+
+	got := next.published()
+	if len(got) != 3 {
+		t.Fatalf("want 3 events delivered after retries, got %d", len(got))
+	}
+	for i, event := range got {
+		if event.ProductID != int64(i+1) {
+			t.Fatalf("want events in enqueue order, got %v", got)
+		}
+	}
+}
+
+func TestAsyncPublisher_BufferFull(t *testing.T) {
+	tests := []struct {
+		name        string
+		overflow    string
+		wantErr     error
+		wantDropped float64
+	}{
+		{
+			name:        "drop mode discards and counts",
+			overflow:    OverflowDrop,
+			wantErr:     ErrBufferFull,
+			wantDropped: 1,
+		},
+		{
+			name:     "block mode waits until the caller gives up",
+			overflow: OverflowBlock,
+			wantErr:  context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The gate holds the worker on the first event so the buffer
+			// (size 1) fills with the second.
+			next := &recordingPublisher{gate: make(chan struct{})}
+			pub, dropped := newTestAsync(next, AsyncConfig{BufferSize: 1, Overflow: tt.overflow})
+
+			_ = pub.Publish(context.Background(), products.ProductEvent{ProductID: 1})
+			waitForWorker(t, pub)
+			if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: 2}); err != nil {
+				t.Fatalf("second event should fit in the buffer: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := pub.Publish(ctx, products.ProductEvent{ProductID: 3})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if got := testutil.ToFloat64(dropped); got != tt.wantDropped {
+				t.Fatalf("want %v dropped, got %v", tt.wantDropped, got)
+			}
+
+			close(next.gate)
+			_ = pub.Close()
+			if got := len(next.published()); got != 2 {
+				t.Fatalf("want the 2 accepted events delivered, got %d", got)
+			}
+		})
+	}
+}
+
+func TestAsyncPublisher_SpillsDuringOutage(t *testing.T) {
+	spill, err := OpenSpill(filepath.Join(t.TempDir(), "events.spill"), 3)
+	if err != nil {
+		t.Fatalf("open spill: %v", err)
+	}
+	// The gate is a broker outage: the worker hangs on event 1, event 2
+	// fills the buffer and the rest spill until the spill is full.
+	next := &recordingPublisher{gate: make(chan struct{})}
+	pub, dropped := newTestAsync(next, AsyncConfig{BufferSize: 1, Overflow: OverflowSpill, Spill: spill})
+
+	for id := int64(1); id <= 5; id++ {
+		if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: id}); err != nil {
+			t.Fatalf("enqueue %d: %v", id, err)
+		}
+		if id == 1 {
+			waitForWorker(t, pub)
+		}
+	}
+	if got := spill.Len(); got != 3 {
+		t.Fatalf("want 3 events spilled, got %d", got)
+	}
+	if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: 6}); !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("want ErrBufferFull once the spill is full, got %v", err)
+	}
+	if got := testutil.ToFloat64(dropped); got != 1 {
+		t.Fatalf("want 1 dropped, got %v", got)
+	}
+
+	close(next.gate)
+	waitForPublished(t, next, 5)
+	_ = pub.Close()
+
+	for i, event := range next.published() {
+		if event.ProductID != int64(i+1) {
+			t.Fatalf("want events published in order, got %v", next.published())
+		}
+	}
+	if got := spill.Len(); got != 0 {
+		t.Fatalf("want an empty spill after recovery, got %d events", got)
+	}
+}
+
+func TestAsyncPublisher_ReplaysSpillFromPreviousRun(t *testing.T) {
+	defer func(interval time.Duration) { spillRetryInterval = interval }(spillRetryInterval)
+	spillRetryInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "events.spill")
+	previous, err := OpenSpill(path, 10)
+	if err != nil {
+		t.Fatalf("open spill: %v", err)
+	}
+	spillEvents(t, previous, 1, 2)
+
+	spill, err := OpenSpill(path, 10)
+	if err != nil {
+		t.Fatalf("reopen spill: %v", err)
+	}
+	if got := spill.Len(); got != 2 {
+		t.Fatalf("want 2 events left from the previous run, got %d", got)
+	}
+	// The broker is still down for all of event 1's first replay attempts.
+	next := &recordingPublisher{failures: defaultAsyncRetries + 1}
+	pub, _ := newTestAsync(next, AsyncConfig{BufferSize: 1, Overflow: OverflowSpill, Spill: spill})
+	defer pub.Close()
+
+	waitForPublished(t, next, 2)
+	if got := next.published(); got[0].ProductID != 1 || got[1].ProductID != 2 {
+		t.Fatalf("want spilled events replayed in order, got %v", got)
+	}
+}
+
+func TestAsyncPublisher_RejectsAfterClose(t *testing.T) {
+	pub, _ := newTestAsync(&recordingPublisher{}, AsyncConfig{BufferSize: 1})
+	_ = pub.Close()
+
+	if err := pub.Publish(context.Background(), products.ProductEvent{}); !errors.Is(err, ErrPublisherClosed) {
+		t.Fatalf("want ErrPublisherClosed, got %v", err)
+	}
+	if err := pub.Health(context.Background()); !errors.Is(err, ErrPublisherClosed) {
+		t.Fatalf("want ErrPublisherClosed from Health, got %v", err)
+	}
+}
+
+func TestAsyncPublisher_Flush(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		wantFlushed int
+		wantFailed  int
+	}{
+		{name: "drains the buffer", wantFlushed: 2},
+		// Every event, the in-flight one included, exhausts its 1+3
+		// attempts.
+		{name: "reports failures", failures: 12, wantFailed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The gate holds the worker on event 1 while events 2 and 3
+			// wait in the buffer and the flush is requested.
+			next := &recordingPublisher{gate: make(chan struct{}), failures: tt.failures}
+			pub, _ := newTestAsync(next, AsyncConfig{BufferSize: 2})
+			defer pub.Close()
+
+			for id := int64(1); id <= 3; id++ {
+				if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: id}); err != nil {
+					t.Fatalf("enqueue %d: %v", id, err)
+				}
+				if id == 1 {
+					waitForWorker(t, pub)
+				}
+			}
+
+			type result struct {
+				flushed, failed int
+				err             error
+			}
+			done := make(chan result, 1)
+			go func() {
+				flushed, failed, err := pub.Flush(context.Background())
+				done <- result{flushed, failed, err}
+			}()
+			deadline := time.Now().Add(time.Second)
+			for len(pub.flushes) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("flush was never requested")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			close(next.gate)
+
+			res := <-done
+			if res.err != nil {
+				t.Fatalf("flush: %v", res.err)
+			}
+			if res.flushed != tt.wantFlushed || res.failed != tt.wantFailed {
+				t.Fatalf("want %d flushed and %d failed, got %d and %d", tt.wantFlushed, tt.wantFailed, res.flushed, res.failed)
+			}
+			if len(pub.events) != 0 {
+				t.Fatalf("want an empty buffer after the flush, got %d events", len(pub.events))
+			}
+		})
+	}
+
+	t.Run("closed publisher", func(t *testing.T) {
+		pub, _ := newTestAsync(&recordingPublisher{}, AsyncConfig{BufferSize: 1})
+		_ = pub.Close()
+		if _, _, err := pub.Flush(context.Background()); !errors.Is(err, ErrPublisherClosed) {
+			t.Fatalf("want ErrPublisherClosed, got %v", err)
+		}
+	})
+}
+
+// holdingPublisher counts the flushes of the events it would hold back.
+type holdingPublisher struct {
+	*recordingPublisher
+	flushes atomic.Int32
+}
+
+func (h *holdingPublisher) Flush(context.Context) error {
+	h.flushes.Add(1)
+	return nil
+}
+
+func TestAsyncPublisher_FlushDrainsSpillAndHeldEvents(t *testing.T) {
+	spill, err := OpenSpill(filepath.Join(t.TempDir(), "events.spill"), 10)
+	if err != nil {
+		t.Fatalf("open spill: %v", err)
+	}
+	// The guard sits between the async publisher and the coalescer in
+	// main, so the flush has to pass through it.
+	next := &holdingPublisher{recordingPublisher: &recordingPublisher{gate: make(chan struct{})}}
+	guard := NewReadOnlyGuard(next, ReadOnlyConfig{Threshold: 1}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	pub, _ := newTestAsync(guard, AsyncConfig{BufferSize: 1, Overflow: OverflowSpill, Spill: spill})
+	defer pub.Close()
+
+	// Event 1 holds the worker, 2 fills the buffer and 3 and 4 spill.
+	for id := int64(1); id <= 4; id++ {
+		if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: id}); err != nil {
+			t.Fatalf("enqueue %d: %v", id, err)
+		}
+		if id == 1 {
+			waitForWorker(t, pub)
+		}
+	}
+
+	type result struct {
+		flushed, failed int
+		err             error
+	}
+	done := make(chan result, 1)
+	go func() {
+		flushed, failed, err := pub.Flush(context.Background())
+		done <- result{flushed, failed, err}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(pub.flushes) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("flush was never requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(next.gate)
+
+	res := <-done
+	if res.err != nil || res.flushed != 3 || res.failed != 0 {
+		t.Fatalf("want 3 flushed and 0 failed, got %+v", res)
+	}
+	if n := spill.Len(); n != 0 {
+		t.Fatalf("want an empty spill after the flush, got %d events", n)
+	}
+	if n := next.flushes.Load(); n != 1 {
+		t.Fatalf("want the held events flushed once, got %d", n)
+	}
+}
+
+func waitForPublished(t *testing.T, next *recordingPublisher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(next.published()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d events published, got %d", n, len(next.published()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForWorker blocks until the worker has taken the buffered event.
+func waitForWorker(t *testing.T, pub *AsyncPublisher) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(pub.events) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker never picked up the buffered event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,206 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"product-notifications/internal/products"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultBatchChunkSize is how many events PublishBatch sends per confirm
+// window when PublisherConfig.BatchChunkSize is unset.
+const defaultBatchChunkSize = 100
+
+// PublishBatch publishes events in chunks of BatchChunkSize. With publisher
+// confirms on, each chunk is sent whole and then waited on until the broker
+// has confirmed every message in it, so a large batch never has thousands
+// of messages outstanding at once. It stops at the first chunk with a
+// failure and returns a *products.BatchPublishError naming the events to
+// retry; later chunks are not sent.
+func (p *RabbitPublisher) PublishBatch(ctx context.Context, events []products.ProductEvent) error {
+	p.checkQueueDue(ctx)
+	size := p.cfg.BatchChunkSize
+	for start := 0; start < len(events); start += size {
+		end := min(start+size, len(events))
+		failed, err := p.publishChunk(ctx, events[start:end])
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrUnroutable) {
+			// The caller retries the failed events; by then a deleted
+			// queue is back.
+			p.recheckQueue(ctx)
+		}
+		for i := range failed {
+			failed[i] += start
+		}
+		return &products.BatchPublishError{
+			Chunk:  start / size,
+			Failed: failed,
+			Unsent: end,
+			Total:  len(events),
+			Err:    err,
+		}
+	}
+	return nil
+}
+
+// publishChunk publishes chunk and returns the indexes into it of the
+// events that were not published or not confirmed.
+func (p *RabbitPublisher) publishChunk(ctx context.Context, chunk []products.ProductEvent) ([]int, error) {
+	msgs := make([]amqp.Publishing, len(chunk))
+	for i, event := range chunk {
+		msg, err := p.encode(ctx, event)
+		if err != nil {
+			return indexRange(0, len(chunk)), err
+		}
+		msgs[i] = msg
+	}
+
+	if !p.cfg.Mandatory {
+		for i, msg := range msgs {
+			exchange, key := p.route(chunk[i])
+			if err := p.channel.PublishWithContext(ctx, exchange, key, false, false, msg); err != nil {
+				return indexRange(i, len(chunk)), fmt.Errorf("publish to %q: %w", p.queue, err)
+			}
+		}
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first := p.channel.GetNextPublishSeqNo()
+	sent := len(msgs)
+	var publishErr error
+	for i, msg := range msgs {
+		exchange, key := p.route(chunk[i])
+		if err := p.channel.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+			sent, publishErr = i, fmt.Errorf("publish to %q: %w", p.queue, err)
+			break
+		}
+	}
+
+	failed, err := p.awaitConfirms(ctx, msgs[:sent], first)
+	failed = append(failed, indexRange(sent, len(msgs))...)
+	if publishErr != nil {
+		err = publishErr
+	}
+	return failed, err
+}
+
+// awaitConfirms waits for the confirms of msgs, published with consecutive
+// delivery tags from first, and returns the indexes of those that were
+// returned, nacked or never confirmed.
+func (p *RabbitPublisher) awaitConfirms(ctx context.Context, msgs []amqp.Publishing, first uint64) ([]int, error) {
+	confirmed := make([]bool, len(msgs))
+	acked := make([]bool, len(msgs))
+	returned := make(map[string]bool)
+
+	var err error
+	for pending := len(msgs); pending > 0 && err == nil; {
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("await confirms from %q: %w", p.queue, ctx.Err())
+		case ret, ok := <-p.returns:
+			if !ok {
+				err = ErrChannelClosed
+				continue
+			}
+			returned[ret.MessageId] = true
+		case conf, ok := <-p.confirms:
+			if !ok {
+				err = ErrChannelClosed
+				continue
+			}
+			// Confirms before first are stale ones from a publish whose
+			// caller gave up.
+			if conf.DeliveryTag < first || conf.DeliveryTag-first >= uint64(len(msgs)) {
+				continue
+			}
+			i := conf.DeliveryTag - first
+			if !confirmed[i] {
+				confirmed[i], acked[i] = true, conf.Ack
+				pending--
+			}
+		}
+	}
+
+	// Returns arrive before the acks of the same messages, so any left for
+	// this chunk are already buffered.
+	for drained := false; !drained; {
+		select {
+		case ret, ok := <-p.returns:
+			if !ok {
+				drained = true
+				continue
+			}
+			returned[ret.MessageId] = true
+		default:
+			drained = true
+		}
+	}
+
+	var (
+		failed []int
+		cause  error
+	)
+	for i, msg := range msgs {
+		var reason error
+		switch {
+		case returned[msg.MessageId]:
+			reason = ErrUnroutable
+		case confirmed[i] && !acked[i]:
+			reason = ErrPublishNacked
+		case confirmed[i]:
+			continue
+		}
+		if cause == nil {
+			cause = reason
+		}
+		failed = append(failed, i)
+	}
+	if err == nil && cause != nil {
+		err = fmt.Errorf("publish to %q: %w", p.queue, cause)
+	}
+	return failed, err
+}
+
+// indexRange returns the indexes from start up to, not including, end.
+func indexRange(start, end int) []int {
+	indexes := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// batchPublisher is implemented by the publishers that can publish a batch
+// in one call.
+type batchPublisher interface {
+	PublishBatch(ctx context.Context, events []products.ProductEvent) error
+}
+
+// publishEvents publishes events through next's PublishBatch when it has
+// one, and otherwise one at a time, stopping at the first failure. Either
+// way a failure is a *products.BatchPublishError, so the wrappers that
+// pass batches on report them as the broker publishers do.
+func publishEvents(ctx context.Context, next eventPublisher, events []products.ProductEvent) error {
+	if batch, ok := next.(batchPublisher); ok {
+		return batch.PublishBatch(ctx, events)
+	}
+	for i, event := range events {
+		if err := next.Publish(ctx, event); err != nil {
+			return &products.BatchPublishError{
+				Failed: []int{i},
+				Unsent: i + 1,
+				Total:  len(events),
+				Err:    err,
+			}
+		}
+	}
+	return nil
+}
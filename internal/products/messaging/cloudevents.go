@@ -0,0 +1,63 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	FormatNative      = "native"
+	FormatCloudEvents = "cloudevents"
+
+	// contentTypeCloudEvents marks a CloudEvents structured-mode message:
+	// the whole envelope is the body.
+	contentTypeCloudEvents = "application/cloudevents+json"
+	cloudEventsSpecVersion = "1.0"
+
+	// DefaultEventSource is the CloudEvents source when none is configured.
+	DefaultEventSource = "/products"
+)
+
+// CloudEvent is the CloudEvents 1.0 JSON envelope around a product event.
+type CloudEvent struct {
+	SpecVersion     string                `json:"specversion"`
+	Type            string                `json:"type"`
+	Source          string                `json:"source"`
+	ID              string                `json:"id"`
+	Time            time.Time             `json:"time"`
+	DataContentType string                `json:"datacontenttype"`
+	Data            products.ProductEvent `json:"data"`
+}
+
+func newCloudEvent(event products.ProductEvent, id, source string) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            event.EventType,
+		Source:          source,
+		ID:              id,
+		Time:            event.Timestamp,
+		DataContentType: contentTypeJSON,
+		Data:            event,
+	}
+}
+
+// DecodeEvent parses a decoded message body in either the native format or
+// a CloudEvents envelope, telling them apart by content type.
+func DecodeEvent(contentType string, body []byte) (products.ProductEvent, error) {
+	if contentType == contentTypeCloudEvents {
+		var ce CloudEvent
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return products.ProductEvent{}, fmt.Errorf("unmarshal cloudevent: %w", err)
+		}
+		return ce.Data, nil
+	}
+
+	var event products.ProductEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return products.ProductEvent{}, fmt.Errorf("unmarshal event: %w", err)
+	}
+	return event, nil
+}
@@ -0,0 +1,167 @@
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const defaultCoalesceMaxBatch = 100
+
+type CoalesceConfig struct {
+	// Window is how long the first buffered create waits for others to
+	// join its batch.
+	Window time.Duration
+	// MaxBatch flushes the batch as soon as it holds this many creates.
+	MaxBatch int
+}
+
+// CoalescingPublisher merges product_created events that arrive within
+// Window of each other into one products_created_batch event. Other events
+// are published on their own, after any pending batch so ordering is kept.
+// A batch of one is published as the plain product_created event.
+//
+// Publish of a create returns only once its batch has been handed to next,
+// with next's error, so a create is never reported sent while it is only
+// buffered; each create waits up to Window for that.
+type CoalescingPublisher struct {
+	next   eventPublisher
+	cfg    CoalesceConfig
+	logger *slog.Logger
+
+	// publishMu serialises publishes to next, so a timed flush cannot
+	// overtake an event published after it.
+	publishMu sync.Mutex
+
+	mu      sync.Mutex
+	pending *coalesceBatch
+	timer   *time.Timer
+}
+
+// coalesceBatch is a batch of creates waiting to be flushed. done is closed
+// once it has been, with err set to the outcome.
+type coalesceBatch struct {
+	events []products.ProductEvent
+	done   chan struct{}
+	err    error
+}
+
+func NewCoalescingPublisher(next eventPublisher, cfg CoalesceConfig, logger *slog.Logger) *CoalescingPublisher {
+	if cfg.MaxBatch == 0 {
+		cfg.MaxBatch = defaultCoalesceMaxBatch
+	}
+	return &CoalescingPublisher{next: next, cfg: cfg, logger: logger}
+}
+
+func (p *CoalescingPublisher) Publish(ctx context.Context, event products.ProductEvent) error {
+	if event.EventType != products.EventCreated || event.Replay {
+		p.publishMu.Lock()
+		defer p.publishMu.Unlock()
+		if err := p.flush(ctx, p.take()); err != nil {
+			return err
+		}
+		return p.next.Publish(ctx, event)
+	}
+
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = &coalesceBatch{done: make(chan struct{})}
+		p.timer = time.AfterFunc(p.cfg.Window, p.flushTimed)
+	}
+	batch := p.pending
+	batch.events = append(batch.events, event)
+	full := len(batch.events) >= p.cfg.MaxBatch
+	p.mu.Unlock()
+
+	if full {
+		p.publishMu.Lock()
+		_ = p.flush(ctx, p.take())
+		p.publishMu.Unlock()
+	}
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		// The create may still go out with its batch.
+		return ctx.Err()
+	}
+}
+
+// take empties the pending batch and stops its timer.
+func (p *CoalescingPublisher) take() *coalesceBatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	batch := p.pending
+	p.pending = nil
+	return batch
+}
+
+func (p *CoalescingPublisher) flushTimed() {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), asyncPublishTimeout)
+	defer cancel()
+	if err := p.flush(ctx, p.take()); err != nil {
+		p.logger.Error("publish coalesced events failed", "error", err)
+	}
+}
+
+// flush publishes batch, if there is one, and hands the outcome to the
+// creates waiting on it; callers hold publishMu.
+func (p *CoalescingPublisher) flush(ctx context.Context, batch *coalesceBatch) error {
+	if batch == nil {
+		return nil
+	}
+	if len(batch.events) == 1 {
+		batch.err = p.next.Publish(ctx, batch.events[0])
+	} else {
+		batch.err = p.next.Publish(ctx, products.ProductEvent{
+			EventType: products.EventCreatedBatch,
+			Timestamp: batch.events[0].Timestamp,
+			Products:  batch.events,
+		})
+	}
+	close(batch.done)
+	return batch.err
+}
+
+// Flush publishes the pending batch now instead of at the end of its
+// window.
+func (p *CoalescingPublisher) Flush(ctx context.Context) error {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+	return p.flush(ctx, p.take())
+}
+
+func (p *CoalescingPublisher) Health(ctx context.Context) error {
+	return p.next.Health(ctx)
+}
+
+// Close publishes whatever batch is pending. It does not close next.
+func (p *CoalescingPublisher) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), asyncPublishTimeout)
+	defer cancel()
+	return p.Flush(ctx)
+}
+
+// PublishBatch publishes events after any pending batch, without
+// coalescing them: they already travel together, through next's
+// PublishBatch when it has one. A failure is returned as a
+// *products.BatchPublishError, as next would.
+func (p *CoalescingPublisher) PublishBatch(ctx context.Context, events []products.ProductEvent) error {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+	if err := p.flush(ctx, p.take()); err != nil {
+		return err
+	}
+	return publishEvents(ctx, p.next, events)
+}
@@ -0,0 +1,153 @@
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+func newTestCoalescer(next eventPublisher, cfg CoalesceConfig) *CoalescingPublisher {
+	return NewCoalescingPublisher(next, cfg, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+func created(id int64) products.ProductEvent {
+	return products.ProductEvent{EventType: products.EventCreated, ProductID: id}
+}
+
+// publishAsync publishes event from a goroutine; the returned channel
+// receives Publish's result.
+func publishAsync(pub *CoalescingPublisher, event products.ProductEvent) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- pub.Publish(context.Background(), event) }()
+	return done
+}
+
+// waitPending blocks until n creates are waiting in pub's batch.
+func waitPending(t *testing.T, pub *CoalescingPublisher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		pub.mu.Lock()
+		got := 0
+		if pub.pending != nil {
+			got = len(pub.pending.events)
+		}
+		pub.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d creates pending, got %d", n, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescingPublisher_FlushesOnWindow(t *testing.T) {
+	next := &recordingPublisher{}
+	pub := newTestCoalescer(next, CoalesceConfig{Window: 200 * time.Millisecond, MaxBatch: 10})
+
+	var results []<-chan error
+	for id := int64(1); id <= 3; id++ {
+		results = append(results, publishAsync(pub, created(id)))
+	}
+	waitPending(t, pub, 3)
+	if got := next.published(); len(got) != 0 {
+		t.Fatalf("want creates held until the window ends, got %v", got)
+	}
+
+	for i, done := range results {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("publish %d: %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("publish did not return after the window")
+		}
+	}
+
+	got := next.published()
+	if len(got) != 1 || got[0].EventType != products.EventCreatedBatch || len(got[0].Products) != 3 {
+		t.Fatalf("want one batch of 3, got %+v", got)
+	}
+	var ids []int
+	for _, event := range got[0].Products {
+		ids = append(ids, int(event.ProductID))
+	}
+	sort.Ints(ids)
+	if ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Fatalf("want products 1-3 in the batch, got %v", ids)
+	}
+}
+
+func TestCoalescingPublisher_FlushesOnSize(t *testing.T) {
+	next := &recordingPublisher{}
+	pub := newTestCoalescer(next, CoalesceConfig{Window: time.Hour, MaxBatch: 2})
+
+	first, second := publishAsync(pub, created(1)), publishAsync(pub, created(2))
+	for _, done := range []<-chan error{first, second} {
+		if err := <-done; err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	got := next.published()
+	if len(got) != 1 || len(got[0].Products) != 2 {
+		t.Fatalf("want a full batch flushed without waiting, got %+v", got)
+	}
+
+	last := publishAsync(pub, created(3))
+	waitPending(t, pub, 1)
+	if err := pub.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := <-last; err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	got = next.published()
+	if len(got) != 2 || got[1].EventType != products.EventCreated || got[1].ProductID != 3 {
+		t.Fatalf("want the lone pending create flushed as is on close, got %+v", got)
+	}
+}
+
+func TestCoalescingPublisher_OtherEventsKeepOrder(t *testing.T) {
+	next := &recordingPublisher{}
+	pub := newTestCoalescer(next, CoalesceConfig{Window: time.Hour, MaxBatch: 10})
+
+	first, second := publishAsync(pub, created(1)), publishAsync(pub, created(2))
+	waitPending(t, pub, 2)
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventDeleted, ProductID: 1}); err != nil {
+		t.Fatalf("publish delete: %v", err)
+	}
+	for _, done := range []<-chan error{first, second} {
+		if err := <-done; err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+
+	got := next.published()
+	if len(got) != 2 || got[0].EventType != products.EventCreatedBatch || got[1].EventType != products.EventDeleted {
+		t.Fatalf("want the pending batch flushed before the delete, got %+v", got)
+	}
+}
+
+func TestCoalescingPublisher_FailedFlush(t *testing.T) {
+	next := &recordingPublisher{failures: 1}
+	pub := newTestCoalescer(next, CoalesceConfig{Window: time.Hour, MaxBatch: 10})
+
+	first, second := publishAsync(pub, created(1)), publishAsync(pub, created(2))
+	waitPending(t, pub, 2)
+	if err := pub.Flush(context.Background()); err == nil {
+		t.Fatal("want the flush error")
+	}
+	for _, done := range []<-chan error{first, second} {
+		if err := <-done; err == nil {
+			t.Fatal("want the failed flush returned to every create in the batch")
+		}
+	}
+}
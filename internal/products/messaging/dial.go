@@ -0,0 +1,17 @@
+package messaging
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Dial connects to RabbitMQ at url like amqp.Dial, but proposes heartbeat
+// as the heartbeat interval. The broker may negotiate it down to its own,
+// shorter interval, and a heartbeat parameter in url takes precedence.
+func Dial(url string, heartbeat time.Duration) (*amqp.Connection, error) {
+	return amqp.DialConfig(url, amqp.Config{
+		Heartbeat: heartbeat,
+		Locale:    "en_US",
+	})
+}
@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// ContentEncodingGzip is the AMQP content-encoding of gzip-compressed
+// message bodies.
+const ContentEncodingGzip = "gzip"
+
+// compressBody gzips payload when it is larger than threshold bytes and
+// returns the body to send with its content-encoding. A zero threshold
+// never compresses.
+func compressBody(payload []byte, threshold int) ([]byte, string, error) {
+	if threshold <= 0 || len(payload) <= threshold {
+		return payload, "", nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, "", fmt.Errorf("gzip body: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", fmt.Errorf("gzip body: %w", err)
+	}
+	return buf.Bytes(), ContentEncodingGzip, nil
+}
+
+// DecodeBody undoes the content-encoding a publisher applied to body.
+func DecodeBody(contentEncoding string, body []byte) ([]byte, error) {
+	switch contentEncoding {
+	case "":
+		return body, nil
+	case ContentEncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("gunzip body: %w", err)
+		}
+		defer zr.Close()
+		decoded, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("gunzip body: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+}
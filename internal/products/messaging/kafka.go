@@ -0,0 +1,133 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka record headers carrying what AMQP has message properties for.
+const (
+	HeaderContentType     = "content-type"
+	HeaderContentEncoding = "content-encoding"
+	HeaderMessageID       = "message-id"
+)
+
+// kafkaBatchTimeout bounds how long a write waits for other messages to
+// share its batch; the library default of a second would add that much to
+// every synchronous publish.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// kafkaWriter is the subset of *kafka.Writer the publisher uses, so tests
+// can substitute a fake.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes events to a Kafka topic, keyed by product id so
+// all events of one product land on the same partition in order. Bodies
+// are encoded and signed exactly as RabbitPublisher does it, the signature
+// in a record header; Mandatory does not apply.
+type KafkaPublisher struct {
+	writer kafkaWriter
+	topic  string
+	cfg    PublisherConfig
+	closed atomic.Bool
+}
+
+func NewKafkaPublisher(brokers []string, topic string, cfg PublisherConfig) *KafkaPublisher {
+	return newKafkaPublisher(&kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		BatchTimeout:           kafkaBatchTimeout,
+		AllowAutoTopicCreation: true,
+	}, topic, cfg)
+}
+
+func newKafkaPublisher(w kafkaWriter, topic string, cfg PublisherConfig) *KafkaPublisher {
+	if cfg.Source == "" {
+		cfg.Source = DefaultEventSource
+	}
+	return &KafkaPublisher{writer: w, topic: topic, cfg: cfg}
+}
+
+// Publish writes event as one message. A products_created_batch is
+// written as its product_created events instead, in one write, so each is
+// keyed by its own product and stays in order with that product's other
+// events.
+func (p *KafkaPublisher) Publish(ctx context.Context, event products.ProductEvent) error {
+	events := []products.ProductEvent{event}
+	if event.EventType == products.EventCreatedBatch {
+		events = event.Products
+	}
+
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		msg, err := p.message(event)
+		if err != nil {
+			return err
+		}
+		msgs[i] = msg
+	}
+	if err := p.writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("publish to topic %q: %w", p.topic, err)
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) message(event products.ProductEvent) (kafka.Message, error) {
+	msg, err := encodeEvent(event, p.cfg)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	headers := []kafka.Header{
+		{Key: HeaderContentType, Value: []byte(msg.ContentType)},
+		{Key: HeaderMessageID, Value: []byte(msg.MessageId)},
+	}
+	if msg.ContentEncoding != "" {
+		headers = append(headers, kafka.Header{Key: HeaderContentEncoding, Value: []byte(msg.ContentEncoding)})
+	}
+	if signature, ok := msg.Headers[HeaderSignature].(string); ok {
+		headers = append(headers, kafka.Header{Key: HeaderSignature, Value: []byte(signature)})
+	}
+	return kafka.Message{
+		Key:     []byte(strconv.FormatInt(event.ProductID, 10)),
+		Value:   msg.Body,
+		Headers: headers,
+	}, nil
+}
+
+// Health reports ErrPublisherClosed after Close. The writer dials brokers
+// per write, so there is no connection to inspect before that.
+func (p *KafkaPublisher) Health(context.Context) error {
+	if p.closed.Load() {
+		return ErrPublisherClosed
+	}
+	return nil
+}
+
+// Close flushes pending writes and closes the writer.
+func (p *KafkaPublisher) Close() error {
+	p.closed.Store(true)
+	return p.writer.Close()
+}
+
+// KafkaHeader returns the value of the header named key, or "".
+func KafkaHeader(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
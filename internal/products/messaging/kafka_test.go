@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeWriter struct {
+	messages []kafka.Message
+	err      error
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafkaPublisher_Publish(t *testing.T) {
+	sent := products.ProductEvent{
+		EventType: products.EventCreated,
+		ProductID: 42,
+		Name:      "iPhone 16",
+		Timestamp: time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name            string
+		cfg             PublisherConfig
+		wantContentType string
+		wantEncoding    string
+	}{
+		{
+			name:            "native JSON",
+			wantContentType: contentTypeJSON,
+		},
+		{
+			name:            "cloudevents",
+			cfg:             PublisherConfig{Format: FormatCloudEvents},
+			wantContentType: contentTypeCloudEvents,
+		},
+		{
+			name:            "compressed",
+			cfg:             PublisherConfig{CompressAbove: 1},
+			wantContentType: contentTypeJSON,
+			wantEncoding:    ContentEncodingGzip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &fakeWriter{}
+			pub := newKafkaPublisher(w, products.EventsQueue, tt.cfg)
+
+			if err := pub.Publish(context.Background(), sent); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if len(w.messages) != 1 {
+				t.Fatalf("want 1 message written, got %d", len(w.messages))
+			}
+
+			msg := w.messages[0]
+			if string(msg.Key) != "42" {
+				t.Fatalf("want key %q, got %q", "42", msg.Key)
+			}
+			contentType := KafkaHeader(msg.Headers, HeaderContentType)
+			encoding := KafkaHeader(msg.Headers, HeaderContentEncoding)
+			if contentType != tt.wantContentType || encoding != tt.wantEncoding {
+				t.Fatalf("want content-type %q encoding %q, got %q %q", tt.wantContentType, tt.wantEncoding, contentType, encoding)
+			}
+			if KafkaHeader(msg.Headers, HeaderMessageID) == "" {
+				t.Fatal("want a message-id header")
+			}
+
+			body, err := DecodeBody(encoding, msg.Value)
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			got, err := DecodeEvent(contentType, body)
+			if err != nil {
+				t.Fatalf("decode event: %v", err)
+			}
+			if got.ProductID != sent.ProductID || got.Name != sent.Name || !got.Timestamp.Equal(sent.Timestamp) {
+				t.Fatalf("want %+v after round trip, got %+v", sent, got)
+			}
+		})
+	}
+}
+
+func TestKafkaPublisher_Errors(t *testing.T) {
+	errBroker := errors.New("leader not available")
+	w := &fakeWriter{err: errBroker}
+	pub := newKafkaPublisher(w, products.EventsQueue, PublisherConfig{})
+
+	if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: 1}); !errors.Is(err, errBroker) {
+		t.Fatalf("want the writer error, got %v", err)
+	}
+	if err := pub.Health(context.Background()); err != nil {
+		t.Fatalf("want healthy before close, got %v", err)
+	}
+
+	_ = pub.Close()
+	if !w.closed {
+		t.Fatal("want the writer closed")
+	}
+	if err := pub.Health(context.Background()); !errors.Is(err, ErrPublisherClosed) {
+		t.Fatalf("want ErrPublisherClosed after close, got %v", err)
+	}
+}
+
+func TestKafkaPublisher_CreatedBatch(t *testing.T) {
+	w := &fakeWriter{}
+	pub := newKafkaPublisher(w, products.EventsQueue, PublisherConfig{})
+
+	if err := pub.Publish(context.Background(), products.ProductEvent{
+		EventType: products.EventCreatedBatch,
+		Products:  []products.ProductEvent{created(1), created(2)},
+	}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if len(w.messages) != 2 {
+		t.Fatalf("want one message per product, got %d", len(w.messages))
+	}
+	for i, msg := range w.messages {
+		if want := strconv.Itoa(i + 1); string(msg.Key) != want {
+			t.Fatalf("message %d: want key %q, got %q", i, want, msg.Key)
+		}
+		got, err := DecodeEvent(KafkaHeader(msg.Headers, HeaderContentType), msg.Value)
+		if err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		if got.EventType != products.EventCreated {
+			t.Fatalf("message %d: want %s, got %s", i, products.EventCreated, got.EventType)
+		}
+	}
+}
+
+func TestKafkaPublisher_Signature(t *testing.T) {
+	w := &fakeWriter{}
+	secret := []byte("shared")
+	pub := newKafkaPublisher(w, products.EventsQueue, PublisherConfig{SigningSecret: secret})
+
+	if err := pub.Publish(context.Background(), created(1)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	msg := w.messages[0]
+	signature := KafkaHeader(msg.Headers, HeaderSignature)
+	if err := VerifySignature(secret, amqp.Table{HeaderSignature: signature}, msg.Value); err != nil {
+		t.Fatalf("want the record signed under the secret, got %v", err)
+	}
+}
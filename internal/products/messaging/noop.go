@@ -0,0 +1,17 @@
+package messaging
+
+import (
+	"context"
+
+	"product-notifications/internal/products"
+)
+
+// NoopPublisher discards every event. It stands in for RabbitMQ when event
+// publishing is disabled, so the service runs without a broker.
+type NoopPublisher struct{}
+
+func (NoopPublisher) Publish(context.Context, products.ProductEvent) error { return nil }
+
+func (NoopPublisher) Health(context.Context) error { return nil }
+
+func (NoopPublisher) Close() error { return nil }
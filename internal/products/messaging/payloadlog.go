@@ -0,0 +1,85 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	defaultLogPayloadMax = 4096
+
+	redactedValue = "[REDACTED]"
+)
+
+// logPayload logs event's payload at debug level as cfg.Logger asks, with
+// the exchange and routing key it is sent under. The payload is the one
+// already marshaled for the message, so this costs nothing unless debug
+// logging is on.
+func (p *RabbitPublisher) logPayload(ctx context.Context, event products.ProductEvent, messageID string, payload []byte) {
+	logger := p.cfg.Logger
+	if logger == nil || !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	body := payload
+	if len(p.cfg.LogRedact) > 0 {
+		body = redactPayload(payload, p.cfg.LogRedact)
+	}
+	truncated := len(body) > p.cfg.LogPayloadMax
+	if truncated {
+		body = body[:p.cfg.LogPayloadMax]
+	}
+
+	exchange, key := p.route(event)
+	logger.LogAttrs(ctx, slog.LevelDebug, "publish event payload",
+		slog.String("queue", p.queue),
+		slog.String("exchange", exchange),
+		slog.String("routing_key", key),
+		slog.String("message_id", messageID),
+		slog.Int("size", len(payload)),
+		slog.Bool("truncated", truncated),
+		slog.String("payload", string(body)),
+	)
+}
+
+// redactPayload masks the value of every field named in fields, at any
+// depth, so CloudEvents envelopes and coalesced batches are covered too.
+// A payload that is not JSON is not logged at all.
+func redactPayload(payload []byte, fields []string) []byte {
+	var doc any
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return []byte(redactedValue)
+	}
+
+	redact := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		redact[f] = true
+	}
+
+	out, err := json.Marshal(redactValue(doc, redact))
+	if err != nil {
+		return []byte(redactedValue)
+	}
+	return out
+}
+
+func redactValue(v any, redact map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if redact[key] {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactValue(field, redact)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, redact)
+		}
+	}
+	return v
+}
@@ -0,0 +1,124 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"product-notifications/internal/products"
+)
+
+func TestRabbitPublisher_PayloadLogging(t *testing.T) {
+	event := products.ProductEvent{EventType: products.EventCreated, ProductID: 7, Name: "Secret Phone"}
+
+	tests := []struct {
+		name       string
+		level      slog.Level
+		cfg        PublisherConfig
+		wantLogged bool
+		wantIn     string
+		wantOut    string
+		wantCut    bool
+	}{
+		{
+			name:  "silent above debug",
+			level: slog.LevelInfo,
+		},
+		{
+			name:       "payload logged at debug",
+			level:      slog.LevelDebug,
+			wantLogged: true,
+			wantIn:     `"name":"Secret Phone"`,
+		},
+		{
+			name:       "redacted fields masked",
+			level:      slog.LevelDebug,
+			cfg:        PublisherConfig{LogRedact: []string{"name"}},
+			wantLogged: true,
+			wantIn:     `"name":"[REDACTED]"`,
+			wantOut:    "Secret Phone",
+		},
+		{
+			name:       "redacted inside a CloudEvents envelope",
+			level:      slog.LevelDebug,
+			cfg:        PublisherConfig{Format: FormatCloudEvents, LogRedact: []string{"name"}},
+			wantLogged: true,
+			wantIn:     `"name":"[REDACTED]"`,
+			wantOut:    "Secret Phone",
+		},
+		{
+			name:       "routed through an exchange",
+			level:      slog.LevelDebug,
+			cfg:        PublisherConfig{Exchange: "products"},
+			wantLogged: true,
+			wantIn:     `"name":"Secret Phone"`,
+		},
+		{
+			name:       "large payload cut",
+			level:      slog.LevelDebug,
+			cfg:        PublisherConfig{LogPayloadMax: 10},
+			wantLogged: true,
+			wantIn:     `{"event_ty`,
+			wantOut:    "Secret Phone",
+			wantCut:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.cfg.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: tt.level}))
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, tt.cfg)
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			if err := pub.Publish(context.Background(), event); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+
+			if !tt.wantLogged {
+				if buf.Len() != 0 {
+					t.Fatalf("want nothing logged, got %s", buf.String())
+				}
+				return
+			}
+
+			var entry struct {
+				Msg        string `json:"msg"`
+				Queue      string `json:"queue"`
+				Exchange   string `json:"exchange"`
+				RoutingKey string `json:"routing_key"`
+				MessageID  string `json:"message_id"`
+				Truncated  bool   `json:"truncated"`
+				Payload    string `json:"payload"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("decode log entry %q: %v", buf.String(), err)
+			}
+			if entry.Queue != products.EventsQueue || entry.MessageID != ch.published[0].MessageId {
+				t.Fatalf("want queue %q and message id %q, got %+v", products.EventsQueue, ch.published[0].MessageId, entry)
+			}
+			if route := entry.Exchange + "/" + entry.RoutingKey; route != ch.routes[0] {
+				t.Fatalf("want route %q logged, got %q", ch.routes[0], route)
+			}
+			if !strings.Contains(entry.Payload, tt.wantIn) {
+				t.Fatalf("want payload containing %s, got %s", tt.wantIn, entry.Payload)
+			}
+			if tt.wantOut != "" && strings.Contains(entry.Payload, tt.wantOut) {
+				t.Fatalf("want %q kept out of the log, got %s", tt.wantOut, entry.Payload)
+			}
+			if entry.Truncated != tt.wantCut {
+				t.Fatalf("want truncated %v, got %v", tt.wantCut, entry.Truncated)
+			}
+			// Redaction only touches the log, never the message.
+			if !bytes.Contains(ch.published[0].Body, []byte("Secret Phone")) {
+				t.Fatalf("want the published body untouched, got %s", ch.published[0].Body)
+			}
+		})
+	}
+}
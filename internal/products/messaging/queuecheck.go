@@ -0,0 +1,114 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// queueProber runs the passive declares of the queue check. A passive
+// declare of a missing queue closes the channel it ran on, so each check
+// gets a short-lived channel of its own rather than the publishing one.
+type queueProber interface {
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Close() error
+}
+
+// checkQueueDue runs checkQueue when QueueCheckInterval has passed since
+// the last check. The check is best-effort: a failed probe is logged and
+// the publish goes ahead.
+func (p *RabbitPublisher) checkQueueDue(ctx context.Context) {
+	if p.probe == nil {
+		return
+	}
+	p.checkMu.Lock()
+	now := time.Now()
+	due := !now.Before(p.nextCheck)
+	if due {
+		p.nextCheck = now.Add(p.cfg.QueueCheckInterval)
+	}
+	p.checkMu.Unlock()
+
+	if !due {
+		return
+	}
+	p.recheckQueue(ctx)
+}
+
+// recheckQueue runs checkQueue, logging a failure instead of returning it,
+// and reports whether the queue had to be declared again.
+func (p *RabbitPublisher) recheckQueue(ctx context.Context) bool {
+	redeclared, err := p.checkQueue(ctx)
+	if err != nil {
+		p.logger().WarnContext(ctx, "check queue", "queue", p.queue, "error", err)
+	}
+	return redeclared
+}
+
+// checkQueue declares the queue again, and binds it to the exchange, when
+// a passive declare finds it gone, and reports whether it did. Only one
+// check runs at a time; a call while one is in progress returns at once.
+// Every broker call is bounded by SetupTimeout, so a broker that stops
+// answering cannot hold up the publishes that triggered the check.
+func (p *RabbitPublisher) checkQueue(ctx context.Context) (bool, error) {
+	if p.probe == nil {
+		return false, nil
+	}
+	p.checkMu.Lock()
+	if p.checking {
+		p.checkMu.Unlock()
+		return false, nil
+	}
+	p.checking = true
+	p.checkMu.Unlock()
+	defer func() {
+		p.checkMu.Lock()
+		p.checking = false
+		p.checkMu.Unlock()
+	}()
+
+	_, err := CallWithTimeout(p.cfg.SetupTimeout, func() (struct{}, error) {
+		probe, err := p.probe()
+		if err != nil {
+			return struct{}{}, fmt.Errorf("open probe channel: %w", err)
+		}
+		_, err = probe.QueueDeclarePassive(p.queue, true, false, false, false, nil)
+		// The broker has already closed the channel if the queue is missing.
+		_ = probe.Close()
+		return struct{}{}, err
+	})
+
+	var amqpErr *amqp.Error
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound:
+		return false, fmt.Errorf("check queue %q: %w", p.queue, err)
+	}
+
+	if _, err := CallWithTimeout(p.cfg.SetupTimeout, func() (amqp.Queue, error) {
+		return p.channel.QueueDeclare(p.queue, true, false, false, false, nil)
+	}); err != nil {
+		return false, fmt.Errorf("declare queue %q: %w", p.queue, err)
+	}
+	if p.cfg.Exchange != "" {
+		if _, err := CallWithTimeout(p.cfg.SetupTimeout, func() (struct{}, error) {
+			return struct{}{}, p.channel.QueueBind(p.queue, exchangeBindingKey, p.cfg.Exchange, false, nil)
+		}); err != nil {
+			return false, fmt.Errorf("bind queue %q to exchange %q: %w", p.queue, p.cfg.Exchange, err)
+		}
+	}
+	p.logger().WarnContext(ctx, "queue was missing, declared it again", "queue", p.queue)
+	return true, nil
+}
+
+func (p *RabbitPublisher) logger() *slog.Logger {
+	if p.cfg.Logger == nil {
+		return slog.Default()
+	}
+	return p.cfg.Logger
+}
@@ -0,0 +1,148 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeProber answers passive declares from the fake channel's queue state,
+// the way the broker would on a channel of its own.
+type fakeProber struct {
+	ch *fakeChannel
+}
+
+func (f fakeProber) QueueDeclarePassive(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	if f.ch.queueMissing {
+		return amqp.Queue{}, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (fakeProber) Close() error { return nil }
+
+func TestRabbitPublisher_QueueCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       PublisherConfig
+		probe     bool
+		wantLost  int
+		wantBinds int
+	}{
+		{
+			name:      "periodic check declares the queue again",
+			cfg:       PublisherConfig{QueueCheckInterval: time.Nanosecond, Exchange: "products"},
+			probe:     true,
+			wantBinds: 2,
+		},
+		{
+			name:  "unroutable publish is checked and retried",
+			cfg:   PublisherConfig{QueueCheckInterval: time.Hour, Mandatory: true},
+			probe: true,
+		},
+		{
+			name:     "without the check publishes are lost",
+			wantLost: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, tt.cfg)
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+			if tt.probe {
+				pub.probe = func() (queueProber, error) { return fakeProber{ch}, nil }
+			}
+			ctx := context.Background()
+			event := products.ProductEvent{EventType: products.EventCreated, ProductID: 1}
+
+			if err := pub.Publish(ctx, event); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			// An operator deletes the queue.
+			ch.queueMissing = true
+			if err := pub.Publish(ctx, event); err != nil {
+				t.Fatalf("publish after the queue was deleted: %v", err)
+			}
+
+			if want := !tt.probe; ch.queueMissing != want {
+				t.Fatalf("want queue missing %v, got %v", want, ch.queueMissing)
+			}
+			if ch.lost != tt.wantLost {
+				t.Fatalf("want %d publishes lost, got %d", tt.wantLost, ch.lost)
+			}
+			if len(ch.bindings) != tt.wantBinds {
+				t.Fatalf("want %d bindings, got %v", tt.wantBinds, ch.bindings)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_QueueCheckProbeFailure(t *testing.T) {
+	ch := &fakeChannel{}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{QueueCheckInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	pub.probe = func() (queueProber, error) { return nil, errors.New("channel limit reached") }
+
+	// The check is best-effort, so the publish still goes out.
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventCreated, ProductID: 1}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("want 1 published message, got %d", len(ch.published))
+	}
+}
+
+// hungProber never answers the passive declare until release is closed.
+type hungProber struct {
+	release chan struct{}
+}
+
+func (h hungProber) QueueDeclarePassive(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	<-h.release
+	return amqp.Queue{Name: name}, nil
+}
+
+func (hungProber) Close() error { return nil }
+
+func TestRabbitPublisher_QueueCheckTimeout(t *testing.T) {
+	ch := &fakeChannel{}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{
+		QueueCheckInterval: time.Nanosecond,
+		SetupTimeout:       50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	pub.probe = func() (queueProber, error) { return hungProber{release}, nil }
+
+	start := time.Now()
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventCreated, ProductID: 1}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("want the check abandoned at the timeout, took %s", elapsed)
+	}
+	if len(ch.published) != 1 {
+		t.Fatalf("want 1 published message, got %d", len(ch.published))
+	}
+
+	// While a check is in progress, another one returns at once.
+	pub.checking = true
+	redeclared, err := pub.checkQueue(context.Background())
+	if redeclared || err != nil {
+		t.Fatalf("want a concurrent check skipped, got %v, %v", redeclared, err)
+	}
+}
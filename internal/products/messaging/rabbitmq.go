@@ -3,61 +3,239 @@ package messaging
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
 
 	"product-notifications/internal/products"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-const contentTypeJSON = "application/json"
+const (
+	contentTypeJSON = "application/json"
+
+	// notifyBufferSize bounds how many returns/confirms the library can hand
+	// us before a publish reads them; an unread, full listener channel would
+	// block the AMQP channel's dispatcher.
+	notifyBufferSize = 16
+)
+
+var (
+	ErrUnroutable    = errors.New("message returned as unroutable")
+	ErrPublishNacked = errors.New("message nacked by broker")
+	ErrChannelClosed = errors.New("amqp channel closed")
+)
+
+// PublisherConfig tunes how events are handed to the broker.
+type PublisherConfig struct {
+	// Mandatory asks the broker to return messages it cannot route to any
+	// queue. Returned messages surface as ErrUnroutable from Publish, which
+	// requires publisher confirms and makes every publish wait for the ack.
+	Mandatory bool
+	// CompressAbove gzips message bodies larger than this many bytes and
+	// marks them with ContentEncoding gzip; zero never compresses.
+	CompressAbove int
+	// Format is FormatNative (the default) or FormatCloudEvents, which
+	// wraps each event in a CloudEvents envelope with Source as its source.
+	Format string
+	Source string
+	// Transient publishes with amqp.Transient instead of amqp.Persistent:
+	// RabbitMQ keeps the messages in memory only, which is faster but
+	// loses them on a broker restart even though the queue is durable.
+	Transient bool
+	// MessageTTL sets each message's AMQP expiration, after which RabbitMQ
+	// drops it unconsumed; zero keeps messages until they are consumed.
+	MessageTTL time.Duration
+	// Exchange, when set, publishes to this durable topic exchange instead
+	// of straight to the queue. The queue is bound to it with "#", and each
+	// event is sent under RoutingKey (DefaultRoutingKey when empty), so
+	// other queues can bind to one owner's events, e.g. product.#.acme.
+	Exchange   string
+	RoutingKey string
+
+	// SigningSecret, when set, signs every message body with HMAC-SHA256
+	// under it in the HeaderSignature header, so consumers sharing the
+	// secret can tell the events came from this service unaltered.
+	SigningSecret []byte
+
+	// BatchChunkSize is how many events PublishBatch sends per confirm
+	// window before waiting for the broker to confirm them all;
+	// defaultBatchChunkSize when zero.
+	BatchChunkSize int
+
+	// SetupTimeout bounds each broker call made while constructing the
+	// publisher, so a broker that never answers fails startup with
+	// ErrSetupTimeout instead of hanging it; zero waits forever. It bounds
+	// the calls of the queue check too.
+	SetupTimeout time.Duration
+
+	// QueueCheckInterval, when non-zero, has the publisher check at most
+	// this often, before a publish, that its queue still exists. A queue
+	// an operator deleted at runtime is declared again, and bound to
+	// Exchange again, with a warning, instead of publishes disappearing
+	// into the default exchange. With Mandatory, a publish returned as
+	// unroutable is checked at once and retried if the queue was missing.
+	QueueCheckInterval time.Duration
+
+	// Logger, when set and enabled for debug, logs every payload the
+	// RabbitMQ publisher sends, before compression, cut to LogPayloadMax
+	// bytes (default 4096) and with any JSON field named in LogRedact
+	// masked. Below debug nothing extra is marshaled.
+	Logger        *slog.Logger
+	LogPayloadMax int
+	LogRedact     []string
+}
+
+type amqpChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	GetNextPublishSeqNo() uint64
+	IsClosed() bool
+	Close() error
+}
 
 type RabbitPublisher struct {
-	channel *amqp.Channel
+	channel amqpChannel
 	queue   string
+	cfg     PublisherConfig
+
+	// mu serializes mandatory publishes so each one can match its own
+	// return/confirm. Returns carry no delivery tag, only the message id.
+	mu       sync.Mutex
+	returns  chan amqp.Return
+	confirms chan amqp.Confirmation
+
+	// probe opens a channel for the queue check; nil disables it.
+	probe     func() (queueProber, error)
+	checkMu   sync.Mutex
+	nextCheck time.Time
+	// checking is set while a queue check runs, guarded by checkMu.
+	checking bool
 }
 
-func NewRabbitPublisher(conn *amqp.Connection, queue string) (*RabbitPublisher, error) {
+func NewRabbitPublisher(conn *amqp.Connection, queue string, cfg PublisherConfig) (*RabbitPublisher, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("open channel: %w", err)
 	}
 
-	_, err = ch.QueueDeclare(
-		queue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	p, err := newRabbitPublisher(ch, queue, cfg)
 	if err != nil {
 		_ = ch.Close()
+		return nil, err
+	}
+	if cfg.QueueCheckInterval > 0 {
+		p.probe = func() (queueProber, error) { return conn.Channel() }
+	}
+	return p, nil
+}
+
+func newRabbitPublisher(ch amqpChannel, queue string, cfg PublisherConfig) (*RabbitPublisher, error) {
+	_, err := CallWithTimeout(cfg.SetupTimeout, func() (amqp.Queue, error) {
+		return ch.QueueDeclare(
+			queue,
+			true,
+			false,
+			false,
+			false,
+			nil,
+		)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("declare queue %q: %w", queue, err)
 	}
 
-	return &RabbitPublisher{
+	if cfg.Exchange != "" {
+		if err := declareExchange(ch, queue, cfg); err != nil {
+			return nil, err
+		}
+		if cfg.RoutingKey == "" {
+			cfg.RoutingKey = DefaultRoutingKey
+		}
+	}
+
+	if cfg.Source == "" {
+		cfg.Source = DefaultEventSource
+	}
+	if cfg.LogPayloadMax == 0 {
+		cfg.LogPayloadMax = defaultLogPayloadMax
+	}
+	if cfg.BatchChunkSize <= 0 {
+		cfg.BatchChunkSize = defaultBatchChunkSize
+	}
+
+	p := &RabbitPublisher{
 		channel: ch,
 		queue:   queue,
-	}, nil
+		cfg:     cfg,
+	}
+
+	if cfg.Mandatory {
+		if _, err := CallWithTimeout(cfg.SetupTimeout, func() (struct{}, error) {
+			return struct{}{}, ch.Confirm(false)
+		}); err != nil {
+			return nil, fmt.Errorf("enable publisher confirms: %w", err)
+		}
+		// The library closes both listener channels when the AMQP channel
+		// closes, so no goroutine is needed to drain them. They hold a whole
+		// batch chunk, whose confirms are only read once it is all sent.
+		size := max(notifyBufferSize, cfg.BatchChunkSize)
+		p.returns = ch.NotifyReturn(make(chan amqp.Return, size))
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, size))
+	}
+
+	return p, nil
+}
+
+// declareExchange declares cfg.Exchange as a durable topic exchange and
+// binds queue to all of its keys.
+func declareExchange(ch amqpChannel, queue string, cfg PublisherConfig) error {
+	if _, err := CallWithTimeout(cfg.SetupTimeout, func() (struct{}, error) {
+		return struct{}{}, ch.ExchangeDeclare(cfg.Exchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	}); err != nil {
+		return fmt.Errorf("declare exchange %q: %w", cfg.Exchange, err)
+	}
+	if _, err := CallWithTimeout(cfg.SetupTimeout, func() (struct{}, error) {
+		return struct{}{}, ch.QueueBind(queue, exchangeBindingKey, cfg.Exchange, false, nil)
+	}); err != nil {
+		return fmt.Errorf("bind queue %q to exchange %q: %w", queue, cfg.Exchange, err)
+	}
+	return nil
 }
 
 func (p *RabbitPublisher) Publish(ctx context.Context, event products.ProductEvent) error {
-	payload, err := json.Marshal(event)
+	msg, err := p.encode(ctx, event)
 	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
+		return err
+	}
+
+	p.checkQueueDue(ctx)
+	exchange, key := p.route(event)
+	if p.cfg.Mandatory {
+		err := p.publishMandatory(ctx, exchange, key, msg)
+		if errors.Is(err, ErrUnroutable) && p.recheckQueue(ctx) {
+			err = p.publishMandatory(ctx, exchange, key, msg)
+		}
+		return err
 	}
 
 	if err := p.channel.PublishWithContext(
 		ctx,
-		"",
-		p.queue,
+		exchange,
+		key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: contentTypeJSON,
-			Body:        payload,
-		},
+		msg,
 	); err != nil {
 		return fmt.Errorf("publish to %q: %w", p.queue, err)
 	}
@@ -65,6 +243,160 @@ func (p *RabbitPublisher) Publish(ctx context.Context, event products.ProductEve
 	return nil
 }
 
+// route returns the exchange and routing key for event: the configured
+// exchange and rendered key, or the default exchange and the queue name.
+func (p *RabbitPublisher) route(event products.ProductEvent) (exchange, key string) {
+	if p.cfg.Exchange == "" {
+		return "", p.queue
+	}
+	return p.cfg.Exchange, routingKey(p.cfg.RoutingKey, event)
+}
+
+func (p *RabbitPublisher) encode(ctx context.Context, event products.ProductEvent) (amqp.Publishing, error) {
+	msg, payload, err := marshalEvent(event, p.cfg)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	p.logPayload(ctx, event, msg.MessageId, payload)
+
+	msg.Body, msg.ContentEncoding, err = compressBody(payload, p.cfg.CompressAbove)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	if len(p.cfg.SigningSecret) > 0 {
+		msg.Headers = amqp.Table{HeaderSignature: Sign(p.cfg.SigningSecret, msg.Body)}
+	}
+	return msg, nil
+}
+
+// encodeEvent serialises event as cfg asks, with a fresh message id, and
+// signs it under cfg.SigningSecret. Other transports reuse it and copy the
+// fields they can carry.
+func encodeEvent(event products.ProductEvent, cfg PublisherConfig) (amqp.Publishing, error) {
+	msg, payload, err := marshalEvent(event, cfg)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	msg.Body, msg.ContentEncoding, err = compressBody(payload, cfg.CompressAbove)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	if len(cfg.SigningSecret) > 0 {
+		msg.Headers = amqp.Table{HeaderSignature: Sign(cfg.SigningSecret, msg.Body)}
+	}
+	return msg, nil
+}
+
+// marshalEvent returns the message properties and the uncompressed body
+// for event, stamped with the schema version this build writes; the body
+// is left for the caller to compress.
+func marshalEvent(event products.ProductEvent, cfg PublisherConfig) (amqp.Publishing, []byte, error) {
+	event.SchemaVersion = products.EventSchemaVersion
+	msg := amqp.Publishing{
+		ContentType:  contentTypeJSON,
+		MessageId:    uuid.NewString(),
+		DeliveryMode: amqp.Persistent,
+	}
+	if cfg.Transient {
+		msg.DeliveryMode = amqp.Transient
+	}
+	if cfg.MessageTTL > 0 {
+		// Expiration is a string of whole milliseconds.
+		msg.Expiration = strconv.FormatInt(cfg.MessageTTL.Milliseconds(), 10)
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	if cfg.Format == FormatCloudEvents {
+		msg.ContentType = contentTypeCloudEvents
+		msg.Type = event.EventType
+		msg.Timestamp = event.Timestamp
+		payload, err = json.Marshal(newCloudEvent(event, msg.MessageId, cfg.Source))
+	} else {
+		payload, err = json.Marshal(event)
+	}
+	if err != nil {
+		return amqp.Publishing{}, nil, fmt.Errorf("marshal event: %w", err)
+	}
+	return msg, payload, nil
+}
+
+func (p *RabbitPublisher) publishMandatory(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seqNo := p.channel.GetNextPublishSeqNo()
+	if err := p.channel.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+		return fmt.Errorf("publish to %q: %w", p.queue, err)
+	}
+
+	// RabbitMQ sends basic.return before the basic.ack of the same message,
+	// so a return for this message id is already buffered by the time its
+	// confirm arrives, even if select happens to pick the confirm first.
+	var returned *amqp.Return
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("await confirm from %q: %w", p.queue, ctx.Err())
+		case ret, ok := <-p.returns:
+			if !ok {
+				return ErrChannelClosed
+			}
+			if ret.MessageId == msg.MessageId {
+				returned = &ret
+			}
+		case conf, ok := <-p.confirms:
+			if !ok {
+				return ErrChannelClosed
+			}
+			if conf.DeliveryTag < seqNo {
+				// Stale confirm from an earlier publish whose caller gave up.
+				continue
+			}
+			if returned == nil {
+				returned = p.drainReturns(msg.MessageId)
+			}
+			if returned != nil {
+				return fmt.Errorf("publish to %q: %w: %d %s",
+					p.queue, ErrUnroutable, returned.ReplyCode, returned.ReplyText)
+			}
+			if !conf.Ack {
+				return fmt.Errorf("publish to %q: %w", p.queue, ErrPublishNacked)
+			}
+			return nil
+		}
+	}
+}
+
+func (p *RabbitPublisher) drainReturns(messageID string) *amqp.Return {
+	var returned *amqp.Return
+	for {
+		select {
+		case ret, ok := <-p.returns:
+			if !ok {
+				return returned
+			}
+			if ret.MessageId == messageID {
+				returned = &ret
+			}
+		default:
+			return returned
+		}
+	}
+}
+
+// Health reports ErrChannelClosed once the AMQP channel has closed, whether
+// by Close or because the broker or connection went away.
+func (p *RabbitPublisher) Health(context.Context) error {
+	if p.channel.IsClosed() {
+		return ErrChannelClosed
+	}
+	return nil
+}
+
 func (p *RabbitPublisher) Close() error {
 	return p.channel.Close()
 }
@@ -0,0 +1,518 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type fakeChannel struct {
+	unroutable bool
+	// nack lists the delivery tags the broker nacks.
+	nack      map[uint64]bool
+	published []amqp.Publishing
+	seqNo     uint64
+	returns   chan amqp.Return
+	confirms  chan amqp.Confirmation
+	closed    bool
+	// declareDelay stalls QueueDeclare like a broker slow to answer.
+	declareDelay time.Duration
+	// routes records "exchange/key" for every publish; bindings records
+	// "queue/key/exchange" for every QueueBind.
+	routes   []string
+	bindings []string
+	// queueMissing simulates the queue deleted behind the publisher's
+	// back: publishes are unroutable until QueueDeclare runs again, and
+	// lost counts the ones that vanished without a return.
+	queueMissing bool
+	lost         int
+}
+
+func (f *fakeChannel) QueueDeclare(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	time.Sleep(f.declareDelay)
+	f.queueMissing = false
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeChannel) ExchangeDeclare(_, _ string, _, _, _, _ bool, _ amqp.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) QueueBind(name, key, exchange string, _ bool, _ amqp.Table) error {
+	f.bindings = append(f.bindings, name+"/"+key+"/"+exchange)
+	return nil
+}
+
+func (f *fakeChannel) PublishWithContext(_ context.Context, exchange, key string, mandatory, _ bool, msg amqp.Publishing) error {
+	f.published = append(f.published, msg)
+	f.routes = append(f.routes, exchange+"/"+key)
+	if f.queueMissing && !mandatory {
+		f.lost++
+	}
+	if f.confirms == nil {
+		return nil
+	}
+
+	f.seqNo++
+	if mandatory && (f.unroutable || f.queueMissing) {
+		f.returns <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", MessageId: msg.MessageId}
+	}
+	f.confirms <- amqp.Confirmation{DeliveryTag: f.seqNo, Ack: !f.nack[f.seqNo]}
+	return nil
+}
+
+func (f *fakeChannel) Confirm(_ bool) error {
+	f.seqNo = 0
+	return nil
+}
+
+func (f *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	f.returns = c
+	return c
+}
+
+func (f *fakeChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	f.confirms = c
+	return c
+}
+
+func (f *fakeChannel) GetNextPublishSeqNo() uint64 {
+	return f.seqNo + 1
+}
+
+func (f *fakeChannel) IsClosed() bool {
+	return f.closed
+}
+
+func (f *fakeChannel) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestRabbitPublisher_Publish(t *testing.T) {
+	tests := []struct {
+		name       string
+		mandatory  bool
+		unroutable bool
+		wantErr    error
+	}{
+		{
+			name:       "non-mandatory ignores routing",
+			unroutable: true,
+		},
+		{
+			name:      "mandatory routed",
+			mandatory: true,
+		},
+		{
+			name:       "mandatory unroutable returns error",
+			mandatory:  true,
+			unroutable: true,
+			wantErr:    ErrUnroutable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{unroutable: tt.unroutable}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{Mandatory: tt.mandatory})
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			err = pub.Publish(context.Background(), products.ProductEvent{
+				EventType: products.EventCreated,
+				ProductID: 1,
+			})
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(ch.published) != 1 {
+				t.Fatalf("want 1 published message, got %d", len(ch.published))
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_DeliveryMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		transient bool
+		want      uint8
+	}{
+		{name: "persistent by default", want: amqp.Persistent},
+		{name: "transient when configured", transient: true, want: amqp.Transient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{Transient: tt.transient})
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: 1}); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if got := ch.published[0].DeliveryMode; got != tt.want {
+				t.Fatalf("want delivery mode %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_MessageTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want string
+	}{
+		{name: "no expiration by default"},
+		{name: "ttl in milliseconds", ttl: 90 * time.Second, want: "90000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{MessageTTL: tt.ttl})
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			if err := pub.Publish(context.Background(), products.ProductEvent{ProductID: 1}); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if got := ch.published[0].Expiration; got != tt.want {
+				t.Fatalf("want expiration %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_RoutingKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       PublisherConfig
+		event     products.ProductEvent
+		wantRoute string
+	}{
+		{
+			name:      "straight to the queue without an exchange",
+			event:     products.ProductEvent{EventType: products.EventCreated, Owner: "acme"},
+			wantRoute: "/" + products.EventsQueue,
+		},
+		{
+			name:      "default template includes the owner",
+			cfg:       PublisherConfig{Exchange: "products"},
+			event:     products.ProductEvent{EventType: products.EventCreated, Owner: "acme"},
+			wantRoute: "products/product.created.acme",
+		},
+		{
+			name:      "owner sanitized to one word",
+			cfg:       PublisherConfig{Exchange: "products"},
+			event:     products.ProductEvent{EventType: products.EventDeleted, Owner: "acme corp.eu#1"},
+			wantRoute: "products/product.deleted.acme_corp_eu_1",
+		},
+		{
+			name:      "no owner",
+			cfg:       PublisherConfig{Exchange: "products"},
+			event:     products.ProductEvent{EventType: products.EventUpdated},
+			wantRoute: "products/product.updated._",
+		},
+		{
+			name:      "custom template",
+			cfg:       PublisherConfig{Exchange: "products", RoutingKey: "tenant.{owner}.{event}"},
+			event:     products.ProductEvent{EventType: products.EventCreated, Owner: "acme"},
+			wantRoute: "products/tenant.acme.product.created",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, tt.cfg)
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			if err := pub.Publish(context.Background(), tt.event); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+			if got := ch.routes[0]; got != tt.wantRoute {
+				t.Fatalf("want route %q, got %q", tt.wantRoute, got)
+			}
+			// The events queue keeps getting every event either way.
+			var wantBindings []string
+			if tt.cfg.Exchange != "" {
+				wantBindings = []string{products.EventsQueue + "/#/" + tt.cfg.Exchange}
+			}
+			if !reflect.DeepEqual(ch.bindings, wantBindings) {
+				t.Fatalf("want bindings %v, got %v", wantBindings, ch.bindings)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_SetupTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		wantErr bool
+	}{
+		{name: "declare answered in time", delay: time.Millisecond},
+		{name: "declare too slow", delay: time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{declareDelay: tt.delay}
+			start := time.Now()
+			_, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{SetupTimeout: 50 * time.Millisecond})
+
+			if errors.Is(err, ErrSetupTimeout) != tt.wantErr {
+				t.Fatalf("want ErrSetupTimeout=%v, got %v", tt.wantErr, err)
+			}
+			if elapsed := time.Since(start); tt.wantErr && elapsed > 500*time.Millisecond {
+				t.Fatalf("want the declare abandoned at the timeout, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_MandatoryIgnoresForeignReturns(t *testing.T) {
+	ch := &fakeChannel{}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{Mandatory: true})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+
+	// A leftover return from an earlier, abandoned publish must not fail
+	// the next one.
+	ch.returns <- amqp.Return{MessageId: "someone-else"}
+
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventCreated}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRabbitPublisher_PublishBatch_FailedChunk(t *testing.T) {
+	// Tags 5 and 6 are the second and third events of the second chunk.
+	ch := &fakeChannel{nack: map[uint64]bool{5: true, 6: true}}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{
+		Mandatory:      true,
+		BatchChunkSize: 3,
+	})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+
+	events := make([]products.ProductEvent, 8)
+	for i := range events {
+		events[i] = products.ProductEvent{EventType: products.EventCreated, ProductID: int64(i + 1)}
+	}
+	err = pub.PublishBatch(context.Background(), events)
+
+	var batchErr *products.BatchPublishError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("want *products.BatchPublishError, got %v", err)
+	}
+	if !errors.Is(err, ErrPublishNacked) {
+		t.Fatalf("want ErrPublishNacked, got %v", err)
+	}
+	if batchErr.Chunk != 1 {
+		t.Fatalf("want chunk 1 to fail, got %d", batchErr.Chunk)
+	}
+	if want := []int{4, 5}; !reflect.DeepEqual(batchErr.Failed, want) {
+		t.Fatalf("want failed indexes %v, got %v", want, batchErr.Failed)
+	}
+	if want := []int{4, 5, 6, 7}; !reflect.DeepEqual(batchErr.Unpublished(), want) {
+		t.Fatalf("want unpublished indexes %v, got %v", want, batchErr.Unpublished())
+	}
+	if len(ch.published) != 6 {
+		t.Fatalf("want the third chunk unsent, got %d published", len(ch.published))
+	}
+}
+
+func TestRabbitPublisher_CompressionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		productName  string
+		wantEncoding string
+	}{
+		{
+			name:        "small body sent as is",
+			productName: "Laptop",
+		},
+		{
+			name:         "large body gzipped",
+			productName:  strings.Repeat("very long product name ", 500),
+			wantEncoding: ContentEncodingGzip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{}
+			pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{CompressAbove: 1024})
+			if err != nil {
+				t.Fatalf("new publisher: %v", err)
+			}
+
+			sent := products.ProductEvent{EventType: products.EventCreated, ProductID: 1, Name: tt.productName}
+			if err := pub.Publish(context.Background(), sent); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Every published event is stamped with the current schema.
+			sent.SchemaVersion = products.EventSchemaVersion
+
+			msg := ch.published[0]
+			if msg.ContentEncoding != tt.wantEncoding {
+				t.Fatalf("want content encoding %q, got %q", tt.wantEncoding, msg.ContentEncoding)
+			}
+			if tt.wantEncoding != "" && len(msg.Body) >= len(tt.productName) {
+				t.Fatalf("want compressed body smaller than the name alone, got %d bytes", len(msg.Body))
+			}
+
+			body, err := DecodeBody(msg.ContentEncoding, msg.Body)
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			var got products.ProductEvent
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, sent) {
+				t.Fatalf("want %+v after round trip, got %+v", sent, got)
+			}
+		})
+	}
+}
+
+func TestRabbitPublisher_Signature(t *testing.T) {
+	secret := []byte("shared-secret")
+	ch := &fakeChannel{}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{SigningSecret: secret})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventCreated, ProductID: 1, Name: "Laptop"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := ch.published[0]
+
+	if err := VerifySignature(secret, msg.Headers, msg.Body); err != nil {
+		t.Fatalf("want the published signature to verify, got %v", err)
+	}
+	if err := VerifySignature([]byte("other-secret"), msg.Headers, msg.Body); !errors.Is(err, ErrSignatureMismatch) {
+		t.Fatalf("want ErrSignatureMismatch under another secret, got %v", err)
+	}
+	tampered := bytes.Replace(msg.Body, []byte("Laptop"), []byte("Laptoq"), 1)
+	if err := VerifySignature(secret, msg.Headers, tampered); !errors.Is(err, ErrSignatureMismatch) {
+		t.Fatalf("want ErrSignatureMismatch for a tampered body, got %v", err)
+	}
+
+	unsigned := &fakeChannel{}
+	pub, err = newRabbitPublisher(unsigned, products.EventsQueue, PublisherConfig{})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	if err := pub.Publish(context.Background(), products.ProductEvent{EventType: products.EventCreated, ProductID: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VerifySignature(secret, unsigned.published[0].Headers, unsigned.published[0].Body); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("want ErrUnsigned without a signing secret, got %v", err)
+	}
+}
+
+func TestRabbitPublisher_CloudEventsEnvelope(t *testing.T) {
+	ch := &fakeChannel{}
+	pub, err := newRabbitPublisher(ch, products.EventsQueue, PublisherConfig{Format: FormatCloudEvents, Source: "/test"})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+
+	sent := products.ProductEvent{
+		EventType: products.EventCreated,
+		ProductID: 7,
+		Name:      "Laptop",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := pub.Publish(context.Background(), sent); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sent.SchemaVersion = products.EventSchemaVersion
+
+	msg := ch.published[0]
+	if msg.ContentType != contentTypeCloudEvents {
+		t.Fatalf("want content type %q, got %q", contentTypeCloudEvents, msg.ContentType)
+	}
+
+	var ce CloudEvent
+	if err := json.Unmarshal(msg.Body, &ce); err != nil {
+		t.Fatalf("unmarshal envelope: %v", err)
+	}
+	if ce.SpecVersion != "1.0" {
+		t.Fatalf("want specversion 1.0, got %q", ce.SpecVersion)
+	}
+	if ce.Type != products.EventCreated {
+		t.Fatalf("want type %q, got %q", products.EventCreated, ce.Type)
+	}
+	if ce.Source != "/test" {
+		t.Fatalf("want source /test, got %q", ce.Source)
+	}
+	if ce.ID == "" || ce.ID != msg.MessageId {
+		t.Fatalf("want id matching message id %q, got %q", msg.MessageId, ce.ID)
+	}
+	if !ce.Time.Equal(sent.Timestamp) {
+		t.Fatalf("want time %v, got %v", sent.Timestamp, ce.Time)
+	}
+	if !reflect.DeepEqual(ce.Data, sent) {
+		t.Fatalf("want data %+v, got %+v", sent, ce.Data)
+	}
+
+	got, err := DecodeEvent(msg.ContentType, msg.Body)
+	if err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if !reflect.DeepEqual(got, sent) {
+		t.Fatalf("want %+v after round trip, got %+v", sent, got)
+	}
+}
+
+func TestRabbitPublisher_Health(t *testing.T) {
+	pub, err := newRabbitPublisher(&fakeChannel{}, products.EventsQueue, PublisherConfig{})
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+
+	if err := pub.Health(context.Background()); err != nil {
+		t.Fatalf("want healthy open channel, got %v", err)
+	}
+	_ = pub.Close()
+	if err := pub.Health(context.Background()); !errors.Is(err, ErrChannelClosed) {
+		t.Fatalf("want ErrChannelClosed after close, got %v", err)
+	}
+}
+
+func TestDecodeBody_UnsupportedEncoding(t *testing.T) {
+	if _, err := DecodeBody("br", []byte("x")); err == nil {
+		t.Fatal("expected error for unsupported encoding, got nil")
+	}
+}
@@ -0,0 +1,114 @@
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const defaultReadOnlyRetry = 30 * time.Second
+
+type ReadOnlyConfig struct {
+	// Threshold is how many consecutive publish failures switch the
+	// service to read-only.
+	Threshold int
+	// Retry is how long the service stays read-only before writes are let
+	// through again to find out whether the broker is back. Zero uses
+	// defaultReadOnlyRetry.
+	Retry time.Duration
+}
+
+// ReadOnlyGuard watches publishes to the wrapped publisher and reports the
+// service read-only once Threshold of them fail in a row, so writes can be
+// refused cleanly instead of succeeding without their events.
+//
+// Read-only lasts Retry. After that writes are let through again, but the
+// failure count is kept, so the next failed publish switches straight back;
+// the first successful publish, from a write or from the outbox relay,
+// clears it.
+type ReadOnlyGuard struct {
+	next   eventPublisher
+	cfg    ReadOnlyConfig
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+}
+
+func NewReadOnlyGuard(next eventPublisher, cfg ReadOnlyConfig, logger *slog.Logger) *ReadOnlyGuard {
+	if cfg.Retry == 0 {
+		cfg.Retry = defaultReadOnlyRetry
+	}
+	return &ReadOnlyGuard{next: next, cfg: cfg, logger: logger, now: time.Now}
+}
+
+func (g *ReadOnlyGuard) Publish(ctx context.Context, event products.ProductEvent) error {
+	return g.record(g.next.Publish(ctx, event))
+}
+
+// PublishBatch publishes events through next, as one batch when it can,
+// and counts the batch as a single publish.
+func (g *ReadOnlyGuard) PublishBatch(ctx context.Context, events []products.ProductEvent) error {
+	return g.record(publishEvents(ctx, g.next, events))
+}
+
+// record counts the outcome of a publish and returns its error.
+func (g *ReadOnlyGuard) record(err error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err == nil {
+		if g.failures >= g.cfg.Threshold {
+			g.logger.Info("publishing recovered, leaving read-only mode")
+		}
+		g.failures = 0
+		g.until = time.Time{}
+		return nil
+	}
+
+	g.failures++
+	if g.failures >= g.cfg.Threshold {
+		now := g.now()
+		if !g.readOnly(now) {
+			g.logger.Warn("publishing keeps failing, entering read-only mode",
+				"consecutive_failures", g.failures,
+				"retry_after", g.cfg.Retry,
+			)
+		}
+		g.until = now.Add(g.cfg.Retry)
+	}
+	return err
+}
+
+// ReadOnly reports whether writes should currently be refused.
+func (g *ReadOnlyGuard) ReadOnly() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.readOnly(g.now())
+}
+
+func (g *ReadOnlyGuard) readOnly(now time.Time) bool {
+	return !g.until.IsZero() && now.Before(g.until)
+}
+
+// Flush flushes next when it holds events back. The outcome is not
+// counted: the publishes waiting on those events count their own.
+func (g *ReadOnlyGuard) Flush(ctx context.Context) error {
+	if held, ok := g.next.(heldEventsFlusher); ok {
+		return held.Flush(ctx)
+	}
+	return nil
+}
+
+func (g *ReadOnlyGuard) Health(ctx context.Context) error {
+	return g.next.Health(ctx)
+}
+
+// Close does nothing; next is closed by its owner.
+func (g *ReadOnlyGuard) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+func TestReadOnlyGuard(t *testing.T) {
+	next := &recordingPublisher{failures: 4}
+	guard := NewReadOnlyGuard(next, ReadOnlyConfig{Threshold: 3, Retry: time.Minute},
+		slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+
+	publish := func() error {
+		return guard.Publish(context.Background(), products.ProductEvent{ProductID: 1})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := publish(); err == nil {
+			t.Fatal("want the publish error passed through")
+		}
+	}
+	if guard.ReadOnly() {
+		t.Fatal("want writable below the threshold")
+	}
+
+	_ = publish()
+	if !guard.ReadOnly() {
+		t.Fatal("want read-only once the threshold is reached")
+	}
+
+	// Past Retry, writes are let through again, but one more failure
+	// switches straight back.
+	now = now.Add(2 * time.Minute)
+	if guard.ReadOnly() {
+		t.Fatal("want writable again after the retry period")
+	}
+	_ = publish()
+	if !guard.ReadOnly() {
+		t.Fatal("want read-only again after a failure in the retry period")
+	}
+
+	// A successful publish, e.g. by the outbox relay, leaves read-only
+	// mode straight away.
+	if err := publish(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if guard.ReadOnly() {
+		t.Fatal("want writable after a successful publish")
+	}
+	next.failures = 1
+	_ = publish()
+	if guard.ReadOnly() {
+		t.Fatal("want the failure count reset by the success")
+	}
+}
+
+func TestReadOnlyGuard_PublishBatch(t *testing.T) {
+	next := &recordingPublisher{failures: 1}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// The relay sees the coalescer in front of the guard; both must pass a
+	// batch on for it to publish batches at all.
+	pub := NewCoalescingPublisher(NewReadOnlyGuard(next, ReadOnlyConfig{Threshold: 3, Retry: time.Minute}, logger),
+		CoalesceConfig{Window: time.Hour}, logger)
+	events := []products.ProductEvent{{ProductID: 1}, {ProductID: 2}}
+
+	err := pub.PublishBatch(context.Background(), events)
+	var batchErr *products.BatchPublishError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("want a *products.BatchPublishError, got %v", err)
+	}
+	if got := batchErr.Unpublished(); len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatalf("want both events unpublished after the first failed, got %v", got)
+	}
+
+	if err := pub.PublishBatch(context.Background(), events); err != nil {
+		t.Fatalf("publish batch: %v", err)
+	}
+	if got := next.published(); len(got) != 2 || got[0].ProductID != 1 || got[1].ProductID != 2 {
+		t.Fatalf("want the batch published in order, got %+v", got)
+	}
+}
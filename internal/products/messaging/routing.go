@@ -0,0 +1,49 @@
+package messaging
+
+import (
+	"strings"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	// DefaultRoutingKey routes e.g. a product_created event of owner acme
+	// as product.created.acme.
+	DefaultRoutingKey = "{event}.{owner}"
+
+	routingKeyEvent = "{event}"
+	routingKeyOwner = "{owner}"
+	// noOwnerKey stands in for an empty owner, so the key keeps as many
+	// words and a binding like product.*.* still matches.
+	noOwnerKey = "_"
+	// exchangeBindingKey binds the events queue to every key, so its
+	// consumers keep receiving all events.
+	exchangeBindingKey = "#"
+)
+
+// routingKey renders template for event: {event} is the event type with
+// underscores as dots, {owner} the owner made safe for a routing key word.
+func routingKey(template string, event products.ProductEvent) string {
+	return strings.NewReplacer(
+		routingKeyEvent, strings.ReplaceAll(event.EventType, "_", "."),
+		routingKeyOwner, routingKeyWord(event.Owner),
+	).Replace(template)
+}
+
+// routingKeyWord replaces every character but ASCII letters, digits, '-'
+// and '_' with '_'. Dots would split the owner into several words and '*'
+// or '#' read as wildcards in bindings, so "acme corp.eu" becomes
+// "acme_corp_eu"; owners that differ only in such characters share a key.
+func routingKeyWord(s string) string {
+	if s == "" {
+		return noOwnerKey
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
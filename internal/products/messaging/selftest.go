@@ -0,0 +1,79 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrSelfTestTimeout = errors.New("self-test event not received before timeout")
+
+type selfTestChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// SelfTest publishes a synthetic event to a temporary, server-named queue
+// and waits for it to come back, proving the broker accepts publishes and
+// delivers to consumers. The queue is exclusive and auto-deleted, so it
+// disappears with the channel.
+func SelfTest(ctx context.Context, ch selfTestChannel, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	q, err := ch.QueueDeclare(
+		"",
+		false,
+		true,
+		true,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("declare self-test queue: %w", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume self-test queue %q: %w", q.Name, err)
+	}
+
+	payload, err := json.Marshal(products.ProductEvent{
+		EventType: products.EventSelfTest,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal self-test event: %w", err)
+	}
+
+	messageID := uuid.NewString()
+	if err := ch.PublishWithContext(ctx, "", q.Name, false, false, amqp.Publishing{
+		ContentType: contentTypeJSON,
+		MessageId:   messageID,
+		Body:        payload,
+	}); err != nil {
+		return fmt.Errorf("publish self-test event: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (%s)", ErrSelfTestTimeout, timeout)
+		case msg, ok := <-msgs:
+			if !ok {
+				return ErrChannelClosed
+			}
+			if msg.MessageId == messageID {
+				return nil
+			}
+		}
+	}
+}
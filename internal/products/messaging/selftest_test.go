@@ -0,0 +1,81 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type loopbackChannel struct {
+	deliveries chan amqp.Delivery
+	deliver    bool
+	publishErr error
+}
+
+func (l *loopbackChannel) QueueDeclare(_ string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: "amq.gen-selftest"}, nil
+}
+
+func (l *loopbackChannel) Consume(_, _ string, _, _, _, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+	return l.deliveries, nil
+}
+
+func (l *loopbackChannel) PublishWithContext(_ context.Context, _, _ string, _, _ bool, msg amqp.Publishing) error {
+	if l.publishErr != nil {
+		return l.publishErr
+	}
+	if l.deliver {
+		l.deliveries <- amqp.Delivery{MessageId: msg.MessageId, Body: msg.Body}
+	}
+	return nil
+}
+
+func TestSelfTest(t *testing.T) {
+	errBroker := errors.New("broker down")
+
+	tests := []struct {
+		name       string
+		deliver    bool
+		publishErr error
+		wantErr    error
+	}{
+		{
+			name:    "round trip succeeds",
+			deliver: true,
+		},
+		{
+			name:    "event never arrives",
+			wantErr: ErrSelfTestTimeout,
+		},
+		{
+			name:       "publish fails",
+			publishErr: errBroker,
+			wantErr:    errBroker,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &loopbackChannel{
+				deliveries: make(chan amqp.Delivery, 1),
+				deliver:    tt.deliver,
+				publishErr: tt.publishErr,
+			}
+
+			err := SelfTest(context.Background(), ch, 50*time.Millisecond)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
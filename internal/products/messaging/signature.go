@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderSignature is the AMQP or Kafka header carrying the hex HMAC-SHA256
+// of the message body, as sent (after any compression), under a secret
+// shared between publisher and consumers.
+const HeaderSignature = "x-signature"
+
+var (
+	ErrSignatureMismatch = errors.New("event signature does not match")
+	ErrUnsigned          = errors.New("event is not signed")
+)
+
+// Sign returns the HeaderSignature value for body under secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the HeaderSignature in headers against body. An
+// unsigned message is ErrUnsigned, which callers may choose to accept.
+func VerifySignature(secret []byte, headers amqp.Table, body []byte) error {
+	raw, ok := headers[HeaderSignature]
+	if !ok {
+		return ErrUnsigned
+	}
+	signature, ok := raw.(string)
+	if !ok {
+		return fmt.Errorf("%w: header is %T, not a string", ErrSignatureMismatch, raw)
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignatureMismatch, err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
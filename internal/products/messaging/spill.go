@@ -0,0 +1,293 @@
+package messaging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"product-notifications/internal/products"
+)
+
+// ErrSpillFull is returned when the spill file already holds its capacity.
+var ErrSpillFull = errors.New("event spill full")
+
+// spillHeaderLen is the size of the spill file's header line: the byte
+// offset of the oldest unpublished event, zero-padded so it is always
+// rewritten in place.
+const spillHeaderLen = 21
+
+// Spill is a bounded, append-only file of events, one JSON object per
+// line, that the async publisher overflows to instead of dropping or
+// blocking. Published events are not removed from the file one by one: a
+// header line records where the unpublished ones start, and the file is
+// only rewritten once the published part outgrows the rest. Whatever is
+// left in it when the process stops is replayed by the next publisher
+// opened on the same path.
+type Spill struct {
+	path     string
+	capacity int
+
+	mu    sync.Mutex
+	count int
+	// head is the offset of the oldest unpublished event, size the end of
+	// the last complete one.
+	head, size int64
+}
+
+// OpenSpill opens the spill file at path, creating it if needed, and counts
+// the events left in it by a previous run. A last line cut short by a
+// crash mid-write is truncated away. capacity is the most events it holds
+// at once.
+func OpenSpill(path string, capacity int) (*Spill, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("spill capacity must be positive, got %d", capacity)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open spill: %w", err)
+	}
+	defer f.Close()
+
+	s := &Spill{path: path, capacity: capacity}
+	if s.head, err = readSpillHeader(f); err != nil {
+		return nil, fmt.Errorf("read spill %s: %w", path, err)
+	}
+
+	// Count the complete events after the header; a previous run may have
+	// published some of them already, and they are skipped past.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat spill: %w", err)
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, spillHeaderLen, info.Size()-spillHeaderLen))
+	s.size = spillHeaderLen
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read spill %s: %w", path, err)
+		}
+		if s.size >= s.head {
+			if !json.Valid(line) {
+				return nil, fmt.Errorf("read spill %s: invalid event at offset %d", path, s.size)
+			}
+			s.count++
+		}
+		s.size += int64(len(line))
+	}
+	if err := f.Truncate(s.size); err != nil {
+		return nil, fmt.Errorf("truncate spill: %w", err)
+	}
+	// A head past the end is left by a crash between emptying the file
+	// and resetting the header: everything was published.
+	s.head = min(s.head, s.size)
+	return s, nil
+}
+
+// readSpillHeader returns the head offset f's header line records, writing
+// the header of an empty spill to a new file.
+func readSpillHeader(f *os.File) (int64, error) {
+	var header [spillHeaderLen]byte
+	// A short read is a new file, or one whose header was cut short as
+	// it was created; neither holds events yet.
+	if _, err := f.ReadAt(header[:], 0); errors.Is(err, io.EOF) {
+		return spillHeaderLen, writeSpillHeader(f, spillHeaderLen)
+	} else if err != nil {
+		return 0, err
+	}
+	head, err := strconv.ParseInt(string(header[:spillHeaderLen-1]), 10, 64)
+	if err != nil || header[spillHeaderLen-1] != '\n' || head < spillHeaderLen {
+		return 0, errors.New("invalid header")
+	}
+	return head, nil
+}
+
+func writeSpillHeader(f *os.File, head int64) error {
+	_, err := f.WriteAt([]byte(fmt.Sprintf("%0*d\n", spillHeaderLen-1, head)), 0)
+	return err
+}
+
+// Len reports how many events are waiting in the spill.
+func (s *Spill) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// pushLocked appends event, or returns ErrSpillFull at capacity. Callers
+// hold mu.
+func (s *Spill) pushLocked(event products.ProductEvent) error {
+	if s.count >= s.capacity {
+		return ErrSpillFull
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal spilled event: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open spill: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write spill: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close spill: %w", err)
+	}
+	s.count++
+	s.size += int64(len(line)) + 1
+	return nil
+}
+
+// peek returns up to n of the oldest spilled events without removing them.
+func (s *Spill) peek(n int) ([]products.ProductEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines, err := s.lines(n)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]products.ProductEvent, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal(line, &events[i]); err != nil {
+			return nil, fmt.Errorf("read spill %s: %w", s.path, err)
+		}
+	}
+	return events, nil
+}
+
+// drop removes the n oldest spilled events by moving the header's head
+// past them. Once every event is published the file is emptied, and once
+// the published part is larger than the rest it is compacted through a
+// temporary file, so a crash leaves either the old or the new contents.
+func (s *Spill) drop(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines, err := s.lines(n)
+	if err != nil {
+		return err
+	}
+	head := s.head
+	for _, line := range lines {
+		head += int64(len(line))
+	}
+
+	if len(lines) == s.count {
+		return s.empty()
+	}
+	if head-spillHeaderLen > s.size-head {
+		err = s.compact(head)
+	} else {
+		err = s.setHead(head)
+	}
+	if err != nil {
+		return err
+	}
+	s.count -= len(lines)
+	return nil
+}
+
+// setHead records head as the offset of the oldest unpublished event.
+func (s *Spill) setHead(head int64) error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open spill: %w", err)
+	}
+	if err := writeSpillHeader(f, head); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write spill: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close spill: %w", err)
+	}
+	s.head = head
+	return nil
+}
+
+// empty truncates the file to its header. Failing to reset the header, or
+// a crash before it is, leaves a head past the end, which OpenSpill reads
+// as empty.
+func (s *Spill) empty() error {
+	if err := os.Truncate(s.path, spillHeaderLen); err != nil {
+		return fmt.Errorf("truncate spill: %w", err)
+	}
+	s.count = 0
+	s.head, s.size = spillHeaderLen, spillHeaderLen
+	return s.setHead(spillHeaderLen)
+}
+
+// compact replaces the file with one holding only the events from head on.
+func (s *Spill) compact(head int64) error {
+	src, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("open spill: %w", err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create spill: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeSpillHeader(tmp, spillHeaderLen); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write spill: %w", err)
+	}
+	if _, err := tmp.Seek(spillHeaderLen, io.SeekStart); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write spill: %w", err)
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(src, head, s.size-head)); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write spill: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close spill: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace spill: %w", err)
+	}
+	s.size = spillHeaderLen + s.size - head
+	s.head = spillHeaderLen
+	return nil
+}
+
+// lines reads up to n of the oldest unpublished events as raw lines,
+// newline included. Callers hold mu.
+func (s *Spill) lines(n int) ([][]byte, error) {
+	n = min(n, s.count)
+	if n <= 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("open spill: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(f, s.head, s.size-s.head))
+	lines := make([][]byte, 0, n)
+	for len(lines) < n {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read spill %s: %w", s.path, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"product-notifications/internal/products"
+)
+
+// spillEvents appends an event for each product id to s.
+func spillEvents(t *testing.T, s *Spill, ids ...int64) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if err := s.pushLocked(products.ProductEvent{ProductID: id}); err != nil {
+			t.Fatalf("spill %d: %v", id, err)
+		}
+	}
+}
+
+func reopenSpill(t *testing.T, path string) *Spill {
+	t.Helper()
+	s, err := OpenSpill(path, 10)
+	if err != nil {
+		t.Fatalf("open spill: %v", err)
+	}
+	return s
+}
+
+func assertSpilled(t *testing.T, s *Spill, want ...int64) {
+	t.Helper()
+	if got := s.Len(); got != len(want) {
+		t.Fatalf("want %d events spilled, got %d", len(want), got)
+	}
+	events, err := s.peek(len(want) + 1)
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("want %d events, got %v", len(want), events)
+	}
+	for i, event := range events {
+		if event.ProductID != want[i] {
+			t.Fatalf("want events %v, got %v", want, events)
+		}
+	}
+}
+
+func TestSpill_TruncatesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.spill")
+	spillEvents(t, reopenSpill(t, path), 1, 2)
+
+	// A crash in the middle of writing event 3.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"product_id":3,"ev`); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	s := reopenSpill(t, path)
+	assertSpilled(t, s, 1, 2)
+
+	spillEvents(t, s, 4)
+	assertSpilled(t, reopenSpill(t, path), 1, 2, 4)
+}
+
+func TestSpill_DropKeepsPlaceAcrossRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.spill")
+	s := reopenSpill(t, path)
+	spillEvents(t, s, 1, 2, 3, 4)
+
+	// Fewer published than left: only the header moves.
+	if err := s.drop(1); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	s = reopenSpill(t, path)
+	assertSpilled(t, s, 2, 3, 4)
+
+	// More published than left: the file is compacted.
+	before, _ := os.Stat(path)
+	if err := s.drop(2); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("want the spill compacted, size went from %d to %d", before.Size(), after.Size())
+	}
+	s = reopenSpill(t, path)
+	assertSpilled(t, s, 4)
+
+	// Everything published: the file holds only its header.
+	if err := s.drop(1); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != spillHeaderLen {
+		t.Fatalf("want an emptied spill, got %d bytes", info.Size())
+	}
+	assertSpilled(t, reopenSpill(t, path))
+}
@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSetupTimeout is returned when the broker does not answer a setup call
+// such as a queue declaration within the configured timeout.
+var ErrSetupTimeout = errors.New("broker did not answer in time")
+
+// CallWithTimeout runs fn and returns its result, or ErrSetupTimeout once
+// timeout passes without one; zero waits as long as fn does. AMQP calls
+// take no context, so a timed-out fn keeps running until its channel is
+// closed, which callers must do.
+func CallWithTimeout[T any](timeout time.Duration, fn func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		var zero T
+		return zero, fmt.Errorf("%w after %s", ErrSetupTimeout, timeout)
+	}
+}
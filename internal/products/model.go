@@ -2,29 +2,284 @@ package products
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
 	ErrNotFound    = errors.New("product not found")
 	ErrInvalidName = errors.New("product name is required")
+
+	ErrNameTooLong      = errors.New("product name is too long")
+	ErrNameTooShort     = errors.New("product name is too short")
+	ErrNameControlChars = errors.New("product name contains control characters")
+
+	ErrWebhookRejected    = errors.New("product rejected by create webhook")
+	ErrAttributesTooLarge = errors.New("product attributes are too large")
+	ErrDuplicateName      = errors.New("product with this name already exists")
+	ErrBatchTooLarge      = errors.New("too many products in one request")
+	ErrQueryTimeout       = errors.New("query exceeded its statement timeout")
+	ErrQuotaExceeded      = errors.New("owner has reached their product quota")
+	ErrNameNotAllowed     = errors.New("product name is not allowed")
+	ErrExpiryInPast       = errors.New("product expiry must be in the future")
+	ErrInvalidStatus      = errors.New("product status must be draft, published or archived")
+	ErrInvalidTransition  = errors.New("product status cannot change that way")
+	ErrAlreadyReserved    = errors.New("product is already reserved")
+	ErrReservedByOther    = errors.New("product is reserved by someone else")
+)
+
+// DuplicateNameError is ErrDuplicateName naming the product that already
+// holds the name.
+type DuplicateNameError struct {
+	ExistingID       int64
+	ExistingPublicID string
+}
+
+func (e *DuplicateNameError) Error() string { return ErrDuplicateName.Error() }
+
+func (e *DuplicateNameError) Unwrap() error { return ErrDuplicateName }
+
+// BatchPublishError reports a batch publish that stopped at a chunk the
+// broker did not fully accept. Failed holds the batch indexes of that
+// chunk's events that were not confirmed; the events from Unsent on were
+// never sent.
+type BatchPublishError struct {
+	Chunk  int
+	Failed []int
+	Unsent int
+	Total  int
+	Err    error
+}
+
+func (e *BatchPublishError) Error() string {
+	return fmt.Sprintf("publish batch chunk %d: %d events failed, %d unsent: %v",
+		e.Chunk, len(e.Failed), e.Total-e.Unsent, e.Err)
+}
+
+func (e *BatchPublishError) Unwrap() error { return e.Err }
+
+// Unpublished returns the indexes of every event to retry: Failed, then
+// Unsent through the end of the batch.
+func (e *BatchPublishError) Unpublished() []int {
+	indexes := append([]int(nil), e.Failed...)
+	for i := e.Unsent; i < e.Total; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// A product's lifecycle runs draft, published, archived, in that order and
+// one step at a time. Products are published unless created as drafts.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
 )
 
+// ValidStatus reports whether status is one of the lifecycle statuses.
+func ValidStatus(status string) bool {
+	return status == StatusDraft || status == StatusPublished || status == StatusArchived
+}
+
+// CanTransition reports whether a product may move from one status to
+// another: draft to published, or published to archived.
+func CanTransition(from, to string) bool {
+	return (from == StatusDraft && to == StatusPublished) ||
+		(from == StatusPublished && to == StatusArchived)
+}
+
 const (
 	EventsQueue  = "products.events"
 	EventCreated = "product_created"
 	EventDeleted = "product_deleted"
+	EventUpdated = "product_updated"
+	// EventExpired is published by the expiry sweeper once a product's
+	// expires_at has passed; its timestamp is the expiry time.
+	EventExpired = "product_expired"
+	// EventStatusChanged is published when a product moves through its
+	// lifecycle; Status and PreviousStatus say from where to where.
+	EventStatusChanged = "product_status_changed"
+	// EventReservationExpired is published by the reservation sweeper
+	// once it has released a reservation that ran out; its timestamp is
+	// when the reservation ended.
+	EventReservationExpired = "product_reservation_expired"
+	// EventCreatedBatch carries several product_created events, coalesced
+	// by the publisher, in its Products field. Its own ProductID and
+	// AggregateVersion are zero: consumers handle each of Products as a
+	// product_created of its own.
+	EventCreatedBatch = "products_created_batch"
+	// EventHeartbeat is published periodically by every instance, when
+	// enabled, with no product; its InstanceID names the sender.
+	EventHeartbeat = "products_heartbeat"
+
+	// EventSelfTest is only ever published to a throwaway queue by the
+	// startup self-test; it never reaches EventsQueue.
+	EventSelfTest = "self_test"
 )
 
 type Product struct {
-	ID        int64     `json:"id" example:"1"`
-	Name      string    `json:"name" example:"iPhone 16"`
-	CreatedAt time.Time `json:"created_at" example:"2026-02-24T12:00:00Z"`
+	// ID is the internal id. It is left out of API responses when
+	// products are addressed by PublicID.
+	ID         int64          `json:"id,omitempty" example:"1"`
+	PublicID   string         `json:"public_id,omitempty" example:"0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"`
+	Name       string         `json:"name" example:"iPhone 16"`
+	Owner      string         `json:"owner,omitempty" example:"acme"`
+	Attributes map[string]any `json:"attributes,omitempty" swaggertype:"object"`
+	CreatedAt  time.Time      `json:"created_at" example:"2026-02-24T12:00:00Z"`
+	// ExpiresAt, when set, hides the product from reads once it passes.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2026-12-31T23:59:59Z"`
+	// Version starts at 1 and goes up by one with every change to the
+	// product.
+	Version int64 `json:"version,omitempty" example:"1"`
+	// Slug is the URL-friendly, unique form of the name; empty for
+	// products created before slugs were introduced or with them off.
+	Slug string `json:"slug,omitempty" example:"iphone-16"`
+	// Status is where the product is in its lifecycle: draft, published
+	// or archived.
+	Status string `json:"status,omitempty" example:"published"`
 }
 
+// Reservation is a product held for someone, e.g. an order being checked
+// out, until it is released or runs out.
+type Reservation struct {
+	// ProductID is left out of API responses, in favour of
+	// ProductPublicID, when products are addressed by public id.
+	ProductID       int64     `json:"product_id,omitempty" example:"1"`
+	ProductPublicID string    `json:"product_public_id,omitempty" example:"0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"`
+	ReservedBy      string    `json:"reserved_by" example:"order-1234"`
+	ReservedUntil   time.Time `json:"reserved_until" example:"2026-02-24T12:15:00Z"`
+}
+
+// ListPosition is where a product sits in the default product list,
+// newest first: its 1-based Position, and the Page holding it when pages
+// hold Limit products.
+type ListPosition struct {
+	Position int64 `json:"position" example:"23"`
+	Page     int64 `json:"page" example:"3"`
+	Limit    int   `json:"limit" example:"10"`
+}
+
+// CreateInput carries the client-supplied fields of a new product.
+type CreateInput struct {
+	Name string
+	// Owner is the tenant the product belongs to; empty for none.
+	Owner      string
+	Attributes map[string]any
+	// ExpiresAt is when the product expires; nil for never.
+	ExpiresAt *time.Time
+	// Status is StatusDraft or StatusPublished; empty for published.
+	Status string
+}
+
+// CreateResult is the outcome of one item of a partial bulk create: Product
+// when it was created, Err when it was not.
+type CreateResult struct {
+	Index   int
+	Product Product
+	Err     error
+}
+
+// ListOptions narrows which products List and Count consider. The zero
+// value matches every product.
+type ListOptions struct {
+	// Search matches products whose name contains the term.
+	Search string
+	// Rank matches Search against the words of the name instead, each
+	// search word as a prefix of one, and lists the matches by relevance.
+	// A search without any word falls back to the substring match.
+	Rank bool
+	// Attributes matches products whose attributes contain every given
+	// key/value pair (JSONB containment).
+	Attributes map[string]any
+	// ExactCount makes Count run an exact COUNT(*) even when the
+	// repository would otherwise estimate an unfiltered total.
+	ExactCount bool
+	// IncludeExpired also matches products whose expires_at has passed.
+	IncludeExpired bool
+	// Status matches products in that lifecycle status; empty matches
+	// published ones.
+	Status string
+	// MinID and MaxID bound the product id, inclusively, so the catalog
+	// can be processed in id windows; zero leaves that side open.
+	MinID int64
+	MaxID int64
+}
+
+// FieldChange is one changed field of a product_updated event. New is
+// absent when the field was removed; Old is only published when the
+// service is configured to include old values.
+type FieldChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// Filtered reports whether o narrows the products by search or attributes.
+func (o ListOptions) Filtered() bool {
+	return o.Search != "" || len(o.Attributes) > 0
+}
+
+// ListTotals are the counts behind a page of products: Filtered matches
+// the list's search and attribute filters, All ignores them. They are
+// equal for an unfiltered list. Approximate reports that All, and so for
+// an unfiltered list Filtered too, is the planner's estimate.
+type ListTotals struct {
+	Filtered    int64
+	All         int64
+	Approximate bool
+}
+
+// ExpiryCursor marks the last expired product the sweeper announced.
+// Expiries are swept in (ExpiresAt, ProductID) order, so everything up to
+// and including the cursor has been published.
+type ExpiryCursor struct {
+	ExpiresAt time.Time
+	ProductID int64
+}
+
+// EventSchemaVersion is the layout of ProductEvent this code publishes.
+// Raise it when a change would make older consumers misread events.
+const EventSchemaVersion = 1
+
 type ProductEvent struct {
-	EventType string    `json:"event_type"`
-	ProductID int64     `json:"product_id"`
-	Name      string    `json:"name,omitempty"`
+	EventType string `json:"event_type"`
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name,omitempty"`
+	// Owner is the product's tenant, empty for none.
+	Owner     string    `json:"owner,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+	// Replay marks an event re-published on request rather than caused by
+	// a change, so consumers can apply it idempotently.
+	Replay bool `json:"replay,omitempty"`
+	// AggregateVersion is the product's version after the change, one
+	// past its last stored version for a delete. Consumers can drop an
+	// event whose version is below one they already handled. Replays
+	// repeat the current version.
+	AggregateVersion int64 `json:"aggregate_version,omitempty"`
+	// ChangedFields lists, sorted, the fields a product_updated changed;
+	// an attribute is "attributes.<key>". Empty when the update changed
+	// nothing.
+	ChangedFields []string `json:"changed_fields,omitempty"`
+	// Changes holds each changed field's values, keyed like
+	// ChangedFields.
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+	// Status and PreviousStatus are the product's lifecycle status after
+	// and before a product_status_changed.
+	Status         string `json:"status,omitempty"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	// InstanceID names the instance that sent a products_heartbeat.
+	InstanceID string `json:"instance_id,omitempty"`
+	// Products holds the coalesced events of a products_created_batch.
+	Products []ProductEvent `json:"products,omitempty"`
+
+	// SchemaVersion is the layout the event was written in; zero, as in
+	// events published before versions existed, means 1.
+	SchemaVersion int `json:"schema_version,omitempty"`
+}
+
+// Schema is the event's SchemaVersion, reading an unset one as 1.
+func (e ProductEvent) Schema() int {
+	if e.SchemaVersion == 0 {
+		return 1
+	}
+	return e.SchemaVersion
 }
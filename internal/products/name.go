@@ -0,0 +1,24 @@
+package products
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxNameLength is the longest product name allowed, in characters.
+const MaxNameLength = 200
+
+// ValidateName checks an already trimmed product name.
+func ValidateName(name string) error {
+	if name == "" {
+		return ErrInvalidName
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return ErrNameTooLong
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return ErrNameControlChars
+	}
+	return nil
+}
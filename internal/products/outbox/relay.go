@@ -0,0 +1,134 @@
+// Package outbox publishes events that were written to the outbox table in
+// the same transaction as the change they describe.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	defaultInterval  = time.Second
+	defaultBatchSize = 100
+)
+
+type Store interface {
+	RelayOutbox(ctx context.Context, limit int, publish func(context.Context, products.ProductEvent) error) (int, error)
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event products.ProductEvent) error
+}
+
+// BatchStore and BatchPublisher are implemented by stores and publishers
+// that can relay a whole claimed batch in one call. When both are, the
+// relay uses them, and a *products.BatchPublishError leaves the events from
+// the first one it names onwards unpublished.
+type BatchStore interface {
+	RelayOutboxBatch(ctx context.Context, limit int, publish func(context.Context, []products.ProductEvent) error) (int, error)
+}
+
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, events []products.ProductEvent) error
+}
+
+// Leader elects the one relay allowed to publish when several run.
+type Leader interface {
+	// Lead reports whether this relay is the leader, trying to become it
+	// if it is not.
+	Lead(ctx context.Context) (bool, error)
+	// Resign gives up leadership.
+	Resign()
+}
+
+type Config struct {
+	// Interval is how long the relay sleeps once the outbox is drained.
+	Interval time.Duration
+	// BatchSize is how many events are claimed per transaction.
+	BatchSize int
+	// Leader, when set, lets only the elected relay publish; the others
+	// ask again every Interval and take over if the leader goes away.
+	// Unset, every relay publishes and SKIP LOCKED keeps them apart.
+	Leader Leader
+}
+
+type Relay struct {
+	store     Store
+	publisher Publisher
+	cfg       Config
+	logger    *slog.Logger
+	leading   bool
+}
+
+func NewRelay(store Store, publisher Publisher, cfg Config, logger *slog.Logger) *Relay {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	return &Relay{store: store, publisher: publisher, cfg: cfg, logger: logger}
+}
+
+// Run relays events until ctx is done. Full batches are followed straight
+// away by the next one; otherwise the relay waits Interval. With a Leader
+// the relay publishes only while it leads and resigns when it returns.
+func (r *Relay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	if r.cfg.Leader != nil {
+		defer r.cfg.Leader.Resign()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := r.cfg.Interval
+		if !r.lead(ctx) {
+			timer.Reset(wait)
+			continue
+		}
+		n, err := r.relay(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			r.logger.Error("relay outbox", "published", n, "error", err)
+		case err == nil && n == r.cfg.BatchSize:
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// relay relays one batch, in a single publish when the store and publisher
+// both support it.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	if store, ok := r.store.(BatchStore); ok {
+		if publisher, ok := r.publisher.(BatchPublisher); ok {
+			return store.RelayOutboxBatch(ctx, r.cfg.BatchSize, publisher.PublishBatch)
+		}
+	}
+	return r.store.RelayOutbox(ctx, r.cfg.BatchSize, r.publisher.Publish)
+}
+
+// lead reports whether the relay may publish, logging leadership changes.
+func (r *Relay) lead(ctx context.Context) bool {
+	if r.cfg.Leader == nil {
+		return true
+	}
+	leading, err := r.cfg.Leader.Lead(ctx)
+	if err != nil && ctx.Err() == nil {
+		r.logger.Error("outbox relay leader election", "error", err)
+	}
+	if leading != r.leading {
+		r.leading = leading
+		r.logger.Info("outbox relay leadership changed", "leader", leading)
+	}
+	return leading
+}
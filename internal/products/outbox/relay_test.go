@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+// fakeStore serves pending in FIFO order and keeps an event pending while
+// its publish fails, like the outbox table does.
+type fakeStore struct {
+	mu      sync.Mutex
+	pending []products.ProductEvent
+	calls   int
+}
+
+func (f *fakeStore) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, products.ProductEvent) error) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+
+	n := 0
+	for n < limit && len(f.pending) > 0 {
+		if err := publish(ctx, f.pending[0]); err != nil {
+			return n, err
+		}
+		f.pending = f.pending[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeStore) remaining() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pending)
+}
+
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failures  int
+	published []int64
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, event products.ProductEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker down")
+	}
+	p.published = append(p.published, event.ProductID)
+	return nil
+}
+
+func TestRelay_DrainsInOrderAndRetries(t *testing.T) {
+	store := &fakeStore{}
+	for id := int64(1); id <= 5; id++ {
+		store.pending = append(store.pending, products.ProductEvent{EventType: products.EventCreated, ProductID: id})
+	}
+	pub := &flakyPublisher{failures: 1}
+	relay := NewRelay(store, pub, Config{Interval: time.Millisecond, BatchSize: 2}, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for store.remaining() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox not drained, %d events left", store.remaining())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	if len(pub.published) != 5 {
+		t.Fatalf("want 5 events published exactly once, got %v", pub.published)
+	}
+	for i, id := range pub.published {
+		if id != int64(i+1) {
+			t.Fatalf("want events in outbox order, got %v", pub.published)
+		}
+	}
+}
+
+// fakeLeader leads while leading is set and records resignations.
+type fakeLeader struct {
+	leading  atomic.Bool
+	resigned atomic.Bool
+}
+
+func (l *fakeLeader) Lead(context.Context) (bool, error) { return l.leading.Load(), nil }
+func (l *fakeLeader) Resign()                            { l.resigned.Store(true) }
+
+func TestRelay_PublishesOnlyWhileLeading(t *testing.T) {
+	store := &fakeStore{pending: []products.ProductEvent{{EventType: products.EventCreated, ProductID: 1}}}
+	pub := &flakyPublisher{}
+	leader := &fakeLeader{}
+	relay := NewRelay(store, pub, Config{Interval: time.Millisecond, Leader: leader}, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	store.mu.Lock()
+	calls := store.calls
+	store.mu.Unlock()
+	if calls != 0 {
+		t.Fatalf("want no relaying while not leading, got %d calls", calls)
+	}
+
+	leader.leading.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for store.remaining() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("leader did not drain the outbox")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if !leader.resigned.Load() {
+		t.Fatal("want the relay to resign leadership when it stops")
+	}
+}
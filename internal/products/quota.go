@@ -0,0 +1,16 @@
+package products
+
+// OwnerQuota caps how many products a single owner may hold. Overrides
+// replaces Default for the owners it lists; a limit of zero is unlimited.
+type OwnerQuota struct {
+	Default   int64
+	Overrides map[string]int64
+}
+
+// Limit returns the quota that applies to owner.
+func (q OwnerQuota) Limit(owner string) int64 {
+	if limit, ok := q.Overrides[owner]; ok {
+		return limit
+	}
+	return q.Default
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/lib/pq"
+)
+
+// AuditSink receives the audit record of every mutation WithAudit covers.
+type AuditSink interface {
+	Audit(ctx context.Context, rec products.AuditRecord) error
+}
+
+// WithAudit records every product mutation, reservations included, in
+// sink before the mutation's transaction commits. An *AuditLog writes its
+// row in that transaction, so the record and the change commit together;
+// other sinks are written just before the commit. With strict a record
+// that cannot be written rolls the mutation back and fails it with
+// products.ErrAuditFailed; otherwise the failure is logged and the
+// mutation goes ahead unaudited.
+func WithAudit(sink AuditSink, strict bool, logger *slog.Logger) Option {
+	return func(r *PostgresRepository) {
+		r.auditSink = sink
+		r.auditStrict = strict
+		r.auditLogger = logger
+	}
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	querier
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// mutate runs fn in a transaction it commits once fn succeeds, so an audit
+// record fn writes commits or rolls back with the change. Without auditing
+// a single statement needs no transaction, and fn runs straight on the
+// pool.
+func (r *PostgresRepository) mutate(ctx context.Context, fn func(q execer) error) error {
+	if r.auditSink == nil {
+		return fn(r.db)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// audit stamps rec with the time and the actor and request id ctx carries
+// and writes it for a mutation running in tx, if auditing is on. A failed
+// write is logged, and only returned, as products.ErrAuditFailed, when
+// auditing is strict.
+func (r *PostgresRepository) audit(ctx context.Context, tx execer, rec products.AuditRecord) error {
+	if r.auditSink == nil {
+		return nil
+	}
+	rec.Time = time.Now().UTC()
+	rec.Actor = products.Actor(ctx)
+	rec.RequestID = products.RequestID(ctx)
+
+	var err error
+	if _, ok := r.auditSink.(*AuditLog); ok {
+		err = r.insertAuditRecord(ctx, tx, rec)
+	} else {
+		err = r.auditSink.Audit(ctx, rec)
+	}
+	if err == nil {
+		return nil
+	}
+	r.auditLogger.Error("write audit record failed",
+		"action", rec.Action,
+		"product_id", rec.ProductID,
+		"error", err,
+	)
+	if r.auditStrict {
+		return fmt.Errorf("%w: %v", products.ErrAuditFailed, err)
+	}
+	return nil
+}
+
+// insertAuditRecord inserts rec in tx. A lenient failure must leave tx
+// usable for the mutation to commit, so the insert then runs under a
+// savepoint that a failure rolls back to.
+func (r *PostgresRepository) insertAuditRecord(ctx context.Context, tx execer, rec products.AuditRecord) error {
+	if r.auditStrict {
+		return insertAuditRecord(ctx, tx, rec)
+	}
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT audit`); err != nil {
+		return fmt.Errorf("savepoint audit: %w", err)
+	}
+	if err := insertAuditRecord(ctx, tx, rec); err != nil {
+		_, _ = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT audit`)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT audit`)
+	return err
+}
+
+// AuditLog writes audit records to the audit_log table, which a trigger
+// keeps append-only. Given to WithAudit, it writes each record in the
+// transaction of the mutation it describes.
+type AuditLog struct {
+	db *sql.DB
+}
+
+// NewAuditLog returns an AuditLog writing to db.
+func NewAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{db: db}
+}
+
+// Audit inserts rec as one audit_log row of its own.
+func (a *AuditLog) Audit(ctx context.Context, rec products.AuditRecord) error {
+	return insertAuditRecord(ctx, a.db, rec)
+}
+
+func insertAuditRecord(ctx context.Context, q execer, rec products.AuditRecord) error {
+	before, err := auditSnapshot(rec.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditSnapshot(rec.After)
+	if err != nil {
+		return err
+	}
+	reservation, err := auditSnapshot(rec.Reservation)
+	if err != nil {
+		return err
+	}
+
+	var productID, productIDs, count any
+	if rec.ProductID != 0 {
+		productID = rec.ProductID
+	}
+	if rec.ProductIDs != nil {
+		productIDs = pq.Array(rec.ProductIDs)
+		count = rec.Count
+	}
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO audit_log (occurred_at, action, product_id, product_ids, count, actor, request_id, before, after, reservation)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, rec.Time, rec.Action, productID, productIDs, count, rec.Actor, rec.RequestID, before, after, reservation); err != nil {
+		return fmt.Errorf("insert audit record: %w", err)
+	}
+	return nil
+}
+
+// auditSnapshot returns v as a JSONB parameter, or nil for none. Like
+// encodeAttributes it returns a string so lib/pq does not send bytea.
+func auditSnapshot[T any](v *T) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit snapshot: %w", err)
+	}
+	return string(raw), nil
+}
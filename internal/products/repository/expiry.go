@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"product-notifications/internal/products"
+)
+
+// ExpiryCursor returns where the expiry sweeper left off.
+func (r *PostgresRepository) ExpiryCursor(ctx context.Context) (products.ExpiryCursor, error) {
+	var cursor products.ExpiryCursor
+	err := r.db.QueryRowContext(ctx, `SELECT expires_at, product_id FROM expiry_cursor`).
+		Scan(&cursor.ExpiresAt, &cursor.ProductID)
+	if err != nil {
+		return products.ExpiryCursor{}, fmt.Errorf("get expiry cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SaveExpiryCursor records that every expiry up to cursor was announced.
+func (r *PostgresRepository) SaveExpiryCursor(ctx context.Context, cursor products.ExpiryCursor) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE expiry_cursor SET expires_at = $1, product_id = $2`, cursor.ExpiresAt, cursor.ProductID)
+	if err != nil {
+		return fmt.Errorf("save expiry cursor: %w", err)
+	}
+	return nil
+}
+
+// ListExpired returns up to limit products that have expired since after,
+// in (expires_at, id) order, so the last one is the next cursor.
+func (r *PostgresRepository) ListExpired(ctx context.Context, after products.ExpiryCursor, limit int) ([]products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE expires_at <= now() AND (expires_at, id) > ($1, $2)
+		ORDER BY expires_at, id
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, after.ExpiresAt, after.ProductID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query expired products: %w", err)
+	}
+	defer rows.Close()
+
+	var expired []products.Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan expired product: %w", err)
+		}
+		expired = append(expired, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate expired products: %w", err)
+	}
+	return expired, nil
+}
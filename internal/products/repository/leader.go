@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// AdvisoryLeader elects one leader among processes sharing a database. The
+// leader holds a session advisory lock on a connection it keeps out of the
+// pool; if that session ends, Postgres drops the lock and another process
+// can take over.
+type AdvisoryLeader struct {
+	db   *sql.DB
+	name string
+	conn *sql.Conn
+}
+
+// NewAdvisoryLeader returns an AdvisoryLeader for the lock called name.
+// Processes compete only with others using the same name.
+func NewAdvisoryLeader(db *sql.DB, name string) *AdvisoryLeader {
+	return &AdvisoryLeader{db: db, name: name}
+}
+
+// Lead reports whether this process is the leader. A leader confirms it
+// still holds the lock; anyone else tries to take it without waiting.
+// Lead is not safe for concurrent use.
+func (l *AdvisoryLeader) Lead(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		var held bool
+		err := l.conn.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM pg_locks
+				WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND granted
+					AND classid = $1::int::oid AND objsubid = 2
+			)
+		`, lockClassLeader).Scan(&held)
+		if err == nil && held {
+			return true, nil
+		}
+		l.Resign()
+		if err != nil {
+			return false, fmt.Errorf("check advisory lock: %w", err)
+		}
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("get leader connection: %w", err)
+	}
+	var won bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1::int, hashtext($2))`, lockClassLeader, l.name).Scan(&won); err != nil {
+		discardConn(conn)
+		return false, fmt.Errorf("try advisory lock: %w", err)
+	}
+	if !won {
+		_ = conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Resign gives up leadership by ending the session that holds the lock.
+func (l *AdvisoryLeader) Resign() {
+	if l.conn == nil {
+		return
+	}
+	discardConn(l.conn)
+	l.conn = nil
+}
+
+// discardConn closes the connection behind conn instead of returning it to
+// the pool, so any session state on it, advisory locks included, goes too.
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
@@ -0,0 +1,15 @@
+package repository
+
+// Advisory locks are taken with the two-key form, pg_advisory_lock(class,
+// hashtext(key)), each purpose under its own class. A key hashed on its own
+// could collide with another purpose's key, or with a lock some other
+// application takes in the same database, and the two would block each
+// other for no reason.
+const (
+	lockClassLeader int32 = 0x70a0001 + iota
+	// LockClassMigrations serialises schema migrations across instances.
+	LockClassMigrations
+	lockClassProductName
+	lockClassOwnerQuota
+	lockClassSlug
+)
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"product-notifications/internal/products"
+
+	"github.com/lib/pq"
+)
+
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, event products.ProductEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal outbox event: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (payload) VALUES ($1)`, string(payload)); err != nil {
+		return fmt.Errorf("insert outbox event: %w", err)
+	}
+	return nil
+}
+
+// QueueBehindOutbox writes event to the outbox when its product still has
+// an unpublished event there, so the relay publishes it after that one, and
+// reports whether it did. Otherwise the caller publishes event itself.
+func (r *PostgresRepository) QueueBehindOutbox(ctx context.Context, event products.ProductEvent) (bool, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("marshal outbox event: %w", err)
+	}
+	query := `
+		INSERT INTO outbox (payload)
+		SELECT $1
+		WHERE EXISTS (
+			SELECT 1 FROM outbox
+			WHERE published_at IS NULL AND payload->>'product_id' = $2
+		)
+	`
+	res, err := r.db.ExecContext(ctx, query, string(payload), strconv.FormatInt(event.ProductID, 10))
+	if err != nil {
+		return false, fmt.Errorf("queue outbox event: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("queue outbox event: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RelayOutbox hands up to limit unpublished outbox events, oldest first, to
+// publish and marks the ones it accepted as published. It stops at the
+// first publish error so ordering is kept; the rest are retried on the next
+// call. Rows are claimed with SKIP LOCKED, so concurrent relays never
+// publish the same event twice, though a crash between publish and commit
+// will (delivery is at least once).
+func (r *PostgresRepository) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, products.ProductEvent) error) (int, error) {
+	return r.relayOutbox(ctx, limit, func(claimed []outboxRow) ([]int64, error) {
+		var published []int64
+		for _, row := range claimed {
+			if err := publish(ctx, row.event); err != nil {
+				return published, fmt.Errorf("publish outbox event %d: %w", row.id, err)
+			}
+			published = append(published, row.id)
+		}
+		return published, nil
+	})
+}
+
+// RelayOutboxBatch is RelayOutbox handing the claimed events to publish in
+// one call. When publish fails with a *products.BatchPublishError, the
+// events before the first one it lists as unpublished are marked published
+// and the rest are retried, even those that went out; any other error marks
+// none.
+func (r *PostgresRepository) RelayOutboxBatch(ctx context.Context, limit int, publish func(context.Context, []products.ProductEvent) error) (int, error) {
+	return r.relayOutbox(ctx, limit, func(claimed []outboxRow) ([]int64, error) {
+		events := make([]products.ProductEvent, len(claimed))
+		for i, row := range claimed {
+			events[i] = row.event
+		}
+
+		err := publish(ctx, events)
+		if err == nil {
+			ids := make([]int64, len(claimed))
+			for i, row := range claimed {
+				ids[i] = row.id
+			}
+			return ids, nil
+		}
+
+		var batchErr *products.BatchPublishError
+		if !errors.As(err, &batchErr) {
+			return nil, fmt.Errorf("publish outbox events: %w", err)
+		}
+		// Only the events before the first unpublished one are marked, as
+		// RelayOutbox would: one that went out after a failure is retried
+		// too, so a product's events are never delivered out of order.
+		first := len(claimed)
+		for _, i := range batchErr.Unpublished() {
+			first = min(first, i)
+		}
+		published := make([]int64, first)
+		for i, row := range claimed[:first] {
+			published[i] = row.id
+		}
+		return published, fmt.Errorf("publish outbox events: %w", err)
+	})
+}
+
+type outboxRow struct {
+	id    int64
+	event products.ProductEvent
+}
+
+// relayOutbox claims up to limit unpublished outbox events in a
+// transaction, hands them to publish and marks the ids it returns as
+// published, even when it also returns an error.
+func (r *PostgresRepository) relayOutbox(ctx context.Context, limit int, publish func([]outboxRow) ([]int64, error)) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, payload
+		FROM outbox
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("claim outbox events: %w", err)
+	}
+
+	var claimed []outboxRow
+	for rows.Next() {
+		var (
+			row     outboxRow
+			payload []byte
+		)
+		if err := rows.Scan(&row.id, &payload); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scan outbox event: %w", err)
+		}
+		if err := json.Unmarshal(payload, &row.event); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("decode outbox event %d: %w", row.id, err)
+		}
+		claimed = append(claimed, row)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate outbox events: %w", err)
+	}
+	if len(claimed) == 0 {
+		return 0, nil
+	}
+
+	published, publishErr := publish(claimed)
+
+	if len(published) > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE outbox SET published_at = NOW() WHERE id = ANY($1)`, pq.Array(published)); err != nil {
+			return 0, fmt.Errorf("mark outbox events published: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("commit tx: %w", err)
+		}
+	}
+	return len(published), publishErr
+}
@@ -3,64 +3,770 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"product-notifications/internal/products"
+
+	"github.com/lib/pq"
 )
 
-const healthCheckTimeout = 2 * time.Second
+const (
+	healthCheckTimeout = 2 * time.Second
+
+	// replicaCooldown is how long reads stay on the primary after a replica
+	// query fails before the replica is tried again.
+	replicaCooldown = 30 * time.Second
+
+	// defaultDeleteChunkSize is how many ids DeleteBatch deletes per
+	// statement unless WithDeleteChunkSize says otherwise.
+	defaultDeleteChunkSize = 500
+
+	pgUniqueViolation = "23505"
+	pgQueryCanceled   = "57014"
+)
 
 type PostgresRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	replica *sql.DB
+
+	// replicaDownUntil holds a unix-nano deadline; zero means healthy.
+	replicaDownUntil atomic.Int64
+
+	caseInsensitiveNames bool
+	normalizedSearch     bool
+	// approxCountAbove enables planner-estimated totals for unfiltered
+	// counts once the table is estimated to hold at least this many rows;
+	// zero always counts exactly.
+	approxCountAbove int64
+	ownerQuota       products.OwnerQuota
+	deleteChunkSize  int
+	// slugs, when set, gives every new product a unique slug.
+	slugs *products.SlugConfig
+
+	auditSink   AuditSink
+	auditStrict bool
+	auditLogger *slog.Logger
+}
+
+type Option func(*PostgresRepository)
+
+// WithCaseInsensitiveNames treats names differing only in case as
+// duplicates and makes the search filter case-insensitive.
+func WithCaseInsensitiveNames() Option {
+	return func(r *PostgresRepository) {
+		r.caseInsensitiveNames = true
+	}
+}
+
+// WithNormalizedSearch matches the search filter against search_name, the
+// lower-cased and unaccented copy of name, so "iphone" finds "íPhone 16".
+// Names are still stored and returned exactly as given.
+func WithNormalizedSearch() Option {
+	return func(r *PostgresRepository) {
+		r.normalizedSearch = true
+	}
+}
+
+// WithApproximateCount makes counts without search or attribute filters
+// return the planner's row estimate once pg_class.reltuples says the table
+// holds threshold rows, instead of scanning it. Smaller tables, filtered
+// counts and counts with ListOptions.ExactCount are always exact.
+func WithApproximateCount(threshold int64) Option {
+	return func(r *PostgresRepository) {
+		r.approxCountAbove = threshold
+	}
+}
+
+// WithOwnerQuota refuses inserts that would take an owner past its quota
+// with products.ErrQuotaExceeded. The count and the insert share a
+// transaction holding an owner-scoped advisory lock, so concurrent creates
+// cannot overshoot the quota together.
+func WithOwnerQuota(q products.OwnerQuota) Option {
+	return func(r *PostgresRepository) {
+		r.ownerQuota = q
+	}
+}
+
+// WithDeleteChunkSize sets how many ids each DeleteBatch statement
+// deletes.
+func WithDeleteChunkSize(n int) Option {
+	return func(r *PostgresRepository) {
+		r.deleteChunkSize = n
+	}
+}
+
+// WithSlugs gives every new product a slug derived from its name by cfg,
+// made unique with cfg's suffix strategy. Inserts then always run in a
+// transaction holding an advisory lock on the base slug.
+func WithSlugs(cfg products.SlugConfig) Option {
+	return func(r *PostgresRepository) {
+		r.slugs = &cfg
+	}
+}
+
+func NewPostgres(db *sql.DB, opts ...Option) *PostgresRepository {
+	return NewPostgresWithReplica(db, nil, opts...)
+}
+
+// NewPostgresWithReplica routes read-only queries to replica and writes to
+// primary. A nil replica behaves exactly like NewPostgres. A nil primary is
+// a misconfiguration: every method then fails with an error wrapping
+// ErrInternal.
+func NewPostgresWithReplica(primary, replica *sql.DB, opts ...Option) *PostgresRepository {
+	if primary == nil {
+		primary = sql.OpenDB(nilConnector{})
+	}
+	r := &PostgresRepository{db: primary, replica: replica, deleteChunkSize: defaultDeleteChunkSize}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// read runs query against the replica when one is configured and healthy,
+// falling back to the primary if the replica fails.
+func (r *PostgresRepository) read(ctx context.Context, query func(db *sql.DB) error) error {
+	if r.replica == nil || time.Now().UnixNano() < r.replicaDownUntil.Load() {
+		return query(r.db)
+	}
+
+	err := query(r.replica)
+	if err == nil || errors.Is(err, products.ErrNotFound) || errors.Is(err, products.ErrQueryTimeout) || ctx.Err() != nil {
+		return err
+	}
+
+	r.replicaDownUntil.Store(time.Now().Add(replicaCooldown).UnixNano())
+	return query(r.db)
 }
 
-func NewPostgres(db *sql.DB) *PostgresRepository {
-	return &PostgresRepository{db: db}
+// Create inserts a product. A name that is already taken fails with a
+// *products.DuplicateNameError naming the product that holds it, or with
+// plain products.ErrDuplicateName if that product is gone by the time it
+// is looked up.
+func (r *PostgresRepository) Create(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	p, err := r.create(ctx, in)
+	if errors.Is(err, products.ErrDuplicateName) {
+		return products.Product{}, r.duplicateName(ctx, in.Name)
+	}
+	return p, err
 }
 
-func (r *PostgresRepository) Create(ctx context.Context, name string) (products.Product, error) {
+func (r *PostgresRepository) create(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	attrs, err := encodeAttributes(in.Attributes)
+	if err != nil {
+		return products.Product{}, err
+	}
+
+	if !r.needsTx(in.Owner) {
+		return insertProduct(ctx, r.db, in, attrs, nil)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := r.insertTx(ctx, tx, in, attrs)
+	if err != nil {
+		return products.Product{}, err
+	}
+	if err := r.audit(ctx, tx, products.AuditRecord{Action: products.AuditCreate, ProductID: p.ID, After: &p}); err != nil {
+		return products.Product{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return products.Product{}, fmt.Errorf("commit tx: %w", err)
+	}
+	return p, nil
+}
+
+// CreateIfAbsent inserts a product unless its name is taken, in which case
+// it returns the product holding the name instead; created reports which.
+// The existing product is returned even if it has expired, since it still
+// holds the name.
+func (r *PostgresRepository) CreateIfAbsent(ctx context.Context, in products.CreateInput) (p products.Product, created bool, err error) {
+	if r.needsTx(in.Owner) {
+		p, err = r.create(ctx, in)
+	} else {
+		p, err = r.insertIfAbsent(ctx, in)
+	}
+	if errors.Is(err, products.ErrDuplicateName) {
+		p, err = r.productNamed(ctx, in.Name)
+		return p, false, err
+	}
+	if err != nil {
+		return products.Product{}, false, err
+	}
+	return p, true, nil
+}
+
+// insertIfAbsent inserts a product, leaving the row alone if its name is
+// taken. That conflict is reported as products.ErrDuplicateName.
+func (r *PostgresRepository) insertIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	attrs, err := encodeAttributes(in.Attributes)
+	if err != nil {
+		return products.Product{}, err
+	}
+
 	query := `
-		INSERT INTO products (name)
-		VALUES ($1)
-		RETURNING id, name, created_at
+		INSERT INTO products (name, owner, attributes, expires_at, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
 	`
 
-	var p products.Product
-	if err := r.db.QueryRowContext(ctx, query, name).Scan(&p.ID, &p.Name, &p.CreatedAt); err != nil {
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, in.Name, in.Owner, attrs, in.ExpiresAt, createStatus(in)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrDuplicateName
+	}
+	if err != nil {
 		return products.Product{}, fmt.Errorf("insert product: %w", err)
 	}
 	return p, nil
 }
 
-func (r *PostgresRepository) Delete(ctx context.Context, id int64) error {
-	query := `DELETE FROM products WHERE id = $1`
+// productNamed returns the product holding name, matched the way the
+// repository enforces uniqueness. If that product was deleted since the
+// conflict, it fails with products.ErrDuplicateName, as Create would.
+func (r *PostgresRepository) productNamed(ctx context.Context, name string) (products.Product, error) {
+	match := "name = $1"
+	if r.caseInsensitiveNames {
+		match = "lower(name) = lower($1)"
+	}
 
-	result, err := r.db.ExecContext(ctx, query, id)
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE ` + match + `
+		ORDER BY id
+		LIMIT 1
+	`
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrDuplicateName
+	}
 	if err != nil {
-		return fmt.Errorf("delete product %d: %w", id, err)
+		return products.Product{}, fmt.Errorf("get product named %q: %w", name, err)
+	}
+	return p, nil
+}
+
+// duplicateName looks up the product holding name. It reads from the pool
+// rather than the create's transaction, which the unique violation has
+// aborted.
+func (r *PostgresRepository) duplicateName(ctx context.Context, name string) error {
+	match := "name = $1"
+	if r.caseInsensitiveNames {
+		match = "lower(name) = lower($1)"
 	}
 
-	affected, err := result.RowsAffected()
+	var dup products.DuplicateNameError
+	err := r.db.QueryRowContext(ctx, `SELECT id, public_id FROM products WHERE `+match+` ORDER BY id LIMIT 1`, name).
+		Scan(&dup.ExistingID, &dup.ExistingPublicID)
 	if err != nil {
-		return fmt.Errorf("rows affected: %w", err)
+		return products.ErrDuplicateName
 	}
-	if affected == 0 {
-		return products.ErrNotFound
+	return &dup
+}
+
+// CreateBatch inserts all products or none, and in the same transaction
+// writes a product_created event per product to the outbox for the relay
+// to publish.
+func (r *PostgresRepository) CreateBatch(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	created := make([]products.Product, 0, len(inputs))
+	for i, in := range inputs {
+		attrs, err := encodeAttributes(in.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+
+		p, err := r.insertTx(ctx, tx, in, attrs)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+
+		if err := insertOutboxEvent(ctx, tx, products.ProductEvent{
+			EventType:        products.EventCreated,
+			ProductID:        p.ID,
+			Name:             p.Name,
+			Owner:            p.Owner,
+			Timestamp:        p.CreatedAt.UTC(),
+			AggregateVersion: p.Version,
+		}); err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		if err := r.audit(ctx, tx, products.AuditRecord{Action: products.AuditCreate, ProductID: p.ID, After: &p}); err != nil {
+			return nil, err
+		}
+		created = append(created, p)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return created, nil
+}
+
+// insertTx inserts one product inside tx, enforcing the owner quota and
+// case-insensitive uniqueness and assigning a slug when enabled.
+func (r *PostgresRepository) insertTx(ctx context.Context, tx *sql.Tx, in products.CreateInput, attrs string) (products.Product, error) {
+	if r.quotaApplies(in.Owner) {
+		if err := r.checkOwnerQuota(ctx, tx, in.Owner); err != nil {
+			return products.Product{}, err
+		}
+	}
+	var slug any
+	if r.slugs != nil {
+		var err error
+		if slug, err = r.assignSlug(ctx, tx, in.Name); err != nil {
+			return products.Product{}, err
+		}
+	}
+	if r.caseInsensitiveNames {
+		return insertProductCaseInsensitive(ctx, tx, in, attrs, slug)
+	}
+	return insertProduct(ctx, tx, in, attrs, slug)
+}
+
+// needsTx reports whether inserting a product of owner takes more than a
+// single statement.
+func (r *PostgresRepository) needsTx(owner string) bool {
+	return r.caseInsensitiveNames || r.quotaApplies(owner) || r.slugs != nil || r.auditSink != nil
+}
+
+func (r *PostgresRepository) quotaApplies(owner string) bool {
+	return owner != "" && r.ownerQuota.Limit(owner) > 0
+}
+
+// checkOwnerQuota takes a transaction-scoped lock on owner, held until tx
+// ends, and fails if the owner is already at its quota.
+func (r *PostgresRepository) checkOwnerQuota(ctx context.Context, tx *sql.Tx, owner string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::int, hashtext($2))`, lockClassOwnerQuota, owner); err != nil {
+		return fmt.Errorf("lock owner: %w", err)
+	}
+
+	count, err := countByOwner(ctx, tx, owner)
+	if err != nil {
+		return err
+	}
+	if count >= r.ownerQuota.Limit(owner) {
+		return products.ErrQuotaExceeded
+	}
 	return nil
 }
 
-func (r *PostgresRepository) List(ctx context.Context, limit, offset int) ([]products.Product, error) {
+// CountByOwner returns how many products owner holds.
+func (r *PostgresRepository) CountByOwner(ctx context.Context, owner string) (int64, error) {
+	return countByOwner(ctx, r.db, owner)
+}
+
+func countByOwner(ctx context.Context, q queryRower, owner string) (int64, error) {
+	var count int64
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM products WHERE owner = $1`, owner).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count products of owner %q: %w", owner, err)
+	}
+	return count, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertProduct inserts in with the given slug, nil for none.
+func insertProduct(ctx context.Context, q queryRower, in products.CreateInput, attrs string, slug any) (products.Product, error) {
+	query := `
+		INSERT INTO products (name, owner, attributes, expires_at, slug, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+	`
+
+	p, err := scanProduct(q.QueryRowContext(ctx, query, in.Name, in.Owner, attrs, in.ExpiresAt, slug, createStatus(in)))
+	if isUniqueViolation(err) {
+		return products.Product{}, products.ErrDuplicateName
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("insert product: %w", err)
+	}
+	return p, nil
+}
+
+// insertProductCaseInsensitive serializes inserts of the same lower-cased
+// name with a transaction-scoped advisory lock, then inserts only if no
+// name matches case-insensitively. The unique index on name cannot express
+// this on its own, and a unique index on lower(name) could not be switched
+// off.
+func insertProductCaseInsensitive(ctx context.Context, tx *sql.Tx, in products.CreateInput, attrs string, slug any) (products.Product, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::int, hashtext(lower($2)))`, lockClassProductName, in.Name); err != nil {
+		return products.Product{}, fmt.Errorf("lock product name: %w", err)
+	}
+
 	query := `
-		SELECT id, name, created_at
+		INSERT INTO products (name, owner, attributes, expires_at, slug, status)
+		SELECT $1::text, $2::text, $3::jsonb, $4::timestamptz, $5::text, $6::text
+		WHERE NOT EXISTS (SELECT 1 FROM products WHERE lower(name) = lower($1))
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+	`
+
+	p, err := scanProduct(tx.QueryRowContext(ctx, query, in.Name, in.Owner, attrs, in.ExpiresAt, slug, createStatus(in)))
+	if errors.Is(err, sql.ErrNoRows) || isUniqueViolation(err) {
+		return products.Product{}, products.ErrDuplicateName
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("insert product: %w", err)
+	}
+	return p, nil
+}
+
+// createStatus is the status a product is inserted with.
+func createStatus(in products.CreateInput) string {
+	if in.Status == "" {
+		return products.StatusPublished
+	}
+	return in.Status
+}
+
+// Get returns the product with the given id. Expired products are not
+// found.
+func (r *PostgresRepository) Get(ctx context.Context, id int64) (products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
 		FROM products
-		ORDER BY id DESC
-		LIMIT $1 OFFSET $2
+		WHERE id = $1 AND ` + notExpired + `
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("get product %d: %w", id, err)
+	}
+	return p, nil
+}
+
+func (r *PostgresRepository) GetByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE public_id = $1 AND ` + notExpired + `
+	`
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, publicID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("get product %s: %w", publicID, err)
+	}
+	return p, nil
+}
+
+// GetIncludingExpired is Get without the expiry check: it finds exactly the
+// product DeleteReturning would delete.
+func (r *PostgresRepository) GetIncludingExpired(ctx context.Context, id int64) (products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE id = $1
+	`
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("get product %d: %w", id, err)
+	}
+	return p, nil
+}
+
+// GetByPublicIDIncludingExpired is GetIncludingExpired addressed by public
+// id, finding what DeleteByPublicID would delete.
+func (r *PostgresRepository) GetByPublicIDIncludingExpired(ctx context.Context, publicID string) (products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE public_id = $1
+	`
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, publicID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("get product %s: %w", publicID, err)
+	}
+	return p, nil
+}
+
+// GetBySlug returns the product with the given slug. Expired products are
+// not found.
+func (r *PostgresRepository) GetBySlug(ctx context.Context, slug string) (products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE slug = $1 AND ` + notExpired + `
+	`
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, fmt.Errorf("get product by slug %q: %w", slug, err)
+	}
+	return p, nil
+}
+
+// UpdateAttributes replaces the product's attributes and returns the
+// updated product along with the attributes it had before. The old row is
+// locked by the same statement, so the two are consistent.
+func (r *PostgresRepository) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+	query := `
+		UPDATE products p
+		SET attributes = $2, version = p.version + 1
+		FROM (SELECT id, attributes FROM products WHERE id = $1 FOR UPDATE) prev
+		WHERE p.id = prev.id
+		RETURNING p.id, p.public_id, p.name, p.owner, p.attributes, p.created_at, p.expires_at, p.version, p.slug, p.status, prev.attributes
+	`
+
+	attrs, err := encodeAttributes(attributes)
+	if err != nil {
+		return products.Product{}, nil, err
+	}
+
+	var (
+		p        products.Product
+		previous map[string]any
+	)
+	err = r.mutate(ctx, func(q execer) error {
+		var rawPrevious []byte
+		p, err = scanProduct(withExtraColumn{q.QueryRowContext(ctx, query, id, attrs), &rawPrevious})
+		if errors.Is(err, sql.ErrNoRows) {
+			return products.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("update product %d attributes: %w", id, err)
+		}
+		if previous, err = decodeAttributes(rawPrevious); err != nil {
+			return err
+		}
+
+		before := p
+		before.Attributes, before.Version = previous, p.Version-1
+		return r.audit(ctx, q, products.AuditRecord{
+			Action:    products.AuditUpdateAttributes,
+			ProductID: p.ID,
+			Before:    &before,
+			After:     &p,
+		})
+	})
+	if err != nil {
+		return products.Product{}, nil, err
+	}
+	return p, previous, nil
+}
+
+// UpdateStatus moves the product to status and returns it along with the
+// status it had before. An expired product is not found. The row is locked
+// while the move is checked, and a move products.CanTransition does not
+// allow fails with products.ErrInvalidTransition.
+func (r *PostgresRepository) UpdateStatus(ctx context.Context, id int64, status string) (products.Product, string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return products.Product{}, "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM products WHERE id = $1 AND `+notExpired+` FOR UPDATE`, id).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Product{}, "", products.ErrNotFound
+	}
+	if err != nil {
+		return products.Product{}, "", fmt.Errorf("get product %d status: %w", id, err)
+	}
+	if !products.CanTransition(previous, status) {
+		return products.Product{}, "", fmt.Errorf("%w: %s to %s", products.ErrInvalidTransition, previous, status)
+	}
+
+	query := `
+		UPDATE products
+		SET status = $2, version = version + 1
+		WHERE id = $1
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+	`
+	p, err := scanProduct(tx.QueryRowContext(ctx, query, id, status))
+	if err != nil {
+		return products.Product{}, "", fmt.Errorf("update product %d status: %w", id, err)
+	}
+
+	before := p
+	before.Status, before.Version = previous, p.Version-1
+	if err := r.audit(ctx, tx, products.AuditRecord{
+		Action:    products.AuditChangeStatus,
+		ProductID: p.ID,
+		Before:    &before,
+		After:     &p,
+	}); err != nil {
+		return products.Product{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return products.Product{}, "", fmt.Errorf("commit tx: %w", err)
+	}
+	return p, previous, nil
+}
+
+// DeleteReturning deletes the product and returns the row as it was, so
+// callers can report or publish what was removed.
+func (r *PostgresRepository) DeleteReturning(ctx context.Context, id int64) (products.Product, error) {
+	query := `
+		DELETE FROM products
+		WHERE id = $1
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+	`
+
+	return r.deleteReturning(ctx, query, id)
+}
+
+// DeleteByPublicID is DeleteReturning addressed by public id.
+func (r *PostgresRepository) DeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	query := `
+		DELETE FROM products
+		WHERE public_id = $1
+		RETURNING id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+	`
+
+	return r.deleteReturning(ctx, query, publicID)
+}
+
+// deleteReturning runs query, a DELETE of one product addressed by key
+// returning the row, and audits the delete.
+func (r *PostgresRepository) deleteReturning(ctx context.Context, query string, key any) (products.Product, error) {
+	var p products.Product
+	err := r.mutate(ctx, func(q execer) error {
+		var err error
+		p, err = scanProduct(q.QueryRowContext(ctx, query, key))
+		if errors.Is(err, sql.ErrNoRows) {
+			return products.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("delete product %v: %w", key, err)
+		}
+		return r.audit(ctx, q, products.AuditRecord{Action: products.AuditDelete, ProductID: p.ID, Before: &p})
+	})
+	if err != nil {
+		return products.Product{}, err
+	}
+	return p, nil
+}
+
+// DeleteBatch deletes the products with the given ids, skipping ids that
+// do not exist, and reports how many it deleted. The ids are deleted a chunk
+// at a time so a huge list stays within parameter limits, but all chunks
+// share one transaction: either every product is deleted or none is. A
+// product_deleted event for each one is written to the outbox in the same
+// transaction.
+func (r *PostgresRepository) DeleteBatch(ctx context.Context, ids []int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var deleted int64
+	for start := 0; start < len(ids); start += r.deleteChunkSize {
+		chunk := ids[start:min(start+r.deleteChunkSize, len(ids))]
+		n, err := deleteChunk(ctx, tx, chunk)
+		if err != nil {
+			return 0, fmt.Errorf("delete ids %d-%d: %w", start, start+len(chunk)-1, err)
+		}
+		deleted += n
+	}
+	if err := r.audit(ctx, tx, products.AuditRecord{Action: products.AuditDeleteBatch, ProductIDs: ids, Count: deleted}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return deleted, nil
+}
+
+func deleteChunk(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`DELETE FROM products WHERE id = ANY($1) RETURNING id, name, owner, now(), version + 1`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var events []products.ProductEvent
+	for rows.Next() {
+		event := products.ProductEvent{EventType: products.EventDeleted}
+		if err := rows.Scan(&event.ProductID, &event.Name, &event.Owner, &event.Timestamp, &event.AggregateVersion); err != nil {
+			return 0, err
+		}
+		event.Timestamp = event.Timestamp.UTC()
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// The outbox inserts run on the transaction's connection, so the
+	// result set has to be closed first.
+	_ = rows.Close()
+
+	for _, event := range events {
+		if err := insertOutboxEvent(ctx, tx, event); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(events)), nil
+}
+
+func (r *PostgresRepository) List(ctx context.Context, opts products.ListOptions, limit, offset int) ([]products.Product, error) {
+	var list []products.Product
+	err := r.read(ctx, func(db *sql.DB) error {
+		return withStatementTimeout(ctx, db, func(q querier) error {
+			var err error
+			list, err = r.listProducts(ctx, q, opts, limit, offset)
+			return err
+		})
+	})
+	return list, err
+}
+
+func (r *PostgresRepository) listProducts(ctx context.Context, db querier, opts products.ListOptions, limit, offset int) ([]products.Product, error) {
+	f, err := r.buildFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		%s
+		ORDER BY %sid DESC
+		LIMIT $%d OFFSET $%d
+	`, f.where(), f.order, len(f.args)+1, len(f.args)+2)
+
+	rows, err := db.QueryContext(ctx, query, append(f.args, limit, offset)...)
 	if err != nil {
 		return nil, fmt.Errorf("query products: %w", err)
 	}
@@ -68,10 +774,20 @@ func (r *PostgresRepository) List(ctx context.Context, limit, offset int) ([]pro
 
 	list := make([]products.Product, 0)
 	for rows.Next() {
-		var p products.Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt); err != nil {
+		var (
+			p         products.Product
+			attrs     sql.RawBytes
+			expiresAt sql.NullTime
+			slug      sql.NullString
+		)
+		if err := rows.Scan(&p.ID, &p.PublicID, &p.Name, &p.Owner, &attrs, &p.CreatedAt, &expiresAt, &p.Version, &slug, &p.Status); err != nil {
 			return nil, fmt.Errorf("scan product: %w", err)
 		}
+		if p.Attributes, err = decodeAttributes(attrs); err != nil {
+			return nil, err
+		}
+		p.ExpiresAt = nullTime(expiresAt)
+		p.Slug = slug.String
 		list = append(list, p)
 	}
 
@@ -82,12 +798,178 @@ func (r *PostgresRepository) List(ctx context.Context, limit, offset int) ([]pro
 	return list, nil
 }
 
-func (r *PostgresRepository) Count(ctx context.Context) (int64, error) {
+// ListAfter returns up to limit published, unexpired products with ids
+// above afterID, in id order: the products List shows by default. Each
+// call is a query of its own, so paging through the table with it holds no
+// transaction or cursor open between pages.
+func (r *PostgresRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]products.Product, error) {
+	query := `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status
+		FROM products
+		WHERE id > $1 AND status = $3 AND ` + notExpired + `
+		ORDER BY id
+		LIMIT $2
+	`
+
+	var list []products.Product
+	err := r.read(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, afterID, limit, products.StatusPublished)
+		if err != nil {
+			return fmt.Errorf("query products after %d: %w", afterID, err)
+		}
+		defer rows.Close()
+
+		list = list[:0]
+		for rows.Next() {
+			p, err := scanProduct(rows)
+			if err != nil {
+				return fmt.Errorf("scan product: %w", err)
+			}
+			list = append(list, p)
+		}
+		return rows.Err()
+	})
+	return list, err
+}
+
+// Count counts the products matching opts. With WithApproximateCount, a
+// count without search or attribute filters on a large table may be the
+// planner's estimate instead, and approximate reports when it is.
+func (r *PostgresRepository) Count(ctx context.Context, opts products.ListOptions) (total int64, approximate bool, err error) {
+	f, err := r.buildFilter(opts)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// The estimate is the planner's for the count's own conditions, so it
+	// leaves out expired products and other statuses as List does. Its
+	// guess for an id window would be too rough to be worth it.
+	estimate := r.approxCountAbove > 0 && !opts.ExactCount && !opts.Filtered() && opts.MinID == 0 && opts.MaxID == 0
+
+	err = r.read(ctx, func(db *sql.DB) error {
+		return withStatementTimeout(ctx, db, func(q querier) error {
+			var err error
+			total, approximate, err = r.count(ctx, q, f, estimate)
+			return err
+		})
+	})
+	return total, approximate, err
+}
+
+func (r *PostgresRepository) count(ctx context.Context, db querier, f *filter, estimate bool) (int64, bool, error) {
+	if estimate {
+		var tableRows int64
+		// reltuples is -1 until the table is first analyzed.
+		err := db.QueryRowContext(ctx,
+			`SELECT reltuples::bigint FROM pg_class WHERE oid = 'products'::regclass`).Scan(&tableRows)
+		if err != nil {
+			return 0, false, fmt.Errorf("estimate products count: %w", err)
+		}
+		if tableRows >= r.approxCountAbove {
+			approx, err := estimateRows(ctx, db, `SELECT 1 FROM products `+f.where(), f.args)
+			if err != nil {
+				return 0, false, fmt.Errorf("estimate products count: %w", err)
+			}
+			return approx, true, nil
+		}
+	}
+
 	var total int64
-	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products`).Scan(&total); err != nil {
-		return 0, fmt.Errorf("count products: %w", err)
+	query := `SELECT COUNT(*) FROM products ` + f.where()
+	if err := db.QueryRowContext(ctx, query, f.args...).Scan(&total); err != nil {
+		return 0, false, fmt.Errorf("count products: %w", err)
+	}
+	return total, false, nil
+}
+
+// estimateRows returns the planner's estimate of how many rows query
+// returns, without running it.
+func estimateRows(ctx context.Context, db querier, query string, args []any) (int64, error) {
+	var raw []byte
+	if err := db.QueryRowContext(ctx, `EXPLAIN (FORMAT JSON) `+query, args...).Scan(&raw); err != nil {
+		return 0, err
+	}
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return 0, fmt.Errorf("decode plan: %w", err)
 	}
-	return total, nil
+	if len(plans) == 0 {
+		return 0, errors.New("empty plan")
+	}
+	return int64(plans[0].Plan.Rows), nil
+}
+
+// Position returns the 1-based position of product id in the default list,
+// published unexpired products newest first, or products.ErrNotFound when
+// the product is not in it.
+func (r *PostgresRepository) Position(ctx context.Context, id int64) (int64, error) {
+	f, err := r.buildFilter(products.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	// The products listed before it are those with a higher id.
+	listed := strings.Join(f.conds, " AND ")
+	query := fmt.Sprintf(`
+		SELECT (SELECT COUNT(*) FROM products WHERE id > $%[1]d AND %[2]s) + 1
+		FROM products
+		WHERE id = $%[1]d AND %[2]s
+	`, len(f.args)+1, listed)
+
+	var position int64
+	err = r.read(ctx, func(db *sql.DB) error {
+		return withStatementTimeout(ctx, db, func(q querier) error {
+			err := q.QueryRowContext(ctx, query, append(f.args, id)...).Scan(&position)
+			if errors.Is(err, sql.ErrNoRows) {
+				return products.ErrNotFound
+			}
+			return err
+		})
+	})
+	if errors.Is(err, products.ErrNotFound) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get position of product %d: %w", id, err)
+	}
+	return position, nil
+}
+
+// SuggestNames returns up to limit names of published, unexpired products
+// starting with prefix, ignoring case, in name order. The match is written as lower(name) LIKE so it can
+// use the text_pattern_ops index; names are unique, so no DISTINCT is
+// needed.
+func (r *PostgresRepository) SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	query := `
+		SELECT name
+		FROM products
+		WHERE lower(name) LIKE lower($1) || '%' AND status = $3 AND ` + notExpired + `
+		ORDER BY name
+		LIMIT $2
+	`
+
+	names := []string{}
+	err := r.read(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, likeEscaper.Replace(prefix), limit, products.StatusPublished)
+		if err != nil {
+			return fmt.Errorf("suggest names: %w", err)
+		}
+		defer rows.Close()
+
+		names = names[:0]
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return fmt.Errorf("scan name: %w", err)
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	})
+	return names, err
 }
 
 func (r *PostgresRepository) Health() error {
@@ -95,3 +977,8 @@ func (r *PostgresRepository) Health() error {
 	defer cancel()
 	return r.db.PingContext(ctx)
 }
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation
+}
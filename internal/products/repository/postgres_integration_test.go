@@ -6,17 +6,26 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/outbox"
 
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	pgmigrate "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -97,7 +106,7 @@ func TestPostgresRepository_Create(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("creates product and returns it", func(t *testing.T) {
-		p, err := repo.Create(ctx, "Laptop")
+		p, err := repo.Create(ctx, products.CreateInput{Name: "Laptop"})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -113,8 +122,8 @@ func TestPostgresRepository_Create(t *testing.T) {
 	})
 
 	t.Run("auto-increments IDs", func(t *testing.T) {
-		p1, _ := repo.Create(ctx, "A")
-		p2, _ := repo.Create(ctx, "B")
+		p1, _ := repo.Create(ctx, products.CreateInput{Name: "A"})
+		p2, _ := repo.Create(ctx, products.CreateInput{Name: "B"})
 		if p2.ID <= p1.ID {
 			t.Fatalf("expected p2.ID > p1.ID, got %d <= %d", p2.ID, p1.ID)
 		}
@@ -127,13 +136,17 @@ func TestPostgresRepository_Delete(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("deletes existing product", func(t *testing.T) {
-		p, _ := repo.Create(ctx, "ToDelete")
-		if err := repo.Delete(ctx, p.ID); err != nil {
+		p, _ := repo.Create(ctx, products.CreateInput{Name: "ToDelete", Attributes: map[string]any{"color": "red"}})
+		deleted, err := repo.DeleteReturning(ctx, p.ID)
+		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+		if deleted.ID != p.ID || deleted.PublicID != p.PublicID || deleted.Name != "ToDelete" || deleted.Attributes["color"] != "red" {
+			t.Fatalf("want the deleted row returned, got %+v", deleted)
+		}
 
-		count, _ := repo.Count(ctx)
-		list, _ := repo.List(ctx, 100, 0)
+		count, _, _ := repo.Count(ctx, products.ListOptions{})
+		list, _ := repo.List(ctx, products.ListOptions{}, 100, 0)
 		for _, item := range list {
 			if item.ID == p.ID {
 				t.Fatalf("product %d should have been deleted, but still in list (count=%d)", p.ID, count)
@@ -142,22 +155,93 @@ func TestPostgresRepository_Delete(t *testing.T) {
 	})
 
 	t.Run("returns ErrNotFound for non-existent ID", func(t *testing.T) {
-		err := repo.Delete(ctx, 999999)
+		_, err := repo.DeleteReturning(ctx, 999999)
 		if !errors.Is(err, products.ErrNotFound) {
 			t.Fatalf("want ErrNotFound, got %v", err)
 		}
 	})
 
 	t.Run("delete is idempotent — second call returns ErrNotFound", func(t *testing.T) {
-		p, _ := repo.Create(ctx, "DeleteTwice")
-		_ = repo.Delete(ctx, p.ID)
-		err := repo.Delete(ctx, p.ID)
+		p, _ := repo.Create(ctx, products.CreateInput{Name: "DeleteTwice"})
+		_, _ = repo.DeleteReturning(ctx, p.ID)
+		_, err := repo.DeleteReturning(ctx, p.ID)
 		if !errors.Is(err, products.ErrNotFound) {
 			t.Fatalf("want ErrNotFound on second delete, got %v", err)
 		}
 	})
 }
 
+func TestPostgresRepository_Get(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	t.Run("returns existing product", func(t *testing.T) {
+		created, _ := repo.Create(ctx, products.CreateInput{Name: "Lookup", Attributes: map[string]any{"color": "red"}})
+		got, err := repo.Get(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.ID != created.ID || got.Name != "Lookup" || got.Attributes["color"] != "red" {
+			t.Fatalf("want %+v, got %+v", created, got)
+		}
+	})
+
+	t.Run("returns ErrNotFound for non-existent ID", func(t *testing.T) {
+		_, err := repo.Get(ctx, 999999)
+		if !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestPostgresRepository_PublicID(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, products.CreateInput{Name: "Public"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.PublicID == "" {
+		t.Fatal("want a public id assigned on create")
+	}
+
+	t.Run("get by public id", func(t *testing.T) {
+		got, err := repo.GetByPublicID(ctx, created.PublicID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.ID != created.ID || got.PublicID != created.PublicID {
+			t.Fatalf("want %+v, got %+v", created, got)
+		}
+	})
+
+	t.Run("unknown public id", func(t *testing.T) {
+		_, err := repo.GetByPublicID(ctx, "9f0e1d2c-3b4a-4c5d-8e6f-7a8b9c0d1e2f")
+		if !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound, got %v", err)
+		}
+		if _, err := repo.DeleteByPublicID(ctx, "9f0e1d2c-3b4a-4c5d-8e6f-7a8b9c0d1e2f"); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound from delete, got %v", err)
+		}
+	})
+
+	t.Run("delete by public id returns the deleted product", func(t *testing.T) {
+		deleted, err := repo.DeleteByPublicID(ctx, created.PublicID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if deleted.ID != created.ID {
+			t.Fatalf("want id %d, got %d", created.ID, deleted.ID)
+		}
+		if _, err := repo.Get(ctx, created.ID); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want product gone, got %v", err)
+		}
+	})
+}
+
 func TestPostgresRepository_List(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewPostgres(db)
@@ -165,13 +249,13 @@ func TestPostgresRepository_List(t *testing.T) {
 
 	names := []string{"Alpha", "Beta", "Gamma", "Delta", "Epsilon"}
 	for _, name := range names {
-		if _, err := repo.Create(ctx, name); err != nil {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: name}); err != nil {
 			t.Fatalf("seed %q: %v", name, err)
 		}
 	}
 
 	t.Run("returns all with large limit", func(t *testing.T) {
-		list, err := repo.List(ctx, 100, 0)
+		list, err := repo.List(ctx, products.ListOptions{}, 100, 0)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -181,7 +265,7 @@ func TestPostgresRepository_List(t *testing.T) {
 	})
 
 	t.Run("ordered by id DESC", func(t *testing.T) {
-		list, _ := repo.List(ctx, 100, 0)
+		list, _ := repo.List(ctx, products.ListOptions{}, 100, 0)
 		for i := 1; i < len(list); i++ {
 			if list[i].ID >= list[i-1].ID {
 				t.Fatalf("expected descending order, got id %d after %d", list[i].ID, list[i-1].ID)
@@ -190,15 +274,15 @@ func TestPostgresRepository_List(t *testing.T) {
 	})
 
 	t.Run("respects limit", func(t *testing.T) {
-		list, _ := repo.List(ctx, 2, 0)
+		list, _ := repo.List(ctx, products.ListOptions{}, 2, 0)
 		if len(list) != 2 {
 			t.Fatalf("want 2 items, got %d", len(list))
 		}
 	})
 
 	t.Run("respects offset", func(t *testing.T) {
-		all, _ := repo.List(ctx, 100, 0)
-		page2, _ := repo.List(ctx, 2, 2)
+		all, _ := repo.List(ctx, products.ListOptions{}, 100, 0)
+		page2, _ := repo.List(ctx, products.ListOptions{}, 2, 2)
 		if len(page2) != 2 {
 			t.Fatalf("want 2 items, got %d", len(page2))
 		}
@@ -208,7 +292,7 @@ func TestPostgresRepository_List(t *testing.T) {
 	})
 
 	t.Run("empty result returns empty slice", func(t *testing.T) {
-		list, _ := repo.List(ctx, 10, 1000)
+		list, _ := repo.List(ctx, products.ListOptions{}, 10, 1000)
 		if list == nil {
 			t.Fatal("expected non-nil empty slice")
 		}
@@ -224,7 +308,7 @@ func TestPostgresRepository_Count(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("empty table returns zero", func(t *testing.T) {
-		count, err := repo.Count(ctx)
+		count, _, err := repo.Count(ctx, products.ListOptions{})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -234,16 +318,16 @@ func TestPostgresRepository_Count(t *testing.T) {
 	})
 
 	t.Run("count reflects inserts and deletes", func(t *testing.T) {
-		p1, _ := repo.Create(ctx, "X")
-		_, _ = repo.Create(ctx, "Y")
+		p1, _ := repo.Create(ctx, products.CreateInput{Name: "X"})
+		_, _ = repo.Create(ctx, products.CreateInput{Name: "Y"})
 
-		count, _ := repo.Count(ctx)
+		count, _, _ := repo.Count(ctx, products.ListOptions{})
 		if count != 2 {
 			t.Fatalf("want 2 after inserts, got %d", count)
 		}
 
-		_ = repo.Delete(ctx, p1.ID)
-		count, _ = repo.Count(ctx)
+		_, _ = repo.DeleteReturning(ctx, p1.ID)
+		count, _, _ = repo.Count(ctx, products.ListOptions{})
 		if count != 1 {
 			t.Fatalf("want 1 after delete, got %d", count)
 		}
@@ -258,3 +342,1662 @@ func TestPostgresRepository_Health(t *testing.T) {
 		t.Fatalf("health check failed: %v", err)
 	}
 }
+
+func TestPostgresRepository_ReadReplica(t *testing.T) {
+	primary := setupTestDB(t)
+	replica := setupTestDB(t)
+	repo := NewPostgresWithReplica(primary, replica)
+	ctx := context.Background()
+
+	// The two databases are independent, so whichever one a read returns
+	// rows from is the one it was routed to.
+	if _, err := NewPostgres(replica).Create(ctx, products.CreateInput{Name: "OnReplica"}); err != nil {
+		t.Fatalf("seed replica: %v", err)
+	}
+
+	t.Run("writes go to primary", func(t *testing.T) {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: "OnPrimary"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count, _, _ := NewPostgres(primary).Count(ctx, products.ListOptions{})
+		if count != 1 {
+			t.Fatalf("want 1 row on primary, got %d", count)
+		}
+	})
+
+	t.Run("reads hit replica", func(t *testing.T) {
+		list, err := repo.List(ctx, products.ListOptions{}, 10, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 1 || list[0].Name != "OnReplica" {
+			t.Fatalf("want replica row, got %+v", list)
+		}
+		count, _, err := repo.Count(ctx, products.ListOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 1 {
+			t.Fatalf("want replica count 1, got %d", count)
+		}
+	})
+
+	t.Run("falls back to primary when replica is unhealthy", func(t *testing.T) {
+		_ = replica.Close()
+
+		list, err := repo.List(ctx, products.ListOptions{}, 10, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 1 || list[0].Name != "OnPrimary" {
+			t.Fatalf("want primary row, got %+v", list)
+		}
+	})
+
+	t.Run("nil replica reads from primary", func(t *testing.T) {
+		list, err := NewPostgresWithReplica(primary, nil).List(ctx, products.ListOptions{}, 10, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 1 || list[0].Name != "OnPrimary" {
+			t.Fatalf("want primary row, got %+v", list)
+		}
+	})
+}
+
+func TestPostgresRepository_Attributes(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	t.Run("round-trips attributes", func(t *testing.T) {
+		p, err := repo.Create(ctx, products.CreateInput{
+			Name:       "Laptop",
+			Attributes: map[string]any{"color": "silver", "ram_gb": 16, "tags": []any{"pro"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p.Attributes["color"] != "silver" || p.Attributes["ram_gb"] != float64(16) {
+			t.Fatalf("unexpected attributes after create: %v", p.Attributes)
+		}
+
+		list, _ := repo.List(ctx, products.ListOptions{}, 10, 0)
+		if len(list) != 1 || list[0].Attributes["color"] != "silver" {
+			t.Fatalf("unexpected attributes after list: %+v", list)
+		}
+	})
+
+	t.Run("no attributes scans as nil", func(t *testing.T) {
+		p, err := repo.Create(ctx, products.CreateInput{Name: "Plain"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p.Attributes != nil {
+			t.Fatalf("want nil attributes, got %v", p.Attributes)
+		}
+	})
+
+	t.Run("updates attributes", func(t *testing.T) {
+		p, _ := repo.Create(ctx, products.CreateInput{Name: "Phone", Attributes: map[string]any{"color": "black"}})
+		updated, previous, err := repo.UpdateAttributes(ctx, p.ID, map[string]any{"color": "white"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if updated.Attributes["color"] != "white" {
+			t.Fatalf("want color white, got %v", updated.Attributes)
+		}
+		if previous["color"] != "black" {
+			t.Fatalf("want previous color black, got %v", previous)
+		}
+	})
+
+	t.Run("update unknown id returns ErrNotFound", func(t *testing.T) {
+		_, _, err := repo.UpdateAttributes(ctx, 999999, map[string]any{"color": "white"})
+		if !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("filters by attribute containment", func(t *testing.T) {
+		opts := products.ListOptions{Attributes: map[string]any{"color": "silver"}}
+		list, err := repo.List(ctx, opts, 10, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 1 || list[0].Name != "Laptop" {
+			t.Fatalf("want only Laptop, got %+v", list)
+		}
+		count, _, _ := repo.Count(ctx, opts)
+		if count != 1 {
+			t.Fatalf("want filtered count 1, got %d", count)
+		}
+	})
+}
+
+func TestPostgresRepository_DuplicateNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		second      string
+		wantDupErr  bool
+		search      string
+		wantMatches int
+	}{
+		{
+			name:        "exact duplicate rejected",
+			second:      "iPhone",
+			wantDupErr:  true,
+			search:      "iPh",
+			wantMatches: 1,
+		},
+		{
+			name:        "case-sensitive mode allows case variants",
+			second:      "iphone",
+			search:      "iph",
+			wantMatches: 1,
+		},
+		{
+			name:        "case-insensitive mode rejects case variants",
+			opts:        []Option{WithCaseInsensitiveNames()},
+			second:      "IPHONE",
+			wantDupErr:  true,
+			search:      "IPH",
+			wantMatches: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			repo := NewPostgres(db, tt.opts...)
+			ctx := context.Background()
+
+			first, err := repo.Create(ctx, products.CreateInput{Name: "iPhone"})
+			if err != nil {
+				t.Fatalf("first create: %v", err)
+			}
+
+			_, err = repo.Create(ctx, products.CreateInput{Name: tt.second})
+			if tt.wantDupErr && !errors.Is(err, products.ErrDuplicateName) {
+				t.Fatalf("want ErrDuplicateName, got %v", err)
+			}
+			var dup *products.DuplicateNameError
+			if tt.wantDupErr && (!errors.As(err, &dup) || dup.ExistingID != first.ID || dup.ExistingPublicID != first.PublicID) {
+				t.Fatalf("want the existing product %d (%s) in the error, got %v", first.ID, first.PublicID, err)
+			}
+			if !tt.wantDupErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			list, err := repo.List(ctx, products.ListOptions{Search: tt.search}, 10, 0)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if len(list) != tt.wantMatches {
+				t.Fatalf("want %d search matches for %q, got %+v", tt.wantMatches, tt.search, list)
+			}
+		})
+	}
+
+	t.Run("search treats wildcards literally", func(t *testing.T) {
+		db := setupTestDB(t)
+		repo := NewPostgres(db)
+		ctx := context.Background()
+		_, _ = repo.Create(ctx, products.CreateInput{Name: "100% cotton"})
+		_, _ = repo.Create(ctx, products.CreateInput{Name: "1000 cotton"})
+
+		list, _ := repo.List(ctx, products.ListOptions{Search: "100%"}, 10, 0)
+		if len(list) != 1 || list[0].Name != "100% cotton" {
+			t.Fatalf("want only the literal match, got %+v", list)
+		}
+	})
+}
+
+func TestPostgresRepository_Status(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	live, err := repo.Create(ctx, products.CreateInput{Name: "Live"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if live.Status != products.StatusPublished {
+		t.Fatalf("want new products published, got %q", live.Status)
+	}
+	draft, err := repo.Create(ctx, products.CreateInput{Name: "Draft", Status: products.StatusDraft})
+	if err != nil {
+		t.Fatalf("create draft: %v", err)
+	}
+
+	listed, err := repo.List(ctx, products.ListOptions{}, 10, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != live.ID {
+		t.Fatalf("want only the published product listed, got %+v", listed)
+	}
+	drafts, _, err := repo.Count(ctx, products.ListOptions{Status: products.StatusDraft})
+	if err != nil || drafts != 1 {
+		t.Fatalf("want 1 draft counted, got %d, %v", drafts, err)
+	}
+	after, err := repo.ListAfter(ctx, 0, 10)
+	if err != nil || len(after) != 1 || after[0].ID != live.ID {
+		t.Fatalf("want only the published product exported, got %+v, %v", after, err)
+	}
+	if names, err := repo.SuggestNames(ctx, "Dr", 10); err != nil || len(names) != 0 {
+		t.Fatalf("want no draft names suggested, got %v, %v", names, err)
+	}
+
+	if _, _, err := repo.UpdateStatus(ctx, draft.ID, products.StatusArchived); !errors.Is(err, products.ErrInvalidTransition) {
+		t.Fatalf("want ErrInvalidTransition archiving a draft, got %v", err)
+	}
+	published, previous, err := repo.UpdateStatus(ctx, draft.ID, products.StatusPublished)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if published.Status != products.StatusPublished || previous != products.StatusDraft || published.Version != draft.Version+1 {
+		t.Fatalf("want draft moved to published at the next version, got %+v from %q", published, previous)
+	}
+	if _, _, err := repo.UpdateStatus(ctx, live.ID, products.StatusArchived); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if _, _, err := repo.UpdateStatus(ctx, live.ID, products.StatusPublished); !errors.Is(err, products.ErrInvalidTransition) {
+		t.Fatalf("want ErrInvalidTransition unarchiving, got %v", err)
+	}
+	if _, _, err := repo.UpdateStatus(ctx, 999999, products.StatusPublished); !errors.Is(err, products.ErrNotFound) {
+		t.Fatalf("want ErrNotFound for an unknown product, got %v", err)
+	}
+}
+
+func TestPostgresRepository_Position(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	var seeded []products.Product
+	for i := 0; i < 25; i++ {
+		p, err := repo.Create(ctx, products.CreateInput{Name: fmt.Sprintf("Product %02d", i)})
+		if err != nil {
+			t.Fatalf("create product %d: %v", i, err)
+		}
+		seeded = append(seeded, p)
+	}
+	// A draft among the newer products is not listed, so it does not
+	// count towards the position.
+	draft, err := repo.Create(ctx, products.CreateInput{Name: "Draft", Status: products.StatusDraft})
+	if err != nil {
+		t.Fatalf("create draft: %v", err)
+	}
+
+	// Products 24 down to 13 are listed before product 12.
+	target := seeded[12]
+	position, err := repo.Position(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("position: %v", err)
+	}
+	if position != 13 {
+		t.Fatalf("want position 13, got %d", position)
+	}
+
+	// The position agrees with the list: page 2 of 10 holds it third.
+	page, err := repo.List(ctx, products.ListOptions{}, 10, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if page[2].ID != target.ID {
+		t.Fatalf("want product %d third on page 2, got %+v", target.ID, page)
+	}
+
+	if first, err := repo.Position(ctx, seeded[24].ID); err != nil || first != 1 {
+		t.Fatalf("want the newest product first, got %d, %v", first, err)
+	}
+	if _, err := repo.Position(ctx, draft.ID); !errors.Is(err, products.ErrNotFound) {
+		t.Fatalf("want ErrNotFound for an unlisted draft, got %v", err)
+	}
+	if _, err := repo.Position(ctx, 999999); !errors.Is(err, products.ErrNotFound) {
+		t.Fatalf("want ErrNotFound for an unknown product, got %v", err)
+	}
+}
+
+func TestPostgresRepository_IDWindow(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	var ids []int64
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		p, err := repo.Create(ctx, products.CreateInput{Name: name})
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	tests := []struct {
+		name string
+		opts products.ListOptions
+		want []int64
+	}{
+		{name: "both bounds inclusive", opts: products.ListOptions{MinID: ids[1], MaxID: ids[3]}, want: ids[1:4]},
+		{name: "single id", opts: products.ListOptions{MinID: ids[2], MaxID: ids[2]}, want: ids[2:3]},
+		{name: "open above", opts: products.ListOptions{MinID: ids[3]}, want: ids[3:]},
+		{name: "open below", opts: products.ListOptions{MaxID: ids[1]}, want: ids[:2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed, err := repo.List(ctx, tt.opts, 10, 0)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			var got []int64
+			for _, p := range listed {
+				got = append(got, p.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("want products %v, got %v", tt.want, got)
+			}
+
+			count, _, err := repo.Count(ctx, tt.opts)
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if count != int64(len(tt.want)) {
+				t.Fatalf("want count %d, got %d", len(tt.want), count)
+			}
+		})
+	}
+}
+
+func TestPostgresRepository_Slugs(t *testing.T) {
+	t.Run("counter suffix on collision", func(t *testing.T) {
+		db := setupTestDB(t)
+		repo := NewPostgres(db, WithSlugs(products.SlugConfig{}))
+		ctx := context.Background()
+
+		// Names are unique but all slugify to the same base.
+		var slugs []string
+		for _, name := range []string{"Café Crème", "cafe creme", "CAFE-CREME"} {
+			p, err := repo.Create(ctx, products.CreateInput{Name: name})
+			if err != nil {
+				t.Fatalf("create %q: %v", name, err)
+			}
+			slugs = append(slugs, p.Slug)
+		}
+		if want := []string{"cafe-creme", "cafe-creme-2", "cafe-creme-3"}; !slices.Equal(slugs, want) {
+			t.Fatalf("want slugs %v, got %v", want, slugs)
+		}
+
+		got, err := repo.GetBySlug(ctx, "cafe-creme-2")
+		if err != nil {
+			t.Fatalf("get by slug: %v", err)
+		}
+		if got.Name != "cafe creme" {
+			t.Fatalf("want the second product, got %+v", got)
+		}
+		if _, err := repo.GetBySlug(ctx, "cafe-creme-9"); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound for an unknown slug, got %v", err)
+		}
+	})
+
+	t.Run("counter continues past the highest", func(t *testing.T) {
+		db := setupTestDB(t)
+		repo := NewPostgres(db, WithSlugs(products.SlugConfig{}))
+		ctx := context.Background()
+
+		// "Pen 7" takes pen-7 as its own base; "Pen Pro" is not a counter.
+		var slugs []string
+		for _, name := range []string{"Pen", "Pen 7", "Pen Pro", "pen!"} {
+			p, err := repo.Create(ctx, products.CreateInput{Name: name})
+			if err != nil {
+				t.Fatalf("create %q: %v", name, err)
+			}
+			slugs = append(slugs, p.Slug)
+		}
+		if want := []string{"pen", "pen-7", "pen-pro", "pen-8"}; !slices.Equal(slugs, want) {
+			t.Fatalf("want slugs %v, got %v", want, slugs)
+		}
+	})
+
+	t.Run("batch shares the counter", func(t *testing.T) {
+		db := setupTestDB(t)
+		repo := NewPostgres(db, WithSlugs(products.SlugConfig{}))
+
+		created, err := repo.CreateBatch(context.Background(), []products.CreateInput{{Name: "Box"}, {Name: "box!"}})
+		if err != nil {
+			t.Fatalf("create batch: %v", err)
+		}
+		if created[0].Slug != "box" || created[1].Slug != "box-2" {
+			t.Fatalf("want box and box-2, got %q and %q", created[0].Slug, created[1].Slug)
+		}
+	})
+
+	t.Run("random suffix on collision", func(t *testing.T) {
+		db := setupTestDB(t)
+		repo := NewPostgres(db, WithSlugs(products.SlugConfig{Suffix: products.SlugSuffixRandom}))
+		ctx := context.Background()
+
+		first, err := repo.Create(ctx, products.CreateInput{Name: "Lamp"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		second, err := repo.Create(ctx, products.CreateInput{Name: "lamp"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if first.Slug != "lamp" || !regexp.MustCompile(`^lamp-[0-9a-f]{6}$`).MatchString(second.Slug) {
+			t.Fatalf("want lamp and lamp-<hex>, got %q and %q", first.Slug, second.Slug)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		db := setupTestDB(t)
+		p, err := NewPostgres(db).Create(context.Background(), products.CreateInput{Name: "Plain"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if p.Slug != "" {
+			t.Fatalf("want no slug without WithSlugs, got %q", p.Slug)
+		}
+	})
+}
+
+func TestPostgresRepository_CreateIfAbsent(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		second string
+	}{
+		{name: "exact name", second: "iPhone"},
+		{name: "case-insensitive names", opts: []Option{WithCaseInsensitiveNames()}, second: "IPHONE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			repo := NewPostgres(db, tt.opts...)
+			ctx := context.Background()
+
+			first, created, err := repo.CreateIfAbsent(ctx, products.CreateInput{Name: "iPhone", Attributes: map[string]any{"color": "black"}})
+			if err != nil || !created {
+				t.Fatalf("want an absent name created, got created=%v, %v", created, err)
+			}
+
+			existing, created, err := repo.CreateIfAbsent(ctx, products.CreateInput{Name: tt.second, Attributes: map[string]any{"color": "white"}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if created {
+				t.Fatal("want a present name not created again")
+			}
+			if existing.ID != first.ID || existing.Attributes["color"] != "black" {
+				t.Fatalf("want the existing product %d untouched, got %+v", first.ID, existing)
+			}
+
+			count, _, err := repo.Count(ctx, products.ListOptions{ExactCount: true})
+			if err != nil || count != 1 {
+				t.Fatalf("want 1 product stored, got %d, %v", count, err)
+			}
+		})
+	}
+}
+
+func TestPostgresRepository_NormalizedSearch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db, WithNormalizedSearch())
+	ctx := context.Background()
+
+	for _, name := range []string{"iPhone 16", "íPhone 15", "Pixel 9"} {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: name}); err != nil {
+			t.Fatalf("create %q: %v", name, err)
+		}
+	}
+
+	for _, search := range []string{"iphone", "íphone", "IPHONE", "Íphone"} {
+		list, err := repo.List(ctx, products.ListOptions{Search: search}, 10, 0)
+		if err != nil {
+			t.Fatalf("search %q: %v", search, err)
+		}
+		var names []string
+		for _, p := range list {
+			names = append(names, p.Name)
+		}
+		// Matches ignore case and accents, but names keep both.
+		if want := []string{"íPhone 15", "iPhone 16"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("search %q: want %v, got %v", search, want, names)
+		}
+	}
+
+	total, _, err := repo.Count(ctx, products.ListOptions{Search: "iphone"})
+	if err != nil || total != 2 {
+		t.Fatalf("want count 2, got %d, %v", total, err)
+	}
+}
+
+func TestPostgresRepository_RankedSearch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	// Created least relevant first, so id order alone would list them the
+	// other way round.
+	for _, name := range []string{"Laptop", "Gaming laptop", "Laptop stand for a laptop", "Phone"} {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: name}); err != nil {
+			t.Fatalf("create %q: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		// Two matching words outrank one; equal ranks keep id order.
+		{search: "laptop", want: []string{"Laptop stand for a laptop", "Gaming laptop", "Laptop"}},
+		{search: "LAP", want: []string{"Laptop stand for a laptop", "Gaming laptop", "Laptop"}},
+		// Every word has to match.
+		{search: "gam lap", want: []string{"Gaming laptop"}},
+		{search: "laptop phone", want: nil},
+	}
+	for _, tt := range tests {
+		list, err := repo.List(ctx, products.ListOptions{Search: tt.search, Rank: true}, 10, 0)
+		if err != nil {
+			t.Fatalf("search %q: %v", tt.search, err)
+		}
+		var names []string
+		for _, p := range list {
+			names = append(names, p.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Fatalf("search %q: want %v, got %v", tt.search, tt.want, names)
+		}
+
+		total, _, err := repo.Count(ctx, products.ListOptions{Search: tt.search, Rank: true})
+		if err != nil || total != int64(len(tt.want)) {
+			t.Fatalf("search %q: want count %d, got %d, %v", tt.search, len(tt.want), total, err)
+		}
+	}
+
+	// A search without words falls back to the substring match.
+	list, err := repo.List(ctx, products.ListOptions{Search: " ", Rank: true}, 10, 0)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("want the 2 names containing a space, got %+v, %v", list, err)
+	}
+}
+
+func TestPostgresRepository_CreateBatch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	t.Run("writes exactly one outbox event per product", func(t *testing.T) {
+		inputs := make([]products.CreateInput, 50)
+		for i := range inputs {
+			inputs[i] = products.CreateInput{Name: fmt.Sprintf("Bulk %d", i)}
+		}
+
+		created, err := repo.CreateBatch(ctx, inputs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(created) != len(inputs) {
+			t.Fatalf("want %d products, got %d", len(inputs), len(created))
+		}
+
+		for _, p := range created {
+			var n int
+			err := db.QueryRowContext(ctx,
+				`SELECT count(*) FROM outbox WHERE (payload->>'product_id')::bigint = $1 AND payload->>'event_type' = $2`,
+				p.ID, products.EventCreated).Scan(&n)
+			if err != nil {
+				t.Fatalf("count outbox rows: %v", err)
+			}
+			if n != 1 {
+				t.Fatalf("product %d: want 1 outbox row, got %d", p.ID, n)
+			}
+		}
+	})
+
+	t.Run("a duplicate rolls back the whole batch", func(t *testing.T) {
+		before, _, _ := repo.Count(ctx, products.ListOptions{})
+		var outboxBefore int
+		_ = db.QueryRowContext(ctx, `SELECT count(*) FROM outbox`).Scan(&outboxBefore)
+
+		_, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "Fresh"}, {Name: "Bulk 0"}})
+		if !errors.Is(err, products.ErrDuplicateName) {
+			t.Fatalf("want ErrDuplicateName, got %v", err)
+		}
+
+		after, _, _ := repo.Count(ctx, products.ListOptions{})
+		var outboxAfter int
+		_ = db.QueryRowContext(ctx, `SELECT count(*) FROM outbox`).Scan(&outboxAfter)
+		if after != before || outboxAfter != outboxBefore {
+			t.Fatalf("want nothing written, products %d -> %d, outbox %d -> %d", before, after, outboxBefore, outboxAfter)
+		}
+	})
+}
+
+func TestPostgresRepository_Version(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, products.CreateInput{Name: "iPhone 16"})
+	if err != nil || created.Version != 1 {
+		t.Fatalf("want version 1 on create, got %d, %v", created.Version, err)
+	}
+	for want := int64(2); want <= 3; want++ {
+		updated, _, err := repo.UpdateAttributes(ctx, created.ID, map[string]any{"rev": want})
+		if err != nil || updated.Version != want {
+			t.Fatalf("want version %d after update, got %d, %v", want, updated.Version, err)
+		}
+	}
+	if got, err := repo.Get(ctx, created.ID); err != nil || got.Version != 3 {
+		t.Fatalf("want version 3 read back, got %d, %v", got.Version, err)
+	}
+
+	deleted, err := repo.DeleteReturning(ctx, created.ID)
+	if err != nil || deleted.Version != 3 {
+		t.Fatalf("want the deleted row's version 3, got %d, %v", deleted.Version, err)
+	}
+
+	batch, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "Pixel 9"}})
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if _, _, err := repo.UpdateAttributes(ctx, batch[0].ID, map[string]any{"color": "black"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := repo.DeleteBatch(ctx, []int64{batch[0].ID}); err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+	var versions []int64
+	rows, err := db.QueryContext(ctx,
+		`SELECT (payload->>'aggregate_version')::bigint FROM outbox WHERE (payload->>'product_id')::bigint = $1 ORDER BY id`, batch[0].ID)
+	if err != nil {
+		t.Fatalf("query outbox: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	// Created at 1, updated to 2 (not through the outbox), deleted as 3.
+	if want := []int64{1, 3}; !reflect.DeepEqual(versions, want) {
+		t.Fatalf("want outbox versions %v, got %v", want, versions)
+	}
+}
+
+func TestPostgresRepository_DeleteBatch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db, WithDeleteChunkSize(3))
+	ctx := context.Background()
+
+	createAll := func(prefix string, n int) []int64 {
+		inputs := make([]products.CreateInput, n)
+		for i := range inputs {
+			inputs[i] = products.CreateInput{Name: fmt.Sprintf("%s %d", prefix, i)}
+		}
+		created, err := repo.CreateBatch(ctx, inputs)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		ids := make([]int64, len(created))
+		for i, p := range created {
+			ids[i] = p.ID
+		}
+		return ids
+	}
+	countDeletedEvents := func() int {
+		var n int
+		if err := db.QueryRowContext(ctx,
+			`SELECT count(*) FROM outbox WHERE payload->>'event_type' = $1`, products.EventDeleted).Scan(&n); err != nil {
+			t.Fatalf("count outbox rows: %v", err)
+		}
+		return n
+	}
+
+	t.Run("deletes across more ids than the chunk size", func(t *testing.T) {
+		ids := createAll("Chunked", 10)
+		before := countDeletedEvents()
+
+		deleted, err := repo.DeleteBatch(ctx, append(ids, 999999))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if deleted != 10 {
+			t.Fatalf("want 10 deleted with the unknown id skipped, got %d", deleted)
+		}
+		for _, id := range ids {
+			if _, err := repo.Get(ctx, id); !errors.Is(err, products.ErrNotFound) {
+				t.Fatalf("product %d: want ErrNotFound after delete, got %v", id, err)
+			}
+		}
+		if got := countDeletedEvents() - before; got != 10 {
+			t.Fatalf("want 10 product_deleted outbox events, got %d", got)
+		}
+	})
+
+	t.Run("a failing chunk rolls back the earlier ones", func(t *testing.T) {
+		ids := createAll("Guarded", 7)
+		// Make the last product undeletable, so only the third chunk fails.
+		if _, err := db.ExecContext(ctx, `
+			CREATE FUNCTION refuse_guarded_delete() RETURNS trigger AS $$
+			BEGIN
+				IF OLD.name = 'Guarded 6' THEN RAISE EXCEPTION 'undeletable'; END IF;
+				RETURN OLD;
+			END $$ LANGUAGE plpgsql;
+			CREATE TRIGGER refuse_guarded_delete BEFORE DELETE ON products
+				FOR EACH ROW EXECUTE FUNCTION refuse_guarded_delete();
+		`); err != nil {
+			t.Fatalf("create trigger: %v", err)
+		}
+		t.Cleanup(func() {
+			_, _ = db.ExecContext(ctx, `DROP TRIGGER refuse_guarded_delete ON products; DROP FUNCTION refuse_guarded_delete()`)
+		})
+		before := countDeletedEvents()
+
+		if _, err := repo.DeleteBatch(ctx, ids); err == nil {
+			t.Fatal("want an error from the failing chunk")
+		}
+		for _, id := range ids {
+			if _, err := repo.Get(ctx, id); err != nil {
+				t.Fatalf("product %d: want it kept after the rollback, got %v", id, err)
+			}
+		}
+		if got := countDeletedEvents(); got != before {
+			t.Fatalf("want no outbox events after the rollback, got %d new", got-before)
+		}
+	})
+}
+
+func TestPostgresRepository_RelayOutbox(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "A"}, {Name: "B"}, {Name: "C"}})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	var published []int64
+	failOn := created[1].ID
+	publish := func(_ context.Context, event products.ProductEvent) error {
+		if event.ProductID == failOn {
+			return errors.New("broker down")
+		}
+		published = append(published, event.ProductID)
+		return nil
+	}
+
+	n, err := repo.RelayOutbox(ctx, 10, publish)
+	if err == nil || n != 1 {
+		t.Fatalf("want 1 published before the failure and an error, got %d, %v", n, err)
+	}
+
+	failOn = 0
+	n, err = repo.RelayOutbox(ctx, 10, publish)
+	if err != nil || n != 2 {
+		t.Fatalf("want the remaining 2 published, got %d, %v", n, err)
+	}
+
+	n, err = repo.RelayOutbox(ctx, 10, publish)
+	if err != nil || n != 0 {
+		t.Fatalf("want nothing left to publish, got %d, %v", n, err)
+	}
+
+	for i, p := range created {
+		if published[i] != p.ID {
+			t.Fatalf("want events published once each in order, got %v", published)
+		}
+	}
+}
+
+func TestPostgresRepository_QueueBehindOutbox(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "A"}})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	deleted := products.ProductEvent{EventType: products.EventDeleted, ProductID: created[0].ID, AggregateVersion: 2}
+
+	queued, err := repo.QueueBehindOutbox(ctx, deleted)
+	if err != nil || !queued {
+		t.Fatalf("want the event queued behind the unpublished create, got %v, %v", queued, err)
+	}
+
+	var published []string
+	publish := func(_ context.Context, event products.ProductEvent) error {
+		published = append(published, event.EventType)
+		return nil
+	}
+	if _, err := repo.RelayOutbox(ctx, 10, publish); err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	if want := []string{products.EventCreated, products.EventDeleted}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("want events relayed as %v, got %v", want, published)
+	}
+
+	queued, err = repo.QueueBehindOutbox(ctx, deleted)
+	if err != nil || queued {
+		t.Fatalf("want nothing queued once the outbox is drained, got %v, %v", queued, err)
+	}
+}
+
+func TestPostgresRepository_RelayOutboxBatch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "A"}, {Name: "B"}, {Name: "C"}})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	// Only the second event is unconfirmed; the first is kept, and the third
+	// is retried behind it.
+	n, err := repo.RelayOutboxBatch(ctx, 10, func(_ context.Context, events []products.ProductEvent) error {
+		return &products.BatchPublishError{Failed: []int{1}, Unsent: len(events), Total: len(events), Err: errors.New("nacked")}
+	})
+	if err == nil || n != 1 {
+		t.Fatalf("want 1 published and an error, got %d, %v", n, err)
+	}
+
+	var retried []int64
+	n, err = repo.RelayOutboxBatch(ctx, 10, func(_ context.Context, events []products.ProductEvent) error {
+		for _, event := range events {
+			retried = append(retried, event.ProductID)
+		}
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("want the failed event and the one after it published, got %d, %v", n, err)
+	}
+	if len(retried) != 2 || retried[0] != created[1].ID || retried[1] != created[2].ID {
+		t.Fatalf("want products %d and %d retried in order, got %v", created[1].ID, created[2].ID, retried)
+	}
+}
+
+// relayRecorder records which relay published each event.
+type relayRecorder struct {
+	mu sync.Mutex
+	by map[int64][]string
+}
+
+func (r *relayRecorder) publisher(name string) outbox.Publisher {
+	return publishFunc(func(_ context.Context, event products.ProductEvent) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.by[event.ProductID] = append(r.by[event.ProductID], name)
+		return nil
+	})
+}
+
+func (r *relayRecorder) publishers(id int64) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.by[id]
+}
+
+type publishFunc func(context.Context, products.ProductEvent) error
+
+func (f publishFunc) Publish(ctx context.Context, event products.ProductEvent) error {
+	return f(ctx, event)
+}
+
+func TestAdvisoryLeader_SingleRelayPublishes(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	rec := &relayRecorder{by: map[int64][]string{}}
+
+	stops := map[string]func(){}
+	for _, name := range []string{"a", "b"} {
+		relay := outbox.NewRelay(repo, rec.publisher(name), outbox.Config{
+			Interval: 10 * time.Millisecond,
+			Leader:   NewAdvisoryLeader(db, "outbox-relay"),
+		}, logger)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			relay.Run(runCtx)
+			close(done)
+		}()
+		stops[name] = func() {
+			cancel()
+			<-done
+		}
+	}
+	defer func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}()
+
+	waitPublished := func(created []products.Product) string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for _, p := range created {
+			for len(rec.publishers(p.ID)) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("product %d was not published", p.ID)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		leader := rec.publishers(created[0].ID)[0]
+		for _, p := range created {
+			if got := rec.publishers(p.ID); len(got) != 1 || got[0] != leader {
+				t.Fatalf("want product %d published once by the leader %s, got %v", p.ID, leader, got)
+			}
+		}
+		return leader
+	}
+
+	first, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "A"}, {Name: "B"}, {Name: "C"}})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	leader := waitPublished(first)
+
+	// With the leader gone, the other relay takes over.
+	stops[leader]()
+	delete(stops, leader)
+	second, err := repo.CreateBatch(ctx, []products.CreateInput{{Name: "D"}, {Name: "E"}})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if next := waitPublished(second); next == leader {
+		t.Fatalf("want the other relay to take over from %s", leader)
+	}
+}
+
+func TestPostgresRepository_ApproximateCount(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	const rows = 5000
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO products (name) SELECT 'Seed ' || g FROM generate_series(1, $1) g`, rows); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	// As many archived products, which the list and so its estimate leave
+	// out.
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO products (name, status) SELECT 'Archived ' || g, 'archived' FROM generate_series(1, $1) g`, rows); err != nil {
+		t.Fatalf("seed archived: %v", err)
+	}
+
+	exactRepo := NewPostgres(db)
+	exact, _, err := exactRepo.Count(ctx, products.ListOptions{})
+	if err != nil || exact != rows {
+		t.Fatalf("want exact count %d, got %d, %v", rows, exact, err)
+	}
+
+	approxRepo := NewPostgres(db, WithApproximateCount(1000))
+
+	t.Run("falls back to exact before the table is analyzed", func(t *testing.T) {
+		// A fresh table's reltuples is -1 (or 0 on older servers), below
+		// any threshold.
+		got, approximate, err := approxRepo.Count(ctx, products.ListOptions{})
+		if err != nil || got != exact || approximate {
+			t.Fatalf("want exactly %d, got %d (approximate %v), %v", exact, got, approximate, err)
+		}
+	})
+
+	if _, err := db.ExecContext(ctx, `ANALYZE products`); err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	// Rows added after ANALYZE are invisible to the estimate, which shows
+	// the estimate path is the one taken.
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO products (name) SELECT 'Late ' || g FROM generate_series(1, 100) g`); err != nil {
+		t.Fatalf("seed late rows: %v", err)
+	}
+
+	t.Run("estimates above the threshold", func(t *testing.T) {
+		got, approximate, err := approxRepo.Count(ctx, products.ListOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !approximate || got < rows*9/10 || got > rows*11/10 {
+			t.Fatalf("want an estimate near %d of the published products, got %d (approximate %v)", rows, got, approximate)
+		}
+	})
+
+	t.Run("exact when requested", func(t *testing.T) {
+		got, approximate, err := approxRepo.Count(ctx, products.ListOptions{ExactCount: true})
+		if err != nil || got != rows+100 || approximate {
+			t.Fatalf("want %d, got %d, %v", rows+100, got, err)
+		}
+	})
+
+	t.Run("exact when filtered", func(t *testing.T) {
+		got, _, err := approxRepo.Count(ctx, products.ListOptions{Search: "Late"})
+		if err != nil || got != 100 {
+			t.Fatalf("want 100, got %d, %v", got, err)
+		}
+	})
+
+	t.Run("exact below the threshold", func(t *testing.T) {
+		got, _, err := NewPostgres(db, WithApproximateCount(1_000_000)).Count(ctx, products.ListOptions{})
+		if err != nil || got != rows+100 {
+			t.Fatalf("want %d, got %d, %v", rows+100, got, err)
+		}
+	})
+}
+
+func TestPostgresRepository_StatementTimeout(t *testing.T) {
+	db := setupTestDB(t)
+	// One connection, so the check below sees the connection the timed
+	// out query ran on.
+	db.SetMaxOpenConns(1)
+
+	ctx := products.WithStatementTimeout(context.Background(), 50*time.Millisecond)
+
+	start := time.Now()
+	err := withStatementTimeout(ctx, db, func(q querier) error {
+		return q.QueryRowContext(ctx, `SELECT pg_sleep(5)`).Scan(new(string))
+	})
+	if !errors.Is(err, products.ErrQueryTimeout) {
+		t.Fatalf("want ErrQueryTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("slow query ran for %v, expected the statement timeout to cancel it", elapsed)
+	}
+
+	var setting string
+	if err := db.QueryRowContext(context.Background(), `SHOW statement_timeout`).Scan(&setting); err != nil {
+		t.Fatalf("show statement_timeout: %v", err)
+	}
+	if setting != "0" {
+		t.Fatalf("want the timeout scoped to its transaction, connection has %q", setting)
+	}
+
+	repo := NewPostgres(db)
+	if _, err := repo.List(ctx, products.ListOptions{Search: "x"}, 10, 0); err != nil {
+		t.Fatalf("fast list under the timeout: %v", err)
+	}
+}
+
+func TestPostgresRepository_OwnerQuota(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db, WithOwnerQuota(products.OwnerQuota{
+		Default:   2,
+		Overrides: map[string]int64{"vip": 0},
+	}))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: fmt.Sprintf("Acme %d", i), Owner: "acme"}); err != nil {
+			t.Fatalf("create %d within quota: %v", i, err)
+		}
+	}
+
+	t.Run("at the limit", func(t *testing.T) {
+		count, err := repo.CountByOwner(ctx, "acme")
+		if err != nil || count != 2 {
+			t.Fatalf("want 2 products owned, got %d, %v", count, err)
+		}
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		_, err := repo.Create(ctx, products.CreateInput{Name: "Acme 2", Owner: "acme"})
+		if !errors.Is(err, products.ErrQuotaExceeded) {
+			t.Fatalf("want ErrQuotaExceeded, got %v", err)
+		}
+		_, err = repo.CreateBatch(ctx, []products.CreateInput{{Name: "Acme 3", Owner: "acme"}})
+		if !errors.Is(err, products.ErrQuotaExceeded) {
+			t.Fatalf("want ErrQuotaExceeded from batch, got %v", err)
+		}
+	})
+
+	t.Run("batch counts its own items", func(t *testing.T) {
+		_, err := repo.CreateBatch(ctx, []products.CreateInput{
+			{Name: "Globex 0", Owner: "globex"},
+			{Name: "Globex 1", Owner: "globex"},
+			{Name: "Globex 2", Owner: "globex"},
+		})
+		if !errors.Is(err, products.ErrQuotaExceeded) {
+			t.Fatalf("want ErrQuotaExceeded, got %v", err)
+		}
+		if count, _ := repo.CountByOwner(ctx, "globex"); count != 0 {
+			t.Fatalf("want the rejected batch rolled back, got %d products", count)
+		}
+	})
+
+	t.Run("concurrent creates cannot overshoot", func(t *testing.T) {
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			go func(i int) {
+				_, err := repo.Create(ctx, products.CreateInput{Name: fmt.Sprintf("Initech %d", i), Owner: "initech"})
+				errs <- err
+			}(i)
+		}
+		created := 0
+		for i := 0; i < 5; i++ {
+			if err := <-errs; err == nil {
+				created++
+			} else if !errors.Is(err, products.ErrQuotaExceeded) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if created != 2 {
+			t.Fatalf("want exactly 2 creates to succeed, got %d", created)
+		}
+	})
+
+	t.Run("override and unowned products are unlimited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if _, err := repo.Create(ctx, products.CreateInput{Name: fmt.Sprintf("VIP %d", i), Owner: "vip"}); err != nil {
+				t.Fatalf("vip create %d: %v", i, err)
+			}
+			if _, err := repo.Create(ctx, products.CreateInput{Name: fmt.Sprintf("Nobody %d", i)}); err != nil {
+				t.Fatalf("unowned create %d: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestPostgresRepository_SuggestNames(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	for _, name := range []string{"iPhone 16", "iPad Air", "IPX_7 case", "Pixel 9", "ipa"} {
+		if _, err := repo.Create(ctx, products.CreateInput{Name: name}); err != nil {
+			t.Fatalf("seed %q: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{name: "case-insensitive prefix", prefix: "IP", limit: 10, want: []string{"IPX_7 case", "iPad Air", "iPhone 16", "ipa"}},
+		{name: "prefix only, not substring", prefix: "ix", limit: 10, want: []string{}},
+		{name: "wildcards match literally", prefix: "ipx_", limit: 10, want: []string{"IPX_7 case"}},
+		{name: "underscore is not a wildcard", prefix: "ip_", limit: 10, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.SuggestNames(ctx, tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Order follows the database collation; only the set matters here.
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		got, err := repo.SuggestNames(ctx, "ip", 2)
+		if err != nil || len(got) != 2 {
+			t.Fatalf("want 2 names, got %q, %v", got, err)
+		}
+	})
+}
+
+func TestPostgresRepository_Expiry(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	future := time.Now().Add(time.Hour)
+	live, err := repo.Create(ctx, products.CreateInput{Name: "Fresh", ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("create live product: %v", err)
+	}
+	if live.ExpiresAt == nil || !live.ExpiresAt.Equal(future.Truncate(time.Microsecond)) {
+		t.Fatalf("want expires_at %v, got %v", future, live.ExpiresAt)
+	}
+	if _, err := repo.Create(ctx, products.CreateInput{Name: "Forever"}); err != nil {
+		t.Fatalf("create product without expiry: %v", err)
+	}
+
+	cursor, err := repo.ExpiryCursor(ctx)
+	if err != nil {
+		t.Fatalf("get expiry cursor: %v", err)
+	}
+
+	var expired []products.Product
+	for _, name := range []string{"Stale", "Staler"} {
+		p, err := repo.Create(ctx, products.CreateInput{Name: name, ExpiresAt: &future})
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		expired = append(expired, p)
+	}
+	// Both expire at the same instant, after the cursor was seeded.
+	if _, err := db.ExecContext(ctx, `UPDATE products SET expires_at = now() - interval '1 millisecond' WHERE id = ANY($1)`,
+		pq.Array([]int64{expired[0].ID, expired[1].ID})); err != nil {
+		t.Fatalf("expire products: %v", err)
+	}
+
+	t.Run("hidden from reads by default", func(t *testing.T) {
+		if _, err := repo.Get(ctx, expired[0].ID); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound for an expired product, got %v", err)
+		}
+		if _, err := repo.Get(ctx, live.ID); err != nil {
+			t.Fatalf("get live product: %v", err)
+		}
+		// A delete still finds it, and so must its dry run.
+		if p, err := repo.GetIncludingExpired(ctx, expired[0].ID); err != nil || p.ID != expired[0].ID {
+			t.Fatalf("want the expired product found as a delete would, got %+v (err %v)", p, err)
+		}
+		if p, err := repo.GetByPublicIDIncludingExpired(ctx, expired[0].PublicID); err != nil || p.ID != expired[0].ID {
+			t.Fatalf("want the expired product found by public id as a delete would, got %+v (err %v)", p, err)
+		}
+
+		list, err := repo.List(ctx, products.ListOptions{}, 10, 0)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("want the 2 unexpired products, got %+v", list)
+		}
+		total, _, err := repo.Count(ctx, products.ListOptions{})
+		if err != nil || total != 2 {
+			t.Fatalf("want count 2, got %d (err %v)", total, err)
+		}
+	})
+
+	t.Run("listed on request", func(t *testing.T) {
+		list, err := repo.List(ctx, products.ListOptions{IncludeExpired: true}, 10, 0)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(list) != 4 {
+			t.Fatalf("want all 4 products, got %+v", list)
+		}
+	})
+
+	t.Run("swept once past the cursor", func(t *testing.T) {
+		got, err := repo.ListExpired(ctx, cursor, 1)
+		if err != nil {
+			t.Fatalf("list expired: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != expired[0].ID {
+			t.Fatalf("want product %d first, got %+v", expired[0].ID, got)
+		}
+
+		next := products.ExpiryCursor{ExpiresAt: *got[0].ExpiresAt, ProductID: got[0].ID}
+		if err := repo.SaveExpiryCursor(ctx, next); err != nil {
+			t.Fatalf("save expiry cursor: %v", err)
+		}
+		saved, err := repo.ExpiryCursor(ctx)
+		if err != nil {
+			t.Fatalf("get expiry cursor: %v", err)
+		}
+
+		got, err = repo.ListExpired(ctx, saved, 10)
+		if err != nil {
+			t.Fatalf("list expired: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != expired[1].ID {
+			t.Fatalf("want only product %d past the saved cursor, got %+v", expired[1].ID, got)
+		}
+	})
+}
+
+func TestPostgresRepository_Reservations(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	p, err := repo.Create(ctx, products.CreateInput{Name: "Reservable"})
+	if err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	const ttl = time.Hour
+
+	t.Run("reserve", func(t *testing.T) {
+		var now time.Time
+		if err := db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+			t.Fatalf("now: %v", err)
+		}
+		res, err := repo.Reserve(ctx, p.ID, "alice", ttl)
+		if err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+		if res.ProductID != p.ID || res.ReservedBy != "alice" {
+			t.Fatalf("want product %d reserved by alice, got %+v", p.ID, res)
+		}
+		if d := res.ReservedUntil.Sub(now); d < ttl || d > ttl+time.Minute {
+			t.Fatalf("want the reservation to end %v after the database's now, got %v", ttl, d)
+		}
+		got, err := repo.Get(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got.Version != p.Version {
+			t.Fatalf("want version %d kept, got %d", p.Version, got.Version)
+		}
+	})
+
+	t.Run("double reserve conflicts", func(t *testing.T) {
+		if _, err := repo.Reserve(ctx, p.ID, "bob", ttl); !errors.Is(err, products.ErrAlreadyReserved) {
+			t.Fatalf("want ErrAlreadyReserved, got %v", err)
+		}
+		if _, err := repo.Reserve(ctx, p.ID, "alice", ttl); !errors.Is(err, products.ErrAlreadyReserved) {
+			t.Fatalf("want ErrAlreadyReserved for the holder too, got %v", err)
+		}
+	})
+
+	t.Run("release by someone else conflicts", func(t *testing.T) {
+		if err := repo.Release(ctx, p.ID, "bob"); !errors.Is(err, products.ErrReservedByOther) {
+			t.Fatalf("want ErrReservedByOther, got %v", err)
+		}
+		if _, err := repo.Reserve(ctx, p.ID, "bob", ttl); !errors.Is(err, products.ErrAlreadyReserved) {
+			t.Fatalf("want alice's reservation kept, got %v", err)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		if err := repo.Release(ctx, p.ID, "alice"); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if _, err := repo.Reserve(ctx, p.ID, "bob", ttl); err != nil {
+			t.Fatalf("reserve after release: %v", err)
+		}
+		if err := repo.Release(ctx, p.ID, "bob"); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		if err := repo.Release(ctx, p.ID, "carol"); err != nil {
+			t.Fatalf("release of an unreserved product: %v", err)
+		}
+	})
+
+	t.Run("unknown product", func(t *testing.T) {
+		if _, err := repo.Reserve(ctx, 999999, "alice", ttl); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound from reserve, got %v", err)
+		}
+		if err := repo.Release(ctx, 999999, "alice"); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound from release, got %v", err)
+		}
+	})
+
+	t.Run("expired product", func(t *testing.T) {
+		gone, err := repo.Create(ctx, products.CreateInput{Name: "Expired"})
+		if err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE products SET expires_at = now() - interval '1 millisecond' WHERE id = $1`, gone.ID); err != nil {
+			t.Fatalf("expire product: %v", err)
+		}
+		if _, err := repo.Reserve(ctx, gone.ID, "alice", ttl); !errors.Is(err, products.ErrNotFound) {
+			t.Fatalf("want ErrNotFound for an expired product, got %v", err)
+		}
+	})
+
+	t.Run("auto expiry", func(t *testing.T) {
+		res, err := repo.Reserve(ctx, p.ID, "carol", -time.Second)
+		if err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+
+		failing := func(context.Context, products.Product, products.Reservation) error {
+			return errors.New("broker down")
+		}
+		if n, err := repo.ReleaseExpiredReservations(ctx, 10, failing); err == nil || n != 0 {
+			t.Fatalf("want the publish failure and nothing released, got %d, %v", n, err)
+		}
+
+		var got []products.Reservation
+		collect := func(_ context.Context, _ products.Product, res products.Reservation) error {
+			got = append(got, res)
+			return nil
+		}
+		n, err := repo.ReleaseExpiredReservations(ctx, 10, collect)
+		if err != nil || n != 1 {
+			t.Fatalf("want 1 reservation released, got %d, %v", n, err)
+		}
+		if got[0].ProductID != p.ID || got[0].ReservedBy != "carol" || !got[0].ReservedUntil.Equal(res.ReservedUntil) {
+			t.Fatalf("want carol's reservation of product %d, got %+v", p.ID, got[0])
+		}
+		if n, err := repo.ReleaseExpiredReservations(ctx, 10, collect); err != nil || n != 0 {
+			t.Fatalf("want nothing left to release, got %d, %v", n, err)
+		}
+		if _, err := repo.Reserve(ctx, p.ID, "dave", ttl); err != nil {
+			t.Fatalf("reserve after expiry: %v", err)
+		}
+	})
+}
+
+func TestPostgresRepository_ListAfter(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db)
+	ctx := context.Background()
+
+	var ids []int64
+	for _, name := range []string{"A", "B", "C"} {
+		p, err := repo.Create(ctx, products.CreateInput{Name: name})
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	page, err := repo.ListAfter(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != ids[0] || page[1].ID != ids[1] {
+		t.Fatalf("want products %v first, got %+v", ids[:2], page)
+	}
+
+	page, err = repo.ListAfter(ctx, page[1].ID, 2)
+	if err != nil {
+		t.Fatalf("second page: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != ids[2] {
+		t.Fatalf("want only product %d after the cursor, got %+v", ids[2], page)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	db := setupTestDB(t)
+	auditLog := NewAuditLog(db)
+	ctx := context.Background()
+
+	rec := products.AuditRecord{
+		Time:      time.Now().UTC(),
+		Action:    products.AuditUpdateAttributes,
+		ProductID: 7,
+		Actor:     "alice",
+		RequestID: "req-1",
+		Before:    &products.Product{ID: 7, Name: "Widget", Attributes: map[string]any{"color": "black"}},
+		After:     &products.Product{ID: 7, Name: "Widget", Attributes: map[string]any{"color": "white"}},
+	}
+	if err := auditLog.Audit(ctx, rec); err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+
+	var action, actor, color string
+	if err := db.QueryRowContext(ctx,
+		`SELECT action, actor, after->'attributes'->>'color' FROM audit_log WHERE product_id = $1`, rec.ProductID,
+	).Scan(&action, &actor, &color); err != nil {
+		t.Fatalf("read audit record: %v", err)
+	}
+	if action != rec.Action || actor != rec.Actor || color != "white" {
+		t.Fatalf("want %s by %s to white, got %s by %s to %s", rec.Action, rec.Actor, action, actor, color)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE audit_log SET actor = 'mallory'`); err == nil {
+		t.Fatal("want updating the audit log refused")
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM audit_log`); err == nil {
+		t.Fatal("want deleting from the audit log refused")
+	}
+}
+
+// recordingAudit keeps every audit record it is given and fails with err.
+type recordingAudit struct {
+	records []products.AuditRecord
+	err     error
+}
+
+func (a *recordingAudit) Audit(_ context.Context, rec products.AuditRecord) error {
+	a.records = append(a.records, rec)
+	return a.err
+}
+
+func TestPostgresRepository_Audit(t *testing.T) {
+	db := setupTestDB(t)
+	sink := &recordingAudit{}
+	repo := NewPostgres(db, WithAudit(sink, true, slog.New(slog.NewJSONHandler(os.Stdout, nil))))
+	ctx := products.WithActor(products.WithRequestID(context.Background(), "req-1"), "alice")
+
+	p, err := repo.Create(ctx, products.CreateInput{Name: "Widget"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, _, err := repo.UpdateAttributes(ctx, p.ID, map[string]any{"color": "white"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, _, err := repo.UpdateStatus(ctx, p.ID, products.StatusArchived); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if _, err := repo.Reserve(ctx, p.ID, "order-1", time.Minute); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := repo.Release(ctx, p.ID, "order-1"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, err := repo.DeleteReturning(ctx, p.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := repo.DeleteBatch(ctx, []int64{p.ID}); err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+
+	var actions []string
+	for _, rec := range sink.records {
+		if rec.Actor != "alice" || rec.RequestID != "req-1" || rec.Time.IsZero() {
+			t.Fatalf("want every record stamped with the actor, request id and time, got %+v", rec)
+		}
+		actions = append(actions, rec.Action)
+	}
+	want := []string{
+		products.AuditCreate, products.AuditUpdateAttributes, products.AuditChangeStatus,
+		products.AuditReserve, products.AuditRelease, products.AuditDelete, products.AuditDeleteBatch,
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("want actions %v, got %v", want, actions)
+	}
+	if update := sink.records[1]; update.Before == nil || update.Before.Version != 1 || update.After.Attributes["color"] != "white" {
+		t.Fatalf("want the update's snapshots from version 1 to white, got %+v", update)
+	}
+	if res := sink.records[4].Reservation; res == nil || res.ReservedBy != "order-1" {
+		t.Fatalf("want the released reservation recorded, got %+v", res)
+	}
+}
+
+func TestPostgresRepository_AuditStrictRollsBack(t *testing.T) {
+	db := setupTestDB(t)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+
+	strict := NewPostgres(db, WithAudit(&recordingAudit{err: errors.New("disk full")}, true, logger))
+	if _, err := strict.Create(ctx, products.CreateInput{Name: "Widget"}); !errors.Is(err, products.ErrAuditFailed) {
+		t.Fatalf("want ErrAuditFailed, got %v", err)
+	}
+	if n, _, err := NewPostgres(db).Count(ctx, products.ListOptions{}); err != nil || n != 0 {
+		t.Fatalf("want the create rolled back, got %d products, %v", n, err)
+	}
+
+	lenient := NewPostgres(db, WithAudit(&recordingAudit{err: errors.New("disk full")}, false, logger))
+	if _, err := lenient.Create(ctx, products.CreateInput{Name: "Widget"}); err != nil {
+		t.Fatalf("want a lenient audit failure ignored, got %v", err)
+	}
+}
+
+func TestPostgresRepository_AuditLogInTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPostgres(db, WithAudit(NewAuditLog(db), false, slog.New(slog.NewJSONHandler(os.Stdout, nil))))
+	ctx := products.WithActor(context.Background(), "alice")
+
+	p, err := repo.Create(ctx, products.CreateInput{Name: "Widget"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := repo.Reserve(ctx, p.ID, "order-1", time.Minute); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	var creates, reserves int
+	if err := db.QueryRowContext(ctx, `
+		SELECT count(*) FILTER (WHERE action = 'create' AND actor = 'alice'),
+			count(*) FILTER (WHERE action = 'reserve' AND reservation->>'reserved_by' = 'order-1')
+		FROM audit_log WHERE product_id = $1
+	`, p.ID).Scan(&creates, &reserves); err != nil {
+		t.Fatalf("read audit records: %v", err)
+	}
+	if creates != 1 || reserves != 1 {
+		t.Fatalf("want one create and one reserve record, got %d and %d", creates, reserves)
+	}
+}
+
+func TestMigrations_NameIndexRenamesDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	driver, err := pgmigrate.WithInstance(db, &pgmigrate.Config{})
+	if err != nil {
+		t.Fatalf("init migrate driver: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+migrationsDir(t), "postgres", driver)
+	if err != nil {
+		t.Fatalf("init migrate: %v", err)
+	}
+	// Back to before names were unique, where duplicates could be stored.
+	if err := m.Migrate(2); err != nil {
+		t.Fatalf("migrate down to 2: %v", err)
+	}
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		var id int64
+		if err := db.QueryRowContext(ctx, `INSERT INTO products (name) VALUES ('Widget') RETURNING id`).Scan(&id); err != nil {
+			t.Fatalf("insert duplicate: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("migrate up over duplicate names: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT name FROM products ORDER BY id`)
+	if err != nil {
+		t.Fatalf("list names: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan name: %v", err)
+		}
+		names = append(names, name)
+	}
+	want := []string{"Widget", fmt.Sprintf("Widget (%d)", ids[1]), fmt.Sprintf("Widget (%d)", ids[2])}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("want the oldest name kept and the rest renamed, %v, got %v", want, names)
+	}
+}
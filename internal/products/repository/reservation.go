@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"product-notifications/internal/products"
+
+	"github.com/lib/pq"
+)
+
+// Reserve holds the product for by for ttl. The end of the reservation is
+// taken from the database clock, which also decides when it has run out,
+// so the application's clock cannot shorten or stretch it. The row is
+// locked while its current reservation is checked, and a product reserved
+// until a time that has not passed yet fails with
+// products.ErrAlreadyReserved, whoever holds it. Reservations are not
+// changes to the product, so its version stays. An expired product is not
+// found, as in Get.
+func (r *PostgresRepository) Reserve(ctx context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return products.Reservation{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var reserved bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(reserved_until > NOW(), FALSE) FROM products WHERE id = $1 AND `+notExpired+` FOR UPDATE`, id).Scan(&reserved)
+	if errors.Is(err, sql.ErrNoRows) {
+		return products.Reservation{}, products.ErrNotFound
+	}
+	if err != nil {
+		return products.Reservation{}, fmt.Errorf("get product %d reservation: %w", id, err)
+	}
+	if reserved {
+		return products.Reservation{}, products.ErrAlreadyReserved
+	}
+
+	res := products.Reservation{ProductID: id, ReservedBy: by}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE products
+		SET reserved_by = $2, reserved_until = NOW() + $3 * interval '1 microsecond'
+		WHERE id = $1
+		RETURNING reserved_until, public_id
+	`, id, by, ttl.Microseconds()).Scan(&res.ReservedUntil, &res.ProductPublicID)
+	if err != nil {
+		return products.Reservation{}, fmt.Errorf("reserve product %d: %w", id, err)
+	}
+	if err := r.audit(ctx, tx, products.AuditRecord{Action: products.AuditReserve, ProductID: id, Reservation: &res}); err != nil {
+		return products.Reservation{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return products.Reservation{}, fmt.Errorf("commit tx: %w", err)
+	}
+	return res, nil
+}
+
+// Release ends the product's reservation on behalf of by, if it has one.
+// A reservation that has not run out can only be released by its holder;
+// anyone else fails with products.ErrReservedByOther. The old row is locked
+// by the same statement, so the reservation checked and audited is the one
+// released.
+func (r *PostgresRepository) Release(ctx context.Context, id int64, by string) error {
+	return r.mutate(ctx, func(q execer) error {
+		var (
+			heldBy sql.NullString
+			until  sql.NullTime
+		)
+		err := q.QueryRowContext(ctx, `
+			UPDATE products p
+			SET reserved_by = NULL, reserved_until = NULL
+			FROM (SELECT id, reserved_by, reserved_until FROM products WHERE id = $1 FOR UPDATE) prev
+			WHERE p.id = prev.id
+			  AND (prev.reserved_until IS NULL OR prev.reserved_until <= NOW() OR prev.reserved_by = $2)
+			RETURNING prev.reserved_by, prev.reserved_until
+		`, id, by).Scan(&heldBy, &until)
+		if errors.Is(err, sql.ErrNoRows) {
+			return r.releaseMissed(ctx, q, id)
+		}
+		if err != nil {
+			return fmt.Errorf("release product %d: %w", id, err)
+		}
+
+		rec := products.AuditRecord{Action: products.AuditRelease, ProductID: id}
+		if until.Valid {
+			rec.Reservation = &products.Reservation{ProductID: id, ReservedBy: heldBy.String, ReservedUntil: until.Time}
+		}
+		return r.audit(ctx, q, rec)
+	})
+}
+
+// releaseMissed tells why Release matched no row: the product is gone, or
+// someone else holds it.
+func (r *PostgresRepository) releaseMissed(ctx context.Context, q execer, id int64) error {
+	var exists bool
+	if err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("release product %d: %w", id, err)
+	}
+	if !exists {
+		return products.ErrNotFound
+	}
+	return products.ErrReservedByOther
+}
+
+// ReleaseExpiredReservations hands up to limit products whose reservation
+// has run out to publish, with the reservation they had, and releases the
+// ones it accepted. It stops at the first publish error; the rest are
+// released on the next call. Like RelayOutbox it claims rows with SKIP
+// LOCKED, so concurrent sweepers never release the same reservation, and a
+// crash between publish and commit announces one twice.
+func (r *PostgresRepository) ReleaseExpiredReservations(ctx context.Context, limit int, publish func(context.Context, products.Product, products.Reservation) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, public_id, name, owner, attributes, created_at, expires_at, version, slug, status,
+			COALESCE(reserved_by, ''), reserved_until
+		FROM products
+		WHERE reserved_until <= NOW()
+		ORDER BY reserved_until, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("claim expired reservations: %w", err)
+	}
+
+	type expired struct {
+		product     products.Product
+		reservation products.Reservation
+	}
+	var claimed []expired
+	for rows.Next() {
+		var e expired
+		// The outer wrapper's column is appended first: reserved_by, then
+		// reserved_until.
+		p, err := scanProduct(withExtraColumn{
+			row:   withExtraColumn{row: rows, extra: &e.reservation.ReservedUntil},
+			extra: &e.reservation.ReservedBy,
+		})
+		if err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scan expired reservation: %w", err)
+		}
+		e.product, e.reservation.ProductID = p, p.ID
+		claimed = append(claimed, e)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate expired reservations: %w", err)
+	}
+
+	var (
+		released   []int64
+		publishErr error
+	)
+	for _, e := range claimed {
+		if publishErr = publish(ctx, e.product, e.reservation); publishErr != nil {
+			publishErr = fmt.Errorf("publish reservation expiry of product %d: %w", e.product.ID, publishErr)
+			break
+		}
+		released = append(released, e.product.ID)
+	}
+
+	if len(released) > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE products SET reserved_by = NULL, reserved_until = NULL WHERE id = ANY($1)`, pq.Array(released)); err != nil {
+			return 0, fmt.Errorf("release expired reservations: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("commit tx: %w", err)
+		}
+	}
+	return len(released), publishErr
+}
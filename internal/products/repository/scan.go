@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"product-notifications/internal/products"
+)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// notExpired is the condition that hides products whose expiry has passed.
+const notExpired = "(expires_at IS NULL OR expires_at > now())"
+
+// scanProduct reads the columns id, public_id, name, owner, attributes,
+// created_at, expires_at, version, slug, status in that order.
+func scanProduct(row rowScanner) (products.Product, error) {
+	var (
+		p         products.Product
+		attrs     []byte
+		expiresAt sql.NullTime
+		slug      sql.NullString
+	)
+	if err := row.Scan(&p.ID, &p.PublicID, &p.Name, &p.Owner, &attrs, &p.CreatedAt, &expiresAt, &p.Version, &slug, &p.Status); err != nil {
+		return products.Product{}, err
+	}
+	p.ExpiresAt = nullTime(expiresAt)
+	p.Slug = slug.String
+
+	var err error
+	if p.Attributes, err = decodeAttributes(attrs); err != nil {
+		return products.Product{}, err
+	}
+	return p, nil
+}
+
+// withExtraColumn lets scanProduct read a row that has one more column
+// after the product's, scanning it into extra.
+type withExtraColumn struct {
+	row   rowScanner
+	extra any
+}
+
+func (w withExtraColumn) Scan(dest ...any) error {
+	return w.row.Scan(append(dest, w.extra)...)
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// encodeAttributes returns a string rather than []byte: lib/pq sends
+// []byte parameters as bytea, which Postgres will not cast to JSONB.
+func encodeAttributes(attrs map[string]any) (string, error) {
+	if attrs == nil {
+		return "{}", nil
+	}
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return "", fmt.Errorf("marshal attributes: %w", err)
+	}
+	return string(raw), nil
+}
+
+// decodeAttributes returns nil for an empty object so products without
+// attributes omit the field in JSON responses.
+func decodeAttributes(raw []byte) (map[string]any, error) {
+	var attrs map[string]any
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, fmt.Errorf("unmarshal attributes: %w", err)
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+// filter accumulates WHERE conditions and their positional arguments.
+type filter struct {
+	conds []string
+	args  []any
+	// order, when set, lists matches by relevance before the usual order.
+	order string
+}
+
+// add appends a condition whose single placeholder is written as %d.
+func (f *filter) add(cond string, arg any) {
+	f.args = append(f.args, arg)
+	f.conds = append(f.conds, fmt.Sprintf(cond, len(f.args)))
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// likeEscaper escapes LIKE wildcards so a search term matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// prefixQuery turns search into a tsquery matching names that have a word
+// starting with each of its words, or "" when it has none. Words are runs
+// of letters and digits, so nothing in them needs quoting.
+func prefixQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = "'" + w + "':*"
+	}
+	return strings.Join(words, " & ")
+}
+
+func (r *PostgresRepository) buildFilter(opts products.ListOptions) (*filter, error) {
+	f := &filter{}
+	ranked := false
+	if opts.Rank {
+		if q := prefixQuery(opts.Search); q != "" {
+			f.add("search_vector @@ to_tsquery('simple', $%d)", q)
+			f.order = fmt.Sprintf("ts_rank(search_vector, to_tsquery('simple', $%d)) DESC, ", len(f.args))
+			ranked = true
+		}
+	}
+	switch {
+	case opts.Search == "" || ranked:
+	case r.normalizedSearch:
+		f.add("search_name LIKE '%%' || products_search_name($%d) || '%%'", likeEscaper.Replace(opts.Search))
+	case r.caseInsensitiveNames:
+		f.add("name ILIKE '%%' || $%d || '%%'", likeEscaper.Replace(opts.Search))
+	default:
+		f.add("name LIKE '%%' || $%d || '%%'", likeEscaper.Replace(opts.Search))
+	}
+	if len(opts.Attributes) > 0 {
+		attrs, err := encodeAttributes(opts.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		f.add("attributes @> $%d", attrs)
+	}
+	if !opts.IncludeExpired {
+		f.conds = append(f.conds, notExpired)
+	}
+	status := opts.Status
+	if status == "" {
+		status = products.StatusPublished
+	}
+	f.add("status = $%d", status)
+	if opts.MinID > 0 {
+		f.add("id >= $%d", opts.MinID)
+	}
+	if opts.MaxID > 0 {
+		f.add("id <= $%d", opts.MaxID)
+	}
+	return f, nil
+}
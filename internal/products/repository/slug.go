@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+
+	"product-notifications/internal/products"
+)
+
+// maxRandomSlugAttempts bounds how many random suffixes assignSlug tries
+// before giving up; with six hex digits a collision is already unlikely.
+const maxRandomSlugAttempts = 5
+
+// assignSlug picks a slug for name that no product holds. It takes a
+// transaction-scoped advisory lock on the base slug first, so concurrent
+// creates of names with the same base cannot pick the same slug; the lock
+// is held until tx ends.
+func (r *PostgresRepository) assignSlug(ctx context.Context, tx *sql.Tx, name string) (string, error) {
+	base := products.Slugify(name, *r.slugs)
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::int, hashtext($2))`, lockClassSlug, base); err != nil {
+		return "", fmt.Errorf("lock slug: %w", err)
+	}
+
+	if r.slugs.Suffix == products.SlugSuffixRandom {
+		return r.randomSlug(ctx, tx, base)
+	}
+	return r.counterSlug(ctx, tx, base)
+}
+
+// counterSlug returns base if it is free, or base-N with N one past the
+// highest counter taken. A single max() over the slugs starting with
+// base-, read through idx_products_slug_pattern, finds it; only those
+// whose remainder is all digits count, so base-pro or base-2b do not.
+func (r *PostgresRepository) counterSlug(ctx context.Context, tx *sql.Tx, base string) (string, error) {
+	prefix := products.SlugWithSuffix(base, "", *r.slugs)
+	var highest int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(CASE WHEN slug = $1 THEN 1 ELSE substring(slug FROM $3)::bigint END), 0)
+		FROM products
+		WHERE slug = $1
+		   OR (slug LIKE $2 || '%' AND substring(slug FROM $3) ~ '^[1-9][0-9]{0,17}$')
+	`, base, likeEscaper.Replace(prefix), utf8.RuneCountInString(prefix)+1).Scan(&highest)
+	if err != nil {
+		return "", fmt.Errorf("query slug counter of %q: %w", base, err)
+	}
+	return products.SlugWithCounter(base, int(highest)+1, *r.slugs), nil
+}
+
+// randomSlug returns base if it is free, or base with a random suffix.
+func (r *PostgresRepository) randomSlug(ctx context.Context, tx *sql.Tx, base string) (string, error) {
+	candidate := base
+	for attempt := 0; attempt <= maxRandomSlugAttempts; attempt++ {
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return "", fmt.Errorf("random slug suffix: %w", err)
+			}
+			candidate = products.SlugWithSuffix(base, hex.EncodeToString(suffix), *r.slugs)
+		}
+
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE slug = $1)`, candidate).Scan(&taken); err != nil {
+			return "", fmt.Errorf("check slug %q: %w", candidate, err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free slug for %q after %d attempts", base, maxRandomSlugAttempts)
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"product-notifications/internal/products"
+
+	"github.com/lib/pq"
+)
+
+// querier is the read subset shared by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withStatementTimeout runs fn against db, or, when ctx carries a
+// products.StatementTimeout, inside a read-only transaction that applies it
+// with SET LOCAL. The setting ends with the transaction, so it never leaks
+// onto the pooled connection.
+func withStatementTimeout(ctx context.Context, db *sql.DB, fn func(q querier) error) error {
+	timeout, ok := products.StatementTimeout(ctx)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// SET takes no bind parameters. Zero would mean no timeout, so
+	// sub-millisecond values round up.
+	ms := max(timeout.Milliseconds(), 1)
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)); err != nil {
+		return fmt.Errorf("set statement timeout: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if ctx.Err() == nil && isQueryCanceled(err) {
+			return fmt.Errorf("%w: %w", products.ErrQueryTimeout, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func isQueryCanceled(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgQueryCanceled
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrInternal marks failures caused by how the repository was set up, such
+// as a nil database, rather than by a query.
+var ErrInternal = errors.New("repository misconfigured")
+
+var errNilDB = fmt.Errorf("%w: nil *sql.DB", ErrInternal)
+
+// nilConnector backs the stand-in for a nil *sql.DB handed to NewPostgres:
+// every query on it fails with errNilDB instead of panicking.
+type nilConnector struct{}
+
+func (nilConnector) Connect(context.Context) (driver.Conn, error) { return nil, errNilDB }
+func (c nilConnector) Driver() driver.Driver                      { return c }
+func (nilConnector) Open(string) (driver.Conn, error)             { return nil, errNilDB }
+
+// Validate pings db once, so a nil, closed or unreachable database fails
+// at startup with a clear error rather than on the first request. A nil db
+// fails with an error wrapping ErrInternal.
+func Validate(ctx context.Context, db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("validate repository database: %w", errNilDB)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("validate repository database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"product-notifications/internal/products"
+)
+
+func TestNewPostgres_NilDB(t *testing.T) {
+	repo := NewPostgres(nil)
+	ctx := context.Background()
+
+	if err := Validate(ctx, nil); !errors.Is(err, ErrInternal) {
+		t.Fatalf("want Validate to fail with ErrInternal, got %v", err)
+	}
+	if _, err := repo.Get(ctx, 1); !errors.Is(err, ErrInternal) {
+		t.Fatalf("want Get to fail with ErrInternal, got %v", err)
+	}
+	if _, err := repo.Create(ctx, products.CreateInput{Name: "iPhone 16"}); !errors.Is(err, ErrInternal) {
+		t.Fatalf("want Create to fail with ErrInternal, got %v", err)
+	}
+	if _, err := repo.List(ctx, products.ListOptions{}, 10, 0); !errors.Is(err, ErrInternal) {
+		t.Fatalf("want List to fail with ErrInternal, got %v", err)
+	}
+	if err := repo.Health(); !errors.Is(err, ErrInternal) {
+		t.Fatalf("want Health to fail with ErrInternal, got %v", err)
+	}
+}
+
+func TestValidate_ClosedDB(t *testing.T) {
+	// Opening never connects, so no server is needed for a closed handle.
+	db, err := sql.Open("postgres", "postgres://localhost/unused")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = db.Close()
+
+	if err := Validate(context.Background(), db); err == nil {
+		t.Fatal("want Validate to fail on a closed database")
+	}
+}
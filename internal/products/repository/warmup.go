@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// WarmUp opens n connections to db in parallel, pings each, and hands them
+// back to the pool, so the first burst of requests after startup does not
+// pay for connecting. Connections beyond db's idle limit are closed again
+// on release, so n should not exceed it.
+func WarmUp(ctx context.Context, db *sql.DB, n int) error {
+	conns := make([]*sql.Conn, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			conns[i] = conn
+			errs[i] = conn.PingContext(ctx)
+		}()
+	}
+	// Every connection is held until all are open; releasing one early
+	// would let another goroutine reuse it instead of opening its own.
+	wg.Wait()
+
+	var first error
+	for i, conn := range conns {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if errs[i] != nil && first == nil {
+			first = errs[i]
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("warm up connections: %w", first)
+	}
+	return nil
+}
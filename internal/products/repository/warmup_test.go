@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// countingDriver opens connections that do nothing but count themselves.
+type countingDriver struct {
+	opened atomic.Int64
+}
+
+func (d *countingDriver) Open(string) (driver.Conn, error) {
+	d.opened.Add(1)
+	return countingConn{}, nil
+}
+
+type countingConn struct{}
+
+func (countingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (countingConn) Close() error                        { return nil }
+func (countingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestWarmUp(t *testing.T) {
+	drv := &countingDriver{}
+	db := sql.OpenDB(dsnConnector{drv})
+	defer db.Close()
+	db.SetMaxIdleConns(5)
+
+	if err := WarmUp(context.Background(), db, 4); err != nil {
+		t.Fatalf("warm up: %v", err)
+	}
+
+	stats := db.Stats()
+	if stats.OpenConnections != 4 || stats.Idle != 4 {
+		t.Fatalf("want 4 open idle connections, got %d open, %d idle", stats.OpenConnections, stats.Idle)
+	}
+	if got := drv.opened.Load(); got != 4 {
+		t.Fatalf("want 4 connections dialed, got %d", got)
+	}
+}
+
+// dsnConnector adapts a driver.Driver to sql.OpenDB without registering it
+// globally.
+type dsnConnector struct {
+	drv driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open("") }
+func (c dsnConnector) Driver() driver.Driver                        { return c.drv }
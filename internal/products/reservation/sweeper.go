@@ -0,0 +1,97 @@
+// Package reservation releases product reservations that have run out.
+package reservation
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const (
+	defaultInterval  = 30 * time.Second
+	defaultBatchSize = 100
+)
+
+type Store interface {
+	ReleaseExpiredReservations(ctx context.Context, limit int, publish func(context.Context, products.Product, products.Reservation) error) (int, error)
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event products.ProductEvent) error
+}
+
+type Config struct {
+	// Interval is how long the sweeper sleeps once no expired reservation
+	// is left.
+	Interval time.Duration
+	// BatchSize is how many reservations are released per transaction.
+	BatchSize int
+}
+
+// Sweeper releases every reservation whose time has passed and publishes a
+// product_reservation_expired event for it. The store claims reservations
+// with SKIP LOCKED, so sweepers on several instances share the work
+// without electing a leader.
+type Sweeper struct {
+	store     Store
+	publisher Publisher
+	cfg       Config
+	logger    *slog.Logger
+}
+
+func NewSweeper(store Store, publisher Publisher, cfg Config, logger *slog.Logger) *Sweeper {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	return &Sweeper{store: store, publisher: publisher, cfg: cfg, logger: logger}
+}
+
+// Run sweeps until ctx is done. A full batch is followed straight away by
+// the next one; otherwise the sweeper waits Interval.
+func (s *Sweeper) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := s.cfg.Interval
+		n, err := s.Sweep(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			s.logger.Error("release expired reservations", "released", n, "error", err)
+		case err == nil && n == s.cfg.BatchSize:
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Sweep releases up to BatchSize expired reservations and reports how many
+// it released. A reservation whose event fails to publish stays in place
+// and is retried on the next sweep.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	return s.store.ReleaseExpiredReservations(ctx, s.cfg.BatchSize, s.announce)
+}
+
+func (s *Sweeper) announce(ctx context.Context, p products.Product, res products.Reservation) error {
+	return s.publisher.Publish(ctx, products.ProductEvent{
+		EventType: products.EventReservationExpired,
+		ProductID: p.ID,
+		Name:      p.Name,
+		Owner:     p.Owner,
+		Timestamp: res.ReservedUntil.UTC(),
+		// Reservations are not changes to the product, so the version
+		// stays.
+		AggregateVersion: p.Version,
+	})
+}
@@ -0,0 +1,143 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+// fakeStore holds reservations that have all run out and releases them the
+// way ReleaseExpiredReservations does: oldest first, keeping any the
+// publish callback rejects.
+type fakeStore struct {
+	mu       sync.Mutex
+	reserved []products.Reservation
+}
+
+func (f *fakeStore) ReleaseExpiredReservations(ctx context.Context, limit int, publish func(context.Context, products.Product, products.Reservation) error) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	released := 0
+	for released < limit && released < len(f.reserved) {
+		res := f.reserved[released]
+		p := products.Product{ID: res.ProductID, Name: "p", Version: 3}
+		if err := publish(ctx, p, res); err != nil {
+			f.reserved = f.reserved[released:]
+			return released, err
+		}
+		released++
+	}
+	f.reserved = f.reserved[released:]
+	return released, nil
+}
+
+type flakyPublisher struct {
+	mu       sync.Mutex
+	failures int
+	events   []products.ProductEvent
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, event products.ProductEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker down")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *flakyPublisher) published() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]int64, len(p.events))
+	for i, e := range p.events {
+		ids[i] = e.ProductID
+	}
+	return ids
+}
+
+func newStore(start time.Time) *fakeStore {
+	store := &fakeStore{}
+	for id := int64(1); id <= 5; id++ {
+		store.reserved = append(store.reserved, products.Reservation{
+			ProductID:     id,
+			ReservedBy:    "alice",
+			ReservedUntil: start.Add(time.Duration(id) * time.Second),
+		})
+	}
+	return store
+}
+
+func newTestSweeper(store Store, pub Publisher) *Sweeper {
+	return NewSweeper(store, pub, Config{Interval: time.Millisecond, BatchSize: 2}, slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+func TestSweeper_PublishesEachExpiryOnce(t *testing.T) {
+	start := time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC)
+	store := newStore(start)
+	pub := &flakyPublisher{failures: 1}
+	sweeper := newTestSweeper(store, pub)
+	ctx := context.Background()
+
+	if _, err := sweeper.Sweep(ctx); err == nil {
+		t.Fatal("want the publish failure reported")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := sweeper.Sweep(ctx); err != nil {
+			t.Fatalf("sweep %d: %v", i, err)
+		}
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(pub.published(), want) {
+		t.Fatalf("want each expiry published once in order %v, got %v", want, pub.published())
+	}
+	first := pub.events[0]
+	if first.EventType != products.EventReservationExpired {
+		t.Fatalf("want event type %q, got %q", products.EventReservationExpired, first.EventType)
+	}
+	if !first.Timestamp.Equal(start.Add(time.Second)) {
+		t.Fatalf("want the event stamped with the reservation's end, got %v", first.Timestamp)
+	}
+	if first.AggregateVersion != 3 {
+		t.Fatalf("want the product's version kept, got %d", first.AggregateVersion)
+	}
+	if n, _ := sweeper.Sweep(ctx); n != 0 {
+		t.Fatalf("want nothing left to sweep, released %d", n)
+	}
+}
+
+func TestSweeper_Run(t *testing.T) {
+	store := newStore(time.Date(2026, 2, 24, 12, 0, 0, 0, time.UTC))
+	pub := &flakyPublisher{}
+	sweeper := newTestSweeper(store, pub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := len(pub.published())
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want 5 expiries published, got %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
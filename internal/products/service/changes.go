@@ -0,0 +1,54 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"product-notifications/internal/products"
+)
+
+const attributeFieldPrefix = "attributes."
+
+// attributeChanges reports which attributes differ between previous and
+// current, as sorted field names and their values. Values are compared by
+// their JSON encoding, the form they are stored in. Old values are only
+// included with withOld.
+func attributeChanges(previous, current map[string]any, withOld bool) ([]string, map[string]products.FieldChange) {
+	var (
+		fields  []string
+		changes map[string]products.FieldChange
+	)
+	record := func(key string, oldValue, newValue any) {
+		field := attributeFieldPrefix + key
+		change := products.FieldChange{New: newValue}
+		if withOld {
+			change.Old = oldValue
+		}
+		if changes == nil {
+			changes = make(map[string]products.FieldChange)
+		}
+		fields = append(fields, field)
+		changes[field] = change
+	}
+
+	for key, newValue := range current {
+		oldValue, ok := previous[key]
+		if !ok || !sameJSON(oldValue, newValue) {
+			record(key, oldValue, newValue)
+		}
+	}
+	for key, oldValue := range previous {
+		if _, ok := current[key]; !ok {
+			record(key, oldValue, nil)
+		}
+	}
+	sort.Strings(fields)
+	return fields, changes
+}
+
+func sameJSON(a, b any) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
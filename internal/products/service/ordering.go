@@ -0,0 +1,160 @@
+package service
+
+import "sync"
+
+// eventOrder keeps the events of one product in the order their changes
+// were committed, from commit to the publisher. Without it two requests
+// racing on a product can commit in one order and publish in the other,
+// e.g. a product_deleted before the product_created it follows.
+//
+// A change to an existing product holds the product's lock from before its
+// repository call until its event is handed to the publisher, so the next
+// change's event waits for it. A create cannot lock a product that has no
+// id yet. Instead it takes a ticket before its insert, marks the product as
+// being created once the insert returns the id, and clears the mark after
+// handing product_created over. The first change after a create waits for
+// the creates holding earlier tickets to learn their ids, which takes only
+// as long as their inserts, and then for its own product's mark.
+type eventOrder struct {
+	mu sync.Mutex
+	// resolved is signalled whenever a create's ticket is resolved.
+	resolved *sync.Cond
+	locks    map[int64]*productLock
+
+	next uint64
+	// unresolved holds the tickets of creates whose id is not known yet.
+	unresolved map[uint64]bool
+	// creating holds the products whose product_created is not handed
+	// over yet, each with a channel closed once it is.
+	creating map[int64]chan struct{}
+}
+
+type productLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newEventOrder() *eventOrder {
+	o := &eventOrder{
+		locks:      make(map[int64]*productLock),
+		unresolved: make(map[uint64]bool),
+		creating:   make(map[int64]chan struct{}),
+	}
+	o.resolved = sync.NewCond(&o.mu)
+	return o
+}
+
+// pendingCreate is a create in flight; a nil one does nothing.
+type pendingCreate struct {
+	o      *eventOrder
+	ticket uint64
+	id     int64
+	done   chan struct{}
+}
+
+// create marks a create in flight. Call stored once its product has an id
+// and finish once its event is handed over, or it failed.
+func (o *eventOrder) create() *pendingCreate {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := &pendingCreate{o: o, ticket: o.next}
+	o.next++
+	o.unresolved[p.ticket] = true
+	return p
+}
+
+// stored records that the create produced product id.
+func (p *pendingCreate) stored(id int64) {
+	if p == nil {
+		return
+	}
+	p.o.mu.Lock()
+	defer p.o.mu.Unlock()
+	p.id, p.done = id, make(chan struct{})
+	p.o.creating[id] = p.done
+	p.resolve()
+}
+
+// finish ends the create.
+func (p *pendingCreate) finish() {
+	if p == nil {
+		return
+	}
+	p.o.mu.Lock()
+	defer p.o.mu.Unlock()
+	if p.done != nil {
+		delete(p.o.creating, p.id)
+		close(p.done)
+		return
+	}
+	p.resolve()
+}
+
+func (p *pendingCreate) resolve() {
+	if p.o.unresolved[p.ticket] {
+		delete(p.o.unresolved, p.ticket)
+		p.o.resolved.Broadcast()
+	}
+}
+
+// lock holds id's lock until the returned func is called.
+func (o *eventOrder) lock(id int64) (unlock func()) {
+	if o == nil {
+		return func() {}
+	}
+
+	o.mu.Lock()
+	l, ok := o.locks[id]
+	if !ok {
+		l = &productLock{}
+		o.locks[id] = l
+	}
+	l.refs++
+	o.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		o.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(o.locks, id)
+		}
+		o.mu.Unlock()
+	}
+}
+
+// afterCreate waits, before the event of a change that took product id to
+// version, until its product_created has been handed over. Only the first
+// change after the create can overtake it: every later one waits for the
+// one before.
+func (o *eventOrder) afterCreate(id, version int64) {
+	if o == nil || version > 2 {
+		return
+	}
+
+	o.mu.Lock()
+	// The change committed after the create, so the create took its
+	// ticket before this one would be drawn.
+	last := o.next
+	for o.unresolvedBefore(last) {
+		o.resolved.Wait()
+	}
+	done := o.creating[id]
+	o.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (o *eventOrder) unresolvedBefore(last uint64) bool {
+	for ticket := range o.unresolved {
+		if ticket < last {
+			return true
+		}
+	}
+	return false
+}
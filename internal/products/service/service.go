@@ -2,98 +2,668 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"product-notifications/internal/products"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	defaultPageSize = 10
 	maxPageSize     = 100
+
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+
+	// maxAttributesBytes caps the JSON-encoded size of a product's
+	// attributes.
+	maxAttributesBytes = 16 << 10
+
+	// maxBatchSize caps how many products one CreateProducts call inserts
+	// in a single transaction.
+	maxBatchSize = 1000
+
+	// maxDeleteBatchSize caps how many ids one DeleteProducts call takes.
+	// The repository deletes them in chunks inside a single transaction.
+	maxDeleteBatchSize = 10000
+
+	defaultReservationTTL = 15 * time.Minute
 )
 
 type Repository interface {
-	Create(ctx context.Context, name string) (products.Product, error)
-	Delete(ctx context.Context, id int64) error
-	List(ctx context.Context, limit, offset int) ([]products.Product, error)
-	Count(ctx context.Context) (int64, error)
+	Create(ctx context.Context, in products.CreateInput) (products.Product, error)
+	// CreateIfAbsent returns the product already holding the name, with
+	// created false, instead of failing with products.ErrDuplicateName.
+	CreateIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error)
+	CreateBatch(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error)
+	Get(ctx context.Context, id int64) (products.Product, error)
+	GetByPublicID(ctx context.Context, publicID string) (products.Product, error)
+	GetBySlug(ctx context.Context, slug string) (products.Product, error)
+	// GetIncludingExpired and GetByPublicIDIncludingExpired find expired
+	// products too, as the deletes do.
+	GetIncludingExpired(ctx context.Context, id int64) (products.Product, error)
+	GetByPublicIDIncludingExpired(ctx context.Context, publicID string) (products.Product, error)
+	// UpdateAttributes also returns the attributes the product had before.
+	UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error)
+	// UpdateStatus also returns the status the product had before.
+	UpdateStatus(ctx context.Context, id int64, status string) (products.Product, string, error)
+	DeleteReturning(ctx context.Context, id int64) (products.Product, error)
+	DeleteByPublicID(ctx context.Context, publicID string) (products.Product, error)
+	DeleteBatch(ctx context.Context, ids []int64) (int64, error)
+	List(ctx context.Context, opts products.ListOptions, limit, offset int) ([]products.Product, error)
+	ListAfter(ctx context.Context, afterID int64, limit int) ([]products.Product, error)
+	// Count may estimate, and then reports the total approximate.
+	Count(ctx context.Context, opts products.ListOptions) (total int64, approximate bool, err error)
+	// Position fails with products.ErrNotFound when the product is not in
+	// the default list.
+	Position(ctx context.Context, id int64) (int64, error)
+	SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error)
+	// Reserve fails with products.ErrAlreadyReserved while the product
+	// holds a reservation that has not run out.
+	Reserve(ctx context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error)
+	// Release fails with products.ErrReservedByOther while someone other
+	// than by holds a reservation that has not run out.
+	Release(ctx context.Context, id int64, by string) error
+	// QueueBehindOutbox writes event to the outbox, reporting true, when
+	// its product still has an unpublished event there.
+	QueueBehindOutbox(ctx context.Context, event products.ProductEvent) (bool, error)
 }
 
+// Publisher hands product events to the broker. Health reports whether it
+// can currently publish; Close releases its broker resources.
 type Publisher interface {
 	Publish(ctx context.Context, event products.ProductEvent) error
+	Health(ctx context.Context) error
+	Close() error
+}
+
+// CreateWebhook synchronously confirms a product with an external system
+// before it is stored. Returning an error aborts the create.
+type CreateWebhook interface {
+	Confirm(ctx context.Context, name string) error
 }
 
+// Clock supplies the current time for event timestamps, so tests can pin
+// it.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 type Service struct {
-	repo      Repository
-	publisher Publisher
-	logger    *slog.Logger
-	created   prometheus.Counter
-	deleted   prometheus.Counter
+	repo          Repository
+	publisher     Publisher
+	logger        *slog.Logger
+	created       prometheus.Counter
+	deleted       prometheus.Counter
+	eventLost     prometheus.Counter
+	createWebhook CreateWebhook
+	nameStrip     *regexp.Regexp
+	nameDenylist  *products.NameDenylist
+	minNameLength int
+	clock         Clock
+	oldValues     bool
+	order         *eventOrder
+	reservation   time.Duration
+}
+
+type Option func(*Service)
+
+// WithCreateWebhook makes CreateProduct call w before inserting the product.
+func WithCreateWebhook(w CreateWebhook) Option {
+	return func(s *Service) {
+		s.createWebhook = w
+	}
+}
+
+// WithNameStripPattern removes every match of re from product names before
+// they are trimmed and validated, e.g. `^[^|]*\|` to drop a "SKU-123 |"
+// prefix.
+func WithNameStripPattern(re *regexp.Regexp) Option {
+	return func(s *Service) {
+		s.nameStrip = re
+	}
+}
+
+// WithMinNameLength rejects names shorter than n characters, counted after
+// normalization, with products.ErrNameTooShort.
+func WithMinNameLength(n int) Option {
+	return func(s *Service) {
+		s.minNameLength = n
+	}
+}
+
+// WithNameDenylist rejects names denied by d with products.ErrNameNotAllowed.
+// Names are checked after normalization, as they would be stored.
+func WithNameDenylist(d *products.NameDenylist) Option {
+	return func(s *Service) {
+		s.nameDenylist = d
+	}
+}
+
+// WithClock makes the service read the current time from c instead of the
+// system clock.
+func WithClock(c Clock) Option {
+	return func(s *Service) {
+		s.clock = c
+	}
 }
 
-func New(repo Repository, publisher Publisher, logger *slog.Logger, created, deleted prometheus.Counter) *Service {
-	return &Service{
+// WithCreatedEventLostCounter counts products that CreateProduct stored but
+// whose product_created event failed to publish, so consumers never heard
+// of them. They are still counted as created.
+func WithCreatedEventLostCounter(c prometheus.Counter) Option {
+	return func(s *Service) {
+		s.eventLost = c
+	}
+}
+
+// WithOldValuesInEvents makes product_updated events carry the old value
+// of each changed field next to the new one. Off by default, since old
+// values may be data a consumer should no longer see.
+func WithOldValuesInEvents() Option {
+	return func(s *Service) {
+		s.oldValues = true
+	}
+}
+
+// WithProductEventOrdering hands each product's events to the publisher in
+// the order its changes were committed, even when requests race on it, so
+// for example its product_deleted never goes out before its
+// product_created. Events of different products keep no particular order.
+//
+// The locks live in this process, so the order holds only on a single
+// instance. Events the service does not publish itself, such as the expiry
+// and reservation sweepers', take no lock. Each change also costs an extra
+// query, to check the outbox.
+func WithProductEventOrdering() Option {
+	return func(s *Service) {
+		s.order = newEventOrder()
+	}
+}
+
+// WithReservationTTL sets how long ReserveProduct holds a product; 15
+// minutes otherwise.
+func WithReservationTTL(d time.Duration) Option {
+	return func(s *Service) {
+		s.reservation = d
+	}
+}
+
+func New(repo Repository, publisher Publisher, logger *slog.Logger, created, deleted prometheus.Counter, opts ...Option) *Service {
+	s := &Service{
 		repo:      repo,
 		publisher: publisher,
 		logger:    logger,
 		created:   created,
 		deleted:   deleted,
+		clock:     realClock{},
+
+		reservation: defaultReservationTTL,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *Service) CreateProduct(ctx context.Context, name string) (products.Product, error) {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return products.Product{}, products.ErrInvalidName
+func (s *Service) CreateProduct(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	cleaned, err := s.prepareInput(ctx, in)
+	if err != nil {
+		return products.Product{}, err
 	}
 
-	product, err := s.repo.Create(ctx, name)
+	pending := s.order.create()
+	product, err := s.repo.Create(ctx, cleaned)
 	if err != nil {
+		pending.finish()
 		return products.Product{}, fmt.Errorf("repo create: %w", err)
 	}
 
+	pending.stored(product.ID)
+	s.productCreated(ctx, product)
+	pending.finish()
+	return product, nil
+}
+
+// CreateProductIfAbsent is CreateProduct for clients that want a product
+// by name to exist: if the name is already taken it returns the product
+// holding it, with created false, instead of failing. Only a product it
+// created is announced and counted.
+func (s *Service) CreateProductIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error) {
+	cleaned, err := s.prepareInput(ctx, in)
+	if err != nil {
+		return products.Product{}, false, err
+	}
+
+	pending := s.order.create()
+	product, created, err := s.repo.CreateIfAbsent(ctx, cleaned)
+	if err != nil {
+		pending.finish()
+		return products.Product{}, false, fmt.Errorf("repo create if absent: %w", err)
+	}
+
+	if !created {
+		pending.finish()
+		return product, false, nil
+	}
+
+	pending.stored(product.ID)
+	s.productCreated(ctx, product)
+	pending.finish()
+	return product, true, nil
+}
+
+// productCreated publishes product_created for a product stored by a
+// single create and counts it.
+func (s *Service) productCreated(ctx context.Context, product products.Product) {
 	if err := s.publisher.Publish(ctx, products.ProductEvent{
-		EventType: products.EventCreated,
-		ProductID: product.ID,
-		Name:      product.Name,
-		Timestamp: time.Now().UTC(),
+		EventType:        products.EventCreated,
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Owner:            product.Owner,
+		Timestamp:        s.clock.Now().UTC(),
+		AggregateVersion: product.Version,
 	}); err != nil {
 		s.logger.Error("publish product_created event failed",
 			"product_id", product.ID,
 			"error", err,
 		)
+		if s.eventLost != nil {
+			s.eventLost.Inc()
+		}
 	}
 
 	s.created.Inc()
+}
+
+// publishInOrder publishes the event of a change to an existing product,
+// which the caller holds the lock of, after the events before it. With
+// event ordering on, an event whose product still has one waiting in the
+// outbox, as after a bulk create, is queued behind it rather than
+// published past it.
+func (s *Service) publishInOrder(ctx context.Context, event products.ProductEvent) error {
+	if s.order == nil {
+		return s.publisher.Publish(ctx, event)
+	}
+
+	s.order.afterCreate(event.ProductID, event.AggregateVersion)
+	queued, err := s.repo.QueueBehindOutbox(ctx, event)
+	if err != nil {
+		s.logger.Warn("queue event behind outbox failed, publishing directly",
+			"product_id", event.ProductID,
+			"event_type", event.EventType,
+			"error", err,
+		)
+	}
+	if queued {
+		return nil
+	}
+	return s.publisher.Publish(ctx, event)
+}
+
+// CreateProducts inserts all products or none. Their product_created
+// events are written to the outbox in the same transaction and published
+// by the outbox relay, so a broker failure can neither lose nor duplicate
+// them.
+func (s *Service) CreateProducts(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	if len(inputs) > maxBatchSize {
+		return nil, products.ErrBatchTooLarge
+	}
+
+	cleaned := make([]products.CreateInput, len(inputs))
+	for i, in := range inputs {
+		var err error
+		if cleaned[i], err = s.prepareInput(ctx, in); err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+
+	created, err := s.repo.CreateBatch(ctx, cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("repo create batch: %w", err)
+	}
+
+	s.created.Add(float64(len(created)))
+	return created, nil
+}
+
+// CreateProductsPartial creates each product on its own, so one bad item
+// fails alone instead of taking the batch with it. Each item still goes
+// through the outbox like CreateProducts. Only an oversized batch fails the
+// call; per-item failures are reported in the results, in input order.
+func (s *Service) CreateProductsPartial(ctx context.Context, inputs []products.CreateInput) ([]products.CreateResult, error) {
+	if len(inputs) > maxBatchSize {
+		return nil, products.ErrBatchTooLarge
+	}
+
+	results := make([]products.CreateResult, len(inputs))
+	for i, in := range inputs {
+		results[i].Index = i
+
+		cleaned, err := s.prepareInput(ctx, in)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		created, err := s.repo.CreateBatch(ctx, []products.CreateInput{cleaned})
+		if err != nil {
+			results[i].Err = fmt.Errorf("repo create batch: %w", err)
+			continue
+		}
+
+		results[i].Product = created[0]
+		s.created.Inc()
+	}
+	return results, nil
+}
+
+// prepareInput normalizes and validates a create input and runs it past
+// the create webhook.
+func (s *Service) prepareInput(ctx context.Context, in products.CreateInput) (products.CreateInput, error) {
+	name := s.normalizeName(in.Name)
+	if err := products.ValidateName(name); err != nil {
+		return products.CreateInput{}, err
+	}
+	if utf8.RuneCountInString(name) < s.minNameLength {
+		return products.CreateInput{}, products.ErrNameTooShort
+	}
+	if s.nameDenylist != nil {
+		if err := s.nameDenylist.Check(name); err != nil {
+			return products.CreateInput{}, err
+		}
+	}
+	if err := validateAttributes(in.Attributes); err != nil {
+		return products.CreateInput{}, err
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(s.clock.Now()) {
+		return products.CreateInput{}, products.ErrExpiryInPast
+	}
+	if in.Status != "" && in.Status != products.StatusDraft && in.Status != products.StatusPublished {
+		return products.CreateInput{}, products.ErrInvalidStatus
+	}
+	if s.createWebhook != nil {
+		if err := s.createWebhook.Confirm(ctx, name); err != nil {
+			return products.CreateInput{}, fmt.Errorf("create webhook: %w", err)
+		}
+	}
+	return products.CreateInput{Name: name, Owner: in.Owner, Attributes: in.Attributes, ExpiresAt: in.ExpiresAt, Status: in.Status}, nil
+}
+
+// UpdateAttributes replaces a product's attributes.
+func (s *Service) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, error) {
+	if err := validateAttributes(attributes); err != nil {
+		return products.Product{}, err
+	}
+
+	unlock := s.order.lock(id)
+	product, previous, err := s.repo.UpdateAttributes(ctx, id, attributes)
+	if err != nil {
+		unlock()
+		return products.Product{}, fmt.Errorf("repo update attributes: %w", err)
+	}
+
+	changed, changes := attributeChanges(previous, product.Attributes, s.oldValues)
+	err = s.publishInOrder(ctx, products.ProductEvent{
+		EventType:        products.EventUpdated,
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Owner:            product.Owner,
+		Timestamp:        s.clock.Now().UTC(),
+		AggregateVersion: product.Version,
+		ChangedFields:    changed,
+		Changes:          changes,
+	})
+	unlock()
+	if err != nil {
+		s.logger.Error("publish product_updated event failed",
+			"product_id", product.ID,
+			"error", err,
+		)
+	}
+
 	return product, nil
 }
 
-func (s *Service) DeleteProduct(ctx context.Context, id int64) error {
-	if err := s.repo.Delete(ctx, id); err != nil {
-		return fmt.Errorf("repo delete: %w", err)
+// PublishProduct moves a draft product to published.
+func (s *Service) PublishProduct(ctx context.Context, id int64) (products.Product, error) {
+	return s.changeStatus(ctx, id, products.StatusPublished)
+}
+
+// ArchiveProduct moves a published product to archived.
+func (s *Service) ArchiveProduct(ctx context.Context, id int64) (products.Product, error) {
+	return s.changeStatus(ctx, id, products.StatusArchived)
+}
+
+// changeStatus moves the product to status and publishes
+// product_status_changed. A move the lifecycle does not allow fails with
+// products.ErrInvalidTransition.
+func (s *Service) changeStatus(ctx context.Context, id int64, status string) (products.Product, error) {
+	unlock := s.order.lock(id)
+	product, previous, err := s.repo.UpdateStatus(ctx, id, status)
+	if err != nil {
+		unlock()
+		return products.Product{}, fmt.Errorf("repo update status: %w", err)
 	}
 
-	if err := s.publisher.Publish(ctx, products.ProductEvent{
-		EventType: products.EventDeleted,
-		ProductID: id,
-		Timestamp: time.Now().UTC(),
+	err = s.publishInOrder(ctx, products.ProductEvent{
+		EventType:        products.EventStatusChanged,
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Owner:            product.Owner,
+		Timestamp:        s.clock.Now().UTC(),
+		AggregateVersion: product.Version,
+		Status:           product.Status,
+		PreviousStatus:   previous,
+	})
+	unlock()
+	if err != nil {
+		s.logger.Error("publish product_status_changed event failed",
+			"product_id", product.ID,
+			"error", err,
+		)
+	}
+
+	return product, nil
+}
+
+// DeleteProduct deletes the product and returns it as it was. The
+// product_deleted event carries the same snapshot.
+func (s *Service) DeleteProduct(ctx context.Context, id int64) (products.Product, error) {
+	unlock := s.order.lock(id)
+	product, err := s.repo.DeleteReturning(ctx, id)
+	if err != nil {
+		unlock()
+		return products.Product{}, fmt.Errorf("repo delete: %w", err)
+	}
+
+	s.productDeleted(ctx, product)
+	unlock()
+	return product, nil
+}
+
+// DeleteProductByPublicID is DeleteProduct addressed by public id.
+func (s *Service) DeleteProductByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	product, err := s.repo.DeleteByPublicID(ctx, publicID)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo delete: %w", err)
+	}
+
+	// The id is only known now, which is soon enough: a change committed
+	// before this delete held the lock from before its commit.
+	unlock := s.order.lock(product.ID)
+	s.productDeleted(ctx, product)
+	unlock()
+	return product, nil
+}
+
+// PreviewDelete returns the product DeleteProduct would delete, expired or
+// not, without deleting it.
+func (s *Service) PreviewDelete(ctx context.Context, id int64) (products.Product, error) {
+	product, err := s.repo.GetIncludingExpired(ctx, id)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo get: %w", err)
+	}
+	return product, nil
+}
+
+// PreviewDeleteByPublicID is PreviewDelete addressed by public id.
+func (s *Service) PreviewDeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	product, err := s.repo.GetByPublicIDIncludingExpired(ctx, publicID)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo get: %w", err)
+	}
+	return product, nil
+}
+
+// DeleteProducts deletes every listed product that exists, all or none, and
+// reports how many were deleted. Like CreateProducts, their product_deleted
+// events go through the outbox.
+func (s *Service) DeleteProducts(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) > maxDeleteBatchSize {
+		return 0, products.ErrBatchTooLarge
+	}
+
+	deleted, err := s.repo.DeleteBatch(ctx, ids)
+	if err != nil {
+		return 0, fmt.Errorf("repo delete batch: %w", err)
+	}
+
+	s.deleted.Add(float64(deleted))
+	return deleted, nil
+}
+
+// productDeleted publishes product's deletion. product is the row as it
+// was, so the delete is its next version.
+func (s *Service) productDeleted(ctx context.Context, product products.Product) {
+	if err := s.publishInOrder(ctx, products.ProductEvent{
+		EventType:        products.EventDeleted,
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Owner:            product.Owner,
+		Timestamp:        s.clock.Now().UTC(),
+		AggregateVersion: product.Version + 1,
 	}); err != nil {
 		s.logger.Error("publish product_deleted event failed",
-			"product_id", id,
+			"product_id", product.ID,
 			"error", err,
 		)
 	}
 
 	s.deleted.Inc()
+}
+
+// ReserveProduct holds the product for by for the reservation TTL. A
+// product already held fails with products.ErrAlreadyReserved until it is
+// released or its reservation runs out.
+func (s *Service) ReserveProduct(ctx context.Context, id int64, by string) (products.Reservation, error) {
+	res, err := s.repo.Reserve(ctx, id, by, s.reservation)
+	if err != nil {
+		return products.Reservation{}, fmt.Errorf("repo reserve: %w", err)
+	}
+	return res, nil
+}
+
+// ReleaseProduct ends the product's reservation on behalf of by, who must
+// hold it unless it has run out; releasing a product that is not reserved
+// does nothing.
+func (s *Service) ReleaseProduct(ctx context.Context, id int64, by string) error {
+	if err := s.repo.Release(ctx, id, by); err != nil {
+		return fmt.Errorf("repo release: %w", err)
+	}
+	return nil
+}
+
+// GetProduct returns the product with id.
+func (s *Service) GetProduct(ctx context.Context, id int64) (products.Product, error) {
+	product, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo get: %w", err)
+	}
+	return product, nil
+}
+
+func (s *Service) GetProductByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	product, err := s.repo.GetByPublicID(ctx, publicID)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo get: %w", err)
+	}
+	return product, nil
+}
+
+func (s *Service) GetProductBySlug(ctx context.Context, slug string) (products.Product, error) {
+	product, err := s.repo.GetBySlug(ctx, slug)
+	if err != nil {
+		return products.Product{}, fmt.Errorf("repo get: %w", err)
+	}
+	return product, nil
+}
+
+// ReplayProduct re-publishes a product's current state as a product_created
+// event marked as a replay. Unlike the other methods, a publish failure is
+// returned: publishing is the whole point of a replay.
+func (s *Service) ReplayProduct(ctx context.Context, id int64) error {
+	unlock := s.order.lock(id)
+	defer unlock()
+
+	product, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("repo get: %w", err)
+	}
+
+	if err := s.publisher.Publish(ctx, products.ProductEvent{
+		EventType:        products.EventCreated,
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Owner:            product.Owner,
+		Timestamp:        s.clock.Now().UTC(),
+		Replay:           true,
+		AggregateVersion: product.Version,
+	}); err != nil {
+		return fmt.Errorf("publish replay: %w", err)
+	}
+
 	return nil
 }
 
-func (s *Service) ListProducts(ctx context.Context, page, limit int) ([]products.Product, int64, error) {
+// ProductPosition reports where the product sits in the default list and
+// the page of limit products it falls on; limit is clamped as in
+// ListProducts.
+func (s *Service) ProductPosition(ctx context.Context, id int64, limit int) (products.ListPosition, error) {
+	if limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	position, err := s.repo.Position(ctx, id)
+	if err != nil {
+		return products.ListPosition{}, fmt.Errorf("repo position: %w", err)
+	}
+	return products.ListPosition{
+		Position: position,
+		Page:     (position-1)/int64(limit) + 1,
+		Limit:    limit,
+	}, nil
+}
+
+// ListProducts returns a page of the products matching opts, with both the
+// filtered count and the count of all products regardless of search and
+// attribute filters.
+func (s *Service) ListProducts(ctx context.Context, opts products.ListOptions, page, limit int) ([]products.Product, products.ListTotals, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -106,15 +676,112 @@ func (s *Service) ListProducts(ctx context.Context, page, limit int) ([]products
 
 	offset := (page - 1) * limit
 
-	items, err := s.repo.List(ctx, limit, offset)
-	if err != nil {
-		return nil, 0, fmt.Errorf("repo list: %w", err)
+	// List and the counts run concurrently on a shared context: whichever
+	// fails first cancels the others, so a tight deadline never wastes a
+	// finished list query on a count that cannot complete.
+	var (
+		items          []products.Product
+		totals         products.ListTotals
+		filteredApprox bool
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		if items, err = s.repo.List(gctx, opts, limit, offset); err != nil {
+			return fmt.Errorf("repo list: %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var err error
+		if totals.Filtered, filteredApprox, err = s.repo.Count(gctx, opts); err != nil {
+			return fmt.Errorf("repo count: %w", err)
+		}
+		return nil
+	})
+	if opts.Filtered() {
+		all := products.ListOptions{
+			ExactCount:     opts.ExactCount,
+			IncludeExpired: opts.IncludeExpired,
+			Status:         opts.Status,
+			MinID:          opts.MinID,
+			MaxID:          opts.MaxID,
+		}
+		g.Go(func() error {
+			var err error
+			if totals.All, totals.Approximate, err = s.repo.Count(gctx, all); err != nil {
+				return fmt.Errorf("repo count all: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, products.ListTotals{}, err
+	}
+	if !opts.Filtered() {
+		totals.All, totals.Approximate = totals.Filtered, filteredApprox
+	}
+
+	return items, totals, nil
+}
+
+// ExportProducts hands every published, unexpired product to emit, in id
+// order, a batch of up to batchSize at a time. Batches are read with
+// keyset pagination, so a slow consumer of emit holds no database
+// transaction. An error from emit stops the export and is returned as is.
+func (s *Service) ExportProducts(ctx context.Context, batchSize int, emit func([]products.Product) error) error {
+	var after int64
+	for {
+		batch, err := s.repo.ListAfter(ctx, after, batchSize)
+		if err != nil {
+			return fmt.Errorf("repo list after %d: %w", after, err)
+		}
+		if len(batch) > 0 {
+			if err := emit(batch); err != nil {
+				return err
+			}
+			after = batch[len(batch)-1].ID
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+// SuggestNames returns product names starting with prefix for type-ahead,
+// at most maxSuggestLimit of them.
+func (s *Service) SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	if limit < 1 {
+		limit = defaultSuggestLimit
+	}
+	if limit > maxSuggestLimit {
+		limit = maxSuggestLimit
 	}
 
-	total, err := s.repo.Count(ctx)
+	names, err := s.repo.SuggestNames(ctx, prefix, limit)
 	if err != nil {
-		return nil, 0, fmt.Errorf("repo count: %w", err)
+		return nil, fmt.Errorf("repo suggest names: %w", err)
 	}
+	return names, nil
+}
 
-	return items, total, nil
+func (s *Service) normalizeName(name string) string {
+	if s.nameStrip != nil {
+		name = s.nameStrip.ReplaceAllString(name, "")
+	}
+	return strings.TrimSpace(name)
+}
+
+func validateAttributes(attributes map[string]any) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return fmt.Errorf("marshal attributes: %w", err)
+	}
+	if len(raw) > maxAttributesBytes {
+		return products.ErrAttributesTooLarge
+	}
+	return nil
 }
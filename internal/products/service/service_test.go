@@ -5,32 +5,111 @@ import (
 	"errors"
 	"log/slog"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"product-notifications/internal/products"
+	"product-notifications/internal/products/messaging"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type mockRepo struct {
-	createFn func(ctx context.Context, name string) (products.Product, error)
-	deleteFn func(ctx context.Context, id int64) error
-	listFn   func(ctx context.Context, limit, offset int) ([]products.Product, error)
-	countFn  func(ctx context.Context) (int64, error)
+	createFn      func(ctx context.Context, in products.CreateInput) (products.Product, error)
+	ifAbsentFn    func(ctx context.Context, in products.CreateInput) (products.Product, bool, error)
+	batchFn       func(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error)
+	getFn         func(ctx context.Context, id int64) (products.Product, error)
+	updateAttrFn  func(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error)
+	updateStatFn  func(ctx context.Context, id int64, status string) (products.Product, string, error)
+	deleteFn      func(ctx context.Context, id int64) (products.Product, error)
+	getPubFn      func(ctx context.Context, publicID string) (products.Product, error)
+	getSlugFn     func(ctx context.Context, slug string) (products.Product, error)
+	getAnyFn      func(ctx context.Context, id int64) (products.Product, error)
+	getAnyPubFn   func(ctx context.Context, publicID string) (products.Product, error)
+	deletePubFn   func(ctx context.Context, publicID string) (products.Product, error)
+	deleteBatchFn func(ctx context.Context, ids []int64) (int64, error)
+	listFn        func(ctx context.Context, limit, offset int) ([]products.Product, error)
+	listAfterFn   func(ctx context.Context, afterID int64, limit int) ([]products.Product, error)
+	countFn       func(ctx context.Context, opts products.ListOptions) (int64, bool, error)
+	suggestFn     func(ctx context.Context, prefix string, limit int) ([]string, error)
+	reserveFn     func(ctx context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error)
+	releaseFn     func(ctx context.Context, id int64, by string) error
+	positionFn    func(ctx context.Context, id int64) (int64, error)
+	queueFn       func(ctx context.Context, event products.ProductEvent) (bool, error)
 }
 
-func (m *mockRepo) Create(ctx context.Context, name string) (products.Product, error) {
-	return m.createFn(ctx, name)
+func (m *mockRepo) Create(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	return m.createFn(ctx, in)
 }
-func (m *mockRepo) Delete(ctx context.Context, id int64) error {
+func (m *mockRepo) CreateIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error) {
+	return m.ifAbsentFn(ctx, in)
+}
+func (m *mockRepo) CreateBatch(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	return m.batchFn(ctx, inputs)
+}
+func (m *mockRepo) Get(ctx context.Context, id int64) (products.Product, error) {
+	return m.getFn(ctx, id)
+}
+func (m *mockRepo) GetByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	return m.getPubFn(ctx, publicID)
+}
+func (m *mockRepo) GetBySlug(ctx context.Context, slug string) (products.Product, error) {
+	return m.getSlugFn(ctx, slug)
+}
+func (m *mockRepo) GetIncludingExpired(ctx context.Context, id int64) (products.Product, error) {
+	return m.getAnyFn(ctx, id)
+}
+func (m *mockRepo) GetByPublicIDIncludingExpired(ctx context.Context, publicID string) (products.Product, error) {
+	return m.getAnyPubFn(ctx, publicID)
+}
+func (m *mockRepo) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+	return m.updateAttrFn(ctx, id, attributes)
+}
+func (m *mockRepo) UpdateStatus(ctx context.Context, id int64, status string) (products.Product, string, error) {
+	return m.updateStatFn(ctx, id, status)
+}
+func (m *mockRepo) DeleteReturning(ctx context.Context, id int64) (products.Product, error) {
 	return m.deleteFn(ctx, id)
 }
-func (m *mockRepo) List(ctx context.Context, limit, offset int) ([]products.Product, error) {
+func (m *mockRepo) DeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	return m.deletePubFn(ctx, publicID)
+}
+func (m *mockRepo) DeleteBatch(ctx context.Context, ids []int64) (int64, error) {
+	return m.deleteBatchFn(ctx, ids)
+}
+func (m *mockRepo) List(ctx context.Context, _ products.ListOptions, limit, offset int) ([]products.Product, error) {
 	return m.listFn(ctx, limit, offset)
 }
-func (m *mockRepo) Count(ctx context.Context) (int64, error) {
-	return m.countFn(ctx)
+func (m *mockRepo) ListAfter(ctx context.Context, afterID int64, limit int) ([]products.Product, error) {
+	return m.listAfterFn(ctx, afterID, limit)
+}
+func (m *mockRepo) Count(ctx context.Context, opts products.ListOptions) (int64, bool, error) {
+	return m.countFn(ctx, opts)
+}
+func (m *mockRepo) SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return m.suggestFn(ctx, prefix, limit)
+}
+func (m *mockRepo) Reserve(ctx context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error) {
+	return m.reserveFn(ctx, id, by, ttl)
+}
+func (m *mockRepo) Release(ctx context.Context, id int64, by string) error {
+	return m.releaseFn(ctx, id, by)
+}
+
+func (m *mockRepo) Position(ctx context.Context, id int64) (int64, error) {
+	return m.positionFn(ctx, id)
+}
+func (m *mockRepo) QueueBehindOutbox(ctx context.Context, event products.ProductEvent) (bool, error) {
+	if m.queueFn == nil {
+		return false, nil
+	}
+	return m.queueFn(ctx, event)
 }
 
 type mockPublisher struct {
@@ -43,23 +122,60 @@ func (m *mockPublisher) Publish(_ context.Context, event products.ProductEvent)
 	return m.err
 }
 
+func (m *mockPublisher) Health(context.Context) error {
+	return m.err
+}
+
+func (m *mockPublisher) Close() error {
+	return nil
+}
+
+// testNow is the time newTestService's clock is pinned to.
+var testNow = time.Date(2026, 2, 24, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
 func newTestService(repo Repository, pub Publisher) *Service {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	return New(
 		repo, pub, logger,
 		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
 		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+		WithClock(fixedClock(testNow)),
 	)
 }
 
 func defaultRepo() *mockRepo {
 	return &mockRepo{
-		createFn: func(_ context.Context, name string) (products.Product, error) {
-			return products.Product{ID: 1, Name: name, CreatedAt: time.Now()}, nil
+		createFn: func(_ context.Context, in products.CreateInput) (products.Product, error) {
+			return products.Product{ID: 1, Name: in.Name, Attributes: in.Attributes, CreatedAt: time.Now()}, nil
+		},
+		batchFn: func(_ context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+			created := make([]products.Product, len(inputs))
+			for i, in := range inputs {
+				created[i] = products.Product{ID: int64(i + 1), Name: in.Name}
+			}
+			return created, nil
+		},
+		getFn: func(_ context.Context, id int64) (products.Product, error) {
+			return products.Product{ID: id, Name: "Widget"}, nil
+		},
+		updateAttrFn: func(_ context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+			return products.Product{ID: id, Attributes: attributes}, nil, nil
+		},
+		getPubFn: func(_ context.Context, publicID string) (products.Product, error) {
+			return products.Product{ID: 1, PublicID: publicID, Name: "Widget"}, nil
 		},
-		deleteFn: func(_ context.Context, _ int64) error { return nil },
-		listFn:   func(_ context.Context, _, _ int) ([]products.Product, error) { return nil, nil },
-		countFn:  func(_ context.Context) (int64, error) { return 0, nil },
+		deleteFn: func(_ context.Context, id int64) (products.Product, error) {
+			return products.Product{ID: id, Name: "Widget"}, nil
+		},
+		deletePubFn: func(_ context.Context, publicID string) (products.Product, error) {
+			return products.Product{ID: 1, PublicID: publicID, Name: "Widget"}, nil
+		},
+		listFn:  func(_ context.Context, _, _ int) ([]products.Product, error) { return nil, nil },
+		countFn: func(context.Context, products.ListOptions) (int64, bool, error) { return 0, false, nil },
 	}
 }
 
@@ -85,6 +201,16 @@ func TestCreateProduct(t *testing.T) {
 			input:   "   ",
 			wantErr: products.ErrInvalidName,
 		},
+		{
+			name:    "name too long",
+			input:   strings.Repeat("a", products.MaxNameLength+1),
+			wantErr: products.ErrNameTooLong,
+		},
+		{
+			name:    "control characters",
+			input:   "Pho\x00ne",
+			wantErr: products.ErrNameControlChars,
+		},
 		{
 			name:    "repo error is wrapped",
 			input:   "Phone",
@@ -97,14 +223,14 @@ func TestCreateProduct(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := defaultRepo()
 			if tt.repoErr != nil {
-				repo.createFn = func(_ context.Context, _ string) (products.Product, error) {
+				repo.createFn = func(_ context.Context, _ products.CreateInput) (products.Product, error) {
 					return products.Product{}, tt.repoErr
 				}
 			}
 			pub := &mockPublisher{}
 			svc := newTestService(repo, pub)
 
-			product, err := svc.CreateProduct(context.Background(), tt.input)
+			product, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: tt.input})
 
 			if tt.wantErr != nil {
 				if err == nil {
@@ -125,6 +251,9 @@ func TestCreateProduct(t *testing.T) {
 			if len(pub.events) != 1 || pub.events[0].EventType != tt.wantEvent {
 				t.Fatalf("want event %q, got %v", tt.wantEvent, pub.events)
 			}
+			if got := pub.events[0].Timestamp; got != testNow.UTC() {
+				t.Fatalf("want event timestamp %v in UTC, got %v", testNow.UTC(), got)
+			}
 		})
 	}
 }
@@ -153,13 +282,16 @@ func TestDeleteProduct(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := defaultRepo()
-			repo.deleteFn = func(_ context.Context, _ int64) error {
-				return tt.repoErr
+			repo.deleteFn = func(_ context.Context, id int64) (products.Product, error) {
+				if tt.repoErr != nil {
+					return products.Product{}, tt.repoErr
+				}
+				return products.Product{ID: id, Name: "Laptop"}, nil
 			}
 			pub := &mockPublisher{}
 			svc := newTestService(repo, pub)
 
-			err := svc.DeleteProduct(context.Background(), tt.id)
+			deleted, err := svc.DeleteProduct(context.Background(), tt.id)
 
 			if tt.wantErr != nil {
 				if !errors.Is(err, tt.wantErr) {
@@ -171,8 +303,66 @@ func TestDeleteProduct(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			if len(pub.events) != 1 || pub.events[0].EventType != tt.wantEvent {
-				t.Fatalf("want event %q, got %v", tt.wantEvent, pub.events)
+			if deleted.ID != tt.id || deleted.Name != "Laptop" {
+				t.Fatalf("want the deleted product returned, got %+v", deleted)
+			}
+			if len(pub.events) != 1 || pub.events[0].EventType != tt.wantEvent || pub.events[0].Name != "Laptop" {
+				t.Fatalf("want event %q with the product snapshot, got %v", tt.wantEvent, pub.events)
+			}
+			if got := pub.events[0].Timestamp; got != testNow.UTC() {
+				t.Fatalf("want event timestamp %v, got %v", testNow.UTC(), got)
+			}
+		})
+	}
+}
+
+func TestDeleteProductByPublicID(t *testing.T) {
+	const publicID = "0b6a1c1e-3f7d-4b8e-9a57-2d3c4e5f6a7b"
+
+	tests := []struct {
+		name    string
+		repoErr error
+		wantErr error
+	}{
+		{
+			name: "success publishes the internal id",
+		},
+		{
+			name:    "not found",
+			repoErr: products.ErrNotFound,
+			wantErr: products.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			repo.deletePubFn = func(_ context.Context, got string) (products.Product, error) {
+				if got != publicID {
+					t.Fatalf("want public id %q, got %q", publicID, got)
+				}
+				return products.Product{ID: 42, PublicID: got}, tt.repoErr
+			}
+			pub := &mockPublisher{}
+			svc := newTestService(repo, pub)
+
+			_, err := svc.DeleteProductByPublicID(context.Background(), publicID)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				if len(pub.events) != 0 {
+					t.Fatalf("want no event on failure, got %v", pub.events)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(pub.events) != 1 || pub.events[0].ProductID != 42 || pub.events[0].EventType != products.EventDeleted {
+				t.Fatalf("want product_deleted for id 42, got %v", pub.events)
 			}
 		})
 	}
@@ -240,22 +430,22 @@ func TestListProducts(t *testing.T) {
 				}
 				return tt.items, nil
 			}
-			repo.countFn = func(_ context.Context) (int64, error) {
-				return tt.total, nil
+			repo.countFn = func(context.Context, products.ListOptions) (int64, bool, error) {
+				return tt.total, false, nil
 			}
 
 			pub := &mockPublisher{}
 			svc := newTestService(repo, pub)
 
-			items, total, err := svc.ListProducts(context.Background(), tt.page, tt.limit)
+			items, totals, err := svc.ListProducts(context.Background(), products.ListOptions{}, tt.page, tt.limit)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
 			if len(items) != tt.wantLen {
 				t.Fatalf("want %d items, got %d", tt.wantLen, len(items))
 			}
-			if total != tt.wantTotal {
-				t.Fatalf("want total %d, got %d", tt.wantTotal, total)
+			if totals.Filtered != tt.wantTotal || totals.All != tt.wantTotal {
+				t.Fatalf("want totals %d, got %+v", tt.wantTotal, totals)
 			}
 		})
 	}
@@ -265,12 +455,1236 @@ func TestCreateProduct_PublishFail_StillReturnsProduct(t *testing.T) {
 	repo := defaultRepo()
 	pub := &mockPublisher{err: errors.New("broker down")}
 	svc := newTestService(repo, pub)
+	lost := prometheus.NewCounter(prometheus.CounterOpts{Name: "t_event_lost", Help: "t"})
+	WithCreatedEventLostCounter(lost)(svc)
 
-	product, err := svc.CreateProduct(context.Background(), "Widget")
+	product, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Widget"})
 	if err != nil {
 		t.Fatalf("expected no error despite publish failure, got: %v", err)
 	}
 	if product.Name != "Widget" {
 		t.Fatalf("want name Widget, got %q", product.Name)
 	}
+	if got := testutil.ToFloat64(lost); got != 1 {
+		t.Fatalf("want 1 lost created event, got %v", got)
+	}
+
+	pub.err = nil
+	if _, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Gadget"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(lost); got != 1 {
+		t.Fatalf("want a published create not counted as lost, got %v", got)
+	}
+}
+
+type stubWebhook struct {
+	err   error
+	calls int
+}
+
+func (w *stubWebhook) Confirm(_ context.Context, _ string) error {
+	w.calls++
+	return w.err
+}
+
+func TestService_WithNoopPublisher(t *testing.T) {
+	svc := newTestService(defaultRepo(), messaging.NoopPublisher{})
+	ctx := context.Background()
+
+	if _, err := svc.CreateProduct(ctx, products.CreateInput{Name: "Laptop"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := svc.UpdateAttributes(ctx, 1, map[string]any{"color": "red"}); err != nil {
+		t.Fatalf("update attributes: %v", err)
+	}
+	if _, err := svc.DeleteProduct(ctx, 1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+}
+
+func TestCreateProduct_Webhook(t *testing.T) {
+	errTimeout := context.DeadlineExceeded
+
+	tests := []struct {
+		name       string
+		webhookErr error
+		wantErr    error
+		wantCreate bool
+	}{
+		{
+			name:       "accepted",
+			wantCreate: true,
+		},
+		{
+			name:       "rejected aborts create",
+			webhookErr: products.ErrWebhookRejected,
+			wantErr:    products.ErrWebhookRejected,
+		},
+		{
+			name:       "timeout aborts create",
+			webhookErr: errTimeout,
+			wantErr:    errTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created := false
+			repo := defaultRepo()
+			repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+				created = true
+				return products.Product{ID: 1, Name: in.Name}, nil
+			}
+			hook := &stubWebhook{err: tt.webhookErr}
+			pub := &mockPublisher{}
+			logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+			svc := New(repo, pub, logger,
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+				WithCreateWebhook(hook),
+			)
+
+			_, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Phone"})
+
+			if hook.calls != 1 {
+				t.Fatalf("want webhook called once, got %d", hook.calls)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error wrapping %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if created != tt.wantCreate {
+				t.Fatalf("want created=%v, got %v", tt.wantCreate, created)
+			}
+			if !tt.wantCreate && len(pub.events) != 0 {
+				t.Fatalf("want no events for aborted create, got %v", pub.events)
+			}
+		})
+	}
+}
+
+func TestListProducts_RunsListAndCountConcurrently(t *testing.T) {
+	repo := defaultRepo()
+
+	// Each query waits for the other to start; run sequentially this would
+	// deadlock until the test context expires.
+	listStarted := make(chan struct{})
+	countStarted := make(chan struct{})
+	repo.listFn = func(ctx context.Context, _, _ int) ([]products.Product, error) {
+		close(listStarted)
+		select {
+		case <-countStarted:
+			return []products.Product{{ID: 1}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	repo.countFn = func(ctx context.Context, _ products.ListOptions) (int64, bool, error) {
+		close(countStarted)
+		select {
+		case <-listStarted:
+			return 1, false, nil
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	items, totals, err := newTestService(repo, &mockPublisher{}).ListProducts(ctx, products.ListOptions{}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(items) != 1 || totals.Filtered != 1 {
+		t.Fatalf("want 1 item and total 1, got %d items and total %d", len(items), totals.Filtered)
+	}
+}
+
+func TestListProducts_FailureCancelsSiblingQuery(t *testing.T) {
+	errCount := errors.New("count timed out")
+	repo := defaultRepo()
+
+	listCanceled := make(chan struct{})
+	repo.listFn = func(ctx context.Context, _, _ int) ([]products.Product, error) {
+		<-ctx.Done()
+		close(listCanceled)
+		return nil, ctx.Err()
+	}
+	repo.countFn = func(context.Context, products.ListOptions) (int64, bool, error) {
+		return 0, false, errCount
+	}
+
+	items, totals, err := newTestService(repo, &mockPublisher{}).ListProducts(context.Background(), products.ListOptions{}, 1, 10)
+	if !errors.Is(err, errCount) {
+		t.Fatalf("want error wrapping %v, got %v", errCount, err)
+	}
+	if items != nil || totals != (products.ListTotals{}) {
+		t.Fatalf("want no partial results, got %v items and totals %+v", items, totals)
+	}
+
+	select {
+	case <-listCanceled:
+	default:
+		t.Fatal("expected list query to observe cancellation")
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]any
+		wantErr    error
+	}{
+		{
+			name:       "round-trips to repository",
+			attributes: map[string]any{"color": "red", "tags": []any{"a", "b"}},
+		},
+		{
+			name:       "oversized attributes rejected",
+			attributes: map[string]any{"blob": strings.Repeat("x", maxAttributesBytes)},
+			wantErr:    products.ErrAttributesTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &mockPublisher{}
+			svc := newTestService(defaultRepo(), pub)
+
+			created, err := svc.CreateProduct(context.Background(), products.CreateInput{
+				Name:       "Phone",
+				Attributes: tt.attributes,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("create: want error %v, got %v", tt.wantErr, err)
+				}
+			} else if err != nil || created.Attributes["color"] != "red" {
+				t.Fatalf("create: want attributes stored, got %v (err %v)", created.Attributes, err)
+			}
+
+			updated, err := svc.UpdateAttributes(context.Background(), 7, tt.attributes)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("update: want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || updated.Attributes["color"] != "red" {
+				t.Fatalf("update: want attributes stored, got %v (err %v)", updated.Attributes, err)
+			}
+			if last := pub.events[len(pub.events)-1]; last.EventType != products.EventUpdated {
+				t.Fatalf("want %q event after update, got %q", products.EventUpdated, last.EventType)
+			}
+		})
+	}
+}
+
+func TestReplayProduct(t *testing.T) {
+	tests := []struct {
+		name      string
+		getErr    error
+		pubErr    error
+		wantErrIs error
+		wantErr   bool
+	}{
+		{
+			name: "publishes replay-marked created event",
+		},
+		{
+			name:      "unknown product",
+			getErr:    products.ErrNotFound,
+			wantErr:   true,
+			wantErrIs: products.ErrNotFound,
+		},
+		{
+			name:    "publish failure is returned",
+			pubErr:  errors.New("broker down"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			if tt.getErr != nil {
+				repo.getFn = func(_ context.Context, _ int64) (products.Product, error) {
+					return products.Product{}, tt.getErr
+				}
+			}
+			pub := &mockPublisher{err: tt.pubErr}
+			svc := newTestService(repo, pub)
+
+			err := svc.ReplayProduct(context.Background(), 7)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("want error wrapping %v, got %v", tt.wantErrIs, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(pub.events) != 1 {
+				t.Fatalf("want 1 event published, got %d", len(pub.events))
+			}
+			event := pub.events[0]
+			if event.EventType != products.EventCreated || event.ProductID != 7 || event.Name != "Widget" || event.Timestamp != testNow.UTC() {
+				t.Fatalf("unexpected event: %+v", event)
+			}
+			if !event.Replay {
+				t.Fatal("want replayed event to carry the replay marker")
+			}
+		})
+	}
+}
+
+func TestReserveProduct(t *testing.T) {
+	var gotTTL time.Duration
+	repo := defaultRepo()
+	repo.reserveFn = func(_ context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error) {
+		gotTTL = ttl
+		if id == 8 {
+			return products.Reservation{}, products.ErrAlreadyReserved
+		}
+		return products.Reservation{ProductID: id, ReservedBy: by, ReservedUntil: testNow.Add(ttl)}, nil
+	}
+	pub := &mockPublisher{}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	svc := New(
+		repo, pub, logger,
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+		WithClock(fixedClock(testNow)),
+		WithReservationTTL(5*time.Minute),
+	)
+
+	res, err := svc.ReserveProduct(context.Background(), 7, "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotTTL != 5*time.Minute || res.ReservedBy != "alice" {
+		t.Fatalf("want alice's reservation for 5m, got %v: %+v", gotTTL, res)
+	}
+	if _, err := svc.ReserveProduct(context.Background(), 8, "alice"); !errors.Is(err, products.ErrAlreadyReserved) {
+		t.Fatalf("want ErrAlreadyReserved, got %v", err)
+	}
+	if len(pub.events) != 0 {
+		t.Fatalf("want no event for a reservation, got %+v", pub.events)
+	}
+
+	// Without the option reservations last the default TTL.
+	if _, err := newTestService(repo, pub).ReserveProduct(context.Background(), 7, "alice"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotTTL != defaultReservationTTL {
+		t.Fatalf("want the default TTL, got %v", gotTTL)
+	}
+}
+
+func TestReleaseProduct(t *testing.T) {
+	var gotBy string
+	repo := defaultRepo()
+	repo.releaseFn = func(_ context.Context, _ int64, by string) error {
+		gotBy = by
+		if by != "alice" {
+			return products.ErrReservedByOther
+		}
+		return nil
+	}
+	svc := newTestService(repo, &mockPublisher{})
+
+	if err := svc.ReleaseProduct(context.Background(), 7, "alice"); err != nil || gotBy != "alice" {
+		t.Fatalf("want alice's release passed on, got %q (err %v)", gotBy, err)
+	}
+	if err := svc.ReleaseProduct(context.Background(), 7, "bob"); !errors.Is(err, products.ErrReservedByOther) {
+		t.Fatalf("want ErrReservedByOther, got %v", err)
+	}
+}
+
+func TestProductPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		position int64
+		limit    int
+		want     products.ListPosition
+	}{
+		{name: "first product", position: 1, limit: 10, want: products.ListPosition{Position: 1, Page: 1, Limit: 10}},
+		{name: "last of a page", position: 20, limit: 10, want: products.ListPosition{Position: 20, Page: 2, Limit: 10}},
+		{name: "first of a page", position: 21, limit: 10, want: products.ListPosition{Position: 21, Page: 3, Limit: 10}},
+		{name: "default limit", position: 23, want: products.ListPosition{Position: 23, Page: 3, Limit: defaultPageSize}},
+		{name: "limit clamped", position: 250, limit: 1000, want: products.ListPosition{Position: 250, Page: 3, Limit: maxPageSize}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			repo.positionFn = func(context.Context, int64) (int64, error) { return tt.position, nil }
+
+			got, err := newTestService(repo, &mockPublisher{}).ProductPosition(context.Background(), 7, tt.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateProducts(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputs    []products.CreateInput
+		wantErrIs error
+		wantNames []string
+	}{
+		{
+			name:      "trims names and creates all",
+			inputs:    []products.CreateInput{{Name: " Laptop "}, {Name: "Phone"}},
+			wantNames: []string{"Laptop", "Phone"},
+		},
+		{
+			name:      "one invalid name rejects the batch",
+			inputs:    []products.CreateInput{{Name: "Laptop"}, {Name: "  "}},
+			wantErrIs: products.ErrInvalidName,
+		},
+		{
+			name:      "too many items",
+			inputs:    make([]products.CreateInput, maxBatchSize+1),
+			wantErrIs: products.ErrBatchTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			var stored []products.CreateInput
+			batchFn := repo.batchFn
+			repo.batchFn = func(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+				stored = inputs
+				return batchFn(ctx, inputs)
+			}
+			pub := &mockPublisher{}
+			svc := newTestService(repo, pub)
+
+			created, err := svc.CreateProducts(context.Background(), tt.inputs)
+
+			if tt.wantErrIs != nil {
+				if !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("want error wrapping %v, got %v", tt.wantErrIs, err)
+				}
+				if stored != nil {
+					t.Fatal("repo must not be called for an invalid batch")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(created) != len(tt.wantNames) {
+				t.Fatalf("want %d products, got %d", len(tt.wantNames), len(created))
+			}
+			for i, want := range tt.wantNames {
+				if stored[i].Name != want {
+					t.Fatalf("item %d: want name %q stored, got %q", i, want, stored[i].Name)
+				}
+			}
+			if len(pub.events) != 0 {
+				t.Fatalf("bulk create must leave publishing to the outbox relay, got %d events", len(pub.events))
+			}
+		})
+	}
+}
+
+func TestDeleteProducts(t *testing.T) {
+	repo := defaultRepo()
+	var calls int
+	repo.deleteBatchFn = func(_ context.Context, ids []int64) (int64, error) {
+		calls++
+		return int64(len(ids)) - 1, nil
+	}
+	pub := &mockPublisher{}
+	svc := newTestService(repo, pub)
+
+	deleted, err := svc.DeleteProducts(context.Background(), []int64{1, 2, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("want the repository's deleted count 2, got %d", deleted)
+	}
+	if len(pub.events) != 0 {
+		t.Fatalf("bulk delete must leave publishing to the outbox relay, got %d events", len(pub.events))
+	}
+
+	if _, err := svc.DeleteProducts(context.Background(), make([]int64, maxDeleteBatchSize+1)); !errors.Is(err, products.ErrBatchTooLarge) {
+		t.Fatalf("want ErrBatchTooLarge, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("repo must not be called for an oversized batch, got %d calls", calls)
+	}
+}
+
+func TestCreateProductsPartial(t *testing.T) {
+	repo := defaultRepo()
+	repo.batchFn = func(_ context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+		if inputs[0].Name == "Taken" {
+			return nil, products.ErrDuplicateName
+		}
+		return []products.Product{{ID: 7, Name: inputs[0].Name}}, nil
+	}
+	svc := newTestService(repo, &mockPublisher{})
+
+	results, err := svc.CreateProductsPartial(context.Background(), []products.CreateInput{
+		{Name: " Laptop "}, {Name: "  "}, {Name: "Taken"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].Product.Name != "Laptop" {
+		t.Fatalf("item 0: want created Laptop, got %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, products.ErrInvalidName) {
+		t.Fatalf("item 1: want ErrInvalidName, got %v", results[1].Err)
+	}
+	if !errors.Is(results[2].Err, products.ErrDuplicateName) {
+		t.Fatalf("item 2: want ErrDuplicateName, got %v", results[2].Err)
+	}
+	for i, r := range results {
+		if r.Index != i {
+			t.Fatalf("result %d carries index %d", i, r.Index)
+		}
+	}
+
+	if _, err := svc.CreateProductsPartial(context.Background(), make([]products.CreateInput, maxBatchSize+1)); !errors.Is(err, products.ErrBatchTooLarge) {
+		t.Fatalf("want ErrBatchTooLarge, got %v", err)
+	}
+}
+
+func TestCreateProduct_NameStripPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		input    string
+		wantName string
+		wantErr  error
+	}{
+		{
+			name:     "no pattern only trims",
+			input:    "  SKU-123 | Widget ",
+			wantName: "SKU-123 | Widget",
+		},
+		{
+			name:     "strip up to delimiter",
+			pattern:  `^[^|]*\|`,
+			input:    "SKU-123 | Widget",
+			wantName: "Widget",
+		},
+		{
+			name:     "name without the prefix is untouched",
+			pattern:  `^[^|]*\|`,
+			input:    "Widget",
+			wantName: "Widget",
+		},
+		{
+			name:     "strip suffix",
+			pattern:  `\s*\(discontinued\)$`,
+			input:    "Widget (discontinued)",
+			wantName: "Widget",
+		},
+		{
+			name:    "nothing left after stripping",
+			pattern: `^SKU-\d+$`,
+			input:   "SKU-123",
+			wantErr: products.ErrInvalidName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.pattern != "" {
+				opts = append(opts, WithNameStripPattern(regexp.MustCompile(tt.pattern)))
+			}
+			svc := New(defaultRepo(), &mockPublisher{}, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+				opts...,
+			)
+
+			product, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: tt.input})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if product.Name != tt.wantName {
+				t.Fatalf("want name %q, got %q", tt.wantName, product.Name)
+			}
+		})
+	}
+}
+
+func TestCreateProduct_MinNameLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		strip   string
+		wantErr error
+	}{
+		{name: "at the minimum", input: "TV"},
+		{name: "below the minimum", input: "x", wantErr: products.ErrNameTooShort},
+		{name: "counted in characters", input: "éé"},
+		{name: "counted after trimming", input: "  x  ", wantErr: products.ErrNameTooShort},
+		{name: "counted after stripping", input: "SKU-1 | x", strip: `^[^|]*\|`, wantErr: products.ErrNameTooShort},
+		{name: "empty still invalid", input: " ", wantErr: products.ErrInvalidName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithMinNameLength(2)}
+			if tt.strip != "" {
+				opts = append(opts, WithNameStripPattern(regexp.MustCompile(tt.strip)))
+			}
+			svc := New(defaultRepo(), &mockPublisher{}, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+				opts...,
+			)
+
+			_, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: tt.input})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateProduct_NameDenylist(t *testing.T) {
+	denylist, err := products.NewNameDenylist([]string{"Scam", "ass"}, []string{`^free\s+money`})
+	if err != nil {
+		t.Fatalf("compile denylist: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		strip   string
+		wantErr error
+	}{
+		{name: "denied term", input: "Scam Watch", wantErr: products.ErrNameNotAllowed},
+		{name: "case variation", input: "totally sCAM-free phone", wantErr: products.ErrNameNotAllowed},
+		{name: "denied pattern", input: "FREE   Money Printer", wantErr: products.ErrNameNotAllowed},
+		{name: "term inside a longer word", input: "Glass Vase"},
+		{name: "allowed name", input: "iPhone 16"},
+		{name: "checked after normalization", input: "scam | iPhone 16", strip: `^[^|]*\|`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithNameDenylist(denylist)}
+			if tt.strip != "" {
+				opts = append(opts, WithNameStripPattern(regexp.MustCompile(tt.strip)))
+			}
+			svc := New(defaultRepo(), &mockPublisher{}, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+				opts...,
+			)
+
+			_, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: tt.input})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := products.NewNameDenylist(nil, []string{"(unclosed"}); err == nil {
+		t.Fatal("want an error for a pattern that does not compile")
+	}
+}
+
+func TestCreateProduct_Expiry(t *testing.T) {
+	future := testNow.Add(time.Hour)
+	past := testNow.Add(-time.Second)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		wantErr   error
+	}{
+		{name: "no expiry"},
+		{name: "future expiry", expiresAt: &future},
+		{name: "past expiry", expiresAt: &past, wantErr: products.ErrExpiryInPast},
+		{name: "expiry now", expiresAt: &testNow, wantErr: products.ErrExpiryInPast},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got products.CreateInput
+			repo := defaultRepo()
+			repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+				got = in
+				return products.Product{ID: 1, Name: in.Name, ExpiresAt: in.ExpiresAt}, nil
+			}
+			svc := newTestService(repo, &mockPublisher{})
+
+			_, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Milk", ExpiresAt: tt.expiresAt})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && got.ExpiresAt != tt.expiresAt {
+				t.Fatalf("want expiry %v passed to the repository, got %v", tt.expiresAt, got.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestExportProducts(t *testing.T) {
+	const total = 7
+
+	tests := []struct {
+		name       string
+		batchSize  int
+		wantSizes  []int
+		wantCursor []int64
+	}{
+		{name: "partial last batch", batchSize: 3, wantSizes: []int{3, 3, 1}, wantCursor: []int64{0, 3, 6}},
+		{name: "exact multiple", batchSize: 7, wantSizes: []int{7}, wantCursor: []int64{0, 7}},
+		{name: "one big batch", batchSize: 100, wantSizes: []int{7}, wantCursor: []int64{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cursors []int64
+			repo := defaultRepo()
+			repo.listAfterFn = func(_ context.Context, afterID int64, limit int) ([]products.Product, error) {
+				cursors = append(cursors, afterID)
+				var page []products.Product
+				for id := afterID + 1; id <= total && len(page) < limit; id++ {
+					page = append(page, products.Product{ID: id})
+				}
+				return page, nil
+			}
+			svc := newTestService(repo, &mockPublisher{})
+
+			var sizes []int
+			var next int64 = 1
+			err := svc.ExportProducts(context.Background(), tt.batchSize, func(batch []products.Product) error {
+				sizes = append(sizes, len(batch))
+				for _, p := range batch {
+					if p.ID != next {
+						t.Fatalf("want product %d next, got %d", next, p.ID)
+					}
+					next++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSizes) {
+				t.Fatalf("want batch sizes %v, got %v", tt.wantSizes, sizes)
+			}
+			if !reflect.DeepEqual(cursors, tt.wantCursor) {
+				t.Fatalf("want pages read after ids %v, got %v", tt.wantCursor, cursors)
+			}
+		})
+	}
+
+	t.Run("emit error stops the export", func(t *testing.T) {
+		repo := defaultRepo()
+		repo.listAfterFn = func(_ context.Context, afterID int64, _ int) ([]products.Product, error) {
+			return []products.Product{{ID: afterID + 1}}, nil
+		}
+		svc := newTestService(repo, &mockPublisher{})
+
+		clientGone := errors.New("client went away")
+		err := svc.ExportProducts(context.Background(), 1, func([]products.Product) error { return clientGone })
+		if !errors.Is(err, clientGone) {
+			t.Fatalf("want the emit error, got %v", err)
+		}
+	})
+}
+
+func TestAggregateVersion(t *testing.T) {
+	repo := defaultRepo()
+	repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+		return products.Product{ID: 1, Name: in.Name, Version: 1}, nil
+	}
+	repo.updateAttrFn = func(_ context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+		return products.Product{ID: id, Attributes: attributes, Version: 2}, nil, nil
+	}
+	repo.getFn = func(_ context.Context, id int64) (products.Product, error) {
+		return products.Product{ID: id, Version: 2}, nil
+	}
+	repo.deleteFn = func(_ context.Context, id int64) (products.Product, error) {
+		return products.Product{ID: id, Version: 2}, nil
+	}
+	pub := &mockPublisher{}
+	svc := newTestService(repo, pub)
+	ctx := context.Background()
+
+	if _, err := svc.CreateProduct(ctx, products.CreateInput{Name: "iPhone 16"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := svc.UpdateAttributes(ctx, 1, map[string]any{"color": "black"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := svc.ReplayProduct(ctx, 1); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if _, err := svc.DeleteProduct(ctx, 1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// The replay repeats the current version; the delete is one past the
+	// deleted row's.
+	var got []int64
+	for _, event := range pub.events {
+		got = append(got, event.AggregateVersion)
+	}
+	if want := []int64{1, 2, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want aggregate versions %v, got %v", want, got)
+	}
+}
+
+func TestChangeStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		change  func(*Service, context.Context, int64) (products.Product, error)
+		want    string
+		wantErr error
+	}{
+		{name: "publish a draft", from: products.StatusDraft, change: (*Service).PublishProduct, want: products.StatusPublished},
+		{name: "archive a published product", from: products.StatusPublished, change: (*Service).ArchiveProduct, want: products.StatusArchived},
+		{name: "archive a draft", from: products.StatusDraft, change: (*Service).ArchiveProduct, wantErr: products.ErrInvalidTransition},
+		{name: "publish an archived product", from: products.StatusArchived, change: (*Service).PublishProduct, wantErr: products.ErrInvalidTransition},
+		{name: "publish a published product", from: products.StatusPublished, change: (*Service).PublishProduct, wantErr: products.ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			repo.updateStatFn = func(_ context.Context, id int64, status string) (products.Product, string, error) {
+				if !products.CanTransition(tt.from, status) {
+					return products.Product{}, "", products.ErrInvalidTransition
+				}
+				return products.Product{ID: id, Name: "Widget", Status: status, Version: 2}, tt.from, nil
+			}
+			pub := &mockPublisher{}
+			svc := newTestService(repo, pub)
+
+			product, err := tt.change(svc, context.Background(), 7)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				if len(pub.events) != 0 {
+					t.Fatalf("want no event for a refused transition, got %v", pub.events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if product.Status != tt.want {
+				t.Fatalf("want status %q, got %q", tt.want, product.Status)
+			}
+
+			want := products.ProductEvent{
+				EventType:        products.EventStatusChanged,
+				ProductID:        7,
+				Name:             "Widget",
+				Timestamp:        testNow.UTC(),
+				AggregateVersion: 2,
+				Status:           tt.want,
+				PreviousStatus:   tt.from,
+			}
+			if len(pub.events) != 1 || !reflect.DeepEqual(pub.events[0], want) {
+				t.Fatalf("want event %+v, got %+v", want, pub.events)
+			}
+		})
+	}
+}
+
+func TestCreateProduct_Status(t *testing.T) {
+	repo := defaultRepo()
+	var got products.CreateInput
+	repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+		got = in
+		return products.Product{ID: 1, Name: in.Name, Status: in.Status}, nil
+	}
+	svc := newTestService(repo, &mockPublisher{})
+
+	if _, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Widget", Status: products.StatusDraft}); err != nil {
+		t.Fatalf("create draft: %v", err)
+	}
+	if got.Status != products.StatusDraft {
+		t.Fatalf("want the draft status passed to the repository, got %q", got.Status)
+	}
+
+	_, err := svc.CreateProduct(context.Background(), products.CreateInput{Name: "Widget", Status: products.StatusArchived})
+	if !errors.Is(err, products.ErrInvalidStatus) {
+		t.Fatalf("want ErrInvalidStatus creating an archived product, got %v", err)
+	}
+}
+
+func TestTimed(t *testing.T) {
+	repo := defaultRepo()
+	repo.listFn = func(context.Context, int, int) ([]products.Product, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	}
+	timed := Timed(repo)
+
+	if _, err := timed.List(context.Background(), products.ListOptions{}, 10, 0); err != nil {
+		t.Fatalf("list without timings: %v", err)
+	}
+
+	ctx, timings := products.WithTimings(context.Background())
+	if _, err := timed.List(ctx, products.ListOptions{}, 10, 0); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := timings.DB(); got < 5*time.Millisecond {
+		t.Fatalf("want at least 5ms recorded, got %v", got)
+	}
+
+	// Concurrent calls count the time they span, not their sum.
+	repo.listFn = func(context.Context, int, int) ([]products.Product, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	}
+	ctx, timings = products.WithTimings(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = timed.List(ctx, products.ListOptions{}, 10, 0)
+		}()
+	}
+	wg.Wait()
+	if got := timings.DB(); got < 20*time.Millisecond || got >= 60*time.Millisecond {
+		t.Fatalf("want about 20ms recorded for 4 overlapping 20ms calls, got %v", got)
+	}
+}
+
+func TestUpdateAttributes_ChangedFields(t *testing.T) {
+	previous := map[string]any{"color": "black", "storage": "128GB", "dual_sim": true}
+
+	tests := []struct {
+		name        string
+		attributes  map[string]any
+		oldValues   bool
+		wantFields  []string
+		wantChanges map[string]products.FieldChange
+	}{
+		{
+			name:       "no-op",
+			attributes: map[string]any{"color": "black", "storage": "128GB", "dual_sim": true},
+		},
+		{
+			name:       "one changed",
+			attributes: map[string]any{"color": "white", "storage": "128GB", "dual_sim": true},
+			wantFields: []string{"attributes.color"},
+			wantChanges: map[string]products.FieldChange{
+				"attributes.color": {New: "white"},
+			},
+		},
+		{
+			name:       "added and removed",
+			attributes: map[string]any{"color": "black", "dual_sim": true, "weight": float64(170)},
+			wantFields: []string{"attributes.storage", "attributes.weight"},
+			wantChanges: map[string]products.FieldChange{
+				"attributes.storage": {},
+				"attributes.weight":  {New: float64(170)},
+			},
+		},
+		{
+			name:       "old values included",
+			attributes: map[string]any{"color": "white", "dual_sim": true},
+			oldValues:  true,
+			wantFields: []string{"attributes.color", "attributes.storage"},
+			wantChanges: map[string]products.FieldChange{
+				"attributes.color":   {Old: "black", New: "white"},
+				"attributes.storage": {Old: "128GB"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			repo.updateAttrFn = func(_ context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+				return products.Product{ID: id, Attributes: attributes, Version: 2}, previous, nil
+			}
+			pub := &mockPublisher{}
+			svc := newTestService(repo, pub)
+			if tt.oldValues {
+				WithOldValuesInEvents()(svc)
+			}
+
+			if _, err := svc.UpdateAttributes(context.Background(), 1, tt.attributes); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			event := pub.events[len(pub.events)-1]
+			if !reflect.DeepEqual(event.ChangedFields, tt.wantFields) {
+				t.Fatalf("want changed fields %v, got %v", tt.wantFields, event.ChangedFields)
+			}
+			if !reflect.DeepEqual(event.Changes, tt.wantChanges) {
+				t.Fatalf("want changes %v, got %v", tt.wantChanges, event.Changes)
+			}
+		})
+	}
+}
+
+func TestListProducts_Totals(t *testing.T) {
+	repo := defaultRepo()
+	var (
+		mu     sync.Mutex
+		counts []products.ListOptions
+	)
+	repo.countFn = func(_ context.Context, opts products.ListOptions) (int64, bool, error) {
+		mu.Lock()
+		counts = append(counts, opts)
+		mu.Unlock()
+		if opts.Filtered() {
+			return 12, false, nil
+		}
+		return 4532, true, nil
+	}
+	svc := newTestService(repo, &mockPublisher{})
+
+	_, totals, err := svc.ListProducts(context.Background(), products.ListOptions{Search: "iphone", ExactCount: true}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (products.ListTotals{Filtered: 12, All: 4532, Approximate: true}); totals != want {
+		t.Fatalf("want totals %+v, got %+v", want, totals)
+	}
+	for _, opts := range counts {
+		if !opts.Filtered() && !opts.ExactCount {
+			t.Fatalf("want the grand count to keep exact=true, got %+v", opts)
+		}
+	}
+
+	counts = nil
+	_, totals, err = svc.ListProducts(context.Background(), products.ListOptions{}, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (products.ListTotals{Filtered: 4532, All: 4532, Approximate: true}); totals != want {
+		t.Fatalf("want totals %+v, got %+v", want, totals)
+	}
+	if len(counts) != 1 {
+		t.Fatalf("want a single count for an unfiltered list, got %d", len(counts))
+	}
+}
+
+func TestCreateProductIfAbsent(t *testing.T) {
+	tests := []struct {
+		name       string
+		created    bool
+		wantEvents int
+	}{
+		{name: "absent is created and announced", created: true, wantEvents: 1},
+		{name: "present is returned quietly", created: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := defaultRepo()
+			var gotName string
+			repo.ifAbsentFn = func(_ context.Context, in products.CreateInput) (products.Product, bool, error) {
+				gotName = in.Name
+				return products.Product{ID: 3, Name: in.Name, Version: 1}, tt.created, nil
+			}
+			pub := &mockPublisher{}
+			svc := newTestService(repo, pub)
+
+			product, created, err := svc.CreateProductIfAbsent(context.Background(), products.CreateInput{Name: "  Widget "})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotName != "Widget" {
+				t.Fatalf("want the normalized name looked up, got %q", gotName)
+			}
+			if created != tt.created || product.ID != 3 {
+				t.Fatalf("want product 3 created=%v, got %d created=%v", tt.created, product.ID, created)
+			}
+			if len(pub.events) != tt.wantEvents {
+				t.Fatalf("want %d events, got %d", tt.wantEvents, len(pub.events))
+			}
+		})
+	}
+}
+
+// gatedPublisher records events, holding product_created back until
+// release is closed.
+type gatedPublisher struct {
+	mockPublisher
+	release chan struct{}
+	mu      sync.Mutex
+}
+
+func (p *gatedPublisher) Publish(ctx context.Context, event products.ProductEvent) error {
+	if event.EventType == products.EventCreated {
+		<-p.release
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mockPublisher.Publish(ctx, event)
+}
+
+func TestProductEventOrdering(t *testing.T) {
+	inserted := make(chan struct{})
+	repo := defaultRepo()
+	repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+		close(inserted)
+		return products.Product{ID: 1, Name: in.Name, Version: 1}, nil
+	}
+	repo.deleteFn = func(_ context.Context, id int64) (products.Product, error) {
+		return products.Product{ID: id, Version: 1}, nil
+	}
+	pub := &gatedPublisher{release: make(chan struct{})}
+	svc := New(repo, pub, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+		WithClock(fixedClock(testNow)),
+		WithProductEventOrdering(),
+	)
+	ctx := context.Background()
+
+	// The create has committed but not yet published when the delete of
+	// the same product runs.
+	created := make(chan error, 1)
+	go func() {
+		_, err := svc.CreateProduct(ctx, products.CreateInput{Name: "iPhone 16"})
+		created <- err
+	}()
+	<-inserted
+
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := svc.DeleteProduct(ctx, 1)
+		deleted <- err
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("delete finished before the create's event was published")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(pub.release)
+	if err := <-created; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := <-deleted; err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	var got []string
+	for _, event := range pub.events {
+		got = append(got, event.EventType)
+	}
+	if want := []string{products.EventCreated, products.EventDeleted}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want events %v, got %v", want, got)
+	}
+}
+
+func TestProductEventOrdering_OtherProducts(t *testing.T) {
+	var ids atomic.Int64
+	inserted := make(chan struct{}, 2)
+	repo := defaultRepo()
+	repo.createFn = func(_ context.Context, in products.CreateInput) (products.Product, error) {
+		defer func() { inserted <- struct{}{} }()
+		return products.Product{ID: ids.Add(1), Name: in.Name, Version: 1}, nil
+	}
+	repo.deleteFn = func(_ context.Context, id int64) (products.Product, error) {
+		return products.Product{ID: id, Version: 1}, nil
+	}
+	pub := &gatedPublisher{release: make(chan struct{})}
+	defer close(pub.release)
+	svc := New(repo, pub, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+		WithClock(fixedClock(testNow)),
+		WithProductEventOrdering(),
+	)
+	ctx := context.Background()
+
+	// Product 1's create is stuck publishing; neither another create nor
+	// the first change of another product waits for it.
+	go func() { _, _ = svc.CreateProduct(ctx, products.CreateInput{Name: "iPhone 16"}) }()
+	go func() { _, _ = svc.CreateProduct(ctx, products.CreateInput{Name: "iPhone 17"}) }()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-inserted:
+		case <-time.After(time.Second):
+			t.Fatal("a create waited for another create's event")
+		}
+	}
+
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := svc.DeleteProduct(ctx, 99)
+		deleted <- err
+	}()
+	select {
+	case err := <-deleted:
+		if err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delete of another product waited for a pending create's event")
+	}
+}
+
+func TestProductEventOrdering_Outbox(t *testing.T) {
+	var queued []products.ProductEvent
+	repo := defaultRepo()
+	repo.deleteFn = func(_ context.Context, id int64) (products.Product, error) {
+		return products.Product{ID: id, Version: 1}, nil
+	}
+	// The product came from a bulk create whose event is still in the
+	// outbox.
+	repo.queueFn = func(_ context.Context, event products.ProductEvent) (bool, error) {
+		queued = append(queued, event)
+		return true, nil
+	}
+	pub := &mockPublisher{}
+	svc := New(repo, pub, slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_created", Help: "t"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "t_deleted", Help: "t"}),
+		WithClock(fixedClock(testNow)),
+		WithProductEventOrdering(),
+	)
+
+	if _, err := svc.DeleteProduct(context.Background(), 7); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(pub.events) != 0 {
+		t.Fatalf("want the event left to the outbox relay, got %d published", len(pub.events))
+	}
+	if len(queued) != 1 || queued[0].EventType != products.EventDeleted || queued[0].AggregateVersion != 2 {
+		t.Fatalf("want product_deleted at version 2 queued, got %+v", queued)
+	}
 }
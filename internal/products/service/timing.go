@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+// Timed wraps repo so the time spent in its calls is added to the
+// products.Timings carried by the call's context, if there is one.
+func Timed(repo Repository) Repository {
+	return timedRepository{next: repo}
+}
+
+type timedRepository struct {
+	next Repository
+}
+
+// track starts timing a call; the returned func records it. Calls made
+// concurrently, as ListProducts does, count the wall time they span.
+func track(ctx context.Context) func() {
+	return products.TimingsFrom(ctx).StartDB()
+}
+
+func (r timedRepository) Create(ctx context.Context, in products.CreateInput) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.Create(ctx, in)
+}
+
+func (r timedRepository) CreateIfAbsent(ctx context.Context, in products.CreateInput) (products.Product, bool, error) {
+	defer track(ctx)()
+	return r.next.CreateIfAbsent(ctx, in)
+}
+
+func (r timedRepository) CreateBatch(ctx context.Context, inputs []products.CreateInput) ([]products.Product, error) {
+	defer track(ctx)()
+	return r.next.CreateBatch(ctx, inputs)
+}
+
+func (r timedRepository) Get(ctx context.Context, id int64) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.Get(ctx, id)
+}
+
+func (r timedRepository) GetByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.GetByPublicID(ctx, publicID)
+}
+
+func (r timedRepository) GetBySlug(ctx context.Context, slug string) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.GetBySlug(ctx, slug)
+}
+
+func (r timedRepository) GetIncludingExpired(ctx context.Context, id int64) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.GetIncludingExpired(ctx, id)
+}
+
+func (r timedRepository) GetByPublicIDIncludingExpired(ctx context.Context, publicID string) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.GetByPublicIDIncludingExpired(ctx, publicID)
+}
+
+func (r timedRepository) UpdateAttributes(ctx context.Context, id int64, attributes map[string]any) (products.Product, map[string]any, error) {
+	defer track(ctx)()
+	return r.next.UpdateAttributes(ctx, id, attributes)
+}
+
+func (r timedRepository) UpdateStatus(ctx context.Context, id int64, status string) (products.Product, string, error) {
+	defer track(ctx)()
+	return r.next.UpdateStatus(ctx, id, status)
+}
+
+func (r timedRepository) DeleteReturning(ctx context.Context, id int64) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.DeleteReturning(ctx, id)
+}
+
+func (r timedRepository) DeleteByPublicID(ctx context.Context, publicID string) (products.Product, error) {
+	defer track(ctx)()
+	return r.next.DeleteByPublicID(ctx, publicID)
+}
+
+func (r timedRepository) DeleteBatch(ctx context.Context, ids []int64) (int64, error) {
+	defer track(ctx)()
+	return r.next.DeleteBatch(ctx, ids)
+}
+
+func (r timedRepository) List(ctx context.Context, opts products.ListOptions, limit, offset int) ([]products.Product, error) {
+	defer track(ctx)()
+	return r.next.List(ctx, opts, limit, offset)
+}
+
+func (r timedRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]products.Product, error) {
+	defer track(ctx)()
+	return r.next.ListAfter(ctx, afterID, limit)
+}
+
+func (r timedRepository) Count(ctx context.Context, opts products.ListOptions) (int64, bool, error) {
+	defer track(ctx)()
+	return r.next.Count(ctx, opts)
+}
+
+func (r timedRepository) Reserve(ctx context.Context, id int64, by string, ttl time.Duration) (products.Reservation, error) {
+	defer track(ctx)()
+	return r.next.Reserve(ctx, id, by, ttl)
+}
+
+func (r timedRepository) Release(ctx context.Context, id int64, by string) error {
+	defer track(ctx)()
+	return r.next.Release(ctx, id, by)
+}
+
+func (r timedRepository) Position(ctx context.Context, id int64) (int64, error) {
+	defer track(ctx)()
+	return r.next.Position(ctx, id)
+}
+
+func (r timedRepository) SuggestNames(ctx context.Context, prefix string, limit int) ([]string, error) {
+	defer track(ctx)()
+	return r.next.SuggestNames(ctx, prefix, limit)
+}
+
+func (r timedRepository) QueueBehindOutbox(ctx context.Context, event products.ProductEvent) (bool, error) {
+	defer track(ctx)()
+	return r.next.QueueBehindOutbox(ctx, event)
+}
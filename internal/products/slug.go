@@ -0,0 +1,94 @@
+package products
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// SlugSuffixCounter makes a taken slug unique by appending -2, -3, ...
+	SlugSuffixCounter = "counter"
+	// SlugSuffixRandom makes a taken slug unique by appending six random
+	// hex digits.
+	SlugSuffixRandom = "random"
+
+	defaultSlugSeparator = "-"
+	// fallbackSlug stands in for a name with no letters or digits at all.
+	fallbackSlug = "product"
+)
+
+// SlugConfig controls how product slugs are derived from names.
+type SlugConfig struct {
+	// Separator replaces every run of characters other than letters and
+	// digits; "-" when empty.
+	Separator string
+	// MaxLength caps the slug, in characters, before any uniqueness
+	// suffix; zero leaves it uncapped.
+	MaxLength int
+	// Suffix is SlugSuffixCounter or SlugSuffixRandom; counter when empty.
+	Suffix string
+}
+
+func (c SlugConfig) separator() string {
+	if c.Separator == "" {
+		return defaultSlugSeparator
+	}
+	return c.Separator
+}
+
+// Slugify derives the base slug of a product name: accents removed,
+// lower-cased, and every run of other characters than letters and digits
+// replaced by the separator, so "Café Crème 2.0!" becomes
+// "cafe-creme-2-0".
+func Slugify(name string, cfg SlugConfig) string {
+	sep := cfg.separator()
+
+	var b strings.Builder
+	pending := false
+	length := 0
+	for _, r := range norm.NFD.String(name) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pending = b.Len() > 0
+			continue
+		}
+		if cfg.MaxLength > 0 && length+1 > cfg.MaxLength {
+			break
+		}
+		if pending {
+			if cfg.MaxLength > 0 && length+utf8.RuneCountInString(sep)+1 > cfg.MaxLength {
+				break
+			}
+			b.WriteString(sep)
+			length += utf8.RuneCountInString(sep)
+			pending = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+		length++
+	}
+
+	if b.Len() == 0 {
+		return fallbackSlug
+	}
+	return b.String()
+}
+
+// SlugWithCounter is the n-th candidate for base under SlugSuffixCounter:
+// base itself for n <= 1, then base-2, base-3, ...
+func SlugWithCounter(base string, n int, cfg SlugConfig) string {
+	if n <= 1 {
+		return base
+	}
+	return base + cfg.separator() + strconv.Itoa(n)
+}
+
+// SlugWithSuffix appends suffix to base with the configured separator.
+func SlugWithSuffix(base, suffix string, cfg SlugConfig) string {
+	return base + cfg.separator() + suffix
+}
@@ -0,0 +1,41 @@
+package products
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		cfg  SlugConfig
+		want string
+	}{
+		{name: "lower-cased and hyphenated", in: "iPhone 16 Pro", want: "iphone-16-pro"},
+		{name: "accents removed", in: "Café Crème", want: "cafe-creme"},
+		{name: "punctuation runs collapse", in: "  Hello, World!! 2.0 ", want: "hello-world-2-0"},
+		{name: "non-latin letters kept", in: "Смартфон X", want: "смартфон-x"},
+		{name: "nothing usable", in: "!!!", want: "product"},
+		{name: "custom separator", in: "Big Box", cfg: SlugConfig{Separator: "_"}, want: "big_box"},
+		{name: "capped at a word boundary", in: "abc def", cfg: SlugConfig{MaxLength: 4}, want: "abc"},
+		{name: "capped mid-word", in: "abcdef", cfg: SlugConfig{MaxLength: 4}, want: "abcd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Slugify(tt.in, tt.cfg); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSlugWithCounter(t *testing.T) {
+	cfg := SlugConfig{}
+	for n, want := range map[int]string{1: "phone", 2: "phone-2", 3: "phone-3"} {
+		if got := SlugWithCounter("phone", n, cfg); got != want {
+			t.Fatalf("n=%d: want %q, got %q", n, want, got)
+		}
+	}
+	if got := SlugWithCounter("phone", 2, SlugConfig{Separator: "."}); got != "phone.2" {
+		t.Fatalf("want the configured separator, got %q", got)
+	}
+}
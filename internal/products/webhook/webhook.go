@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+const contentTypeJSON = "application/json"
+
+type confirmRequest struct {
+	Name string `json:"name"`
+}
+
+// HTTPWebhook asks an external endpoint to accept a product before it is
+// created. Any 2xx response accepts it; any other status rejects it.
+type HTTPWebhook struct {
+	client *http.Client
+	url    string
+}
+
+// New returns a webhook whose calls are bounded by timeout, so a slow
+// endpoint fails the create instead of hanging the request.
+func New(url string, timeout time.Duration) *HTTPWebhook {
+	return &HTTPWebhook{
+		client: &http.Client{Timeout: timeout},
+		url:    url,
+	}
+}
+
+func (w *HTTPWebhook) Confirm(ctx context.Context, name string) error {
+	body, err := json.Marshal(confirmRequest{Name: name})
+	if err != nil {
+		return fmt.Errorf("marshal webhook request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status %d", products.ErrWebhookRejected, resp.StatusCode)
+	}
+
+	return nil
+}
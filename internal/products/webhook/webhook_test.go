@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"product-notifications/internal/products"
+)
+
+func TestHTTPWebhook_Confirm(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		delay       time.Duration
+		wantErr     bool
+		wantErrIs   error
+		wantTimeout bool
+	}{
+		{
+			name:   "accepted",
+			status: http.StatusNoContent,
+		},
+		{
+			name:      "rejected",
+			status:    http.StatusConflict,
+			wantErr:   true,
+			wantErrIs: products.ErrWebhookRejected,
+		},
+		{
+			name:        "timeout",
+			status:      http.StatusOK,
+			delay:       200 * time.Millisecond,
+			wantErr:     true,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotName string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req confirmRequest
+				_ = json.NewDecoder(r.Body).Decode(&req)
+				gotName = req.Name
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			hook := New(srv.URL, 50*time.Millisecond)
+
+			start := time.Now()
+			err := hook.Confirm(context.Background(), "Laptop")
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if gotName != "Laptop" {
+					t.Fatalf("want name Laptop sent, got %q", gotName)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Fatalf("want error wrapping %v, got %v", tt.wantErrIs, err)
+			}
+			if tt.wantTimeout {
+				if errors.Is(err, products.ErrWebhookRejected) {
+					t.Fatalf("timeout must not be reported as a rejection: %v", err)
+				}
+				if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+					t.Fatalf("webhook call took %v, expected it to be cut off by the timeout", elapsed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_attributes;
+
+ALTER TABLE products DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_name_lower;
+
+DROP INDEX IF EXISTS products_name_key;
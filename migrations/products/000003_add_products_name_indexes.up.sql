@@ -0,0 +1,15 @@
+-- Names were not unique before this migration. Existing duplicates would
+-- make the unique index fail, so every product but the oldest holding a
+-- name is renamed first, to the name followed by its id, e.g.
+-- "Widget (42)". Nothing is deleted.
+UPDATE products p
+SET name = p.name || ' (' || p.id || ')'
+WHERE EXISTS (
+    SELECT 1 FROM products older
+    WHERE older.name = p.name AND older.id < p.id
+);
+
+DROP INDEX IF EXISTS products_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS products_name_key ON products (name);
+
+CREATE INDEX IF NOT EXISTS idx_products_name_lower ON products (lower(name));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_public_id;
+
+ALTER TABLE products DROP COLUMN IF EXISTS public_id;
@@ -0,0 +1,3 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_products_owner ON products (owner);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_products_name_prefix ON products (lower(name) text_pattern_ops);
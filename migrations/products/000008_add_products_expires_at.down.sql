@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS expiry_cursor;
+
+DROP INDEX IF EXISTS idx_products_expires_at;
+
+ALTER TABLE products DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,13 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_products_expires_at ON products (expires_at, id) WHERE expires_at IS NOT NULL;
+
+-- expiry_cursor is a single row marking the last expiry the sweeper
+-- announced, so no product_expired event is published twice.
+CREATE TABLE IF NOT EXISTS expiry_cursor (
+    singleton  BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (singleton),
+    expires_at TIMESTAMPTZ NOT NULL,
+    product_id BIGINT NOT NULL
+);
+
+INSERT INTO expiry_cursor (expires_at, product_id) VALUES (now(), 0) ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+ALTER TABLE products DROP COLUMN IF EXISTS search_name;
+
+DROP FUNCTION IF EXISTS products_search_name(text);
+
+DROP EXTENSION IF EXISTS unaccent;
@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent(text) is only STABLE because it looks its dictionary up by
+-- search_path; naming the dictionary makes the wrapper safe to declare
+-- IMMUTABLE and so usable in a generated column.
+CREATE OR REPLACE FUNCTION products_search_name(text) RETURNS text
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+    AS $$ SELECT lower(public.unaccent('public.unaccent'::regdictionary, $1)) $$;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_name TEXT
+    GENERATED ALWAYS AS (products_search_name(name)) STORED;
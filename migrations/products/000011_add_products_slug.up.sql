@@ -0,0 +1,5 @@
+-- Products created before this migration keep a NULL slug; the unique
+-- index ignores NULLs.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS slug TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_slug ON products (slug);
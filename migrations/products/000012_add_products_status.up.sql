@@ -0,0 +1,3 @@
+-- Existing products were all visible, so they start out published.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'
+    CONSTRAINT products_status_check CHECK (status IN ('draft', 'published', 'archived'));
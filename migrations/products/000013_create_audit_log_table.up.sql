@@ -0,0 +1,32 @@
+-- audit_log is append-only: the trigger below refuses to change or remove
+-- a record once written.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    action      TEXT NOT NULL,
+    product_id  BIGINT,
+    product_ids BIGINT[],
+    count       BIGINT,
+    actor       TEXT NOT NULL DEFAULT '',
+    request_id  TEXT NOT NULL DEFAULT '',
+    before      JSONB,
+    after       JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_product_id ON audit_log (product_id, occurred_at) WHERE product_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log records cannot be changed or removed';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_immutable ON audit_log;
+CREATE TRIGGER audit_log_immutable
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_immutable();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_immutable();
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_products_reserved_until;
+
+ALTER TABLE products DROP COLUMN IF EXISTS reserved_until;
+ALTER TABLE products DROP COLUMN IF EXISTS reserved_by;
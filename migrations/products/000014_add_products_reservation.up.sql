@@ -0,0 +1,6 @@
+-- A product is reserved while reserved_until is in the future; the
+-- reservation sweeper clears both columns once it has passed.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reserved_by TEXT;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_products_reserved_until ON products (reserved_until) WHERE reserved_until IS NOT NULL;
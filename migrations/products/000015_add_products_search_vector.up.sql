@@ -0,0 +1,6 @@
+-- The 'simple' configuration only lower-cases words, without stemming or
+-- stop words, which suits product names in any language.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', name)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
@@ -0,0 +1,2 @@
+-- reservation holds the reservation a reserve or release record is about.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS reservation JSONB;
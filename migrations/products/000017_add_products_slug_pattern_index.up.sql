@@ -0,0 +1,3 @@
+-- Lets the slug counter's LIKE prefix scan use an index whatever the
+-- database collation; idx_products_slug only serves equality then.
+CREATE INDEX IF NOT EXISTS idx_products_slug_pattern ON products (slug text_pattern_ops);